@@ -0,0 +1,137 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrACLPolicy is matched by errors.Is for the ACLPolicyError returned when
+// a create violates the ACL policy of the connection.
+var ErrACLPolicy = errors.New("zk: ACL violates the client ACL policy")
+
+// ACLPolicyError is returned, before anything is sent, by a create whose ACL
+// lacks entries required by the ACL policy of the connection.
+type ACLPolicyError struct {
+	Path    string
+	Missing []ACL
+}
+
+func (e *ACLPolicyError) Error() string {
+	return fmt.Sprintf("zk: ACL for %q is missing %v required by the ACL policy", e.Path, e.Missing)
+}
+
+// Is reports whether target is ErrACLPolicy.
+func (e *ACLPolicyError) Is(target error) bool {
+	return target == ErrACLPolicy
+}
+
+// ACLPolicyMode is what an ACLPolicy does with creates of matching paths.
+type ACLPolicyMode int
+
+const (
+	// ACLPolicyApply replaces the ACL of the create with the template.
+	ACLPolicyApply ACLPolicyMode = iota
+	// ACLPolicyReject fails creates whose ACL does not contain every entry
+	// of the template with an ACLPolicyError.
+	ACLPolicyReject
+)
+
+type aclRule struct {
+	match func(string) bool
+	acl   []ACL
+}
+
+// ACLPolicy maps paths to the ACLs that nodes created there must have, so
+// that e.g. tenancy rules are enforced by every client of a platform. It
+// covers Create, Create2, CreateContainer, CreateTTL and the creates of
+// Multi, though not SetACL. Rules are tried in the order they were added and
+// the first match applies; paths matching no rule are not checked. A policy
+// must not be changed once passed to WithACLPolicy.
+type ACLPolicy struct {
+	mode  ACLPolicyMode
+	rules []aclRule
+}
+
+// NewACLPolicy returns a policy without rules.
+func NewACLPolicy(mode ACLPolicyMode) *ACLPolicy {
+	return &ACLPolicy{mode: mode}
+}
+
+// AddPattern adds a rule for the paths matching pattern, in the syntax of
+// path.Match, e.g. "/tenants/*/config".
+func (p *ACLPolicy) AddPattern(pattern string, acl []ACL) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	p.rules = append(p.rules, aclRule{
+		match: func(s string) bool {
+			ok, _ := path.Match(pattern, s)
+			return ok
+		},
+		acl: acl,
+	})
+	return nil
+}
+
+// AddPrefix adds a rule for prefix and every path below it.
+func (p *ACLPolicy) AddPrefix(prefix string, acl []ACL) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	p.rules = append(p.rules, aclRule{
+		match: func(s string) bool {
+			return prefix == "" || s == prefix || strings.HasPrefix(s, prefix+"/")
+		},
+		acl: acl,
+	})
+}
+
+// Check returns the ACL to create the node at path with, given the ACL the
+// caller asked for.
+func (p *ACLPolicy) Check(path string, acl []ACL) ([]ACL, error) {
+	for _, rule := range p.rules {
+		if !rule.match(path) {
+			continue
+		}
+		if p.mode == ACLPolicyApply {
+			return rule.acl, nil
+		}
+		var missing []ACL
+		for _, required := range rule.acl {
+			if !containsACL(acl, required) {
+				missing = append(missing, required)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, &ACLPolicyError{Path: path, Missing: missing}
+		}
+		return acl, nil
+	}
+	return acl, nil
+}
+
+func containsACL(acl []ACL, a ACL) bool {
+	for _, b := range acl {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// WithACLPolicy returns a connection option that checks creates against
+// policy, with paths relative to the chroot.
+func WithACLPolicy(policy *ACLPolicy) connOption {
+	return func(c *Conn) {
+		c.aclPolicy = policy
+	}
+}
+
+// checkACL applies the ACL policy, if any, to a create of the node at path,
+// as sent to the server.
+func (c *Conn) checkACL(path string, acl []ACL) ([]ACL, error) {
+	if c.aclPolicy == nil {
+		return acl, nil
+	}
+	return c.aclPolicy.Check(c.clientPath(path), acl)
+}
@@ -0,0 +1,94 @@
+package zk
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestACLPolicyCheck(t *testing.T) {
+	t.Parallel()
+	tenant := DigestACL(PermAll, "tenant", "secret")
+	admin := DigestACL(PermAdmin, "admin", "secret")
+	both := append(append([]ACL{}, tenant...), admin...)
+
+	p := NewACLPolicy(ACLPolicyReject)
+	if err := p.AddPattern("/tenants/*/config", admin); err != nil {
+		t.Fatal(err)
+	}
+	p.AddPrefix("/tenants/", both)
+	if err := p.AddPattern("[", nil); err == nil {
+		t.Fatal("AddPattern accepted a malformed pattern")
+	}
+
+	tests := []struct {
+		path    string
+		acl     []ACL
+		missing []ACL
+	}{
+		{"/other", WorldACL(PermAll), nil},
+		{"/tenantsx", WorldACL(PermAll), nil},
+		{"/tenants/a/config", admin, nil},
+		{"/tenants/a/config", tenant, admin},
+		{"/tenants", both, nil},
+		{"/tenants/a/b", tenant, admin},
+		{"/tenants/a/b", WorldACL(PermAll), both},
+	}
+	for _, tt := range tests {
+		acl, err := p.Check(tt.path, tt.acl)
+		if tt.missing == nil {
+			if err != nil || !reflect.DeepEqual(acl, tt.acl) {
+				t.Errorf("Check(%q, %v) = %v, %v, expected it to pass", tt.path, tt.acl, acl, err)
+			}
+			continue
+		}
+		perr, ok := err.(*ACLPolicyError)
+		if !ok || !errors.Is(err, ErrACLPolicy) || !reflect.DeepEqual(perr.Missing, tt.missing) {
+			t.Errorf("Check(%q, %v) returned %v, expected %v to be missing", tt.path, tt.acl, err, tt.missing)
+		}
+	}
+
+	p = NewACLPolicy(ACLPolicyApply)
+	p.AddPrefix("/tenants", tenant)
+	if acl, err := p.Check("/tenants/a", WorldACL(PermAll)); err != nil || !reflect.DeepEqual(acl, tenant) {
+		t.Errorf("Check with ACLPolicyApply = %v, %v, expected %v", acl, err, tenant)
+	}
+	if acl, _ := p.Check("/other", WorldACL(PermAll)); !reflect.DeepEqual(acl, WorldACL(PermAll)) {
+		t.Errorf("Check outside the policy = %v, expected the requested ACL", acl)
+	}
+}
+
+func TestACLPolicyCreate(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	tenant := DigestACL(PermAll, "tenant", "secret")
+	p := NewACLPolicy(ACLPolicyApply)
+	p.AddPrefix("/tenants", tenant)
+	zk, _, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithACLPolicy(p), WithChroot("/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := zk.Create("/tenants/a", nil, 0, WorldACL(PermAll))
+		done <- err
+	}()
+	req, err := fc.ExpectRequest("create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := req.Body.(*CreateRequest); body.Path != "/app/tenants/a" || !reflect.DeepEqual(body.Acl, tenant) {
+		t.Fatalf("Create sent %+v, expected the ACL of the policy", body)
+	}
+	if err := fc.Reply(req, 1, nil, &createResponse{Path: "/app/tenants/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,338 @@
+package zk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAdminPort is the port of the AdminServer if the address of a
+// server has none.
+const DefaultAdminPort = 8080
+
+// AdminClient queries the AdminServer of ZooKeeper 3.5 and later, which
+// serves the commands of the four letter words over HTTP at
+// /commands/<name>. Those servers only allow srvr among the four letter
+// words unless 4lw.commands.whitelist is set, while the AdminServer has all
+// the commands. The methods mirror the FLW helpers and return the same
+// structs, with an Error per server and a boolean value false if any server
+// had an issue.
+//
+// The servers are addressed as host:port, the port of the AdminServer
+// (admin.serverPort) being DefaultAdminPort if left out, or as a base URL,
+// e.g. "https://zk1:8443" for an AdminServer with TLS. The zero value is
+// ready to use.
+type AdminClient struct {
+	// Client sends the requests, or http.DefaultClient if nil. Set it for
+	// the TLS configuration of https servers.
+	Client *http.Client
+	// Timeout bounds each request if positive.
+	Timeout time.Duration
+}
+
+// Ruok reports which servers answer the ruok command, which they only do
+// when running.
+func (a *AdminClient) Ruok(servers []string) []bool {
+	oks := make([]bool, len(servers))
+	for i, server := range servers {
+		oks[i] = a.get(server, "ruok", &struct{}{}) == nil
+	}
+	return oks
+}
+
+// adminServerStats is the response of the server_stats (srvr) and stats
+// (stat) commands.
+type adminServerStats struct {
+	Version     string `json:"version"`
+	ServerStats struct {
+		PacketsSent               int64   `json:"packets_sent"`
+		PacketsReceived           int64   `json:"packets_received"`
+		LastProcessedZxid         int64   `json:"last_processed_zxid"`
+		OutstandingRequests       int64   `json:"outstanding_requests"`
+		ServerState               string  `json:"server_state"`
+		AvgLatency                float64 `json:"avg_latency"`
+		MaxLatency                float64 `json:"max_latency"`
+		MinLatency                float64 `json:"min_latency"`
+		NumAliveClientConnections int64   `json:"num_alive_client_connections"`
+	} `json:"server_stats"`
+	NodeCount         int64             `json:"node_count"`
+	Connections       []adminConnection `json:"connections"`
+	SecureConnections []adminConnection `json:"secure_connections"`
+}
+
+func (s *adminServerStats) serverStats() ServerStats {
+	stats := ServerStats{
+		Sent:        s.ServerStats.PacketsSent,
+		Received:    s.ServerStats.PacketsReceived,
+		NodeCount:   s.NodeCount,
+		MinLatency:  int64(s.ServerStats.MinLatency),
+		AvgLatency:  int64(s.ServerStats.AvgLatency),
+		MaxLatency:  int64(s.ServerStats.MaxLatency),
+		Connections: s.ServerStats.NumAliveClientConnections,
+		Outstanding: s.ServerStats.OutstandingRequests,
+		Epoch:       int32(s.ServerStats.LastProcessedZxid >> 32),
+		Counter:     int32(s.ServerStats.LastProcessedZxid & 0xFFFFFFFF),
+		Mode:        parseMode(s.ServerStats.ServerState),
+	}
+	// e.g. "3.5.8-f439ca5, built on 05/04/2020 15:07 GMT"
+	parts := strings.SplitN(s.Version, ", built on ", 2)
+	stats.Version = parts[0]
+	if len(parts) == 2 {
+		stats.BuildTime, _ = time.Parse("01/02/2006 15:04 MST", parts[1])
+	}
+	return stats
+}
+
+// Srvr returns the statistics of the servers, as FLWSrvr does.
+func (a *AdminClient) Srvr(servers []string) ([]*ServerStats, bool) {
+	ss := make([]*ServerStats, len(servers))
+	imOk := true
+	for i, server := range servers {
+		var resp adminServerStats
+		if err := a.get(server, "server_stats", &resp); err != nil {
+			ss[i] = &ServerStats{Error: err}
+			imOk = false
+			continue
+		}
+		stats := resp.serverStats()
+		ss[i] = &stats
+	}
+	return ss, imOk
+}
+
+// Stat returns the statistics of the servers with their clients, as FLWStat
+// does. The AdminServer tells more about the clients than the stat four
+// letter word: their fields are set as by Cons.
+func (a *AdminClient) Stat(servers []string) ([]*ServerStatus, bool) {
+	ss := make([]*ServerStatus, len(servers))
+	imOk := true
+	for i, server := range servers {
+		var resp adminServerStats
+		if err := a.get(server, "stats", &resp); err != nil {
+			ss[i] = &ServerStatus{ServerStats: ServerStats{Error: err}}
+			imOk = false
+			continue
+		}
+		ss[i] = &ServerStatus{
+			ServerStats: resp.serverStats(),
+			Clients:     serverClients(resp.Connections, resp.SecureConnections),
+		}
+	}
+	return ss, imOk
+}
+
+// Mntr returns the monitoring values of the servers, as FLWMntr does. The
+// keys of MonitorStats.Values have the zk_ prefix of the four letter word.
+func (a *AdminClient) Mntr(servers []string) ([]*MonitorStats, bool) {
+	ms := make([]*MonitorStats, len(servers))
+	imOk := true
+	for i, server := range servers {
+		var resp map[string]interface{}
+		if err := a.get(server, "monitor", &resp); err != nil {
+			ms[i] = &MonitorStats{Error: err}
+			imOk = false
+			continue
+		}
+
+		// Write the values as the mntr output, so that they are parsed the
+		// same way.
+		keys := make([]string, 0, len(resp))
+		for key := range resp {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var mntr bytes.Buffer
+		for _, key := range keys {
+			switch v := resp[key].(type) {
+			case string, json.Number, bool:
+				if key != "command" {
+					fmt.Fprintf(&mntr, "zk_%s\t%v\n", key, v)
+				}
+			}
+		}
+		ms[i] = parseMntr(mntr.Bytes())
+		if ms[i].Error != nil {
+			imOk = false
+		}
+	}
+	return ms, imOk
+}
+
+// adminConnection is a client in the response of the connections (cons)
+// and stats (stat) commands.
+type adminConnection struct {
+	RemoteSocketAddress string  `json:"remote_socket_address"`
+	OutstandingRequests int64   `json:"outstanding_requests"`
+	PacketsReceived     int64   `json:"packets_received"`
+	PacketsSent         int64   `json:"packets_sent"`
+	SessionID           int64   `json:"session_id"`
+	LastOperation       string  `json:"last_operation"`
+	Established         int64   `json:"established"`
+	SessionTimeout      int32   `json:"session_timeout"`
+	LastCxid            int64   `json:"last_cxid"`
+	LastZxid            int64   `json:"last_zxid"`
+	LastResponseTime    int64   `json:"last_response_time"`
+	LastLatency         float64 `json:"last_latency"`
+	MinLatency          float64 `json:"min_latency"`
+	AvgLatency          float64 `json:"avg_latency"`
+	MaxLatency          float64 `json:"max_latency"`
+}
+
+// serverClients converts the connections of the responses, in
+// milliseconds since the epoch for the times.
+func serverClients(conns ...[]adminConnection) []*ServerClient {
+	var clients []*ServerClient
+	for _, cs := range conns {
+		for _, c := range cs {
+			clients = append(clients, &ServerClient{
+				Queued:        c.OutstandingRequests,
+				Received:      c.PacketsReceived,
+				Sent:          c.PacketsSent,
+				SessionID:     c.SessionID,
+				Lcxid:         c.LastCxid,
+				Lzxid:         c.LastZxid,
+				Timeout:       c.SessionTimeout,
+				LastLatency:   int32(c.LastLatency),
+				MinLatency:    int32(c.MinLatency),
+				AvgLatency:    int32(c.AvgLatency),
+				MaxLatency:    int32(c.MaxLatency),
+				Established:   time.Unix(0, c.Established*int64(time.Millisecond)),
+				LastResponse:  time.Unix(0, c.LastResponseTime*int64(time.Millisecond)),
+				Addr:          strings.TrimPrefix(c.RemoteSocketAddress, "/"),
+				LastOperation: c.LastOperation,
+			})
+		}
+	}
+	return clients
+}
+
+// Cons returns the clients of the servers, as FLWCons does, both those
+// connected to the plaintext and to the secure client port.
+func (a *AdminClient) Cons(servers []string) ([]*ServerClients, bool) {
+	sc := make([]*ServerClients, len(servers))
+	imOk := true
+	for i, server := range servers {
+		var resp struct {
+			Connections       []adminConnection `json:"connections"`
+			SecureConnections []adminConnection `json:"secure_connections"`
+		}
+		if err := a.get(server, "connections", &resp); err != nil {
+			sc[i] = &ServerClients{Error: err}
+			imOk = false
+			continue
+		}
+		sc[i] = &ServerClients{Clients: serverClients(resp.Connections, resp.SecureConnections)}
+	}
+	return sc, imOk
+}
+
+// Wchs returns the numbers of watches of the servers, as FLWWchs does.
+func (a *AdminClient) Wchs(servers []string) ([]*WatchStats, bool) {
+	ws := make([]*WatchStats, len(servers))
+	imOk := true
+	for i, server := range servers {
+		var resp struct {
+			NumConnections  int64 `json:"num_connections"`
+			NumPaths        int64 `json:"num_paths"`
+			NumTotalWatches int64 `json:"num_total_watches"`
+		}
+		if err := a.get(server, "watch_summary", &resp); err != nil {
+			ws[i] = &WatchStats{Error: err}
+			imOk = false
+			continue
+		}
+		ws[i] = &WatchStats{
+			Connections: resp.NumConnections,
+			Paths:       resp.NumPaths,
+			Watches:     resp.NumTotalWatches,
+		}
+	}
+	return ws, imOk
+}
+
+// Leader returns the leader of the ensemble as seen by each server. The
+// leader command has no four letter word and needs ZooKeeper 3.6.
+func (a *AdminClient) Leader(servers []string) ([]*LeaderInfo, bool) {
+	li := make([]*LeaderInfo, len(servers))
+	imOk := true
+	for i, server := range servers {
+		var resp struct {
+			IsLeader bool   `json:"is_leader"`
+			LeaderID int64  `json:"leader_id"`
+			LeaderIP string `json:"leader_ip"`
+		}
+		if err := a.get(server, "leader", &resp); err != nil {
+			li[i] = &LeaderInfo{Error: err}
+			imOk = false
+			continue
+		}
+		li[i] = &LeaderInfo{IsLeader: resp.IsLeader, LeaderID: resp.LeaderID, LeaderIP: resp.LeaderIP}
+	}
+	return li, imOk
+}
+
+// adminURL returns the URL of command on server.
+func adminURL(server, command string) string {
+	if !strings.Contains(server, "://") {
+		if !strings.Contains(server, ":") {
+			server += ":" + strconv.Itoa(DefaultAdminPort)
+		}
+		server = "http://" + server
+	}
+	return strings.TrimSuffix(server, "/") + "/commands/" + command
+}
+
+// get runs command on server and decodes the response into v. The commands
+// that fail have the reason in the error field of the response.
+func (a *AdminClient) get(server, command string, v interface{}) error {
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx := context.Background()
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	url := adminURL(server, command)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Error *string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("zk: %s: %s", url, resp.Status)
+		}
+		return fmt.Errorf("zk: %s: %v", url, err)
+	}
+	if result.Error != nil {
+		return fmt.Errorf("zk: %s: %s", url, *result.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("zk: %s: %s", url, resp.Status)
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	return d.Decode(v)
+}
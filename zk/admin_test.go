@@ -0,0 +1,226 @@
+package zk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var adminResponses = map[string]string{
+	"ruok": `{"command": "ruok", "error": null}`,
+	"server_stats": `{
+  "version": "3.5.8-f439ca583e70862c3068a1f2a7d4d068eec33315, built on 05/04/2020 15:07 GMT",
+  "read_only": false,
+  "server_stats": {
+    "packets_sent": 4220,
+    "packets_received": 4207,
+    "last_processed_zxid": 73190248247,
+    "outstanding_requests": 1,
+    "server_state": "leader",
+    "avg_latency": 1,
+    "max_latency": 10,
+    "min_latency": 0,
+    "num_alive_client_connections": 81,
+    "uptime": 6790
+  },
+  "node_count": 306,
+  "command": "server_stats",
+  "error": null
+}`,
+	"stats": `{
+  "version": "3.5.8-f439ca583e70862c3068a1f2a7d4d068eec33315, built on 05/04/2020 15:07 GMT",
+  "server_stats": {"packets_sent": 4220, "server_state": "follower"},
+  "node_count": 306,
+  "connections": [{
+    "remote_socket_address": "/10.42.45.231:45361",
+    "outstanding_requests": 0,
+    "packets_received": 9435,
+    "packets_sent": 9457,
+    "session_id": 669956116721374901,
+    "last_operation": "PING",
+    "established": 1427238717217,
+    "session_timeout": 20001,
+    "last_cxid": 1427245333,
+    "last_zxid": -1,
+    "last_response_time": 1427259255908,
+    "last_latency": 0,
+    "min_latency": 0,
+    "avg_latency": 1,
+    "max_latency": 17
+  }],
+  "secure_connections": [{"remote_socket_address": "/10.55.33.98:34342", "packets_received": 9338}],
+  "command": "stats",
+  "error": null
+}`,
+	"monitor": `{
+  "version": "3.5.8-f439ca583e70862c3068a1f2a7d4d068eec33315, built on 05/04/2020 15:07 GMT",
+  "avg_latency": 0.5,
+  "max_latency": 10,
+  "min_latency": 0,
+  "packets_received": 4207,
+  "packets_sent": 4220,
+  "num_alive_connections": 81,
+  "outstanding_requests": 1,
+  "server_state": "leader",
+  "znode_count": 306,
+  "watch_count": 12,
+  "followers": 2,
+  "synced_followers": 1,
+  "pending_syncs": 0,
+  "last_proposal_size": -1,
+  "command": "monitor",
+  "error": null
+}`,
+	"watch_summary": `{"num_connections": 3, "num_paths": 2, "num_total_watches": 4, "command": "watch_summary", "error": null}`,
+	"leader":        `{"is_leader": false, "leader_id": 2, "leader_ip": "10.0.0.2", "command": "leader", "error": null}`,
+}
+
+func adminServer(serving bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		command := strings.TrimPrefix(r.URL.Path, "/commands/")
+		resp, ok := adminResponses[command]
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case !serving:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"command": "` + command + `", "error": "This ZooKeeper instance is not currently serving requests"}`))
+		default:
+			w.Write([]byte(resp))
+		}
+	}))
+}
+
+func TestAdminClient(t *testing.T) {
+	t.Parallel()
+	s := adminServer(true)
+	defer s.Close()
+	a := &AdminClient{Timeout: 10 * time.Second}
+	servers := []string{strings.TrimPrefix(s.URL, "http://")}
+
+	if oks := a.Ruok(servers); !oks[0] {
+		t.Errorf("server should be marked as OK")
+	}
+
+	ss, ok := a.Srvr(servers)
+	if !ok {
+		t.Fatalf("srvr failed: %v", ss[0].Error)
+	}
+	expected := ServerStats{
+		Sent:        4220,
+		Received:    4207,
+		NodeCount:   306,
+		MinLatency:  0,
+		AvgLatency:  1,
+		MaxLatency:  10,
+		Connections: 81,
+		Outstanding: 1,
+		Epoch:       17,
+		Counter:     175804215,
+		BuildTime:   time.Date(2020, 5, 4, 15, 7, 0, 0, time.UTC),
+		Mode:        ModeLeader,
+		Version:     "3.5.8-f439ca583e70862c3068a1f2a7d4d068eec33315",
+	}
+	if stats := *ss[0]; !stats.BuildTime.Equal(expected.BuildTime) {
+		t.Errorf("BuildTime = %v, expected %v", stats.BuildTime, expected.BuildTime)
+	} else if stats.BuildTime = expected.BuildTime; stats != expected {
+		t.Errorf("stats = %+v, expected %+v", stats, expected)
+	}
+
+	statuses, ok := a.Stat([]string{s.URL})
+	if !ok {
+		t.Fatalf("stat failed: %v", statuses[0].Error)
+	}
+	if status := statuses[0]; status.Mode != ModeFollower || status.Sent != 4220 || len(status.Clients) != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	ms, ok := a.Mntr(servers)
+	if !ok {
+		t.Fatalf("mntr failed: %v", ms[0].Error)
+	}
+	if m := ms[0]; m.Version != "3.5.8-f439ca583e70862c3068a1f2a7d4d068eec33315" || m.AvgLatency != 0.5 ||
+		m.ZnodeCount != 306 || m.Mode != ModeLeader || m.Followers != 2 || m.SyncedFollowers != 1 ||
+		m.Values["zk_last_proposal_size"] != "-1" {
+		t.Errorf("unexpected monitor stats %+v", m)
+	}
+
+	ws, ok := a.Wchs(servers)
+	if !ok {
+		t.Fatalf("wchs failed: %v", ws[0].Error)
+	}
+	if w := *ws[0]; w != (WatchStats{Connections: 3, Paths: 2, Watches: 4}) {
+		t.Errorf("watch stats = %+v", w)
+	}
+
+	li, ok := a.Leader(servers)
+	if !ok {
+		t.Fatalf("leader failed: %v", li[0].Error)
+	}
+	if l := *li[0]; l != (LeaderInfo{LeaderID: 2, LeaderIP: "10.0.0.2"}) {
+		t.Errorf("leader = %+v", l)
+	}
+}
+
+func TestAdminClientConnections(t *testing.T) {
+	t.Parallel()
+	// The connections command answers with the clients as the stats command
+	// does.
+	resp := adminResponses["stats"]
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resp))
+	}))
+	defer s.Close()
+
+	clients, ok := (&AdminClient{}).Cons([]string{s.URL})
+	if !ok {
+		t.Fatalf("cons failed: %v", clients[0].Error)
+	}
+	if n := len(clients[0].Clients); n != 2 {
+		t.Fatalf("%d clients, expected 2", n)
+	}
+	c := clients[0].Clients[0]
+	if c.Addr != "10.42.45.231:45361" || c.SessionID != 669956116721374901 || c.Lzxid != -1 ||
+		c.LastOperation != "PING" || c.Timeout != 20001 || c.MaxLatency != 17 {
+		t.Errorf("unexpected client %+v", c)
+	}
+	if est := time.Unix(1427238717, 217000000); !c.Established.Equal(est) {
+		t.Errorf("Established = %v, expected %v", c.Established, est)
+	}
+	if c := clients[0].Clients[1]; c.Addr != "10.55.33.98:34342" || c.Received != 9338 {
+		t.Errorf("unexpected secure client %+v", c)
+	}
+}
+
+func TestAdminClientErrors(t *testing.T) {
+	t.Parallel()
+	s := adminServer(false)
+	defer s.Close()
+	a := &AdminClient{Timeout: 10 * time.Second}
+
+	if oks := a.Ruok([]string{s.URL}); oks[0] {
+		t.Errorf("server not serving requests should not be marked as OK")
+	}
+	ms, ok := a.Mntr([]string{s.URL})
+	if ok || ms[0].Error == nil || !strings.Contains(ms[0].Error.Error(), "not currently serving") {
+		t.Errorf("mntr error = %v", ms[0].Error)
+	}
+	if _, ok := a.Srvr([]string{s.URL + "/missing"}); ok {
+		t.Errorf("no failure indicated for a missing command")
+	}
+}
+
+func TestAdminURL(t *testing.T) {
+	for server, url := range map[string]string{
+		"zk1":                "http://zk1:8080/commands/monitor",
+		"zk1:9090":           "http://zk1:9090/commands/monitor",
+		"https://zk1:8443/":  "https://zk1:8443/commands/monitor",
+		"http://zk1/zkadmin": "http://zk1/zkadmin/commands/monitor",
+	} {
+		if u := adminURL(server, "monitor"); u != url {
+			t.Errorf("adminURL(%q) = %q, expected %q", server, u, url)
+		}
+	}
+}
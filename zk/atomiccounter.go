@@ -0,0 +1,189 @@
+package zk
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCounterContention is returned by AtomicCounter.Add when the value kept
+// changing concurrently until the retry policy gave up.
+var ErrCounterContention = errors.New("zk: counter changed concurrently too often")
+
+// ErrBadCounter is returned by an AtomicCounter whose node holds data that is
+// not a counter value.
+var ErrBadCounter = errors.New("zk: node is not a counter")
+
+// defaultCounterRetry is the retry policy of an AtomicCounter unless set with
+// SetRetryPolicy.
+var defaultCounterRetry RetryPolicy = ExponentialBackoff{BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second, MaxRetries: 10}
+
+// AtomicCounter is a 64-bit counter shared through the data of a node, like
+// Curator's DistributedAtomicLong, whose encoding it uses: 8 bytes in big
+// endian order. Changes read the value and write it back with a version
+// check, and are retried when the node changed in between. A missing node
+// counts as 0 and is created by the first change.
+type AtomicCounter struct {
+	c     *Conn
+	path  string
+	acl   []ACL
+	retry RetryPolicy
+}
+
+// NewAtomicCounter creates a new counter instance using the provided
+// connection, path, and acl.
+func NewAtomicCounter(c *Conn, path string, acl []ACL) *AtomicCounter {
+	return &AtomicCounter{
+		c:     c,
+		path:  path,
+		acl:   acl,
+		retry: defaultCounterRetry,
+	}
+}
+
+// SetRetryPolicy sets how often and when a change is tried again after the
+// value changed concurrently. It defaults to 10 retries with exponential
+// backoff from 10ms.
+func (a *AtomicCounter) SetRetryPolicy(policy RetryPolicy) {
+	a.retry = policy
+}
+
+// Get returns the value of the counter.
+func (a *AtomicCounter) Get() (int64, error) {
+	data, _, err := a.c.Get(a.path)
+	if err == ErrNoNode {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return decodeCounter(data)
+}
+
+// Increment adds 1 to the counter and returns the new value.
+func (a *AtomicCounter) Increment() (int64, error) {
+	return a.Add(1)
+}
+
+// Decrement subtracts 1 from the counter and returns the new value.
+func (a *AtomicCounter) Decrement() (int64, error) {
+	return a.Add(-1)
+}
+
+// Add adds delta to the counter and returns the new value.
+func (a *AtomicCounter) Add(delta int64) (int64, error) {
+	start := time.Now()
+	for retries := 1; ; retries++ {
+		value, ok, err := a.add(delta)
+		if err != nil || ok {
+			return value, err
+		}
+		delay, ok := a.retry.AllowRetry(retries, time.Since(start))
+		if !ok {
+			return 0, ErrCounterContention
+		}
+		time.Sleep(delay)
+	}
+}
+
+// add tries to add delta to the counter once. It returns false if the node
+// changed concurrently.
+func (a *AtomicCounter) add(delta int64) (int64, bool, error) {
+	data, stat, err := a.c.Get(a.path)
+	if err == ErrNoNode {
+		_, err = a.c.Create(a.path, encodeCounter(delta), 0, a.acl)
+		if err == ErrNoNode {
+			if err := a.c.CreateParents(a.path, a.acl); err != nil {
+				return 0, false, err
+			}
+			_, err = a.c.Create(a.path, encodeCounter(delta), 0, a.acl)
+		}
+		if err == ErrNodeExists {
+			return 0, false, nil
+		}
+		return delta, err == nil, err
+	} else if err != nil {
+		return 0, false, err
+	}
+	value, err := decodeCounter(data)
+	if err != nil {
+		return 0, false, err
+	}
+	value += delta
+	if _, err := a.c.Set(a.path, encodeCounter(value), stat.Version); err == ErrBadVersion || err == ErrNoNode {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// Watch returns a channel receiving the value of the counter, first the
+// current one and then every new one, until ctx is done or the watch ends,
+// see Conn.WatchData, when it is closed. Only the latest value is kept for a
+// slow reader, and changes made in quick succession may be seen as one.
+func (a *AtomicCounter) Watch(ctx context.Context) (<-chan int64, error) {
+	ch := make(chan int64, 1)
+	var mu sync.Mutex
+	closed := false
+	closeCh := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+	w, err := a.c.WatchData(a.path, func(ev Event, data []byte, stat *Stat) {
+		if ev.Type == EventNotWatching {
+			closeCh()
+			return
+		}
+		var value int64
+		if stat != nil {
+			var err error
+			if value, err = decodeCounter(data); err != nil {
+				return
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- value
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.Stop()
+		case <-w.Done():
+		}
+		closeCh()
+	}()
+	return ch, nil
+}
+
+func encodeCounter(value int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(value))
+	return b
+}
+
+func decodeCounter(data []byte) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if len(data) != 8 {
+		return 0, ErrBadCounter
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
@@ -0,0 +1,110 @@
+package zk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAtomicCounterAdd(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	a := NewAtomicCounter(zk, "/counter", WorldACL(PermAll))
+	a.SetRetryPolicy(BoundedRetries{MaxRetries: 1})
+
+	type result struct {
+		value int64
+		err   error
+	}
+	done := make(chan result, 1)
+	add := func(delta int64) {
+		go func() {
+			v, err := a.Add(delta)
+			done <- result{v, err}
+		}()
+	}
+
+	// The node is created by the first change.
+	add(5)
+	serveFake(t, fc, "getData", "/counter", ErrNoNode, nil)
+	req := serveFake(t, fc, "create", "/counter", nil, &createResponse{Path: "/counter"})
+	if v, _ := decodeCounter(req.Body.(*CreateRequest).Data); v != 5 {
+		t.Fatalf("Node created with %d", v)
+	}
+	if r := <-done; r.value != 5 || r.err != nil {
+		t.Fatalf("Add returned %d, %+v", r.value, r.err)
+	}
+
+	// A concurrent change is retried.
+	add(1)
+	serveFake(t, fc, "getData", "/counter", nil, &getDataResponse{Data: encodeCounter(5), Stat: Stat{Version: 1}})
+	serveFake(t, fc, "setData", "/counter", ErrBadVersion, nil)
+	serveFake(t, fc, "getData", "/counter", nil, &getDataResponse{Data: encodeCounter(6), Stat: Stat{Version: 2}})
+	req = serveFake(t, fc, "setData", "/counter", nil, &setDataResponse{})
+	if r := req.Body.(*SetDataRequest); r.Version != 2 {
+		t.Fatalf("Set with version %d", r.Version)
+	} else if v, _ := decodeCounter(r.Data); v != 7 {
+		t.Fatalf("Set to %d", v)
+	}
+	if r := <-done; r.value != 7 || r.err != nil {
+		t.Fatalf("Add returned %d, %+v", r.value, r.err)
+	}
+
+	// Until the policy gives up.
+	add(1)
+	serveFake(t, fc, "getData", "/counter", nil, &getDataResponse{Data: encodeCounter(7), Stat: Stat{Version: 3}})
+	serveFake(t, fc, "setData", "/counter", ErrBadVersion, nil)
+	serveFake(t, fc, "getData", "/counter", nil, &getDataResponse{Data: encodeCounter(8), Stat: Stat{Version: 4}})
+	serveFake(t, fc, "setData", "/counter", ErrBadVersion, nil)
+	if r := <-done; r.err != ErrCounterContention {
+		t.Fatalf("Add returned %d, %+v", r.value, r.err)
+	}
+}
+
+func TestAtomicCounterWatch(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	a := NewAtomicCounter(zk, "/counter", WorldACL(PermAll))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := a.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(value int64) {
+		t.Helper()
+		select {
+		case v := <-ch:
+			if v != value {
+				t.Fatalf("Received %d, expected %d", v, value)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatalf("Did not receive %d", value)
+		}
+	}
+
+	serveFake(t, fc, "getData", "", nil, &getDataResponse{Data: encodeCounter(3)})
+	expect(3)
+	if err := fc.SendEvent(2, EventNodeDataChanged, "/counter"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "", nil, &getDataResponse{Data: encodeCounter(4)})
+	expect(4)
+
+	cancel()
+	serveFake(t, fc, "removeWatches", "", nil, &removeWatchesResponse{})
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("Received a value after cancel")
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Channel not closed")
+	}
+}
@@ -0,0 +1,82 @@
+package zk
+
+// authCreds are credentials added with AddAuth.
+type authCreds struct {
+	scheme string
+	auth   []byte
+}
+
+// WithReauthFailed returns a connection option that calls f when the server
+// rejects credentials added with AddAuth as they are applied again over a
+// new connection, with the error, usually ErrAuthFailed. The rejected
+// credentials are dropped so that the connection can proceed without them,
+// and f is the place to refresh them, e.g. an expired token, and add them
+// again with AddAuth. It is called from a goroutine of its own.
+func WithReauthFailed(f func(scheme string, auth []byte, err error)) connOption {
+	return func(c *Conn) {
+		c.reauthFailed = f
+	}
+}
+
+// Credentials returns the schemes and credentials added with AddAuth, which
+// are applied again over every new connection.
+func (c *Conn) Credentials() map[string][][]byte {
+	c.credsLock.Lock()
+	defer c.credsLock.Unlock()
+	creds := make(map[string][][]byte)
+	for _, cred := range c.creds {
+		creds[cred.scheme] = append(creds[cred.scheme], cred.auth)
+	}
+	return creds
+}
+
+// addCreds remembers credentials the server accepted, unless they are known
+// already.
+func (c *Conn) addCreds(scheme string, auth []byte) {
+	c.credsLock.Lock()
+	defer c.credsLock.Unlock()
+	for _, cred := range c.creds {
+		if cred.scheme == scheme && string(cred.auth) == string(auth) {
+			return
+		}
+	}
+	c.creds = append(c.creds, authCreds{scheme, auth})
+}
+
+func (c *Conn) removeCreds(scheme string, auth []byte) {
+	c.credsLock.Lock()
+	defer c.credsLock.Unlock()
+	for i, cred := range c.creds {
+		if cred.scheme == scheme && string(cred.auth) == string(auth) {
+			c.creds = append(c.creds[:i], c.creds[i+1:]...)
+			return
+		}
+	}
+}
+
+// reapplyAuth sends the credentials added with AddAuth over the freshly
+// authenticated connection, before any other request, as the server forgets
+// them with the connection. Credentials the server rejects are dropped and
+// reported to the WithReauthFailed callback, and the error is returned as
+// the server closes the connection after rejecting them.
+func (c *Conn) reapplyAuth() error {
+	c.credsLock.Lock()
+	creds := append([]authCreds(nil), c.creds...)
+	c.credsLock.Unlock()
+
+	for _, cred := range creds {
+		err := c.handshakeRoundTrip(opSetAuth, &setAuthRequest{Type: 0, Scheme: cred.scheme, Auth: cred.auth}, &setAuthResponse{})
+		if err == ErrAuthFailed {
+			c.logf(LogWarn, LogConnection, "Re-applying %s credentials failed: %s", cred.scheme, err)
+			c.removeCreds(cred.scheme, cred.auth)
+			c.setState(StateAuthFailed)
+			if c.reauthFailed != nil {
+				go c.reauthFailed(cred.scheme, cred.auth, err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReapplyAuth(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	type failure struct {
+		scheme string
+		auth   []byte
+		err    error
+	}
+	failed := make(chan failure, 1)
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()),
+		WithReauthFailed(func(scheme string, auth []byte, err error) { failed <- failure{scheme, auth, err} }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	done := make(chan error, 1)
+	go func() { done <- zk.AddAuth("digest", []byte("user:secret")) }()
+	req, err := fc.ExpectRequest("setAuth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("AddAuth returned error: %+v", err)
+	}
+	if creds := zk.Credentials(); !reflect.DeepEqual(creds, map[string][][]byte{"digest": {[]byte("user:secret")}}) {
+		t.Fatalf("Unexpected credentials %q", creds)
+	}
+
+	// The credentials are sent again before anything else on a new
+	// connection.
+	fc.Close()
+	fc = acceptFake(t, s, 1)
+	req, err = fc.ExpectRequest("setAuth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth := req.Body.(*setAuthRequest); auth.Scheme != "digest" || string(auth.Auth) != "user:secret" {
+		t.Fatalf("Unexpected setAuth request %+v", auth)
+	}
+	if err := fc.Reply(req, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, ch, StateHasSession)
+
+	// Rejected credentials are reported and dropped.
+	fc.Close()
+	fc = acceptFake(t, s, 1)
+	if req, err = fc.ExpectRequest("setAuth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, ErrAuthFailed, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case f := <-failed:
+		if f.scheme != "digest" || string(f.auth) != "user:secret" || f.err != ErrAuthFailed {
+			t.Fatalf("Unexpected failure %+v", f)
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Failure not reported")
+	}
+	fc = acceptFake(t, s, 1)
+	waitForState(t, ch, StateHasSession)
+	go func() {
+		_, _, err := zk.Get("/node")
+		done <- err
+	}()
+	if req, err = fc.ExpectRequest("getData"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, nil, &getDataResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if creds := zk.Credentials(); len(creds) != 0 {
+		t.Fatalf("Rejected credentials kept: %q", creds)
+	}
+}
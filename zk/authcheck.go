@@ -0,0 +1,217 @@
+package zk
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthCheckError is returned, before anything is sent, by a mutation that
+// the ACL of its target node would refuse to the auth identities of the
+// connection. It matches ErrNoAuth with errors.Is.
+type AuthCheckError struct {
+	// Path is the node whose ACL refuses the mutation: the parent for
+	// creates and deletes, the node itself otherwise.
+	Path string
+	// Perm is the permission the mutation needs, e.g. PermCreate.
+	Perm int32
+	ACL  []ACL
+}
+
+func (e *AuthCheckError) Error() string {
+	return fmt.Sprintf("zk: permission %d on %q not granted to the connection by ACL %v", e.Perm, e.Path, e.ACL)
+}
+
+// Is reports whether target is ErrNoAuth.
+func (e *AuthCheckError) Is(target error) bool {
+	return target == ErrNoAuth
+}
+
+// WithAuthPrecheck returns a connection option that checks mutations against
+// the ACL of their target before sending them, and fails those the ACL would
+// refuse with an AuthCheckError naming the ACL, for better errors than the
+// server's ErrNoAuth in locked-down trees. ACLs are read with GetACL and
+// cached for ttl, and the identities of the connection are asked for with
+// WhoAmI, or derived from the credentials added with AddAuth on servers
+// older than 3.7.
+//
+// The check is advisory: ACL entries of schemes it cannot evaluate locally
+// are assumed to match, and a target whose ACL cannot be read is not
+// checked. Super users are refused like other identities, so do not enable
+// it for connections authenticating as one.
+func WithAuthPrecheck(ttl time.Duration) connOption {
+	return func(c *Conn) {
+		c.authPrecheck = &authPrecheck{ttl: ttl, acls: make(map[string]cachedACL)}
+	}
+}
+
+type authPrecheck struct {
+	ttl time.Duration
+
+	mu              sync.Mutex
+	acls            map[string]cachedACL // by server path
+	identities      []ClientInfo
+	complete        bool  // identities were asked for, not derived
+	identitySession int64 // session the identities were asked for
+	identityCreds   int   // number of credentials when they were asked for
+}
+
+type cachedACL struct {
+	acl     []ACL
+	expires time.Time
+}
+
+// precheck checks a mutation, if it is one, before it is sent.
+func (c *Conn) precheck(opcode int32, req interface{}) error {
+	switch r := req.(type) {
+	case *CreateRequest:
+		return c.checkPerm(parentPath(r.Path), PermCreate)
+	case *createTTLRequest:
+		return c.checkPerm(parentPath(r.Path), PermCreate)
+	case *DeleteRequest:
+		return c.checkPerm(parentPath(r.Path), PermDelete)
+	case *SetDataRequest:
+		return c.checkPerm(r.Path, PermWrite)
+	case *setAclRequest:
+		err := c.checkPerm(r.Path, PermAdmin)
+		c.authPrecheck.mu.Lock()
+		delete(c.authPrecheck.acls, r.Path)
+		c.authPrecheck.mu.Unlock()
+		return err
+	case *multiRequest:
+		for _, op := range r.Ops {
+			if err := c.precheck(op.Header.Type, op.Op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPerm checks that the ACL of the node at path, as sent to the server,
+// grants perm to the connection.
+func (c *Conn) checkPerm(path string, perm int32) error {
+	acl, err := c.cachedACL(path)
+	if err != nil {
+		return nil
+	}
+	identities, complete, err := c.authIdentities()
+	if err != nil {
+		return nil
+	}
+	for _, a := range acl {
+		if a.Perms&perm != 0 && aclMatches(a, identities, complete) {
+			return nil
+		}
+	}
+	return &AuthCheckError{Path: c.clientPath(path), Perm: perm, ACL: acl}
+}
+
+func (c *Conn) cachedACL(path string) ([]ACL, error) {
+	p := c.authPrecheck
+	p.mu.Lock()
+	cached, ok := p.acls[path]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.acl, nil
+	}
+
+	res := &getAclResponse{}
+	if _, err := c.request(opGetAcl, &getAclRequest{Path: path}, res, nil); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.acls[path] = cachedACL{acl: res.Acl, expires: time.Now().Add(p.ttl)}
+	p.mu.Unlock()
+	return res.Acl, nil
+}
+
+// authIdentities returns the auth identities of the connection, asked for
+// again once the session or the credentials change. complete is false if
+// they were derived from the credentials, which only covers digest ones.
+func (c *Conn) authIdentities() (identities []ClientInfo, complete bool, err error) {
+	p := c.authPrecheck
+	sessionID := c.SessionID()
+	c.credsLock.Lock()
+	creds := append([]authCreds(nil), c.creds...)
+	c.credsLock.Unlock()
+	p.mu.Lock()
+	if p.identities != nil && p.identitySession == sessionID && p.identityCreds == len(creds) {
+		identities, complete = p.identities, p.complete
+		p.mu.Unlock()
+		return identities, complete, nil
+	}
+	p.mu.Unlock()
+
+	identities, err = c.WhoAmI()
+	complete = err == nil
+	if err == ErrUnimplemented {
+		identities, err = credsIdentities(creds), nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	p.mu.Lock()
+	p.identities, p.complete, p.identitySession, p.identityCreds = identities, complete, sessionID, len(creds)
+	p.mu.Unlock()
+	return identities, complete, nil
+}
+
+// credsIdentities derives the identities the server associates with digest
+// credentials, for servers without WhoAmI.
+func credsIdentities(creds []authCreds) []ClientInfo {
+	identities := []ClientInfo{}
+	for _, cred := range creds {
+		if cred.scheme != "digest" {
+			continue
+		}
+		user := strings.SplitN(string(cred.auth), ":", 2)[0]
+		h := sha1.Sum(cred.auth)
+		identities = append(identities, ClientInfo{AuthScheme: "digest", User: user + ":" + base64.StdEncoding.EncodeToString(h[:])})
+	}
+	return identities
+}
+
+// aclMatches reports whether the ACL entry a may match one of identities.
+// Entries of schemes that cannot be evaluated locally always match, which
+// is all but world and digest ones unless the identities are complete.
+func aclMatches(a ACL, identities []ClientInfo, complete bool) bool {
+	switch {
+	case a.Scheme == "world":
+		return a.ID == "anyone"
+	case a.Scheme == "digest" || complete && (a.Scheme == "sasl" || a.Scheme == "x509"):
+		for _, id := range identities {
+			if id.AuthScheme == a.Scheme && id.User == a.ID {
+				return true
+			}
+		}
+		return false
+	case a.Scheme == "ip" && complete:
+		for _, id := range identities {
+			if id.AuthScheme == "ip" && ipMatches(a.ID, id.User) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ipMatches reports whether addr is the address or in the network id of an
+// ip ACL entry, e.g. "10.0.0.0/8".
+func ipMatches(id, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if !strings.Contains(id, "/") {
+		other := net.ParseIP(id)
+		return other != nil && other.Equal(ip)
+	}
+	_, network, err := net.ParseCIDR(id)
+	return err == nil && network.Contains(ip)
+}
@@ -0,0 +1,129 @@
+package zk
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestACLMatches(t *testing.T) {
+	t.Parallel()
+	identities := []ClientInfo{
+		{AuthScheme: "ip", User: "10.1.2.3"},
+		{AuthScheme: "digest", User: "user:hash"},
+	}
+	tests := []struct {
+		acl      ACL
+		complete bool
+		matches  bool
+	}{
+		{ACL{Scheme: "world", ID: "anyone"}, true, true},
+		{ACL{Scheme: "digest", ID: "user:hash"}, true, true},
+		{ACL{Scheme: "digest", ID: "other:hash"}, true, false},
+		{ACL{Scheme: "digest", ID: "other:hash"}, false, false},
+		{ACL{Scheme: "ip", ID: "10.0.0.0/8"}, true, true},
+		{ACL{Scheme: "ip", ID: "10.1.2.3"}, true, true},
+		{ACL{Scheme: "ip", ID: "192.168.0.0/16"}, true, false},
+		{ACL{Scheme: "ip", ID: "192.168.0.0/16"}, false, true},
+		{ACL{Scheme: "sasl", ID: "user"}, true, false},
+		{ACL{Scheme: "sasl", ID: "user"}, false, true},
+		{ACL{Scheme: "custom", ID: "user"}, true, true},
+	}
+	for _, tt := range tests {
+		if matches := aclMatches(tt.acl, identities, tt.complete); matches != tt.matches {
+			t.Errorf("aclMatches(%+v, complete %t) = %t, expected %t", tt.acl, tt.complete, matches, tt.matches)
+		}
+	}
+}
+
+func TestCredsIdentities(t *testing.T) {
+	t.Parallel()
+	identities := credsIdentities([]authCreds{
+		{scheme: "digest", auth: []byte("user:password")},
+		{scheme: "custom", auth: []byte("token")},
+	})
+	acl := DigestACL(PermAll, "user", "password")[0]
+	if len(identities) != 1 || identities[0].AuthScheme != "digest" || identities[0].User != acl.ID {
+		t.Fatalf("Expected the identity of %s, got %+v", acl.ID, identities)
+	}
+}
+
+func TestAuthPrecheck(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithAuthPrecheck(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	// A write refused by the ACL of the node is not sent.
+	done := make(chan error, 1)
+	go func() {
+		_, err := zk.Set("/locked", []byte("data"), -1)
+		done <- err
+	}()
+	req, err := fc.ExpectRequest("getACL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := req.Body.(*getAclRequest).Path; p != "/locked" {
+		t.Fatalf("Expected the ACL of /locked, got %s", p)
+	}
+	acl := append(DigestACL(PermAll, "admin", "secret"), ACL{Perms: PermRead, Scheme: "world", ID: "anyone"})
+	if err := fc.Reply(req, 1, nil, &getAclResponse{Acl: acl}); err != nil {
+		t.Fatal(err)
+	}
+	if req, err = fc.ExpectRequest("whoAmI"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, nil, &whoAmIResponse{ClientInfo: []ClientInfo{{AuthScheme: "ip", User: "127.0.0.1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	var checkErr *AuthCheckError
+	if !errors.As(err, &checkErr) || !errors.Is(err, ErrNoAuth) {
+		t.Fatalf("Expected an AuthCheckError, got %+v", err)
+	}
+	if checkErr.Path != "/locked" || checkErr.Perm != PermWrite || len(checkErr.ACL) != 2 {
+		t.Fatalf("Unexpected error %+v", checkErr)
+	}
+
+	// A create permitted by the ACL of the parent is sent, and the cached
+	// ACL and identities are used for the next mutation.
+	go func() {
+		_, err := zk.Create("/open", nil, 0, WorldACL(PermAll))
+		done <- err
+	}()
+	if req, err = fc.ExpectRequest("getACL"); err != nil {
+		t.Fatal(err)
+	}
+	if p := req.Body.(*getAclRequest).Path; p != "/" {
+		t.Fatalf("Expected the ACL of /, got %s", p)
+	}
+	if err := fc.Reply(req, 2, nil, &getAclResponse{Acl: WorldACL(PermAll)}); err != nil {
+		t.Fatal(err)
+	}
+	if req, err = fc.ExpectRequest("create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 3, nil, &createResponse{Path: "/open"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	go func() { done <- zk.Delete("/open", -1) }()
+	if req, err = fc.ExpectRequest("delete"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 4, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package zk
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackupStorage stores the backups written by a BackupScheduler.
+type BackupStorage interface {
+	// Create returns a writer for a new backup called name. The backup is
+	// complete once the writer is closed.
+	Create(name string) (io.WriteCloser, error)
+	// List returns the names of the stored backups.
+	List() ([]string, error)
+	// Remove deletes the backup called name.
+	Remove(name string) error
+}
+
+// DirStorage is a BackupStorage keeping backups as files in a directory.
+type DirStorage string
+
+// Create creates the file name in the directory.
+func (d DirStorage) Create(name string) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(string(d), name))
+}
+
+// List returns the names of the backup files in the directory.
+func (d DirStorage) List() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(string(d), backupPrefix+"*"+backupSuffix))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = filepath.Base(p)
+	}
+	return names, nil
+}
+
+// Remove removes the file name from the directory.
+func (d DirStorage) Remove(name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+const (
+	backupPrefix = "backup-"
+	backupSuffix = ".json"
+	// backupTimeFormat sorts in chronological order.
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// BackupScheduler periodically exports subtrees with ExportJSON to a
+// BackupStorage. When several instances of an application run a scheduler
+// with the same lock path, they elect one of them through an ephemeral node
+// at that path and only it performs backups; another takes over if it dies.
+type BackupScheduler struct {
+	c        *Conn
+	lockPath string
+	acl      []ACL
+	storage  BackupStorage
+	interval time.Duration
+	paths    []string
+	retain   int
+	owner    OwnerInfo
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewBackupScheduler creates a scheduler that backs up the subtrees at paths
+// to storage every interval once started. lockPath is the node used to elect
+// the instance performing backups and acl its ACL.
+func NewBackupScheduler(c *Conn, lockPath string, acl []ACL, storage BackupStorage, interval time.Duration, paths ...string) *BackupScheduler {
+	return &BackupScheduler{
+		c:        c,
+		lockPath: lockPath,
+		acl:      acl,
+		storage:  storage,
+		interval: interval,
+		paths:    paths,
+		owner:    NewOwnerInfo(nil),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// SetRetention makes the scheduler keep only the n most recent backups. By
+// default all of them are kept.
+func (s *BackupScheduler) SetRetention(n int) {
+	s.retain = n
+}
+
+// SetOwner sets the owner info written into the lock node. It defaults to
+// NewOwnerInfo(nil).
+func (s *BackupScheduler) SetOwner(owner OwnerInfo) {
+	s.owner = owner
+}
+
+// Start starts backing up in the background.
+func (s *BackupScheduler) Start() {
+	go s.run()
+}
+
+// Stop stops a started scheduler once a backup in progress is done and gives
+// up the lock node.
+func (s *BackupScheduler) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+func (s *BackupScheduler) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	defer s.resign()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		leader, err := s.elect()
+		if err != nil {
+			s.c.logf(LogWarn, LogRecipes, "Backup election failed: %s", err)
+			continue
+		}
+		if !leader {
+			continue
+		}
+		if _, err := s.Backup(); err != nil {
+			s.c.logf(LogWarn, LogRecipes, "Backup failed: %s", err)
+		}
+	}
+}
+
+// Backup writes a backup right away, whether or not the instance is elected,
+// and applies the retention. It returns the name of the backup.
+func (s *BackupScheduler) Backup() (string, error) {
+	name := backupPrefix + time.Now().UTC().Format(backupTimeFormat) + backupSuffix
+	w, err := s.storage.Create(name)
+	if err != nil {
+		return "", err
+	}
+	err = s.c.ExportJSON(w, s.paths...)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.storage.Remove(name)
+		return "", err
+	}
+	return name, pruneBackups(s.storage, s.retain)
+}
+
+// elect reports whether the instance holds the lock node, creating it if
+// nobody does.
+func (s *BackupScheduler) elect() (bool, error) {
+	owner, err := s.owner.Marshal()
+	if err != nil {
+		return false, err
+	}
+	_, err = s.c.Create(s.lockPath, owner, FlagEphemeral, s.acl)
+	if err == ErrNoNode {
+		if err := s.c.CreateParents(s.lockPath, s.acl); err != nil {
+			return false, err
+		}
+		_, err = s.c.Create(s.lockPath, owner, FlagEphemeral, s.acl)
+	}
+	switch err {
+	case nil:
+		return true, nil
+	case ErrNodeExists:
+		_, stat, err := s.c.Exists(s.lockPath)
+		if err != nil {
+			return false, err
+		}
+		return stat.EphemeralOwner == s.c.SessionID(), nil
+	default:
+		return false, err
+	}
+}
+
+// resign deletes the lock node if the instance holds it.
+func (s *BackupScheduler) resign() {
+	ok, stat, err := s.c.Exists(s.lockPath)
+	if err == nil && ok && stat.EphemeralOwner == s.c.SessionID() {
+		s.c.Delete(s.lockPath, stat.Version)
+	}
+}
+
+// pruneBackups removes all but the retain most recent backups of storage. A
+// retain of zero or less keeps all of them.
+func pruneBackups(storage BackupStorage, retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+	names, err := storage.List()
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, name := range names {
+		if strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > retain {
+		if err := storage.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
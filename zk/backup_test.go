@@ -0,0 +1,142 @@
+package zk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPruneBackups(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := DirStorage(dir)
+
+	for _, name := range []string{
+		"backup-20260101T000000.000000000Z.json",
+		"backup-20260103T000000.000000000Z.json",
+		"backup-20260102T000000.000000000Z.json",
+		"other.json",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneBackups(storage, 0); err != nil {
+		t.Fatalf("pruneBackups returned error: %+v", err)
+	}
+	if names, _ := storage.List(); len(names) != 3 {
+		t.Fatalf("pruneBackups without retention removed backups, left %v", names)
+	}
+
+	if err := pruneBackups(storage, 2); err != nil {
+		t.Fatalf("pruneBackups returned error: %+v", err)
+	}
+	names, err := storage.List()
+	if err != nil {
+		t.Fatalf("List returned error: %+v", err)
+	}
+	expected := []string{"backup-20260102T000000.000000000Z.json", "backup-20260103T000000.000000000Z.json"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("pruneBackups left %v instead of %v", names, expected)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.json")); err != nil {
+		t.Fatalf("pruneBackups removed a file that is not a backup: %+v", err)
+	}
+}
+
+func TestBackupScheduler(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk1, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk1.Close()
+	zk2, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk2.Close()
+
+	if _, err := zk1.Create("/gozk-test-backup", []byte{1}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	if _, err := zk1.Create("/gozk-test-backup/a", []byte{2}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "gozk-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storages := []DirStorage{DirStorage(filepath.Join(dir, "1")), DirStorage(filepath.Join(dir, "2"))}
+	var schedulers []*BackupScheduler
+	for i, zk := range []*Conn{zk1, zk2} {
+		if err := os.Mkdir(string(storages[i]), 0700); err != nil {
+			t.Fatal(err)
+		}
+		s := NewBackupScheduler(zk, "/gozk-test-backups/lock", WorldACL(PermAll), storages[i], 50*time.Millisecond, "/gozk-test-backup")
+		s.SetRetention(2)
+		s.Start()
+		defer s.Stop()
+		schedulers = append(schedulers, s)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	var leader int
+	var backups []string
+	for i, storage := range storages {
+		names, err := storage.List()
+		if err != nil {
+			t.Fatalf("List returned error: %+v", err)
+		}
+		if len(names) > 0 {
+			if backups != nil {
+				t.Fatal("Both schedulers wrote backups")
+			}
+			leader, backups = i, names
+		}
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 retained backups got %v", backups)
+	}
+
+	f, err := os.Open(filepath.Join(string(storages[leader]), backups[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var export Export
+	if err := json.NewDecoder(f).Decode(&export); err != nil {
+		t.Fatalf("Backup is not a valid export: %+v", err)
+	}
+	if len(export.Nodes) != 2 || export.Nodes[0].Path != "/gozk-test-backup" || export.Nodes[1].Path != "/gozk-test-backup/a" || export.Nodes[1].Data[0] != 2 {
+		t.Fatalf("Backup does not contain the subtree: %+v", export.Nodes)
+	}
+
+	// The other instance takes over once the leader stops.
+	schedulers[leader].Stop()
+	other := storages[1-leader]
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if names, _ := other.List(); len(names) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Backups were not taken over by the other scheduler")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+package zk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const cacheStateVersion = 1
+
+// cacheState is the state of a TreeCache or PathChildrenCache written by
+// their SaveState methods.
+type cacheState struct {
+	Version int                  `json:"version"`
+	Path    string               `json:"path"`
+	Nodes   map[string]*NodeData `json:"nodes"` // by path, or by name for a PathChildrenCache
+}
+
+func writeCacheState(w io.Writer, path string, nodes map[string]*NodeData) error {
+	return json.NewEncoder(w).Encode(&cacheState{Version: cacheStateVersion, Path: path, Nodes: nodes})
+}
+
+// readCacheState reads the state written by writeCacheState for a cache of
+// path.
+func readCacheState(r io.Reader, path string) (map[string]*NodeData, error) {
+	var state cacheState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	if state.Version != cacheStateVersion {
+		return nil, fmt.Errorf("zk: unknown cache state version %d", state.Version)
+	}
+	if state.Path != path {
+		return nil, fmt.Errorf("zk: cache state of %s, not %s", state.Path, path)
+	}
+	for key, node := range state.Nodes {
+		if node == nil || node.Stat == nil {
+			return nil, fmt.Errorf("zk: cache state without the stat of %s", key)
+		}
+	}
+	return state.Nodes, nil
+}
+
+// restoredNode returns the node to cache for a node restored as old whose
+// stat is now stat: old with the new stat if it was not modified since, as
+// its czxid and mzxid tell, or nil if its data must be read again.
+func restoredNode(old *NodeData, stat *Stat) *NodeData {
+	node := &NodeData{Data: old.Data, Stat: stat}
+	if nodeChanged(old, node) {
+		return nil
+	}
+	return node
+}
@@ -0,0 +1,172 @@
+package zk
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// defaultCallbackWorkers is the number of goroutines running the callbacks of
+// WatchData unless set with WithCallbackWorkers.
+const defaultCallbackWorkers = 4
+
+// WithCallbackWorkers returns a connection option that sets the number of
+// goroutines running the callbacks of WatchData. The callbacks of a watch
+// always run on the same goroutine, in order, so a slow callback delays the
+// callbacks of the other watches sharing it.
+func WithCallbackWorkers(n int) connOption {
+	return func(c *Conn) {
+		c.callbackWorkers = n
+	}
+}
+
+// DataWatch is a watch set with WatchData.
+type DataWatch struct {
+	c      *Conn
+	path   string
+	cb     func(Event, []byte, *Stat)
+	queue  chan func()
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// WatchData calls cb with the data and stat of the node at path every time
+// it changes, until Stop is called. The watch is set again after every event,
+// reading the data in the same request, so changes made in between are
+// reported as one. cb is first called with an EventSession event and the
+// current data, and again so after a session expired, as changes made
+// meanwhile are not reported. While the node does not exist, data and stat
+// are nil and the watch waits for it to be created. If the watch cannot be
+// set, e.g. because of the ACL of the node, cb is called with an
+// EventNotWatching event carrying the error and the watch ends.
+//
+// The callbacks run on a pool of goroutines shared by the watches of the
+// connection, see WithCallbackWorkers, and those of one watch run in order.
+// They may call Stop.
+func (c *Conn) WatchData(path string, cb func(Event, []byte, *Stat)) (*DataWatch, error) {
+	if _, err := c.processPath(path, false); err != nil {
+		return nil, err
+	}
+	w := &DataWatch{c: c, path: path, cb: cb, queue: c.callbackQueue(), done: make(chan struct{})}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.run()
+	return w, nil
+}
+
+// Stop ends the watch and removes it from the server. No callback is started
+// once it returns.
+func (w *DataWatch) Stop() {
+	w.cancel()
+}
+
+// Done is closed once the watch ended, after Stop or an EventNotWatching
+// event.
+func (w *DataWatch) Done() <-chan struct{} {
+	return w.done
+}
+
+// callbackQueue returns the queue of the next callback goroutine, starting
+// them on first use.
+func (c *Conn) callbackQueue() chan func() {
+	c.callbackOnce.Do(func() {
+		n := c.callbackWorkers
+		if n <= 0 {
+			n = defaultCallbackWorkers
+		}
+		c.callbackQueues = make([]chan func(), n)
+		for i := range c.callbackQueues {
+			q := make(chan func())
+			c.callbackQueues[i] = q
+			go func() {
+				for {
+					select {
+					case f := <-q:
+						f()
+					case <-c.shouldQuit:
+						return
+					}
+				}
+			}()
+		}
+	})
+	n := atomic.AddUint32(&c.callbackNext, 1)
+	return c.callbackQueues[int(n)%len(c.callbackQueues)]
+}
+
+// call hands a callback to the watch's goroutine and reports whether the
+// watch is still running.
+func (w *DataWatch) call(ev Event, data []byte, stat *Stat) bool {
+	f := func() {
+		if w.ctx.Err() == nil {
+			w.cb(ev, data, stat)
+		}
+	}
+	select {
+	case w.queue <- f:
+	case <-w.ctx.Done():
+		return false
+	case <-w.c.shouldQuit:
+		return false
+	}
+	return w.ctx.Err() == nil
+}
+
+func (w *DataWatch) run() {
+	defer close(w.done)
+	trigger := Event{Type: EventSession, State: StateHasSession, Path: w.path}
+	for {
+		data, stat, ch, err := w.arm()
+		if err != nil {
+			if err != context.Canceled && err != ErrClosing {
+				w.call(Event{Type: EventNotWatching, State: StateDisconnected, Path: w.path, Err: err}, nil, nil)
+			}
+			return
+		}
+		trigger.Server = w.c.Server()
+		if !w.call(trigger, data, stat) {
+			w.c.RemoveWatches(w.path, ch)
+			return
+		}
+		select {
+		case trigger = <-ch:
+		case <-w.ctx.Done():
+			w.c.RemoveWatches(w.path, ch)
+			return
+		}
+		if trigger.Type == EventNotWatching {
+			// The session expired, start over in the new one.
+			trigger = Event{Type: EventSession, State: StateHasSession, Path: w.path}
+		}
+	}
+}
+
+// arm reads the node and sets the watch again, see armDataWatch.
+func (w *DataWatch) arm() ([]byte, *Stat, <-chan Event, error) {
+	return w.c.armDataWatch(w.ctx, w.path)
+}
+
+// armDataWatch reads the node at path and sets a watch on it: a data watch
+// if it exists, an exists watch otherwise. A lost connection is waited out
+// until ctx is done.
+func (c *Conn) armDataWatch(ctx context.Context, path string) ([]byte, *Stat, <-chan Event, error) {
+	for {
+		data, stat, ch, err := c.GetW(path)
+		if err == ErrNoNode {
+			var exists bool
+			exists, _, ch, err = c.ExistsW(path)
+			if err == nil && exists {
+				// Created in between, its stat is needed.
+				c.RemoveWatches(path, ch)
+				continue
+			} else if err == nil {
+				return nil, nil, ch, nil
+			}
+		}
+		if err == nil || !isConnectionError(err) {
+			return data, stat, ch, err
+		}
+		if err := c.WaitForSession(ctx); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchData(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	type call struct {
+		ev   Event
+		data []byte
+		stat *Stat
+	}
+	calls := make(chan call, 4)
+	w, err := zk.WatchData("/node", func(ev Event, data []byte, stat *Stat) {
+		calls <- call{ev, data, stat}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(typ EventType, data string, exists bool) {
+		t.Helper()
+		select {
+		case c := <-calls:
+			if c.ev.Type != typ || c.ev.Path != "/node" || string(c.data) != data || (c.stat != nil) != exists {
+				t.Fatalf("Callback called with %+v, %q, %+v", c.ev, c.data, c.stat)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatalf("Callback not called for %s", typ)
+		}
+	}
+
+	serveFake(t, fc, "getData", "/node", nil, &getDataResponse{Data: []byte("1"), Stat: Stat{Version: 1}})
+	expect(EventSession, "1", true)
+
+	if err := fc.SendEvent(2, EventNodeDataChanged, "/node"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/node", nil, &getDataResponse{Data: []byte("2"), Stat: Stat{Version: 2}})
+	expect(EventNodeDataChanged, "2", true)
+
+	if err := fc.SendEvent(3, EventNodeDeleted, "/node"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/node", ErrNoNode, nil)
+	serveFake(t, fc, "exists", "/node", ErrNoNode, nil)
+	expect(EventNodeDeleted, "", false)
+
+	// Stopping removes the exists watch from the server.
+	w.Stop()
+	serveFake(t, fc, "removeWatches", "/node", nil, &removeWatchesResponse{})
+	select {
+	case <-w.Done():
+	case <-time.After(fakeTimeout):
+		t.Fatal("Watch did not end")
+	}
+	select {
+	case c := <-calls:
+		t.Fatalf("Callback called after Stop with %+v", c.ev)
+	default:
+	}
+}
@@ -0,0 +1,117 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClientClosed is returned by a Client after Close was called.
+var ErrClientClosed = errors.New("zk: client is closed")
+
+// Client is a managed handle to an ensemble, in the spirit of database/sql's
+// DB. It connects lazily on first use and replaces its connection with a new
+// one once it has been closed or could not be established, so it can be
+// opened at startup and shared for the lifetime of an application.
+//
+// Session events of the underlying connections are discarded; use Connect
+// directly to observe them.
+type Client struct {
+	servers        []string
+	sessionTimeout time.Duration
+	options        []connOption
+
+	mu     sync.Mutex
+	conn   *Conn
+	done   chan struct{} // closed once the event channel of conn is closed
+	closed bool
+}
+
+// Open returns a Client for the given servers. No connection is made until
+// the client is first used. The servers, session timeout and options are
+// those of Connect, and are used again for every connection the client
+// opens.
+func Open(servers []string, sessionTimeout time.Duration, options ...connOption) (*Client, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("zk: server list must not be empty")
+	}
+	return &Client{
+		servers:        append([]string(nil), servers...),
+		sessionTimeout: sessionTimeout,
+		options:        options,
+	}, nil
+}
+
+// Conn returns the current connection of the client, connecting first if
+// there is none or the previous one was closed. The returned connection may
+// still be establishing its session. Closing it makes the client open a new
+// connection on next use.
+func (cl *Client) Conn() (*Conn, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.closed {
+		return nil, ErrClientClosed
+	}
+	if cl.conn != nil {
+		select {
+		case <-cl.done:
+		default:
+			return cl.conn, nil
+		}
+	}
+
+	conn, ec, err := Connect(cl.servers, cl.sessionTimeout, cl.options...)
+	if err != nil {
+		cl.conn = nil
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		for range ec {
+		}
+		close(done)
+	}()
+	cl.conn = conn
+	cl.done = done
+	return conn, nil
+}
+
+// Ping checks that the client can reach the ensemble and that its session
+// serves requests, connecting first if necessary. It returns ctx.Err() if
+// ctx is done before the server responds.
+func (cl *Client) Ping(ctx context.Context) error {
+	conn, err := cl.Conn()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := conn.Exists("/")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the current connection of the client and waits for it to shut
+// down. Afterwards the client can no longer be used.
+func (cl *Client) Close() error {
+	cl.mu.Lock()
+	conn, done := cl.conn, cl.done
+	cl.conn = nil
+	cl.closed = true
+	cl.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+		<-done
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientLifecycle(t *testing.T) {
+	t.Parallel()
+	var dials int32
+	dialer := func(network, address string, timeout time.Duration) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return nil, errors.New("refused")
+	}
+
+	cl, err := Open([]string{"127.0.0.1:2181"}, time.Second, WithDialer(dialer))
+	if err != nil {
+		t.Fatalf("Open returned error: %+v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 0 {
+		t.Fatalf("Open dialed %d times", n)
+	}
+
+	c1, err := cl.Conn()
+	if err != nil {
+		t.Fatalf("Conn returned error: %+v", err)
+	}
+	if c, _ := cl.Conn(); c != c1 {
+		t.Fatal("Conn returned a new connection while the first one is open")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cl.Ping(ctx); err == nil {
+		t.Fatalf("Ping of an unreachable ensemble returned %v", err)
+	}
+
+	c1.Close()
+	c1.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := cl.Conn()
+		if err != nil {
+			t.Fatalf("Conn returned error: %+v", err)
+		}
+		if c != c1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Conn did not replace the closed connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := cl.Close(); err != nil {
+		t.Fatalf("Close returned error: %+v", err)
+	}
+	if _, err := cl.Conn(); err != ErrClientClosed {
+		t.Fatalf("Conn after Close returned %v instead of ErrClientClosed", err)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatalf("second Close returned error: %+v", err)
+	}
+}
+
+func TestOpenWithoutServers(t *testing.T) {
+	t.Parallel()
+	if _, err := Open(nil, time.Second); err == nil {
+		t.Fatal("Open without servers did not fail")
+	}
+}
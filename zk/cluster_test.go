@@ -1,6 +1,12 @@
 package zk
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -127,6 +133,7 @@ func TestNoQuorum(t *testing.T) {
 	// ZooKeeper server, but the attempts are being dropped because there is
 	// no quorum.
 	DefaultLogger.Printf("    Retrying no luck...")
+	tc.ResetConnectionAttempts()
 	var firstDisconnect *Event
 	begin := time.Now()
 	for time.Now().Sub(begin) < 6*time.Second {
@@ -144,6 +151,15 @@ func TestNoQuorum(t *testing.T) {
 		}
 	}
 
+	// Make sure the client does not hammer the remaining server while the
+	// ensemble has no quorum.
+	elapsed := time.Now().Sub(begin)
+	maxAttempts := int(elapsed/time.Second)*2 + 2
+	if attempts := tc.ConnectionAttempts(firstDisconnect.Server); attempts > maxAttempts {
+		t.Fatalf("Too many connection attempts to %s: %d in %s (max %d)",
+			firstDisconnect.Server, attempts, elapsed, maxAttempts)
+	}
+
 	// Start a ZooKeeper node to restore quorum.
 	hasSessionWatcher3 := sl.NewWatcher(sessionStateMatcher(StateHasSession))
 	tc.StartServer(hasSessionEvent1.Server)
@@ -292,3 +308,136 @@ func sessionStateMatcher(s State) func(Event) bool {
 		return e.Type == EventSession && e.State == s
 	}
 }
+
+func TestServerDataFiles(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "version-2"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"log.a", "log.2", "snapshot.1", "snapshot.10"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "version-2", name), make([]byte, 100), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc := &TestCluster{Servers: []TestServer{{Port: 2181, Path: dir}}}
+
+	snapshots, err := tc.Snapshots("127.0.0.1:2181")
+	if err != nil {
+		t.Fatalf("Snapshots returned error: %+v", err)
+	}
+	if len(snapshots) != 2 || filepath.Base(snapshots[0]) != "snapshot.1" || filepath.Base(snapshots[1]) != "snapshot.10" {
+		t.Fatalf("Snapshots returned %v", snapshots)
+	}
+
+	if err := tc.TruncateTxnLog("127.0.0.1:2181", 10); err != nil {
+		t.Fatalf("TruncateTxnLog returned error: %+v", err)
+	}
+	for name, size := range map[string]int64{"log.a": 10, "log.2": 100} {
+		if fi, err := os.Stat(filepath.Join(dir, "version-2", name)); err != nil {
+			t.Fatal(err)
+		} else if fi.Size() != size {
+			t.Fatalf("%s has size %d instead of %d", name, fi.Size(), size)
+		}
+	}
+
+	if err := tc.DeleteServerData("127.0.0.1:2181"); err != nil {
+		t.Fatalf("DeleteServerData returned error: %+v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "version-2")); !os.IsNotExist(err) {
+		t.Fatalf("Data not deleted: %v", err)
+	}
+	if err := tc.TruncateTxnLog("127.0.0.1:2181", 0); err == nil {
+		t.Fatal("TruncateTxnLog without a log returned no error")
+	}
+}
+
+func TestServerDataLoss(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	server := fmt.Sprintf("127.0.0.1:%d", ts.Servers[0].Port)
+	zk, err := ts.Connect(0)
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	if _, err := zk.Create("/gozk-test-data-loss", nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	zk.Close()
+
+	before, err := ts.Snapshots(server)
+	if err != nil {
+		t.Fatalf("Snapshots returned error: %+v", err)
+	}
+	if err := ts.ForceSnapshot(server); err != nil {
+		t.Fatalf("ForceSnapshot returned error: %+v", err)
+	}
+	if after, err := ts.Snapshots(server); err != nil {
+		t.Fatalf("Snapshots returned error: %+v", err)
+	} else if len(after) <= len(before) {
+		t.Fatalf("No new snapshot: %v before, %v after", before, after)
+	}
+
+	ts.StopServer(server)
+	if err := ts.DeleteServerData(server); err != nil {
+		t.Fatalf("DeleteServerData returned error: %+v", err)
+	}
+	ts.StartServer(server)
+	if err := ts.waitForStart(10, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	zk, err = ts.Connect(0)
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+	if ok, _, err := zk.Exists("/gozk-test-data-loss"); err != nil {
+		t.Fatalf("Exists returned error: %+v", err)
+	} else if ok {
+		t.Fatal("Node survived the loss of the server data")
+	}
+}
+
+func TestServerAccessors(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	s := ts.Server(ts.Servers[0].Addr())
+	if s.Path != ts.Servers[0].Path || s.AdminPort == 0 || s.PeerPort == 0 || s.LeaderElectionPort == 0 {
+		t.Fatalf("Unexpected server %+v", s)
+	}
+
+	cfg, err := ioutil.ReadFile(s.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(cfg), fmt.Sprintf("clientPort=%d\n", s.Port)) || !strings.Contains(string(cfg), fmt.Sprintf("admin.serverPort=%d\n", s.AdminPort)) {
+		t.Fatalf("Unexpected config\n%s", cfg)
+	}
+	if ok := FLWRuok([]string{s.Addr()}, time.Second); !ok[0] {
+		t.Fatal("Server did not answer ruok on its client address")
+	}
+
+	r, err := ts.ServerLog(s.Addr())
+	if err != nil {
+		t.Fatalf("ServerLog returned error: %+v", err)
+	}
+	defer r.Close()
+	log, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), strconv.Itoa(s.Port)) {
+		t.Fatalf("Server log does not mention the client port:\n%s", log)
+	}
+}
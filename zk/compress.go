@@ -0,0 +1,94 @@
+package zk
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+	"time"
+)
+
+// Compressor is used to transparently compress the whole stream between the
+// client and the server. The remote end, usually a proxy or sidecar in front
+// of the ensemble, must use the same compression.
+type Compressor interface {
+	// NewWriter returns a writer that compresses into w. Flush must write
+	// all pending compressed data to w.
+	NewWriter(w io.Writer) (CompressWriter, error)
+	// NewReader returns a reader that decompresses from r.
+	NewReader(r io.Reader) (io.Reader, error)
+}
+
+// CompressWriter is a writer returned by a Compressor.
+type CompressWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// FlateCompressor is a Compressor using the DEFLATE format with the given
+// compression level (see compress/flate).
+type FlateCompressor struct {
+	Level int
+}
+
+// NewWriter returns a flate writer compressing into w.
+func (fc FlateCompressor) NewWriter(w io.Writer) (CompressWriter, error) {
+	return flate.NewWriter(w, fc.Level)
+}
+
+// NewReader returns a flate reader decompressing from r.
+func (fc FlateCompressor) NewReader(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+// WithCompression returns a connection option that compresses the connection
+// to the server using compressor. It wraps the connections returned by the
+// configured Dialer.
+func WithCompression(compressor Compressor) connOption {
+	return func(c *Conn) {
+		c.compressor = compressor
+	}
+}
+
+// CompressedDialer returns a Dialer that wraps the connections returned by
+// dialer so that everything written to and read from them is compressed
+// using compressor.
+func CompressedDialer(dialer Dialer, compressor Compressor) Dialer {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		conn, err := dialer(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		w, err := compressor.NewWriter(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &compressedConn{Conn: conn, compressor: compressor, w: w}, nil
+	}
+}
+
+type compressedConn struct {
+	net.Conn
+	compressor Compressor
+	w          CompressWriter
+	r          io.Reader
+}
+
+func (cc *compressedConn) Read(p []byte) (int, error) {
+	if cc.r == nil {
+		r, err := cc.compressor.NewReader(cc.Conn)
+		if err != nil {
+			return 0, err
+		}
+		cc.r = r
+	}
+	return cc.r.Read(p)
+}
+
+func (cc *compressedConn) Write(p []byte) (int, error) {
+	n, err := cc.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, cc.w.Flush()
+}
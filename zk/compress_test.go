@@ -0,0 +1,53 @@
+package zk
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompressedDialer(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	compressor := FlateCompressor{Level: flate.BestSpeed}
+	dialer := CompressedDialer(func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return client, nil
+	}, compressor)
+	conn, err := dialer("tcp", "127.0.0.1:2181", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Echo everything back through a compressing peer.
+	go func() {
+		r, _ := compressor.NewReader(server)
+		w, _ := compressor.NewWriter(server)
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			w.Write(buf[:n])
+			w.Flush()
+		}
+	}()
+
+	msg := bytes.Repeat([]byte("zookeeper"), 5)
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("Write returned error: %+v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Read returned error: %+v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Expected %q instead of %q", msg, got)
+	}
+}
@@ -0,0 +1,228 @@
+package zk
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigPath is the node holding the dynamic configuration of the ensemble.
+const ConfigPath = "/zookeeper/config"
+
+// QuorumServer is a member of the ensemble as listed in its dynamic
+// configuration.
+type QuorumServer struct {
+	ID                 int
+	Host               string
+	PeerPort           int
+	LeaderElectionPort int
+	// Role is "participant" or "observer".
+	Role string
+	// ClientAddr is the host:port clients connect to, or empty if the server
+	// does not accept client connections.
+	ClientAddr string
+}
+
+// QuorumConfig is the dynamic configuration of the ensemble.
+type QuorumConfig struct {
+	Servers []QuorumServer
+	// Version is the zxid of the configuration.
+	Version int64
+}
+
+// ClientAddrs returns the addresses clients can connect to, sorted.
+func (qc *QuorumConfig) ClientAddrs() []string {
+	var addrs []string
+	for _, s := range qc.Servers {
+		if s.ClientAddr != "" {
+			addrs = append(addrs, s.ClientAddr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// ParseQuorumConfig parses the data of ConfigPath, e.g.
+//
+//	server.1=10.0.0.1:2888:3888:participant;0.0.0.0:2181
+//	version=100000000
+//
+// Keys other than server.N and version are ignored. A wildcard or missing
+// client host is replaced by the host of the server.
+func ParseQuorumConfig(data []byte) (*QuorumConfig, error) {
+	qc := &QuorumConfig{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("zk: malformed config line %q", line)
+		}
+		key, value := kv[0], kv[1]
+		switch {
+		case key == "version":
+			v, err := strconv.ParseInt(value, 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("zk: malformed config version %q", value)
+			}
+			qc.Version = v
+		case strings.HasPrefix(key, "server."):
+			id, err := strconv.Atoi(strings.TrimPrefix(key, "server."))
+			if err != nil {
+				return nil, fmt.Errorf("zk: malformed config key %q", key)
+			}
+			s, err := parseQuorumServer(value)
+			if err != nil {
+				return nil, err
+			}
+			s.ID = id
+			qc.Servers = append(qc.Servers, s)
+		}
+	}
+	return qc, nil
+}
+
+// parseQuorumServer parses host:peerPort:electionPort[:role][;[clientHost:]clientPort].
+func parseQuorumServer(value string) (QuorumServer, error) {
+	s := QuorumServer{Role: "participant"}
+	server, client := value, ""
+	if i := strings.Index(value, ";"); i >= 0 {
+		server, client = value[:i], value[i+1:]
+	}
+
+	rest := server
+	if strings.HasPrefix(rest, "[") {
+		i := strings.Index(rest, "]")
+		if i < 0 {
+			return s, fmt.Errorf("zk: malformed config server %q", value)
+		}
+		s.Host, rest = rest[1:i], strings.TrimPrefix(rest[i+1:], ":")
+	} else if i := strings.Index(rest, ":"); i >= 0 {
+		s.Host, rest = rest[:i], rest[i+1:]
+	}
+	parts := strings.Split(rest, ":")
+	if s.Host == "" || len(parts) < 2 || len(parts) > 3 {
+		return s, fmt.Errorf("zk: malformed config server %q", value)
+	}
+	var err error
+	if s.PeerPort, err = strconv.Atoi(parts[0]); err != nil {
+		return s, fmt.Errorf("zk: malformed config server %q", value)
+	}
+	if s.LeaderElectionPort, err = strconv.Atoi(parts[1]); err != nil {
+		return s, fmt.Errorf("zk: malformed config server %q", value)
+	}
+	if len(parts) == 3 {
+		s.Role = parts[2]
+	}
+
+	if client != "" {
+		host, port := "", client
+		if i := strings.LastIndex(client, ":"); i >= 0 {
+			host, port = strings.Trim(client[:i], "[]"), client[i+1:]
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return s, fmt.Errorf("zk: malformed config client address %q", client)
+		}
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			host = s.Host
+		}
+		s.ClientAddr = net.JoinHostPort(host, port)
+	}
+	return s, nil
+}
+
+// GetConfig returns the dynamic configuration of the ensemble. Like the Java
+// client it reads ConfigPath, which requires ZooKeeper 3.5 or later.
+func (c *Conn) GetConfig() (*QuorumConfig, *Stat, error) {
+	qc, stat, _, err := c.getConfig(false)
+	return qc, stat, err
+}
+
+// GetConfigW is like GetConfig and also sets a watch that fires when the
+// configuration changes, e.g. after a reconfig adds or removes servers.
+func (c *Conn) GetConfigW() (*QuorumConfig, *Stat, <-chan Event, error) {
+	return c.getConfig(true)
+}
+
+// getConfig reads ConfigPath directly rather than through Get, as it is not
+// under the chroot.
+func (c *Conn) getConfig(watch bool) (*QuorumConfig, *Stat, <-chan Event, error) {
+	var ech <-chan Event
+	res := &getDataResponse{}
+	_, err := c.request(opGetData, &getDataRequest{Path: ConfigPath, Watch: watch}, res, func(req *request, res *responseHeader, err error) {
+		if err == nil && watch {
+			ech = c.addWatcher(ConfigPath, watchTypeData)
+		}
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	qc, err := ParseQuorumConfig(res.Data)
+	return qc, &res.Stat, ech, err
+}
+
+// WithConfigHostList returns a connection option that watches the dynamic
+// configuration of the ensemble and updates the HostProvider with the client
+// addresses of its members whenever they change, so that servers
+// added by a reconfig are used and removed ones are not.
+func WithConfigHostList() connOption {
+	return func(c *Conn) {
+		c.followConfig = true
+	}
+}
+
+// followConfigHostList keeps the host list in sync with the dynamic
+// configuration until the connection is closed.
+func (c *Conn) followConfigHostList() {
+	var current []string
+	for {
+		qc, _, ch, err := c.GetConfigW()
+		switch {
+		case err == ErrNoNode:
+			c.logf(LogWarn, LogConnection, "Not following the ensemble configuration: %s does not exist", ConfigPath)
+			return
+		case err != nil && ch == nil:
+			// The request failed, e.g. because the connection was lost.
+			select {
+			case <-c.shouldQuit:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		case err != nil:
+			c.logf(LogWarn, LogConnection, "Failed to parse the ensemble configuration: %s", err)
+		default:
+			addrs := qc.ClientAddrs()
+			if len(addrs) > 0 && !stringsEqual(addrs, current) {
+				if err := c.updateHostList(addrs); err != nil {
+					c.logf(LogWarn, LogConnection, "Failed to update the host list to %v: %s", addrs, err)
+				} else {
+					current = addrs
+				}
+			}
+		}
+
+		select {
+		case <-c.shouldQuit:
+			return
+		case <-ch:
+		}
+	}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
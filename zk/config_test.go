@@ -0,0 +1,50 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuorumConfig(t *testing.T) {
+	t.Parallel()
+	data := []byte(`server.1=10.0.0.1:2888:3888:participant;0.0.0.0:2181
+server.2=10.0.0.2:2888:3888:observer;10.0.1.2:2182
+server.3=[::1]:2888:3888;2183
+server.4=10.0.0.4:2888:3888
+group.1=1:2:3
+version=10000000a
+`)
+	qc, err := ParseQuorumConfig(data)
+	if err != nil {
+		t.Fatalf("ParseQuorumConfig returned error: %+v", err)
+	}
+	expected := &QuorumConfig{
+		Servers: []QuorumServer{
+			{1, "10.0.0.1", 2888, 3888, "participant", "10.0.0.1:2181"},
+			{2, "10.0.0.2", 2888, 3888, "observer", "10.0.1.2:2182"},
+			{3, "::1", 2888, 3888, "participant", "[::1]:2183"},
+			{4, "10.0.0.4", 2888, 3888, "participant", ""},
+		},
+		Version: 0x10000000a,
+	}
+	if !reflect.DeepEqual(qc, expected) {
+		t.Fatalf("ParseQuorumConfig returned %+v instead of %+v", qc, expected)
+	}
+	if addrs := qc.ClientAddrs(); !reflect.DeepEqual(addrs, []string{"10.0.0.1:2181", "10.0.1.2:2182", "[::1]:2183"}) {
+		t.Fatalf("ClientAddrs returned %v", addrs)
+	}
+
+	for _, data := range []string{
+		"server",
+		"server.x=10.0.0.1:2888:3888",
+		"server.1=10.0.0.1:2888",
+		"server.1=10.0.0.1:x:3888",
+		"server.1=10.0.0.1:2888:3888;host:x",
+		"server.1=[::1:2888:3888",
+		"version=xyz",
+	} {
+		if _, err := ParseQuorumConfig([]byte(data)); err == nil {
+			t.Errorf("ParseQuorumConfig accepted %q", data)
+		}
+	}
+}
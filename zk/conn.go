@@ -11,6 +11,7 @@ Possible watcher events:
 
 import (
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -27,6 +28,14 @@ import (
 // because attempts to connect to all servers in the list failed.
 var ErrNoServer = errors.New("zk: could not connect to a server")
 
+// ErrSessionEstablishmentTimeout indicates that no session could be
+// established within the timeout given with WithSessionEstablishmentTimeout.
+var ErrSessionEstablishmentTimeout = errors.New("zk: timed out establishing a session")
+
+// ErrDataTooLarge indicates that the data of a node is larger than the
+// maximum size accepted by the client.
+var ErrDataTooLarge = errors.New("zk: data too large")
+
 // ErrInvalidPath indicates that an operation was being attempted on
 // an invalid path. (e.g. empty path)
 var ErrInvalidPath = errors.New("zk: invalid path")
@@ -34,6 +43,16 @@ var ErrInvalidPath = errors.New("zk: invalid path")
 // DefaultLogger uses the stdlib log package for logging.
 var DefaultLogger Logger = defaultLogger{}
 
+// DefaultMaxDataSize is the default maximum size of node data sent by the
+// client. It is the server's default jute.maxbuffer of 1MB minus room for
+// the rest of the request.
+const DefaultMaxDataSize = 1024*1024 - 16*1024
+
+// DefaultSetWatchesSize is the default maximum size of the paths in a request
+// setting the watches of the session again after a reconnect, the same as
+// the Java client's.
+const DefaultSetWatchesSize = 128 * 1024
+
 const (
 	bufferSize      = 1536 * 1024
 	eventChanSize   = 6
@@ -47,6 +66,10 @@ const (
 	watchTypeData  = iota
 	watchTypeExist = iota
 	watchTypeChild = iota
+	watchTypePersistent
+	watchTypePersistentRecursive
+
+	numWatchTypes = iota
 )
 
 type watchPathType struct {
@@ -64,32 +87,124 @@ type Logger interface {
 type Conn struct {
 	lastZxid         int64
 	sessionID        int64
+	pingSentAt       int64 // unix nanoseconds of the oldest unanswered ping, or 0
+	pingRTT          int64 // smoothed ping round trip time
+	pingLastRTT      int64
+	pingsSent        uint64
+	pingsMissed      uint64
+	droppedEvents    uint64 // events dropped by full buffers
+	pingsMissedInRow int32
 	state            State // must be 32-bit aligned
 	xid              uint32
-	sessionTimeoutMs int32 // session timeout in milliseconds
-	passwd           []byte
+	sessionTimeoutMs int32  // session timeout in milliseconds
+	passwd           []byte // protected by sessionLock
+	sessionLock      sync.Mutex
+	seenRWServer     bool // a session was established with a read-write server
 
 	dialer         Dialer
+	flwDialer      Dialer   // dialer without the compression, for the four letter words
+	servers        []string // configured servers, with default ports added
 	hostProvider   HostProvider
-	serverMu       sync.Mutex // protects server
+	serverMu       sync.Mutex // protects server, servers, modeServer, mode, version, moveTo and moveErr
 	server         string     // remember the address/port of the current server
+	modeServer     string     // server whose mode and version were last asked for
+	mode           Mode
+	version        string
+	moveTo         string // server to connect to next, set by the latency eviction
+	moveErr        error  // why the session moves to moveTo
 	conn           net.Conn
 	eventChan      chan Event
+	eventLock      sync.Mutex // serializes sends on eventChan
+	eventPending   uint64     // events dropped since the last EventWatcherOverflow on eventChan
 	shouldQuit     chan struct{}
-	pingInterval   time.Duration
+	closeOnce      sync.Once     // closes shouldQuit
+	done           chan struct{} // closed once the loop exited and requests were flushed
 	recvTimeout    time.Duration
 	connectTimeout time.Duration
 
+	proxy                ProxyFunc
+	tlsConfig            *tls.Config
+	getClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+	compressor           Compressor
+	saslMechanism        SASLMechanism
+	normalizePaths       bool
+	maxDataSize          int
+	setWatchesSize       int
+	traceSelectors       []TraceSelector
+	opTracer             OperationTracer
+	slowThreshold        time.Duration // requests slower than it are logged
+	wireTrace            bool
+	wirePayloads         bool
+	shadow               *shadow
+	reauthFailed         func(scheme string, auth []byte, err error)
+	authPrecheck         *authPrecheck
+	eviction             *latencyEviction
+	callbackWorkers      int
+	eventBuffer          int
+	eventPolicy          OverflowPolicy
+	watchBuffer          int
+	watchPolicy          OverflowPolicy
+	followConfig         bool
+	canBeReadOnly        bool
+	chroot               string
+	aclPolicy            *ACLPolicy
+
+	credsLock sync.Mutex
+	creds     []authCreds // credentials added with AddAuth
+
+	establishTimeout time.Duration
+	established      chan struct{} // closed once the first session is established
+	establishedOnce  sync.Once
+
+	stateChangedLock sync.Mutex
+	stateChanged     chan struct{} // closed and replaced on every state change
+
 	sendChan     chan *request
 	requests     map[int32]*request // Xid -> pending request
 	requestsLock sync.Mutex
 	watchers     map[watchPathType][]chan Event
 	watchersLock sync.Mutex
 
+	persistentWatchers map[watchPathType][]*persistentWatcher // protected by watchersLock
+	emulatedWatches    map[<-chan Event]*emulatedWatch        // protected by watchersLock
+	watcherCounts      [numWatchTypes]int                     // by watchType, protected by watchersLock
+
+	subscribersLock   sync.Mutex
+	subscribers       map[<-chan Event]*persistentWatcher // protected by subscribersLock
+	subscribersClosed bool                                // protected by subscribersLock
+
+	callbackOnce   sync.Once // starts the goroutines running WatchData callbacks
+	callbackQueues []chan func()
+	callbackNext   uint32
+
+	ephemeralGuard func(lost []string)
+	ephemerals     map[string]int64 // path -> session ID that created it
+	ephemeralsLock sync.Mutex
+
+	ttlReapInterval time.Duration // set if TTL nodes are emulated
+
+	registry     *Registry
+	registryName string
+
+	pendingDeletes     map[string]int32 // path -> version of guaranteed deletes
+	pendingDeletesLock sync.Mutex
+	retryDeletes       chan struct{} // set while pending deletes are retried
+
 	// Debug (used by unit tests)
 	reconnectDelay time.Duration
 
-	logger Logger
+	reconnectPolicy RetryPolicy
+	reconnectRounds int       // failed rounds of connection attempts, owned by loop
+	reconnectStart  time.Time // when the first of them started
+	readRetry       RetryPolicy
+
+	journal     *Journal
+	noReconnect bool
+
+	metrics connMetrics
+
+	logLevels [numLogComponents]int32 // LogLevel by LogComponent, accessed atomically
+	logger    Logger
 }
 
 // connOption represents a connection option.
@@ -101,6 +216,7 @@ type request struct {
 	pkt        interface{}
 	recvStruct interface{}
 	recvChan   chan response
+	sentAt     time.Time
 
 	// Because sending and receiving happen in separate go routines, there's
 	// a possible race condition when creating watches from outside the read
@@ -128,8 +244,15 @@ type Event struct {
 // HostProvider is used to represent a set of hosts a ZooKeeper client should connect to.
 // It is an analog of the Java equivalent:
 // http://svn.apache.org/viewvc/zookeeper/trunk/src/java/main/org/apache/zookeeper/client/HostProvider.java?view=markup
+//
+// It decides which server every connection attempt goes to, so custom
+// policies, e.g. sticky, weighted or zone-aware ones, are implemented by
+// passing a HostProvider to WithHostProvider. DNSHostProvider, which tries
+// the servers in random order, is the default.
 type HostProvider interface {
 	// Init is called first, with the servers specified in the connection string.
+	// It is called again, concurrently with the other methods, if the host
+	// list is updated, e.g. with WithConfigHostList.
 	Init(servers []string) error
 	// Len returns the number of servers.
 	Len() int
@@ -140,6 +263,25 @@ type HostProvider interface {
 	Connected()
 }
 
+// HostListUpdater can be implemented by a HostProvider to have the host list
+// of a live connection replaced without starting over, e.g. to keep the
+// order of the servers if the new list resolves to the same addresses.
+// HostProviders that do not implement it are re-initialized with Init.
+type HostListUpdater interface {
+	// UpdateServers replaces the servers, concurrently with the other
+	// methods. On error the previous servers are kept.
+	UpdateServers(servers []string) error
+}
+
+// HostHealthObserver can be implemented by a HostProvider to be notified of
+// the outcome of every connection attempt, e.g. to prefer healthy servers.
+type HostHealthObserver interface {
+	// ObserveConnect is called after an attempt to connect to server. err is
+	// nil if the connection and session handshake succeeded, in which case
+	// latency is the time the handshake took.
+	ObserveConnect(server string, latency time.Duration, err error)
+}
+
 // ConnectWithDialer establishes a new connection to a pool of zookeeper servers
 // using a custom Dialer. See Connect for further information about session timeout.
 // This method is deprecated and provided for compatibility: use the WithDialer option instead.
@@ -179,10 +321,16 @@ func Connect(servers []string, sessionTimeout time.Duration, options ...connOpti
 		state:          StateDisconnected,
 		eventChan:      ec,
 		shouldQuit:     make(chan struct{}),
+		done:           make(chan struct{}),
+		established:    make(chan struct{}),
+		stateChanged:   make(chan struct{}),
 		connectTimeout: 1 * time.Second,
 		sendChan:       make(chan *request, sendChanSize),
 		requests:       make(map[int32]*request),
 		watchers:       make(map[watchPathType][]chan Event),
+		ephemerals:     make(map[string]int64),
+		maxDataSize:    DefaultMaxDataSize,
+		setWatchesSize: DefaultSetWatchesSize,
 		passwd:         emptyPassword,
 		logger:         DefaultLogger,
 
@@ -194,19 +342,73 @@ func Connect(servers []string, sessionTimeout time.Duration, options ...connOpti
 	for _, option := range options {
 		option(conn)
 	}
+	if err := validateChroot(conn.chroot); err != nil {
+		return nil, nil, err
+	}
+	if cap(ec) != conn.eventChanCap() {
+		ec = make(chan Event, conn.eventChanCap())
+		conn.eventChan = ec
+	}
+
+	if conn.proxy != nil {
+		conn.dialer = ProxyDialer(conn.dialer, conn.proxy)
+	}
+	if conn.getClientCertificate != nil {
+		if conn.tlsConfig == nil {
+			conn.tlsConfig = &tls.Config{}
+		} else {
+			conn.tlsConfig = conn.tlsConfig.Clone()
+		}
+		conn.tlsConfig.GetClientCertificate = conn.getClientCertificate
+	}
+	if conn.tlsConfig != nil {
+		conn.dialer = TLSDialer(conn.dialer, conn.tlsConfig)
+	}
+	conn.flwDialer = conn.dialer
+	if conn.compressor != nil {
+		conn.dialer = CompressedDialer(conn.dialer, conn.compressor)
+	}
 
+	conn.servers = srvs
 	if err := conn.hostProvider.Init(srvs); err != nil {
 		return nil, nil, err
 	}
 
 	conn.setTimeouts(int32(sessionTimeout / time.Millisecond))
 
+	if conn.ttlReapInterval > 0 {
+		go conn.reapTTLNodes()
+	}
+	if conn.followConfig {
+		go conn.followConfigHostList()
+	}
+	if conn.eviction != nil {
+		go conn.runLatencyEviction()
+	}
+	if conn.registry != nil {
+		conn.registry.add(conn, conn.registryName)
+	}
+
 	go func() {
 		conn.loop()
 		conn.flushRequests(ErrClosing)
 		conn.invalidateWatches(ErrClosing)
+		if conn.registry != nil {
+			conn.registry.remove(conn)
+		}
 		close(conn.eventChan)
+		conn.closeSubscribers()
+		close(conn.done)
 	}()
+
+	if conn.establishTimeout > 0 {
+		select {
+		case <-conn.established:
+		case <-time.After(conn.establishTimeout):
+			conn.Close()
+			return nil, nil, ErrSessionEstablishmentTimeout
+		}
+	}
 	return conn, ec, nil
 }
 
@@ -217,6 +419,70 @@ func WithDialer(dialer Dialer) connOption {
 	}
 }
 
+// WithSessionEstablishmentTimeout returns a connection option that makes
+// Connect wait until the first session is established. If that does not
+// happen within timeout, the connection is closed and Connect fails with
+// ErrSessionEstablishmentTimeout. This is independent of the session timeout,
+// which governs how long an established session survives without a
+// connection to the ensemble.
+func WithSessionEstablishmentTimeout(timeout time.Duration) connOption {
+	return func(c *Conn) {
+		c.establishTimeout = timeout
+	}
+}
+
+// WithEphemeralGuard returns a connection option that tracks the ephemeral
+// nodes created through the connection. Whenever a session is established
+// with a new session ID (e.g. after the previous session expired) the tracked
+// nodes are checked and fn is called with the paths that no longer exist, so
+// the application can re-create exactly what was lost.
+func WithEphemeralGuard(fn func(lost []string)) connOption {
+	return func(c *Conn) {
+		c.ephemeralGuard = fn
+	}
+}
+
+// WithPathNormalization returns a connection option that cleans up paths
+// before they are validated and sent, collapsing repeated slashes and
+// removing trailing slashes.
+func WithPathNormalization() connOption {
+	return func(c *Conn) {
+		c.normalizePaths = true
+	}
+}
+
+// WithMaxDataSize returns a connection option that sets the maximum size of
+// node data accepted by Create, Set and Multi. Larger payloads fail locally
+// with a DataTooLargeError instead of making the server drop the connection.
+// It should match the server's jute.maxbuffer setting. A size of zero or less
+// disables the check.
+func WithMaxDataSize(size int) connOption {
+	return func(c *Conn) {
+		c.maxDataSize = size
+	}
+}
+
+// WithSetWatchesSize returns a connection option that sets the maximum size
+// of the paths in a request setting the watches of the session again after a
+// reconnect. Sessions with more watches set them with several requests. A
+// size of zero or less sets them with a single request.
+func WithSetWatchesSize(size int) connOption {
+	return func(c *Conn) {
+		c.setWatchesSize = size
+	}
+}
+
+// WithCanBeReadOnly returns a connection option that allows connecting to
+// servers in read-only mode, i.e. servers partitioned from the quorum, so
+// that reads can still be served. While connected to such a server the state
+// is StateConnectedReadOnly and writes fail with ErrNotReadOnly. The
+// connection stays on the server until it loses the connection to it.
+func WithCanBeReadOnly() connOption {
+	return func(c *Conn) {
+		c.canBeReadOnly = true
+	}
+}
+
 // WithHostProvider returns a connection option specifying a non-default HostProvider.
 func WithHostProvider(hostProvider HostProvider) connOption {
 	return func(c *Conn) {
@@ -224,8 +490,17 @@ func WithHostProvider(hostProvider HostProvider) connOption {
 	}
 }
 
+// Close closes the connection and its session. It is safe to call Close more
+// than once; calls after the first do nothing.
 func (c *Conn) Close() {
-	close(c.shouldQuit)
+	first := false
+	c.closeOnce.Do(func() {
+		close(c.shouldQuit)
+		first = true
+	})
+	if !first {
+		return
+	}
 
 	select {
 	case <-c.queueRequest(opClose, &closeRequest{}, &closeResponse{}, nil):
@@ -253,29 +528,54 @@ func (c *Conn) setTimeouts(sessionTimeoutMs int32) {
 	c.sessionTimeoutMs = sessionTimeoutMs
 	sessionTimeout := time.Duration(sessionTimeoutMs) * time.Millisecond
 	c.recvTimeout = sessionTimeout * 2 / 3
-	c.pingInterval = c.recvTimeout / 2
 }
 
 func (c *Conn) setState(state State) {
+	c.setStateErr(state, nil)
+}
+
+// setStateErr is like setState but reports err in the session event.
+func (c *Conn) setStateErr(state State, err error) {
+	c.stateChangedLock.Lock()
 	atomic.StoreInt32((*int32)(&c.state), int32(state))
-	select {
-	case c.eventChan <- Event{Type: EventSession, State: state, Server: c.Server()}:
-	default:
-		// panic("zk: event channel full - it must be monitored and never allowed to be full")
+	close(c.stateChanged)
+	c.stateChanged = make(chan struct{})
+	c.stateChangedLock.Unlock()
+	ev := Event{Type: EventSession, State: state, Server: c.Server(), Err: err}
+	if c.opTracer != nil {
+		c.opTracer.SessionEvent(ev)
 	}
+	c.sendEvent(ev)
+	c.publish(ev)
 }
 
 func (c *Conn) connect() error {
 	var retryStart bool
 	for {
 		c.serverMu.Lock()
-		c.server, retryStart = c.hostProvider.Next()
+		if c.moveTo != "" {
+			c.server, c.moveTo = c.moveTo, ""
+		} else {
+			c.server, retryStart = c.hostProvider.Next()
+		}
 		c.serverMu.Unlock()
 		c.setState(StateConnecting)
 		if retryStart {
 			c.flushUnsentRequests(ErrNoServer)
+			if c.reconnectRounds == 0 {
+				c.reconnectStart = time.Now()
+			}
+			c.reconnectRounds++
+			delay, ok := c.reconnectDelayFor(c.reconnectRounds, c.reconnectStart)
+			if !ok {
+				c.logf(LogError, LogConnection, "Giving up reconnecting after %d rounds of attempts", c.reconnectRounds)
+				c.terminate(ErrNoServer)
+				c.flushUnsentRequests(ErrClosing)
+				return ErrClosing
+			}
+			c.logw(LogDebug, LogConnection, "Tried all the servers, waiting", "round", c.reconnectRounds, "delay", delay)
 			select {
-			case <-time.After(time.Second):
+			case <-time.After(delay):
 				// pass
 			case <-c.shouldQuit:
 				c.setState(StateDisconnected)
@@ -284,15 +584,33 @@ func (c *Conn) connect() error {
 			}
 		}
 
+		c.logw(LogDebug, LogConnection, "Connecting", "server", c.Server(), "timeout", c.connectTimeout)
 		zkConn, err := c.dialer("tcp", c.Server(), c.connectTimeout)
 		if err == nil {
 			c.conn = zkConn
 			c.setState(StateConnected)
-			c.logger.Printf("Connected to %s", c.Server())
+			c.logw(LogInfo, LogConnection, "Connected", "server", c.Server())
 			return nil
 		}
 
-		c.logger.Printf("Failed to connect to %s: %+v", c.Server(), err)
+		c.logw(LogWarn, LogConnection, "Failed to connect", "server", c.Server(), "err", err)
+		c.observeConnect(0, err)
+	}
+}
+
+// updateHostList replaces the servers of the HostProvider.
+func (c *Conn) updateHostList(servers []string) error {
+	if u, ok := c.hostProvider.(HostListUpdater); ok {
+		return u.UpdateServers(servers)
+	}
+	return c.hostProvider.Init(servers)
+}
+
+// observeConnect reports the outcome of a connection attempt to the current
+// server to the HostProvider, if it wants to know.
+func (c *Conn) observeConnect(latency time.Duration, err error) {
+	if o, ok := c.hostProvider.(HostHealthObserver); ok {
+		o.ObserveConnect(c.Server(), latency, err)
 	}
 }
 
@@ -302,17 +620,35 @@ func (c *Conn) loop() {
 			// c.Close() was called
 			return
 		}
+		c.metrics.connect()
+		if c.eviction != nil {
+			c.eviction.reset()
+		}
 
+		start := time.Now()
 		err := c.authenticate()
+		if err == nil && c.saslMechanism != nil {
+			err = c.saslAuthenticate()
+		}
+		if err == nil {
+			err = c.reapplyAuth()
+		}
+		if err == ErrSessionExpired {
+			// The server is fine, only our session is gone.
+			c.observeConnect(time.Since(start), nil)
+		} else {
+			c.observeConnect(time.Since(start), err)
+		}
 		switch {
 		case err == ErrSessionExpired:
-			c.logger.Printf("Authentication failed: %s", err)
+			c.logw(LogWarn, LogConnection, "Authentication failed", "err", err)
 			c.invalidateWatches(err)
 		case err != nil && c.conn != nil:
-			c.logger.Printf("Authentication failed: %s", err)
+			c.logw(LogWarn, LogConnection, "Authentication failed", "err", err)
 			c.conn.Close()
 		case err == nil:
-			c.logger.Printf("Authenticated: id=%d, timeout=%d", c.SessionID(), c.sessionTimeoutMs)
+			c.logw(LogInfo, LogConnection, "Authenticated", "sessionID", c.SessionID(), "timeout", c.sessionTimeoutMs)
+			c.reconnectRounds = 0
 			c.hostProvider.Connected()       // mark success
 			closeChan := make(chan struct{}) // channel to tell send loop stop
 			var wg sync.WaitGroup
@@ -320,7 +656,7 @@ func (c *Conn) loop() {
 			wg.Add(1)
 			go func() {
 				err := c.sendLoop(c.conn, closeChan)
-				c.logger.Printf("Send loop terminated: err=%v", err)
+				c.logw(LogInfo, LogConnection, "Send loop terminated", "err", err)
 				c.conn.Close() // causes recv loop to EOF/exit
 				wg.Done()
 			}()
@@ -328,7 +664,7 @@ func (c *Conn) loop() {
 			wg.Add(1)
 			go func() {
 				err := c.recvLoop(c.conn)
-				c.logger.Printf("Recv loop terminated: err=%v", err)
+				c.logw(LogInfo, LogConnection, "Recv loop terminated", "err", err)
 				if err == nil {
 					panic("zk: recvLoop should never return nil error")
 				}
@@ -337,10 +673,24 @@ func (c *Conn) loop() {
 			}()
 
 			c.sendSetWatches()
+			c.checkEphemerals()
+			c.kickGuaranteedDeletes()
 			wg.Wait()
 		}
 
-		c.setState(StateDisconnected)
+		if c.noReconnect {
+			select {
+			case <-c.shouldQuit:
+			default:
+				if err != ErrSessionExpired {
+					err = ErrConnectionClosed
+				}
+				c.terminate(err)
+				c.flushRequests(err)
+				return
+			}
+		}
+		c.setStateErr(StateDisconnected, c.takeMoveErr())
 
 		select {
 		case <-c.shouldQuit:
@@ -383,6 +733,7 @@ func (c *Conn) flushRequests(err error) {
 	}
 	c.requests = make(map[int32]*request)
 	c.requestsLock.Unlock()
+	c.metrics.flush()
 }
 
 // Send error to all watchers and clear watchers map
@@ -392,7 +743,7 @@ func (c *Conn) invalidateWatches(err error) {
 
 	if len(c.watchers) >= 0 {
 		for pathType, watchers := range c.watchers {
-			ev := Event{Type: EventNotWatching, State: StateDisconnected, Path: pathType.path, Err: err}
+			ev := Event{Type: EventNotWatching, State: StateDisconnected, Path: c.clientPath(pathType.path), Err: err}
 			for _, ch := range watchers {
 				ch <- ev
 				close(ch)
@@ -400,27 +751,44 @@ func (c *Conn) invalidateWatches(err error) {
 		}
 		c.watchers = make(map[watchPathType][]chan Event)
 	}
+
+	for pathType, watchers := range c.persistentWatchers {
+		ev := Event{Type: EventNotWatching, State: StateDisconnected, Path: c.clientPath(pathType.path), Err: err}
+		for _, w := range watchers {
+			w.deliver(ev, -1)
+			w.close()
+		}
+	}
+	c.persistentWatchers = nil
+	for wt, n := range c.watcherCounts {
+		c.countWatchers(watchType(wt), -n)
+	}
 }
 
 func (c *Conn) sendSetWatches() {
 	c.watchersLock.Lock()
 	defer c.watchersLock.Unlock()
 
-	if len(c.watchers) == 0 {
-		return
-	}
-
-	req := &setWatchesRequest{
-		RelativeZxid: c.lastZxid,
-		DataWatches:  make([]string, 0),
-		ExistWatches: make([]string, 0),
-		ChildWatches: make([]string, 0),
-	}
-	n := 0
-	for pathType, watchers := range c.watchers {
-		if len(watchers) == 0 {
-			continue
+	// The watches are split into requests whose paths take up to
+	// setWatchesSize bytes, so that none exceeds the server's jute.maxbuffer.
+	relativeZxid := atomic.LoadInt64(&c.lastZxid)
+	var reqs []*setWatches2Request
+	var req *setWatches2Request
+	size := 0
+	add := func(pathType watchPathType) {
+		if req == nil || c.setWatchesSize > 0 && size+4+len(pathType.path) > c.setWatchesSize {
+			req = &setWatches2Request{
+				RelativeZxid:               relativeZxid,
+				DataWatches:                make([]string, 0),
+				ExistWatches:               make([]string, 0),
+				ChildWatches:               make([]string, 0),
+				PersistentWatches:          make([]string, 0),
+				PersistentRecursiveWatches: make([]string, 0),
+			}
+			reqs = append(reqs, req)
+			size = 0
 		}
+		size += 4 + len(pathType.path)
 		switch pathType.wType {
 		case watchTypeData:
 			req.DataWatches = append(req.DataWatches, pathType.path)
@@ -428,18 +796,140 @@ func (c *Conn) sendSetWatches() {
 			req.ExistWatches = append(req.ExistWatches, pathType.path)
 		case watchTypeChild:
 			req.ChildWatches = append(req.ChildWatches, pathType.path)
+		case watchTypePersistent:
+			req.PersistentWatches = append(req.PersistentWatches, pathType.path)
+		case watchTypePersistentRecursive:
+			req.PersistentRecursiveWatches = append(req.PersistentRecursiveWatches, pathType.path)
+		}
+	}
+	for pathType, watchers := range c.watchers {
+		if len(watchers) > 0 {
+			add(pathType)
+		}
+	}
+	for pathType, watchers := range c.persistentWatchers {
+		if len(watchers) > 0 {
+			add(pathType)
 		}
-		n++
 	}
-	if n == 0 {
+	if len(reqs) == 0 {
 		return
 	}
 
 	go func() {
-		res := &setWatchesResponse{}
-		_, err := c.request(opSetWatches, req, res, nil)
-		if err != nil {
-			c.logger.Printf("Failed to set previous watches: %s", err.Error())
+		for _, req := range reqs {
+			if err := c.setWatches(req); err != nil {
+				c.logf(LogWarn, LogWatches, "Failed to set previous watches: %s", err.Error())
+				return
+			}
+		}
+	}()
+}
+
+// setWatches sends req, as a setWatches request understood by servers older
+// than 3.6 if it has no persistent watches.
+func (c *Conn) setWatches(req *setWatches2Request) error {
+	if len(req.PersistentWatches)+len(req.PersistentRecursiveWatches) == 0 {
+		_, err := c.request(opSetWatches, &setWatchesRequest{
+			RelativeZxid: req.RelativeZxid,
+			DataWatches:  req.DataWatches,
+			ExistWatches: req.ExistWatches,
+			ChildWatches: req.ChildWatches,
+		}, &setWatchesResponse{}, nil)
+		return err
+	}
+	_, err := c.request(opSetWatches2, req, &setWatchesResponse{}, func(_ *request, res *responseHeader, err error) {
+		if err == nil {
+			c.notifyPersistentWatchers(req)
+		}
+	})
+	return err
+}
+
+// notifyPersistentWatchers tells the persistent watchers set again by req
+// that the session was re-established, as changes made in the meantime are
+// not replayed to them.
+func (c *Conn) notifyPersistentWatchers(req *setWatches2Request) {
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+
+	var pathTypes []watchPathType
+	for _, path := range req.PersistentWatches {
+		pathTypes = append(pathTypes, watchPathType{path, watchTypePersistent})
+	}
+	for _, path := range req.PersistentRecursiveWatches {
+		pathTypes = append(pathTypes, watchPathType{path, watchTypePersistentRecursive})
+	}
+	for _, pathType := range pathTypes {
+		ev := Event{Type: EventSession, State: StateHasSession, Path: c.clientPath(pathType.path), Server: c.Server()}
+		for _, w := range c.persistentWatchers[pathType] {
+			w.deliver(ev, -1)
+		}
+	}
+}
+
+func (c *Conn) trackEphemeral(path string) {
+	if c.ephemeralGuard == nil {
+		return
+	}
+	c.ephemeralsLock.Lock()
+	c.ephemerals[path] = c.SessionID()
+	c.ephemeralsLock.Unlock()
+}
+
+func (c *Conn) untrackEphemeral(path string) {
+	if c.ephemeralGuard == nil {
+		return
+	}
+	c.ephemeralsLock.Lock()
+	delete(c.ephemerals, path)
+	c.ephemeralsLock.Unlock()
+}
+
+// checkEphemerals verifies the tracked ephemeral nodes that were created by a
+// session other than the current one and reports the ones that are gone to
+// the ephemeral guard.
+func (c *Conn) checkEphemerals() {
+	if c.ephemeralGuard == nil {
+		return
+	}
+	sessionID := c.SessionID()
+
+	c.ephemeralsLock.Lock()
+	stale := make(map[string]int64)
+	for path, owner := range c.ephemerals {
+		if owner != sessionID {
+			stale[path] = owner
+		}
+	}
+	c.ephemeralsLock.Unlock()
+	if len(stale) == 0 {
+		return
+	}
+
+	go func() {
+		lost := make([]string, 0, len(stale))
+		for path, owner := range stale {
+			exists, stat, err := c.Exists(path)
+			if err != nil {
+				c.logf(LogWarn, LogConnection, "Failed to verify ephemeral %s: %s", path, err.Error())
+				return
+			}
+			if !exists || stat.EphemeralOwner != owner {
+				lost = append(lost, path)
+			}
+		}
+
+		c.ephemeralsLock.Lock()
+		for _, path := range lost {
+			if c.ephemerals[path] == stale[path] {
+				delete(c.ephemerals, path)
+			}
+		}
+		c.ephemeralsLock.Unlock()
+
+		if len(lost) > 0 {
+			c.ephemeralGuard(lost)
 		}
 	}()
 }
@@ -447,13 +937,22 @@ func (c *Conn) sendSetWatches() {
 func (c *Conn) authenticate() error {
 	buf := make([]byte, 256)
 
+	sessionID, passwd := c.SessionID(), c.passwd
+	if c.canBeReadOnly && !c.seenRWServer {
+		// Sessions of read-only servers are local to them, so start over
+		// until a read-write server was seen.
+		sessionID, passwd = 0, emptyPassword
+	}
+
 	// Encode and send a connect request.
+	c.logw(LogDebug, LogConnection, "Requesting session", "sessionID", sessionID, "lastZxid", c.lastZxid)
 	n, err := encodePacket(buf[4:], &connectRequest{
 		ProtocolVersion: protocolVersion,
 		LastZxidSeen:    c.lastZxid,
 		TimeOut:         c.sessionTimeoutMs,
-		SessionID:       c.SessionID(),
-		Passwd:          c.passwd,
+		SessionID:       sessionID,
+		Passwd:          passwd,
+		ReadOnly:        c.canBeReadOnly,
 	})
 	if err != nil {
 		return err
@@ -467,6 +966,9 @@ func (c *Conn) authenticate() error {
 	if err != nil {
 		return err
 	}
+	if c.wireTrace {
+		c.traceWire(LogConnection, "Sent connect request", buf[4:n+4], connectRequestSecret, "sessionID", sessionID, "lastZxid", c.lastZxid, "timeout", c.sessionTimeoutMs)
+	}
 
 	// Receive and decode a connect response.
 	c.conn.SetReadDeadline(time.Now().Add(c.recvTimeout * 10))
@@ -487,29 +989,46 @@ func (c *Conn) authenticate() error {
 	}
 
 	r := connectResponse{}
-	_, err = decodePacket(buf[:blen], &r)
+	n, err = decodePacket(buf[:blen], &r)
 	if err != nil {
 		return err
 	}
+	// Servers since 3.4 append whether they are read-only.
+	readOnly := n < blen && buf[n] != 0
+	if c.wireTrace {
+		c.traceWire(LogConnection, "Received connect response", buf[:blen], connectResponseSecret, "sessionID", r.SessionID, "timeout", r.TimeOut, "readOnly", readOnly)
+	}
 	if r.SessionID == 0 {
 		atomic.StoreInt64(&c.sessionID, int64(0))
+		c.sessionLock.Lock()
 		c.passwd = emptyPassword
-		c.lastZxid = 0
-		c.setState(StateExpired)
+		c.sessionLock.Unlock()
+		atomic.StoreInt64(&c.lastZxid, 0)
+		c.metrics.expire()
+		c.setStateErr(StateExpired, ErrSessionExpired)
 		return ErrSessionExpired
 	}
 
 	atomic.StoreInt64(&c.sessionID, r.SessionID)
 	c.setTimeouts(r.TimeOut)
+	c.sessionLock.Lock()
 	c.passwd = r.Passwd
-	c.setState(StateHasSession)
+	c.sessionLock.Unlock()
+	if readOnly {
+		c.setState(StateConnectedReadOnly)
+	} else {
+		c.seenRWServer = true
+		c.setState(StateHasSession)
+	}
+	c.establishedOnce.Do(func() { close(c.established) })
 
 	return nil
 }
 
 func (c *Conn) sendLoop(conn net.Conn, closeChan <-chan struct{}) error {
-	pingTicker := time.NewTicker(c.pingInterval)
-	defer pingTicker.Stop()
+	c.resetPings()
+	pingTimer := time.NewTimer(c.nextPingInterval())
+	defer pingTimer.Stop()
 
 	buf := make([]byte, bufferSize)
 	for {
@@ -540,8 +1059,11 @@ func (c *Conn) sendLoop(conn net.Conn, closeChan <-chan struct{}) error {
 				return ErrConnectionClosed
 			default:
 			}
+			req.sentAt = time.Now()
 			c.requests[req.xid] = req
+			outstanding := len(c.requests)
 			c.requestsLock.Unlock()
+			c.metrics.request(req.opcode, outstanding)
 
 			conn.SetWriteDeadline(time.Now().Add(c.recvTimeout))
 			_, err = conn.Write(buf[:n+4])
@@ -551,7 +1073,10 @@ func (c *Conn) sendLoop(conn net.Conn, closeChan <-chan struct{}) error {
 				conn.Close()
 				return err
 			}
-		case <-pingTicker.C:
+			if c.wireTrace {
+				c.traceWire(LogRequests, "Sent request", buf[4:n+4], secretOffset(buf[4:n+4], req.opcode, false), "xid", req.xid, "op", opNames[req.opcode])
+			}
+		case now := <-pingTimer.C:
 			n, err := encodePacket(buf[4:], &requestHeader{Xid: -2, Opcode: opPing})
 			if err != nil {
 				panic("zk: opPing should never fail to serialize")
@@ -559,6 +1084,8 @@ func (c *Conn) sendLoop(conn net.Conn, closeChan <-chan struct{}) error {
 
 			binary.BigEndian.PutUint32(buf[:4], uint32(n))
 
+			c.pingSent(now)
+			pingTimer.Reset(c.nextPingInterval())
 			conn.SetWriteDeadline(time.Now().Add(c.recvTimeout))
 			_, err = conn.Write(buf[:n+4])
 			conn.SetWriteDeadline(time.Time{})
@@ -566,6 +1093,12 @@ func (c *Conn) sendLoop(conn net.Conn, closeChan <-chan struct{}) error {
 				conn.Close()
 				return err
 			}
+			if c.wireTrace {
+				c.traceWire(LogRequests, "Sent request", buf[4:n+4], noSecret, "xid", -2, "op", opNames[opPing])
+			}
+		case <-c.evictionKick():
+			conn.Close()
+			return errServerEvicted
 		case <-closeChan:
 			return nil
 		}
@@ -600,6 +1133,12 @@ func (c *Conn) recvLoop(conn net.Conn) error {
 		}
 
 		if res.Xid == -1 {
+			// Servers since 3.9 report the zxid of the change that fired the
+			// watch, older ones only send -1.
+			zxid := c.lastZxid
+			if res.Zxid > zxid {
+				zxid = res.Zxid
+			}
 			res := &watcherEvent{}
 			_, err := decodePacket(buf[16:blen], res)
 			if err != nil {
@@ -608,13 +1147,15 @@ func (c *Conn) recvLoop(conn net.Conn) error {
 			ev := Event{
 				Type:  res.Type,
 				State: res.State,
-				Path:  res.Path,
+				Path:  c.clientPath(res.Path),
 				Err:   nil,
 			}
-			select {
-			case c.eventChan <- ev:
-			default:
+			c.metrics.watchEvent(res.Type)
+			if c.wireTrace {
+				c.traceWire(LogWatches, "Received notification", buf[:blen], noSecret, "xid", -1, "zxid", zxid, "type", res.Type, "state", res.State, "path", ev.Path)
 			}
+			c.logw(LogDebug, LogWatches, "Watch event", "type", res.Type, "path", ev.Path)
+			c.sendEvent(ev)
 			wTypes := make([]watchType, 0, 2)
 			switch res.Type {
 			case EventNodeCreated:
@@ -633,16 +1174,33 @@ func (c *Conn) recvLoop(conn net.Conn) error {
 						close(ch)
 					}
 					delete(c.watchers, wpt)
+					c.countWatchers(t, -len(watchers))
+				}
+			}
+			for _, w := range c.persistentWatchers[watchPathType{res.Path, watchTypePersistent}] {
+				w.deliver(ev, zxid)
+			}
+			if res.Type != EventNodeChildrenChanged {
+				for p := res.Path; ; p = parentPath(p) {
+					for _, w := range c.persistentWatchers[watchPathType{p, watchTypePersistentRecursive}] {
+						w.deliver(ev, zxid)
+					}
+					if p == "/" || p == "" {
+						break
+					}
 				}
 			}
 			c.watchersLock.Unlock()
 		} else if res.Xid == -2 {
-			// Ping response. Ignore.
+			if c.wireTrace {
+				c.traceWire(LogRequests, "Received response", buf[:blen], noSecret, "xid", res.Xid, "zxid", res.Zxid, "op", opNames[opPing], "err", res.Err)
+			}
+			c.pingReceived(time.Now())
 		} else if res.Xid < 0 {
-			c.logger.Printf("Xid < 0 (%d) but not ping or watcher event", res.Xid)
+			c.logw(LogWarn, LogRequests, "Xid < 0 but not ping or watcher event", "xid", res.Xid)
 		} else {
 			if res.Zxid > 0 {
-				c.lastZxid = res.Zxid
+				atomic.StoreInt64(&c.lastZxid, res.Zxid)
 			}
 
 			c.requestsLock.Lock()
@@ -650,11 +1208,20 @@ func (c *Conn) recvLoop(conn net.Conn) error {
 			if ok {
 				delete(c.requests, res.Xid)
 			}
+			outstanding := len(c.requests)
 			c.requestsLock.Unlock()
 
 			if !ok {
-				c.logger.Printf("Response for unknown request with xid %d", res.Xid)
+				c.logw(LogWarn, LogRequests, "Response for unknown request", "xid", res.Xid)
 			} else {
+				if c.wireTrace {
+					c.traceWire(LogRequests, "Received response", buf[:blen], secretOffset(buf[:blen], req.opcode, true), "xid", res.Xid, "zxid", res.Zxid, "op", opNames[req.opcode], "err", res.Err)
+				}
+				latency := time.Since(req.sentAt)
+				c.metrics.reply(req.opcode, res.Err, latency, outstanding)
+				if c.slowThreshold > 0 && latency > c.slowThreshold {
+					c.logSlow(req, latency)
+				}
 				if res.Err != 0 {
 					err = res.Err.toError()
 				} else {
@@ -683,11 +1250,12 @@ func (c *Conn) addWatcher(path string, watchType watchType) <-chan Event {
 	ch := make(chan Event, 1)
 	wpt := watchPathType{path, watchType}
 	c.watchers[wpt] = append(c.watchers[wpt], ch)
+	c.countWatchers(watchType, 1)
 	return ch
 }
 
-func (c *Conn) queueRequest(opcode int32, req interface{}, res interface{}, recvFunc func(*request, *responseHeader, error)) <-chan response {
-	rq := &request{
+func (c *Conn) newRequest(opcode int32, req interface{}, res interface{}, recvFunc func(*request, *responseHeader, error)) *request {
+	return &request{
 		xid:        c.nextXid(),
 		opcode:     opcode,
 		pkt:        req,
@@ -695,30 +1263,140 @@ func (c *Conn) queueRequest(opcode int32, req interface{}, res interface{}, recv
 		recvChan:   make(chan response, 1),
 		recvFunc:   recvFunc,
 	}
+}
+
+func (c *Conn) queueRequest(opcode int32, req interface{}, res interface{}, recvFunc func(*request, *responseHeader, error)) <-chan response {
+	rq := c.newRequest(opcode, req, res, recvFunc)
 	c.sendChan <- rq
 	return rq.recvChan
 }
 
 func (c *Conn) request(opcode int32, req interface{}, res interface{}, recvFunc func(*request, *responseHeader, error)) (int64, error) {
-	r := <-c.queueRequest(opcode, req, res, recvFunc)
+	if c.authPrecheck != nil {
+		if err := c.precheck(opcode, req); err != nil {
+			return -1, err
+		}
+	}
+	traced := c.traced(req)
+	var start time.Time
+	if traced {
+		start = time.Now()
+	}
+	var r response
+	if c.opTracer == nil {
+		r = c.wait(c.queueRequest(opcode, req, res, recvFunc))
+	} else {
+		r = c.traceOperation(opcode, req, res, recvFunc)
+	}
+	if traced {
+		c.trace(opcode, req, res, r, time.Since(start))
+	}
+	if c.shadow != nil {
+		c.shadow.mirror(opcode, req, res, r.err)
+	}
 	return r.zxid, r.err
 }
 
+// wait waits for the response to a request. Requests queued after the
+// connection loop exited are never sent, so they fail with ErrClosing.
+func (c *Conn) wait(ch <-chan response) response {
+	select {
+	case r := <-ch:
+		return r
+	case <-c.done:
+		select {
+		case r := <-ch:
+			return r
+		default:
+			return response{-1, ErrClosing}
+		}
+	}
+}
+
+// processPath normalizes path if requested and validates it, so that invalid
+// paths fail locally with a descriptive error instead of a server round trip.
+// It returns the path to send to the server, which includes the chroot.
+func (c *Conn) processPath(path string, isSequential bool) (string, error) {
+	path, err := c.cleanPath(path, isSequential)
+	if err != nil {
+		return "", err
+	}
+	if c.chroot != "" {
+		if path == "/" {
+			return c.chroot, nil
+		}
+		return c.chroot + path, nil
+	}
+	return path, nil
+}
+
+// cleanPath is like processPath but returns the path without the chroot, for
+// methods that pass it on to other methods.
+func (c *Conn) cleanPath(path string, isSequential bool) (string, error) {
+	if c.normalizePaths {
+		path = normalizePath(path, isSequential)
+	}
+	if err := validatePath(path, isSequential); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// clientPath strips the chroot from a path received from the server.
+func (c *Conn) clientPath(path string) string {
+	if c.chroot == "" || !strings.HasPrefix(path, c.chroot) {
+		return path
+	}
+	if len(path) == len(c.chroot) {
+		return "/"
+	}
+	if path[len(c.chroot)] != '/' {
+		return path
+	}
+	return path[len(c.chroot):]
+}
+
+// checkDataSize returns a DataTooLargeError if data exceeds the maximum
+// data size.
+func (c *Conn) checkDataSize(path string, data []byte) error {
+	if c.maxDataSize > 0 && len(data) > c.maxDataSize {
+		return &DataTooLargeError{Path: c.clientPath(path), Size: len(data), Max: c.maxDataSize}
+	}
+	return nil
+}
+
+// AddAuth adds credentials for scheme, e.g. "digest" with "user:password",
+// to the connection. Once accepted they are applied again over every new
+// connection, including after the session expired, before any other
+// request is sent.
 func (c *Conn) AddAuth(scheme string, auth []byte) error {
 	_, err := c.request(opSetAuth, &setAuthRequest{Type: 0, Scheme: scheme, Auth: auth}, &setAuthResponse{}, nil)
+	if err == nil {
+		c.addCreds(scheme, auth)
+	}
 	return err
 }
 
 func (c *Conn) Children(path string) ([]string, *Stat, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	res := &getChildren2Response{}
-	_, err := c.request(opGetChildren2, &getChildren2Request{Path: path, Watch: false}, res, nil)
+	err = c.readRequest(opGetChildren2, &getChildren2Request{Path: path, Watch: false}, res)
 	return res.Children, &res.Stat, err
 }
 
 func (c *Conn) ChildrenW(path string) ([]string, *Stat, <-chan Event, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	var ech <-chan Event
 	res := &getChildren2Response{}
-	_, err := c.request(opGetChildren2, &getChildren2Request{Path: path, Watch: true}, res, func(req *request, res *responseHeader, err error) {
+	_, err = c.request(opGetChildren2, &getChildren2Request{Path: path, Watch: true}, res, func(req *request, res *responseHeader, err error) {
 		if err == nil {
 			ech = c.addWatcher(path, watchTypeChild)
 		}
@@ -730,16 +1408,26 @@ func (c *Conn) ChildrenW(path string) ([]string, *Stat, <-chan Event, error) {
 }
 
 func (c *Conn) Get(path string) ([]byte, *Stat, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	res := &getDataResponse{}
-	_, err := c.request(opGetData, &getDataRequest{Path: path, Watch: false}, res, nil)
+	err = c.readRequest(opGetData, &getDataRequest{Path: path, Watch: false}, res)
 	return res.Data, &res.Stat, err
 }
 
 // GetW returns the contents of a znode and sets a watch
 func (c *Conn) GetW(path string) ([]byte, *Stat, <-chan Event, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	var ech <-chan Event
 	res := &getDataResponse{}
-	_, err := c.request(opGetData, &getDataRequest{Path: path, Watch: true}, res, func(req *request, res *responseHeader, err error) {
+	_, err = c.request(opGetData, &getDataRequest{Path: path, Watch: true}, res, func(req *request, res *responseHeader, err error) {
 		if err == nil {
 			ech = c.addWatcher(path, watchTypeData)
 		}
@@ -751,18 +1439,109 @@ func (c *Conn) GetW(path string) ([]byte, *Stat, <-chan Event, error) {
 }
 
 func (c *Conn) Set(path string, data []byte, version int32) (*Stat, error) {
-	if path == "" {
-		return nil, ErrInvalidPath
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkDataSize(path, data); err != nil {
+		return nil, err
+	}
+
+	id, err := c.journalBegin(JournalEntry{Op: "set", Path: c.clientPath(path), Data: data, Version: version})
+	if err != nil {
+		return nil, err
 	}
 	res := &setDataResponse{}
-	_, err := c.request(opSetData, &SetDataRequest{path, data, version}, res, nil)
+	_, err = c.request(opSetData, &SetDataRequest{path, data, version}, res, nil)
+	c.journalEnd(id, err)
 	return &res.Stat, err
 }
 
 func (c *Conn) Create(path string, data []byte, flags int32, acl []ACL) (string, error) {
+	path, err := c.processPath(path, flags&FlagSequence != 0)
+	if err != nil {
+		return "", err
+	}
+	if err := c.checkDataSize(path, data); err != nil {
+		return "", err
+	}
+	if acl, err = c.checkACL(path, acl); err != nil {
+		return "", err
+	}
+
+	id, err := c.journalBegin(JournalEntry{Op: "create", Path: c.clientPath(path), Data: data, Flags: flags})
+	if err != nil {
+		return "", err
+	}
 	res := &createResponse{}
-	_, err := c.request(opCreate, &CreateRequest{path, data, acl, flags}, res, nil)
-	return res.Path, err
+	_, err = c.request(opCreate, &CreateRequest{path, data, acl, flags}, res, nil)
+	c.journalEnd(id, err)
+	if err != nil {
+		return "", err
+	}
+	path = c.clientPath(res.Path)
+	if flags&FlagEphemeral != 0 {
+		c.trackEphemeral(path)
+	}
+	return path, nil
+}
+
+// Create2 is like Create but also returns the Stat of the created node,
+// saving a round trip to learn e.g. its czxid or version. It requires
+// ZooKeeper 3.5 or later.
+func (c *Conn) Create2(path string, data []byte, flags int32, acl []ACL) (string, *Stat, error) {
+	path, err := c.processPath(path, flags&FlagSequence != 0)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := c.checkDataSize(path, data); err != nil {
+		return "", nil, err
+	}
+	if acl, err = c.checkACL(path, acl); err != nil {
+		return "", nil, err
+	}
+
+	id, err := c.journalBegin(JournalEntry{Op: "create", Path: c.clientPath(path), Data: data, Flags: flags})
+	if err != nil {
+		return "", nil, err
+	}
+	res := &create2Response{}
+	_, err = c.request(opCreate2, &CreateRequest{path, data, acl, flags}, res, nil)
+	c.journalEnd(id, err)
+	if err != nil {
+		return "", nil, err
+	}
+	path = c.clientPath(res.Path)
+	if flags&FlagEphemeral != 0 {
+		c.trackEphemeral(path)
+	}
+	return path, &res.Stat, nil
+}
+
+// CreateContainer creates a container node at path and returns its path.
+// Container nodes are meant to be parents of other nodes, such as those of
+// locks and queues: the server deletes them once their last child is
+// deleted. It requires ZooKeeper 3.5.1 or later.
+func (c *Conn) CreateContainer(path string, data []byte, acl []ACL) (string, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return "", err
+	}
+	if err := c.checkDataSize(path, data); err != nil {
+		return "", err
+	}
+	if acl, err = c.checkACL(path, acl); err != nil {
+		return "", err
+	}
+
+	id, err := c.journalBegin(JournalEntry{Op: "create", Path: c.clientPath(path), Data: data, Flags: FlagContainer})
+	if err != nil {
+		return "", err
+	}
+	res := &create2Response{}
+	_, err = c.request(opCreateContainer, &CreateRequest{path, data, acl, FlagContainer}, res, nil)
+	c.journalEnd(id, err)
+	return c.clientPath(res.Path), err
 }
 
 // CreateProtectedEphemeralSequential fixes a race condition if the server crashes
@@ -770,8 +1549,13 @@ func (c *Conn) Create(path string, data []byte, flags int32, acl []ACL) (string,
 // ephemeral node still exists. Therefore, on reconnect we need to check if a node
 // with a GUID generated on create exists.
 func (c *Conn) CreateProtectedEphemeralSequential(path string, data []byte, acl []ACL) (string, error) {
+	path, err := c.cleanPath(path, true)
+	if err != nil {
+		return "", err
+	}
+
 	var guid [16]byte
-	_, err := io.ReadFull(rand.Reader, guid[:16])
+	_, err = io.ReadFull(rand.Reader, guid[:16])
 	if err != nil {
 		return "", err
 	}
@@ -811,13 +1595,62 @@ func (c *Conn) CreateProtectedEphemeralSequential(path string, data []byte, acl
 }
 
 func (c *Conn) Delete(path string, version int32) error {
-	_, err := c.request(opDelete, &DeleteRequest{path, version}, &deleteResponse{}, nil)
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.journalBegin(JournalEntry{Op: "delete", Path: c.clientPath(path), Version: version})
+	if err != nil {
+		return err
+	}
+	_, err = c.request(opDelete, &DeleteRequest{path, version}, &deleteResponse{}, nil)
+	c.journalEnd(id, err)
+	if err == nil || err == ErrNoNode {
+		c.untrackEphemeral(c.clientPath(path))
+	}
 	return err
 }
 
+// DeleteIfEmpty deletes the node at path if its version matches and its
+// children did not change since the caller saw the node with cversion, e.g.
+// in the stat returned by Children. It fails with ErrNotEmpty if the node
+// has children, as Delete does, and with ErrBadVersion if a child was
+// created or deleted since, even one created and deleted again. Either
+// version may be -1 to match any. The check and the delete are sent as one
+// Multi; see CheckCversionRequest for what the check covers.
+func (c *Conn) DeleteIfEmpty(path string, version, cversion int32) error {
+	_, err := c.Multi(&CheckCversionRequest{Path: path, Cversion: cversion}, &DeleteRequest{Path: path, Version: version})
+	return err
+}
+
+// CreateParents creates the missing parents of path as persistent nodes
+// without data, with acl, e.g. once creating path failed with ErrNoNode. The
+// last element of path is not created, so path may be the prefix of a
+// sequential node.
+func (c *Conn) CreateParents(path string, acl []ACL) error {
+	if err := validatePath(path, true); err != nil {
+		return err
+	}
+	parts := strings.Split(path, "/")
+	pth := ""
+	for _, p := range parts[1 : len(parts)-1] {
+		pth += "/" + p
+		if _, err := c.Create(pth, []byte{}, 0, acl); err != nil && err != ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Conn) Exists(path string) (bool, *Stat, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return false, nil, err
+	}
+
 	res := &existsResponse{}
-	_, err := c.request(opExists, &existsRequest{Path: path, Watch: false}, res, nil)
+	err = c.readRequest(opExists, &existsRequest{Path: path, Watch: false}, res)
 	exists := true
 	if err == ErrNoNode {
 		exists = false
@@ -827,9 +1660,14 @@ func (c *Conn) Exists(path string) (bool, *Stat, error) {
 }
 
 func (c *Conn) ExistsW(path string) (bool, *Stat, <-chan Event, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return false, nil, nil, err
+	}
+
 	var ech <-chan Event
 	res := &existsResponse{}
-	_, err := c.request(opExists, &existsRequest{Path: path, Watch: true}, res, func(req *request, res *responseHeader, err error) {
+	_, err = c.request(opExists, &existsRequest{Path: path, Watch: true}, res, func(req *request, res *responseHeader, err error) {
 		if err == nil {
 			ech = c.addWatcher(path, watchTypeData)
 		} else if err == ErrNoNode {
@@ -848,20 +1686,64 @@ func (c *Conn) ExistsW(path string) (bool, *Stat, <-chan Event, error) {
 }
 
 func (c *Conn) GetACL(path string) ([]ACL, *Stat, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	res := &getAclResponse{}
-	_, err := c.request(opGetAcl, &getAclRequest{Path: path}, res, nil)
+	err = c.readRequest(opGetAcl, &getAclRequest{Path: path}, res)
 	return res.Acl, &res.Stat, err
 }
+
 func (c *Conn) SetACL(path string, acl []ACL, version int32) (*Stat, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+
 	res := &setAclResponse{}
-	_, err := c.request(opSetAcl, &setAclRequest{Path: path, Acl: acl, Version: version}, res, nil)
+	_, err = c.request(opSetAcl, &setAclRequest{Path: path, Acl: acl, Version: version}, res, nil)
 	return &res.Stat, err
 }
 
+// WhoAmI returns the auth identities the server associated with the
+// connection, e.g. to verify that AddAuth took effect before creating nodes
+// protected by ACLs. It requires ZooKeeper 3.7 or later.
+func (c *Conn) WhoAmI() ([]ClientInfo, error) {
+	res := &whoAmIResponse{}
+	_, err := c.request(opWhoAmI, &whoAmIRequest{}, res, nil)
+	return res.ClientInfo, err
+}
+
+// GetAllChildrenNumber returns the number of descendants of the node at
+// path, not including the node itself. The count is computed by the server,
+// so subtree sizes can be reported without walking the tree. It requires
+// ZooKeeper 3.6 or later.
+func (c *Conn) GetAllChildrenNumber(path string) (int32, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return 0, err
+	}
+
+	res := &getAllChildrenNumberResponse{}
+	_, err = c.request(opGetAllChildrenNumber, &getAllChildrenNumberRequest{Path: path}, res, nil)
+	return res.TotalNumber, err
+}
+
+// Sync makes the server the connection is connected to catch up with the
+// leader for path before it serves further requests. Reads issued after Sync
+// returns see every write committed before it was called, including writes
+// made by other clients, giving read-your-writes semantics across clients.
 func (c *Conn) Sync(path string) (string, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return "", err
+	}
+
 	res := &syncResponse{}
-	_, err := c.request(opSync, &syncRequest{Path: path}, res, nil)
-	return res.Path, err
+	_, err = c.request(opSync, &syncRequest{Path: path}, res, nil)
+	return c.clientPath(res.Path), err
 }
 
 type MultiResponse struct {
@@ -870,38 +1752,183 @@ type MultiResponse struct {
 }
 
 // Multi executes multiple ZooKeeper operations or none of them. The provided
-// ops must be one of *CreateRequest, *DeleteRequest, *SetDataRequest, or
-// *CheckVersionRequest.
+// ops must be one of *CreateRequest, *DeleteRequest, *SetDataRequest,
+// *CheckVersionRequest or *CheckCversionRequest. A *CreateRequest with
+// FlagContainer creates a container node.
 func (c *Conn) Multi(ops ...interface{}) ([]MultiResponse, error) {
 	req := &multiRequest{
 		Ops:        make([]multiRequestOp, 0, len(ops)),
 		DoneHeader: multiHeader{Type: -1, Done: true, Err: -1},
 	}
+	entry := JournalEntry{Op: "multi"}
 	for _, op := range ops {
 		var opCode int32
-		switch op.(type) {
+		var pkt interface{}
+		var sub JournalEntry
+		var err error
+		switch op := op.(type) {
 		case *CreateRequest:
 			opCode = opCreate
+			if op.Flags&FlagContainer != 0 {
+				opCode = opCreateContainer
+			}
+			r := *op
+			r.Path, err = c.processPath(op.Path, op.Flags&FlagSequence != 0)
+			if err == nil {
+				err = c.checkDataSize(r.Path, r.Data)
+			}
+			if err == nil {
+				r.Acl, err = c.checkACL(r.Path, r.Acl)
+			}
+			pkt = &r
+			sub = JournalEntry{Op: "create", Path: c.clientPath(r.Path), Data: r.Data, Flags: r.Flags}
 		case *SetDataRequest:
 			opCode = opSetData
+			r := *op
+			r.Path, err = c.processPath(op.Path, false)
+			if err == nil {
+				err = c.checkDataSize(r.Path, r.Data)
+			}
+			pkt = &r
+			sub = JournalEntry{Op: "set", Path: c.clientPath(r.Path), Data: r.Data, Version: r.Version}
 		case *DeleteRequest:
 			opCode = opDelete
+			r := *op
+			r.Path, err = c.processPath(op.Path, false)
+			pkt = &r
+			sub = JournalEntry{Op: "delete", Path: c.clientPath(r.Path), Version: r.Version}
 		case *CheckVersionRequest:
 			opCode = opCheck
+			r := *op
+			r.Path, err = c.processPath(op.Path, false)
+			pkt = &r
+			sub = JournalEntry{Op: "check", Path: c.clientPath(r.Path), Version: r.Version}
+		case *CheckCversionRequest:
+			opCode = opCheck
+			r := CheckVersionRequest{Version: -1}
+			r.Path, err = c.processPath(op.Path, false)
+			if err == nil && op.Cversion != -1 {
+				r.Version, err = c.checkCversion(op.Path, op.Cversion)
+			}
+			pkt = &r
+			sub = JournalEntry{Op: "check", Path: c.clientPath(r.Path), Version: r.Version}
 		default:
 			return nil, fmt.Errorf("unknown operation type %T", op)
 		}
-		req.Ops = append(req.Ops, multiRequestOp{multiHeader{opCode, false, -1}, op})
+		if err != nil {
+			return nil, err
+		}
+		req.Ops = append(req.Ops, multiRequestOp{multiHeader{opCode, false, -1}, pkt})
+		if c.journal != nil {
+			entry.Ops = append(entry.Ops, sub)
+		}
+	}
+	id, err := c.journalBegin(entry)
+	if err != nil {
+		return nil, err
 	}
 	res := &multiResponse{}
-	_, err := c.request(opMulti, req, res, nil)
+	_, err = c.request(opMulti, req, res, nil)
+	c.journalEnd(id, err)
 	mr := make([]MultiResponse, len(res.Ops))
 	for i, op := range res.Ops {
-		mr[i] = MultiResponse{Stat: op.Stat, String: op.String}
+		mr[i] = MultiResponse{Stat: op.Stat, String: c.clientPath(op.String)}
+	}
+	if err == nil {
+		for i, op := range ops {
+			switch op := op.(type) {
+			case *CreateRequest:
+				if op.Flags&FlagEphemeral != 0 && i < len(mr) {
+					c.trackEphemeral(mr[i].String)
+				}
+			case *DeleteRequest:
+				if path, err := c.processPath(op.Path, false); err == nil {
+					c.untrackEphemeral(c.clientPath(path))
+				}
+			}
+		}
 	}
 	return mr, err
 }
 
+// checkCversion reads the node at path and returns its data version if its
+// cversion is cversion. It fails with ErrBadVersion otherwise.
+func (c *Conn) checkCversion(path string, cversion int32) (int32, error) {
+	exists, stat, err := c.Exists(path)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrNoNode
+	}
+	if stat.Cversion != cversion {
+		return 0, ErrBadVersion
+	}
+	return stat.Version, nil
+}
+
+// MultiRead executes read operations in a single round trip, against the
+// same state of the server. The provided ops must be *GetOp or *ChildrenOp.
+// Unlike Multi the operations are independent: each can fail on its own, as
+// reported in its MultiReadResponse. It requires ZooKeeper 3.6 or later.
+func (c *Conn) MultiRead(ops ...interface{}) ([]MultiReadResponse, error) {
+	req := &multiRequest{
+		Ops:        make([]multiRequestOp, 0, len(ops)),
+		DoneHeader: multiHeader{Type: -1, Done: true, Err: -1},
+	}
+	for _, op := range ops {
+		var opCode int32
+		var path string
+		switch op := op.(type) {
+		case *GetOp:
+			opCode, path = opGetData, op.Path
+		case *ChildrenOp:
+			opCode, path = opGetChildren, op.Path
+		default:
+			return nil, fmt.Errorf("unknown operation type %T", op)
+		}
+		path, err := c.processPath(path, false)
+		if err != nil {
+			return nil, err
+		}
+		req.Ops = append(req.Ops, multiRequestOp{multiHeader{opCode, false, -1}, &pathWatchRequest{Path: path}})
+	}
+	res := &multiReadResponse{}
+	_, err := c.request(opMultiRead, req, res, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Ops, nil
+}
+
+// UpdateServers changes the servers the connection may use without closing
+// it, e.g. after the ensemble was scaled or migrated. The session and the
+// current connection are kept, and the HostProvider picks from the new
+// servers at the next reconnect, which spreads clients over them. If the
+// HostProvider fails to take the new servers, e.g. because none of them
+// resolves, the error is returned and the previous ones are kept.
+func (c *Conn) UpdateServers(servers []string) error {
+	if len(servers) == 0 {
+		return errors.New("zk: server list must not be empty")
+	}
+	srvs := FormatServers(append([]string(nil), servers...))
+	if err := c.updateHostList(srvs); err != nil {
+		return err
+	}
+	c.serverMu.Lock()
+	c.servers = srvs
+	c.serverMu.Unlock()
+	return nil
+}
+
+// configuredServers returns the servers the connection was configured with,
+// or last updated to with UpdateServers.
+func (c *Conn) configuredServers() []string {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+	return c.servers
+}
+
 // Server returns the current or last-connected server name.
 func (c *Conn) Server() string {
 	c.serverMu.Lock()
@@ -0,0 +1,152 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConnectConfig is the structured form of a connect string such as
+// "zk1:2181,zk2:2181,[::1]:2181/app".
+type ConnectConfig struct {
+	// Servers are the addresses of the ensemble members as host:port.
+	Servers []string
+	// Chroot is the path all paths of the connection are relative to, or
+	// empty for none.
+	Chroot string
+}
+
+// ParseConnectString parses a comma separated list of host[:port] addresses
+// optionally followed by a chroot path, as accepted by the other ZooKeeper
+// clients. IPv6 literals must be enclosed in brackets. Hosts without a port
+// get DefaultPort.
+func ParseConnectString(s string) (*ConnectConfig, error) {
+	hosts, chroot := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		hosts, chroot = s[:i], s[i:]
+	}
+	cfg := &ConnectConfig{Chroot: chroot}
+	for _, host := range strings.Split(hosts, ",") {
+		addr, err := parseConnectAddr(strings.TrimSpace(host))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Servers = append(cfg.Servers, addr)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseConnectAddr parses a single host[:port] of a connect string and returns
+// it as host:port.
+func parseConnectAddr(s string) (string, error) {
+	host, port := s, strconv.Itoa(DefaultPort)
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return "", fmt.Errorf("zk: unterminated IPv6 literal in %q", s)
+		}
+		host = s[1:end]
+		if rest := s[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return "", fmt.Errorf("zk: unexpected %q after IPv6 literal", rest)
+			}
+			port = rest[1:]
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return "", fmt.Errorf("zk: invalid IPv6 address %q", host)
+		}
+	case strings.Count(s, ":") > 1:
+		return "", fmt.Errorf("zk: IPv6 address %q must be enclosed in brackets", s)
+	case strings.Contains(s, ":"):
+		i := strings.IndexByte(s, ':')
+		host, port = s[:i], s[i+1:]
+	}
+	if host == "" {
+		return "", fmt.Errorf("zk: missing host in %q", s)
+	}
+	addr := net.JoinHostPort(host, port)
+	if err := validateAddr(addr); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// validateAddr checks that addr is a host:port with a valid host and port.
+func validateAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("zk: invalid address %q: %v", addr, err)
+	}
+	if host == "" || strings.ContainsAny(host, " \t/,") {
+		return fmt.Errorf("zk: invalid host %q", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("zk: invalid port %q", port)
+	}
+	return nil
+}
+
+// Validate checks the servers and chroot of cfg, so that configs built
+// directly rather than by ParseConnectString fail as early.
+func (cfg *ConnectConfig) Validate() error {
+	if len(cfg.Servers) == 0 {
+		return errors.New("zk: server list must not be empty")
+	}
+	for _, addr := range cfg.Servers {
+		if err := validateAddr(addr); err != nil {
+			return err
+		}
+	}
+	if cfg.Chroot != "/" {
+		return validateChroot(cfg.Chroot)
+	}
+	return nil
+}
+
+// validateChroot checks that chroot is "" or a valid path without a
+// trailing slash, as it is prepended to every path sent to the server.
+func validateChroot(chroot string) error {
+	if chroot == "" {
+		return nil
+	}
+	if err := validatePath(chroot, false); err != nil {
+		return fmt.Errorf("zk: invalid chroot %q: %v", chroot, err)
+	}
+	return nil
+}
+
+// String returns cfg as a connect string.
+func (cfg *ConnectConfig) String() string {
+	return strings.Join(cfg.Servers, ",") + cfg.Chroot
+}
+
+// WithChroot returns a connection option that makes all paths relative to
+// chroot, like the chroot suffix of a connect string. Paths returned by the
+// server and in events have the chroot removed. A chroot of "" or "/" has no
+// effect, and Connect fails if chroot is not a valid path, e.g. because it
+// has a trailing slash.
+func WithChroot(chroot string) connOption {
+	return func(c *Conn) {
+		if chroot == "/" {
+			chroot = ""
+		}
+		c.chroot = chroot
+	}
+}
+
+// ConnectWithConfig is like Connect but takes the servers and chroot from
+// cfg, which is validated first.
+func ConnectWithConfig(cfg *ConnectConfig, sessionTimeout time.Duration, options ...connOption) (*Conn, <-chan Event, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	options = append([]connOption{WithChroot(cfg.Chroot)}, options...)
+	return Connect(cfg.Servers, sessionTimeout, options...)
+}
@@ -0,0 +1,156 @@
+package zk
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConnectString(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in  string
+		out *ConnectConfig
+	}{
+		{"zk1", &ConnectConfig{Servers: []string{"zk1:2181"}}},
+		{"zk1:2182,zk2", &ConnectConfig{Servers: []string{"zk1:2182", "zk2:2181"}}},
+		{"zk1, zk2:2183/app/one", &ConnectConfig{Servers: []string{"zk1:2181", "zk2:2183"}, Chroot: "/app/one"}},
+		{"10.0.0.1:2181,[::1],[fe80::1]:2182/", &ConnectConfig{Servers: []string{"10.0.0.1:2181", "[::1]:2181", "[fe80::1]:2182"}, Chroot: "/"}},
+	}
+	for _, tt := range tests {
+		cfg, err := ParseConnectString(tt.in)
+		if err != nil {
+			t.Errorf("ParseConnectString(%q) returned error: %+v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, tt.out) {
+			t.Errorf("ParseConnectString(%q) = %+v, expected %+v", tt.in, cfg, tt.out)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"/app",
+		"zk1,,zk2",
+		"zk1:",
+		"zk1:0",
+		"zk1:65536",
+		"zk1:port",
+		":2181",
+		"::1",
+		"[::1",
+		"[::1]2181",
+		"[10.0.0.1]:2181",
+		"zk1/app/",
+		"zk1/app//one",
+	} {
+		if cfg, err := ParseConnectString(in); err == nil {
+			t.Errorf("ParseConnectString(%q) = %+v, expected an error", in, cfg)
+		}
+	}
+}
+
+func TestConnectConfigValidate(t *testing.T) {
+	t.Parallel()
+	if err := (&ConnectConfig{Servers: []string{"zk1:2181"}, Chroot: "/app"}).Validate(); err != nil {
+		t.Fatalf("Validate returned error: %+v", err)
+	}
+	for _, cfg := range []*ConnectConfig{
+		{},
+		{Servers: []string{"zk1"}},
+		{Servers: []string{"zk1:2181"}, Chroot: "app"},
+		{Servers: []string{"zk1:2181"}, Chroot: "/app/"},
+		{Servers: []string{"zk1:2181"}, Chroot: "/a//b"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate of %+v returned no error", cfg)
+		}
+		if _, _, err := ConnectWithConfig(cfg, time.Second); err == nil {
+			t.Errorf("ConnectWithConfig with %+v returned no error", cfg)
+		}
+	}
+}
+
+func TestChrootPaths(t *testing.T) {
+	t.Parallel()
+	c := &Conn{}
+	WithChroot("/app")(c)
+	for in, out := range map[string]string{"/": "/app", "/a": "/app/a", "/a/b": "/app/a/b"} {
+		if p, err := c.processPath(in, false); err != nil || p != out {
+			t.Errorf("processPath(%q) = %q, %v, expected %q", in, p, err, out)
+		}
+	}
+	for in, out := range map[string]string{"/app": "/", "/app/a": "/a", "/apple": "/apple", "/zookeeper/config": "/zookeeper/config"} {
+		if p := c.clientPath(in); p != out {
+			t.Errorf("clientPath(%q) = %q, expected %q", in, p, out)
+		}
+	}
+
+	WithChroot("/")(c)
+	if p, _ := c.processPath("/a", false); p != "/a" {
+		t.Errorf("processPath with chroot / = %q, expected /a", p)
+	}
+}
+
+func TestConnectInvalidChroot(t *testing.T) {
+	t.Parallel()
+	for _, chroot := range []string{"app/", "/app/", "/a//b"} {
+		if _, _, err := Connect([]string{"127.0.0.1:2181"}, time.Second, WithChroot(chroot)); err == nil {
+			t.Errorf("Connect with chroot %q returned no error", chroot)
+		}
+	}
+}
+
+func TestChroot(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	cfg, err := ParseConnectString(fmt.Sprintf("127.0.0.1:%d/gozk-test-chroot", ts.Servers[0].Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer root.Close()
+	if _, err := root.Create(cfg.Chroot, nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	zk, _, err := ConnectWithConfig(cfg, time.Second*15)
+	if err != nil {
+		t.Fatalf("ConnectWithConfig returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	_, _, ch, err := zk.ChildrenW("/")
+	if err != nil {
+		t.Fatalf("ChildrenW returned error: %+v", err)
+	}
+	path, err := zk.Create("/node-", []byte{1}, FlagSequence|FlagEphemeral, WorldACL(PermAll))
+	if err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	if path != "/node-0000000000" {
+		t.Fatalf("Create returned %q, expected /node-0000000000", path)
+	}
+	if data, _, err := root.Get(cfg.Chroot + path); err != nil || data[0] != 1 {
+		t.Fatalf("Get of %s outside the chroot returned %v, %+v", path, data, err)
+	}
+	select {
+	case ev := <-ch:
+		if ev.Type != EventNodeChildrenChanged || ev.Path != "/" {
+			t.Fatalf("Expected a children event on /, got %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Child watcher timed out")
+	}
+	children, _, err := zk.Children("/")
+	if err != nil || len(children) != 1 || "/"+children[0] != path {
+		t.Fatalf("Children of / returned %v, %+v", children, err)
+	}
+}
@@ -11,45 +11,67 @@ const (
 )
 
 const (
-	opNotify       = 0
-	opCreate       = 1
-	opDelete       = 2
-	opExists       = 3
-	opGetData      = 4
-	opSetData      = 5
-	opGetAcl       = 6
-	opSetAcl       = 7
-	opGetChildren  = 8
-	opSync         = 9
-	opPing         = 11
-	opGetChildren2 = 12
-	opCheck        = 13
-	opMulti        = 14
-	opClose        = -11
-	opSetAuth      = 100
-	opSetWatches   = 101
+	opNotify               = 0
+	opCreate               = 1
+	opDelete               = 2
+	opExists               = 3
+	opGetData              = 4
+	opSetData              = 5
+	opGetAcl               = 6
+	opSetAcl               = 7
+	opGetChildren          = 8
+	opSync                 = 9
+	opPing                 = 11
+	opGetChildren2         = 12
+	opCheck                = 13
+	opMulti                = 14
+	opCreate2              = 15
+	opCheckWatches         = 17
+	opRemoveWatches        = 18
+	opCreateContainer      = 19
+	opCreateTTL            = 21
+	opMultiRead            = 22
+	opClose                = -11
+	opSetAuth              = 100
+	opSetWatches           = 101
+	opSasl                 = 102
+	opGetAllChildrenNumber = 104
+	opSetWatches2          = 105
+	opAddWatch             = 106
+	opWhoAmI               = 107
 	// Not in protocol, used internally
 	opWatcherEvent = -2
 )
 
 const (
-	EventNodeCreated         = EventType(1)
-	EventNodeDeleted         = EventType(2)
-	EventNodeDataChanged     = EventType(3)
-	EventNodeChildrenChanged = EventType(4)
+	EventNodeCreated            = EventType(1)
+	EventNodeDeleted            = EventType(2)
+	EventNodeDataChanged        = EventType(3)
+	EventNodeChildrenChanged    = EventType(4)
+	EventDataWatchRemoved       = EventType(5)
+	EventChildWatchRemoved      = EventType(6)
+	EventPersistentWatchRemoved = EventType(7)
 
 	EventSession     = EventType(-1)
 	EventNotWatching = EventType(-2)
+	// EventWatcherOverflow takes the place of events dropped because a
+	// buffer was full, see WithEventBuffer and WithWatchBuffer. Its Err is
+	// an *EventOverflowError.
+	EventWatcherOverflow = EventType(-3)
 )
 
 var (
 	eventNames = map[EventType]string{
-		EventNodeCreated:         "EventNodeCreated",
-		EventNodeDeleted:         "EventNodeDeleted",
-		EventNodeDataChanged:     "EventNodeDataChanged",
-		EventNodeChildrenChanged: "EventNodeChildrenChanged",
-		EventSession:             "EventSession",
-		EventNotWatching:         "EventNotWatching",
+		EventNodeCreated:            "EventNodeCreated",
+		EventNodeDeleted:            "EventNodeDeleted",
+		EventNodeDataChanged:        "EventNodeDataChanged",
+		EventNodeChildrenChanged:    "EventNodeChildrenChanged",
+		EventDataWatchRemoved:       "EventDataWatchRemoved",
+		EventChildWatchRemoved:      "EventChildWatchRemoved",
+		EventPersistentWatchRemoved: "EventPersistentWatchRemoved",
+		EventSession:                "EventSession",
+		EventNotWatching:            "EventNotWatching",
+		EventWatcherOverflow:        "EventWatcherOverflow",
 	}
 )
 
@@ -70,6 +92,7 @@ const (
 const (
 	FlagEphemeral = 1
 	FlagSequence  = 2
+	FlagContainer = 4
 )
 
 var (
@@ -113,6 +136,10 @@ var (
 	ErrClosing                 = errors.New("zk: zookeeper is closing")
 	ErrNothing                 = errors.New("zk: no server responsees to process")
 	ErrSessionMoved            = errors.New("zk: session moved to another server, so operation is ignored")
+	ErrNoWatcher               = errors.New("zk: no such watcher")
+	ErrNotReadOnly             = errors.New("zk: state-changing request sent to a read-only server")
+	ErrUnimplemented           = errors.New("zk: operation is not implemented by the server")
+	ErrBadArguments            = errors.New("zk: invalid arguments")
 
 	// ErrInvalidCallback         = errors.New("zk: invalid callback specified")
 	errCodeToError = map[ErrCode]error{
@@ -125,12 +152,16 @@ var (
 		errNodeExists:              ErrNodeExists,
 		errNotEmpty:                ErrNotEmpty,
 		errSessionExpired:          ErrSessionExpired,
+		errUnimplemented:           ErrUnimplemented,
+		errBadArguments:            ErrBadArguments,
 		// errInvalidCallback:         ErrInvalidCallback,
 		errInvalidAcl:   ErrInvalidACL,
 		errAuthFailed:   ErrAuthFailed,
 		errClosing:      ErrClosing,
 		errNothing:      ErrNothing,
 		errSessionMoved: ErrSessionMoved,
+		errNotReadOnly:  ErrNotReadOnly,
+		errNoWatcher:    ErrNoWatcher,
 	}
 )
 
@@ -141,6 +172,20 @@ func (e ErrCode) toError() error {
 	return ErrUnknown
 }
 
+// errorCode returns the ZooKeeper error code of err, or 0 if err is nil or
+// has none, e.g. ErrConnectionClosed.
+func errorCode(err error) ErrCode {
+	if err == nil {
+		return 0
+	}
+	for code, e := range errCodeToError {
+		if e == err {
+			return code
+		}
+	}
+	return 0
+}
+
 const (
 	errOk = 0
 	// System and server-side errors
@@ -168,6 +213,33 @@ const (
 	errClosing                 = ErrCode(-116)
 	errNothing                 = ErrCode(-117)
 	errSessionMoved            = ErrCode(-118)
+	errNotReadOnly             = ErrCode(-119)
+	errNoWatcher               = ErrCode(-121)
+)
+
+// WatchMode is the mode of a watch added with AddWatch.
+type WatchMode int32
+
+const (
+	// WatchModePersistent fires for every change to the watched node and
+	// its list of children until it is removed.
+	WatchModePersistent = WatchMode(0)
+	// WatchModePersistentRecursive fires for every change to the watched
+	// node and all of its descendants until it is removed. It does not
+	// report EventNodeChildrenChanged, as creations and deletions of the
+	// descendants themselves are reported.
+	WatchModePersistentRecursive = WatchMode(1)
+)
+
+// WatcherType selects the watches removed by RemoveAllWatches.
+type WatcherType int32
+
+const (
+	WatcherTypeChildren            = WatcherType(1)
+	WatcherTypeData                = WatcherType(2) // Data and exists watches.
+	WatcherTypeAny                 = WatcherType(3)
+	WatcherTypePersistent          = WatcherType(4)
+	WatcherTypePersistentRecursive = WatcherType(5)
 )
 
 // Constants for ACL permissions
@@ -183,23 +255,34 @@ const (
 var (
 	emptyPassword = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	opNames       = map[int32]string{
-		opNotify:       "notify",
-		opCreate:       "create",
-		opDelete:       "delete",
-		opExists:       "exists",
-		opGetData:      "getData",
-		opSetData:      "setData",
-		opGetAcl:       "getACL",
-		opSetAcl:       "setACL",
-		opGetChildren:  "getChildren",
-		opSync:         "sync",
-		opPing:         "ping",
-		opGetChildren2: "getChildren2",
-		opCheck:        "check",
-		opMulti:        "multi",
-		opClose:        "close",
-		opSetAuth:      "setAuth",
-		opSetWatches:   "setWatches",
+		opNotify:               "notify",
+		opCreate:               "create",
+		opDelete:               "delete",
+		opExists:               "exists",
+		opGetData:              "getData",
+		opSetData:              "setData",
+		opGetAcl:               "getACL",
+		opSetAcl:               "setACL",
+		opGetChildren:          "getChildren",
+		opSync:                 "sync",
+		opPing:                 "ping",
+		opGetChildren2:         "getChildren2",
+		opCheck:                "check",
+		opMulti:                "multi",
+		opCreate2:              "create2",
+		opCheckWatches:         "checkWatches",
+		opRemoveWatches:        "removeWatches",
+		opCreateContainer:      "createContainer",
+		opCreateTTL:            "createTTL",
+		opMultiRead:            "multiRead",
+		opClose:                "close",
+		opSetAuth:              "setAuth",
+		opSetWatches:           "setWatches",
+		opSasl:                 "sasl",
+		opGetAllChildrenNumber: "getAllChildrenNumber",
+		opSetWatches2:          "setWatches2",
+		opAddWatch:             "addWatch",
+		opWhoAmI:               "whoAmI",
 
 		opWatcherEvent: "watcherEvent",
 	}
@@ -214,7 +297,7 @@ func (t EventType) String() string {
 	return "Unknown"
 }
 
-// Mode is used to build custom server modes (leader|follower|standalone).
+// Mode is used to build custom server modes (leader|follower|observer|standalone).
 type Mode uint8
 
 func (m Mode) String() string {
@@ -229,6 +312,7 @@ const (
 	ModeLeader     Mode = iota
 	ModeFollower   Mode = iota
 	ModeStandalone Mode = iota
+	ModeObserver   Mode = iota
 )
 
 var (
@@ -236,5 +320,6 @@ var (
 		ModeLeader:     "leader",
 		ModeFollower:   "follower",
 		ModeStandalone: "standalone",
+		ModeObserver:   "observer",
 	}
 )
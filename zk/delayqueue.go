@@ -0,0 +1,80 @@
+package zk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DelayQueue is a Queue whose items can only be claimed once they are due.
+// The time an item is due is encoded in its node name, so the items sort by
+// it, and consumers waiting in Claim set a timer for the first item due
+// instead of polling.
+type DelayQueue struct {
+	q *Queue
+}
+
+// NewDelayQueue creates a new delay queue instance using the provided
+// connection, path, and acl. The path must be a node that is only used by
+// this queue.
+func NewDelayQueue(c *Conn, path string, acl []ACL) *DelayQueue {
+	q := NewQueue(c, path, acl)
+	q.due = itemDue
+	return &DelayQueue{q: q}
+}
+
+// SetOwner sets the owner info written into the claim nodes of the consumer.
+// It defaults to NewOwnerInfo(nil).
+func (d *DelayQueue) SetOwner(owner OwnerInfo) {
+	d.q.SetOwner(owner)
+}
+
+// Put adds an item with the given data that can be claimed from readyAt on,
+// and returns its path. Items due at the same millisecond are claimed in the
+// order they were put. Consumers compare the time with their own clock.
+func (d *DelayQueue) Put(data []byte, readyAt time.Time) (string, error) {
+	return d.q.put("item-"+dueToString(readyAt)+"-", data)
+}
+
+// Len returns the number of items in the queue, including claimed ones and
+// ones not due yet.
+func (d *DelayQueue) Len() (int, error) {
+	return d.q.Len()
+}
+
+// Claim claims the item due first that is due and not claimed by another
+// consumer, waiting for one if there is none.
+func (d *DelayQueue) Claim() (*Claim, error) {
+	return d.q.Claim()
+}
+
+// TryClaim claims the item due first that is due and not claimed by another
+// consumer, or returns ErrEmptyQueue if there is none.
+func (d *DelayQueue) TryClaim() (*Claim, error) {
+	return d.q.TryClaim()
+}
+
+// dueToString encodes t as the fixed width hexadecimal number of milliseconds
+// since the Unix epoch, so the strings sort like the times.
+func dueToString(t time.Time) string {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	if ms < 0 {
+		ms = 0
+	}
+	return fmt.Sprintf("%016X", ms)
+}
+
+// itemDue returns when the item of a DelayQueue called name is due. Items
+// whose name does not tell are due.
+func itemDue(name string) time.Time {
+	s := strings.TrimPrefix(name, "item-")
+	if len(s) < 16 {
+		return time.Time{}
+	}
+	ms, err := strconv.ParseInt(s[:16], 16, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
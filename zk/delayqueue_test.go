@@ -0,0 +1,67 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemDue(t *testing.T) {
+	t.Parallel()
+	at := time.Unix(1700000000, 123000000)
+	name := "item-" + dueToString(at) + "-0000000007"
+	if due := itemDue(name); !due.Equal(at) {
+		t.Fatalf("Item %s due %s, expected %s", name, due, at)
+	}
+	if due := itemDue("item-0000000007"); !due.IsZero() {
+		t.Fatalf("Item without due time due %s", due)
+	}
+	if a, b := dueToString(at), dueToString(at.Add(time.Millisecond)); a >= b {
+		t.Fatalf("%s does not sort before %s", a, b)
+	}
+}
+
+func TestDelayQueueClaim(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	q := NewDelayQueue(zk, "/q", WorldACL(PermAll))
+
+	readyAt := time.Now().Add(200 * time.Millisecond)
+	name := "item-" + dueToString(readyAt) + "-0000000001"
+	type result struct {
+		cl  *Claim
+		err error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		cl, err := q.Claim()
+		done <- result{cl, err}
+	}()
+
+	// Not due yet, Claim waits for it without asking again meanwhile.
+	serveFake(t, fc, "getChildren2", "/q/items", nil, &getChildren2Response{Children: []string{name}})
+	serveFake(t, fc, "getChildren2", "/q/claims", nil, &getChildren2Response{})
+	// The watches did not fire and are not set again.
+	req := serveFake(t, fc, "getChildren2", "/q/items", nil, &getChildren2Response{Children: []string{name}})
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("Asked again after %s", elapsed)
+	}
+	if req.Body.(*getChildren2Request).Watch {
+		t.Fatal("Items watched again while the watch is pending")
+	}
+	serveFake(t, fc, "create", "/q/claims/"+name, nil, &createResponse{Path: "/q/claims/" + name})
+	serveFake(t, fc, "getData", "/q/items/"+name, nil, &getDataResponse{Data: []byte("later")})
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.cl.Path != "/q/items/"+name || string(r.cl.Data) != "later" {
+		t.Fatalf("Claimed %s with %q", r.cl.Path, r.cl.Data)
+	}
+	if n := zk.DebugSnapshot().Watchers; n != 2 {
+		t.Fatalf("%d watchers left instead of 2", n)
+	}
+}
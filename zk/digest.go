@@ -0,0 +1,136 @@
+package zk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+// DigestFields selects the Stat fields a Digest covers besides the paths and
+// data of the nodes.
+type DigestFields int
+
+const (
+	// DigestVersions includes Version, Cversion and Aversion. They differ
+	// between a subtree and a copy made by writing its nodes, so leave them
+	// out to verify a migration.
+	DigestVersions DigestFields = 1 << iota
+	// DigestEphemeral includes whether each node is ephemeral.
+	DigestEphemeral
+)
+
+// Digest is a deterministic checksum of a subtree. Two subtrees with the same
+// node names below their roots, data and selected Stat fields have the same
+// Sum, wherever they are and whichever ensemble they are on.
+type Digest struct {
+	// Sum is the SHA-256 checksum of the whole subtree.
+	Sum [sha256.Size]byte
+	// Nodes are the checksums of each node by path relative to the root of
+	// the subtree, which itself is "/".
+	Nodes map[string][sha256.Size]byte
+}
+
+// Digest computes the digest of the subtree at path, reading it with
+// pipelined requests like Prefetch. As it is not read atomically, the subtree
+// should not change meanwhile.
+func (c *Conn) Digest(path string, fields DigestFields) (*Digest, error) {
+	nodes, err := c.Prefetch(path, -1, exportConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNoNode
+	}
+
+	d := &Digest{Nodes: make(map[string][sha256.Size]byte, len(nodes))}
+	for p, node := range nodes {
+		rel := relativePath(path, p)
+		d.Nodes[rel] = digestNode(rel, node, fields)
+	}
+
+	rels := make([]string, 0, len(d.Nodes))
+	for rel := range d.Nodes {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	h := sha256.New()
+	for _, rel := range rels {
+		sum := d.Nodes[rel]
+		h.Write(sum[:])
+	}
+	copy(d.Sum[:], h.Sum(nil))
+	return d, nil
+}
+
+// Diff returns the relative paths of the nodes that differ between d and
+// other, or exist in only one of them, in sorted order.
+func (d *Digest) Diff(other *Digest) []string {
+	var diff []string
+	for rel, sum := range d.Nodes {
+		if otherSum, ok := other.Nodes[rel]; !ok || otherSum != sum {
+			diff = append(diff, rel)
+		}
+	}
+	for rel := range other.Nodes {
+		if _, ok := d.Nodes[rel]; !ok {
+			diff = append(diff, rel)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// Compare compares the subtrees at pathA and pathB of the connection and
+// returns the relative paths of the nodes that differ, which is empty if the
+// subtrees match.
+func (c *Conn) Compare(pathA, pathB string, fields DigestFields) ([]string, error) {
+	return CompareTrees(c, pathA, c, pathB, fields)
+}
+
+// CompareTrees is like Compare for subtrees on two connections, e.g. to two
+// ensembles after a migration.
+func CompareTrees(connA *Conn, pathA string, connB *Conn, pathB string, fields DigestFields) ([]string, error) {
+	a, err := connA.Digest(pathA, fields)
+	if err != nil {
+		return nil, err
+	}
+	b, err := connB.Digest(pathB, fields)
+	if err != nil {
+		return nil, err
+	}
+	if a.Sum == b.Sum {
+		return nil, nil
+	}
+	return a.Diff(b), nil
+}
+
+// relativePath returns p relative to root, with root itself being "/".
+func relativePath(root, p string) string {
+	if root == "/" {
+		return p
+	}
+	if rel := strings.TrimPrefix(p, root); rel != "" {
+		return rel
+	}
+	return "/"
+}
+
+// digestNode returns the checksum of the node at the relative path rel.
+func digestNode(rel string, node NodeData, fields DigestFields) [sha256.Size]byte {
+	var buf bytes.Buffer
+	writeBytes := func(b []byte) {
+		binary.Write(&buf, binary.BigEndian, int32(len(b)))
+		buf.Write(b)
+	}
+	writeBytes([]byte(rel))
+	writeBytes(node.Data)
+	if fields&DigestVersions != 0 {
+		binary.Write(&buf, binary.BigEndian, []int32{node.Stat.Version, node.Stat.Cversion, node.Stat.Aversion})
+	}
+	if fields&DigestEphemeral != 0 {
+		binary.Write(&buf, binary.BigEndian, node.Stat.EphemeralOwner != 0)
+	}
+	return sha256.Sum256(buf.Bytes())
+}
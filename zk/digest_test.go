@@ -0,0 +1,83 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDigestNode(t *testing.T) {
+	t.Parallel()
+	node := NodeData{Data: []byte("data"), Stat: &Stat{Version: 1, Czxid: 10, Mzxid: 12}}
+	moved := NodeData{Data: []byte("data"), Stat: &Stat{Version: 3, Czxid: 20, Mzxid: 21}}
+	if digestNode("/a", node, 0) != digestNode("/a", moved, 0) {
+		t.Fatal("Digest without versions depends on the Stat")
+	}
+	if digestNode("/a", node, DigestVersions) == digestNode("/a", moved, DigestVersions) {
+		t.Fatal("Digest with versions ignores the version")
+	}
+	if digestNode("/a", node, 0) == digestNode("/b", node, 0) {
+		t.Fatal("Digest ignores the path")
+	}
+	// Path and data are length prefixed, so moving bytes between them
+	// changes the digest.
+	if digestNode("/ab", NodeData{Data: []byte("c"), Stat: &Stat{}}, 0) == digestNode("/a", NodeData{Data: []byte("bc"), Stat: &Stat{}}, 0) {
+		t.Fatal("Digest is ambiguous")
+	}
+	ephemeral := NodeData{Data: []byte("data"), Stat: &Stat{EphemeralOwner: 1}}
+	if digestNode("/a", node, DigestEphemeral) == digestNode("/a", ephemeral, DigestEphemeral) {
+		t.Fatal("Digest with DigestEphemeral ignores ephemeral nodes")
+	}
+
+	for _, tt := range []struct{ root, p, rel string }{
+		{"/", "/", "/"},
+		{"/", "/a", "/a"},
+		{"/root", "/root", "/"},
+		{"/root", "/root/a/b", "/a/b"},
+	} {
+		if rel := relativePath(tt.root, tt.p); rel != tt.rel {
+			t.Errorf("relativePath(%q, %q) = %q, expected %q", tt.root, tt.p, rel, tt.rel)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	for _, root := range []string{"/gozk-test-a", "/gozk-test-b"} {
+		for _, p := range []string{"", "/x", "/x/y", "/z"} {
+			if _, err := zk.Create(root+p, []byte(p), 0, WorldACL(PermAll)); err != nil {
+				t.Fatalf("Create returned error: %+v", err)
+			}
+		}
+	}
+	if diff, err := zk.Compare("/gozk-test-a", "/gozk-test-b", 0); err != nil || len(diff) != 0 {
+		t.Fatalf("Compare of equal subtrees returned %v, %+v", diff, err)
+	}
+
+	if _, err := zk.Set("/gozk-test-b/x/y", []byte("changed"), -1); err != nil {
+		t.Fatalf("Set returned error: %+v", err)
+	}
+	if _, err := zk.Create("/gozk-test-b/extra", nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	diff, err := CompareTrees(zk, "/gozk-test-a", zk, "/gozk-test-b", 0)
+	if err != nil {
+		t.Fatalf("CompareTrees returned error: %+v", err)
+	}
+	if !reflect.DeepEqual(diff, []string{"/extra", "/x/y"}) {
+		t.Fatalf("CompareTrees returned %v", diff)
+	}
+
+	if _, err := zk.Digest("/gozk-test-missing", 0); err != ErrNoNode {
+		t.Fatalf("Digest of a missing node returned %+v instead of ErrNoNode", err)
+	}
+}
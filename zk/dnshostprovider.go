@@ -3,18 +3,28 @@ package zk
 import (
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"time"
 )
 
-// DNSHostProvider is the default HostProvider. It currently matches
-// the Java StaticHostProvider, resolving hosts from DNS once during
-// the call to Init.  It could be easily extended to re-query DNS
-// periodically or if there is trouble connecting.
+// DNSHostProvider is the default HostProvider. It resolves hosts from DNS
+// during the call to Init, and again before every connection attempt, so
+// that ensemble members replaced behind stable host names are found once the
+// connection is lost. If the addresses did not change the servers are tried
+// in the same order, otherwise in a new random order. A failed lookup keeps
+// the previous addresses.
 type DNSHostProvider struct {
+	// ResolveInterval, if positive, makes the hosts be resolved again at most
+	// once per interval rather than before every attempt.
+	ResolveInterval time.Duration
+
 	mu         sync.Mutex // Protects everything, so we can add asynchronous updates later.
+	hosts      []string   // servers as passed to Init
 	servers    []string
 	curr       int
 	last       int
+	resolved   time.Time
 	lookupHost func(string) ([]string, error) // Override of net.LookupHost, for testing.
 }
 
@@ -25,37 +35,39 @@ func (hp *DNSHostProvider) Init(servers []string) error {
 	hp.mu.Lock()
 	defer hp.mu.Unlock()
 
-	lookupHost := hp.lookupHost
-	if lookupHost == nil {
-		lookupHost = net.LookupHost
-	}
-
-	found := []string{}
-	for _, server := range servers {
-		host, port, err := net.SplitHostPort(server)
-		if err != nil {
-			return err
-		}
-		addrs, err := lookupHost(host)
-		if err != nil {
-			return err
-		}
-		for _, addr := range addrs {
-			found = append(found, net.JoinHostPort(addr, port))
-		}
-	}
-
-	if len(found) == 0 {
-		return fmt.Errorf("No hosts found for addresses %q", servers)
+	found, err := resolveServers(servers, hp.lookupHost)
+	if err != nil {
+		return err
 	}
 
 	// Randomize the order of the servers to avoid creating hotspots
 	stringShuffle(found)
 
+	hp.hosts = servers
 	hp.servers = found
 	hp.curr = -1
 	hp.last = -1
+	hp.resolved = time.Now()
+
+	return nil
+}
+
+var _ HostListUpdater = &DNSHostProvider{}
+
+// UpdateServers replaces the servers. They are looked up like in Init, but
+// if they resolve to the same addresses the order in which they are tried is
+// kept.
+func (hp *DNSHostProvider) UpdateServers(servers []string) error {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
 
+	found, err := resolveServers(servers, hp.lookupHost)
+	if err != nil {
+		return err
+	}
+	hp.hosts = servers
+	hp.resolved = time.Now()
+	hp.setServers(found)
 	return nil
 }
 
@@ -72,6 +84,9 @@ func (hp *DNSHostProvider) Len() int {
 func (hp *DNSHostProvider) Next() (server string, retryStart bool) {
 	hp.mu.Lock()
 	defer hp.mu.Unlock()
+	if hp.ResolveInterval <= 0 || time.Since(hp.resolved) >= hp.ResolveInterval {
+		hp.reresolve()
+	}
 	hp.curr = (hp.curr + 1) % len(hp.servers)
 	retryStart = hp.curr == hp.last
 	if hp.last == -1 {
@@ -86,3 +101,69 @@ func (hp *DNSHostProvider) Connected() {
 	defer hp.mu.Unlock()
 	hp.last = hp.curr
 }
+
+// reresolve looks up the hosts again and switches to the new addresses if
+// they changed. The caller must hold mu.
+func (hp *DNSHostProvider) reresolve() {
+	hp.resolved = time.Now()
+	if found, err := resolveServers(hp.hosts, hp.lookupHost); err == nil {
+		hp.setServers(found)
+	}
+}
+
+// setServers switches to the addresses found, in random order, unless they
+// are the current ones. The caller must hold mu.
+func (hp *DNSHostProvider) setServers(found []string) {
+	if sameAddrs(found, hp.servers) {
+		return
+	}
+	stringShuffle(found)
+	hp.servers = found
+	hp.curr = -1
+	hp.last = -1
+}
+
+// sameAddrs reports whether a and b hold the same addresses in any order.
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveServers uses DNS to look up addresses for each server. If lookupHost
+// is nil net.LookupHost is used.
+func resolveServers(servers []string, lookupHost func(string) ([]string, error)) ([]string, error) {
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+
+	found := []string{}
+	for _, server := range servers {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := lookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			found = append(found, net.JoinHostPort(addr, port))
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("No hosts found for addresses %q", servers)
+	}
+	return found, nil
+}
@@ -222,3 +222,84 @@ func TestDNSHostProviderRetryStart(t *testing.T) {
 		}
 	}
 }
+
+// TestDNSHostProviderReresolve tests that hosts are looked up again before
+// connection attempts.
+func TestDNSHostProviderReresolve(t *testing.T) {
+	t.Parallel()
+
+	addrs := []string{"192.0.2.1", "192.0.2.2"}
+	var lookupErr error
+	lookups := 0
+	hp := &DNSHostProvider{lookupHost: func(host string) ([]string, error) {
+		lookups++
+		return addrs, lookupErr
+	}}
+	if err := hp.Init([]string{"foo.example.com:12345"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := hp.Next()
+	second, _ := hp.Next()
+	if third, _ := hp.Next(); third != first {
+		t.Fatalf("Unchanged addresses not tried in the same order: %s, %s, %s", first, second, third)
+	}
+
+	// The host now points elsewhere.
+	addrs = []string{"192.0.2.3"}
+	if server, _ := hp.Next(); server != "192.0.2.3:12345" {
+		t.Fatalf("Next returned %s after the address changed", server)
+	}
+	lookupErr = fmt.Errorf("lookup failed")
+	if server, _ := hp.Next(); server != "192.0.2.3:12345" {
+		t.Fatalf("Next returned %s after a failed lookup", server)
+	}
+	if lookups != 6 {
+		t.Fatalf("Expected 6 lookups, got %d", lookups)
+	}
+
+	hp.ResolveInterval = time.Hour
+	lookupErr = nil
+	hp.Next()
+	hp.Next()
+	if lookups != 6 {
+		t.Fatalf("Hosts looked up again within ResolveInterval: %d lookups", lookups)
+	}
+}
+
+func TestDNSHostProviderUpdateServers(t *testing.T) {
+	t.Parallel()
+
+	hp := &DNSHostProvider{ResolveInterval: time.Hour, lookupHost: func(host string) ([]string, error) {
+		switch host {
+		case "a.example.com", "alias.example.com":
+			return []string{"192.0.2.1", "192.0.2.2"}, nil
+		case "b.example.com":
+			return []string{"192.0.2.3"}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}}
+	if err := hp.Init([]string{"a.example.com:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := hp.Next()
+	hp.Connected()
+
+	// The same addresses under another name keep the position.
+	if err := hp.UpdateServers([]string{"alias.example.com:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	if next, retryStart := hp.Next(); next == first || retryStart {
+		t.Fatalf("Next returned %s, %v after an update to the same addresses", next, retryStart)
+	}
+
+	if err := hp.UpdateServers([]string{"missing.example.com:2181"}); err == nil {
+		t.Fatal("UpdateServers succeeded with a failing lookup")
+	}
+	if err := hp.UpdateServers([]string{"b.example.com:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	if server, _ := hp.Next(); server != "192.0.2.3:2181" || hp.Len() != 1 {
+		t.Fatalf("Next returned %s of %d servers after an update", server, hp.Len())
+	}
+}
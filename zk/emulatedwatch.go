@@ -0,0 +1,337 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SupportsPersistentWatches reports whether the server the connection is
+// connected to supports AddWatch, which requires ZooKeeper 3.6 or later. The
+// server is asked for its version like with ServerVersion.
+func (c *Conn) SupportsPersistentWatches() (bool, error) {
+	version, err := c.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+	return versionAtLeast(version, 3, 6), nil
+}
+
+// versionAtLeast reports whether version, e.g. "3.6.3", is major.minor or
+// later.
+func versionAtLeast(version string, major, minor int) bool {
+	var maj, min int
+	if _, err := fmt.Sscanf(version, "%d.%d", &maj, &min); err != nil {
+		return false
+	}
+	return maj > major || maj == major && min >= minor
+}
+
+// AddWatchCompat adds a watch like AddWatch if the server supports
+// persistent watches, and like AddEmulatedWatch if it does not or its
+// version cannot be told, e.g. because the srvr four letter word is not
+// allowed.
+func (c *Conn) AddWatchCompat(path string, mode WatchMode) (<-chan Event, error) {
+	if ok, err := c.SupportsPersistentWatches(); err == nil && ok {
+		return c.AddWatch(path, mode)
+	}
+	return c.AddEmulatedWatch(path, mode)
+}
+
+// AddEmulatedWatch emulates AddWatch with one-shot watches, for servers older
+// than 3.6. It sets an exists and a children watch on path, and with
+// WatchModePersistentRecursive on every node below it, sets them again after
+// they fire and delivers the events the persistent watch would. Changes made
+// while a watch is being set again are found by reading the node again, so
+// several changes may be reported as one and a node that is created and
+// deleted quickly may not be reported at all. As every node costs two
+// watches and every change two reads, keep the watched subtree small.
+//
+// The one-shot watches are restored after a reconnect and fire for changes
+// made meanwhile, so unlike with AddWatch no EventSession events are
+// delivered. The returned channel must be drained. It is closed after an
+// EventNotWatching event when the watches are lost, e.g. because the session
+// expired, or after an EventPersistentWatchRemoved event once the watch is
+// removed with RemoveWatches.
+func (c *Conn) AddEmulatedWatch(path string, mode WatchMode) (<-chan Event, error) {
+	if _, err := c.processPath(path, false); err != nil {
+		return nil, err
+	}
+	e := &emulatedWatch{
+		c:         c,
+		path:      path,
+		recursive: mode == WatchModePersistentRecursive,
+		w:         c.limitWatcher(newPersistentWatcher(nil), c.watchBuffer, c.watchPolicy),
+		nodes:     make(map[string]bool),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	c.watchersLock.Lock()
+	if c.emulatedWatches == nil {
+		c.emulatedWatches = make(map[<-chan Event]*emulatedWatch)
+	}
+	c.emulatedWatches[e.w.ch] = e
+	c.watchersLock.Unlock()
+
+	if err := e.watch(path, nil); err != nil {
+		e.once.Do(func() {
+			e.cancel()
+			c.watchersLock.Lock()
+			delete(c.emulatedWatches, e.w.ch)
+			c.watchersLock.Unlock()
+			e.w.close()
+		})
+		return nil, err
+	}
+	return e.w.ch, nil
+}
+
+// removeEmulatedWatch ends the emulated watch on path delivering to ch, and
+// reports whether there is one.
+func (c *Conn) removeEmulatedWatch(path string, ch <-chan Event) bool {
+	c.watchersLock.Lock()
+	e := c.emulatedWatches[ch]
+	c.watchersLock.Unlock()
+	if e == nil || e.path != path {
+		return false
+	}
+	e.finish(Event{Type: EventPersistentWatchRemoved, State: StateConnected, Path: path})
+	return true
+}
+
+// emulatedWatch is a persistent watch emulated by a goroutine per watched
+// node, each of which sets the one-shot watches of its node.
+type emulatedWatch struct {
+	c         *Conn
+	path      string
+	recursive bool
+	w         *persistentWatcher
+
+	ctx    context.Context // done once the watch ends
+	cancel context.CancelFunc
+	once   sync.Once
+
+	mu    sync.Mutex
+	nodes map[string]bool // paths watched by a goroutine
+}
+
+// emulatedNode is the state of a watched node, owned by its goroutine.
+type emulatedNode struct {
+	path    string
+	dataCh  <-chan Event // exists watch, set while watching the node
+	childCh <-chan Event // children watch, set while the node exists
+}
+
+// finish ends the watch with ev, once.
+func (e *emulatedWatch) finish(ev Event) {
+	e.once.Do(func() {
+		e.cancel()
+		e.c.watchersLock.Lock()
+		delete(e.c.emulatedWatches, e.w.ch)
+		e.c.watchersLock.Unlock()
+		e.w.deliver(ev, -1)
+		e.w.close()
+	})
+}
+
+func (e *emulatedWatch) fail(err error) {
+	e.finish(Event{Type: EventNotWatching, State: StateDisconnected, Path: e.path, Err: err})
+}
+
+// deliver reports an event of type typ on p, with the state of the server
+// event that revealed it.
+func (e *emulatedWatch) deliver(typ EventType, p string, trigger Event) {
+	if typ == EventNodeChildrenChanged && e.recursive {
+		return
+	}
+	e.w.deliver(Event{Type: typ, State: trigger.State, Path: p}, -1)
+}
+
+// retry calls f until it succeeds or fails with an error other than a lost
+// connection, waiting for the session to be re-established in between.
+func (e *emulatedWatch) retry(f func() error) error {
+	for {
+		err := f()
+		if err == nil || !isConnectionError(err) {
+			return err
+		}
+		if err := e.c.WaitForSession(e.ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// watch starts watching the node at p, unless it is watched already. If
+// trigger is set, the node and the nodes below it are new and reported as
+// created. A node below the watched path that does not exist is not watched.
+func (e *emulatedWatch) watch(p string, trigger *Event) error {
+	if !e.claim(p) {
+		return nil
+	}
+	n := &emulatedNode{path: p}
+	exists, err := e.armData(n)
+	if err != nil {
+		e.release(p)
+		return err
+	}
+	if exists {
+		if trigger != nil {
+			e.deliver(EventNodeCreated, p, *trigger)
+		}
+		if err := e.armChildren(n, trigger); err != nil && err != ErrNoNode {
+			e.removeWatches(n)
+			e.release(p)
+			return err
+		}
+	} else if p != e.path {
+		e.removeWatches(n)
+		e.release(p)
+		return nil
+	}
+	go e.run(n)
+	return nil
+}
+
+// claim marks p as watched and reports whether it was not already.
+func (e *emulatedWatch) claim(p string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.nodes[p] {
+		return false
+	}
+	e.nodes[p] = true
+	return true
+}
+
+func (e *emulatedWatch) release(p string) {
+	e.mu.Lock()
+	delete(e.nodes, p)
+	e.mu.Unlock()
+}
+
+// armData sets the exists watch of n and reports whether the node exists.
+func (e *emulatedWatch) armData(n *emulatedNode) (bool, error) {
+	exists, _, ch, err := e.c.ExistsW(n.path)
+	if err != nil {
+		return false, err
+	}
+	n.dataCh = ch
+	return exists, nil
+}
+
+// armChildren sets the children watch of n and, for a recursive watch,
+// starts watching the children that are not watched yet.
+func (e *emulatedWatch) armChildren(n *emulatedNode, trigger *Event) error {
+	children, _, ch, err := e.c.ChildrenW(n.path)
+	if err != nil {
+		return err
+	}
+	n.childCh = ch
+	if !e.recursive {
+		return nil
+	}
+	for _, name := range children {
+		if err := e.watch(childPath(n.path, name), trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *emulatedWatch) removeWatches(n *emulatedNode) {
+	for _, ch := range []<-chan Event{n.dataCh, n.childCh} {
+		if ch != nil {
+			e.c.RemoveWatches(n.path, ch)
+		}
+	}
+	n.dataCh, n.childCh = nil, nil
+}
+
+// run waits for the watches of n to fire and sets them again, until the
+// node below the watched path is deleted or the watch ends.
+func (e *emulatedWatch) run(n *emulatedNode) {
+	defer e.removeWatches(n)
+	for {
+		var ev Event
+		fromChildren := false
+		select {
+		case ev = <-n.dataCh:
+			n.dataCh = nil
+		case ev = <-n.childCh:
+			n.childCh = nil
+			fromChildren = true
+		case <-e.ctx.Done():
+			return
+		}
+		if ev.Type == EventNotWatching {
+			e.fail(ev.Err)
+			return
+		}
+
+		var err error
+		switch {
+		case fromChildren && ev.Type == EventNodeDeleted:
+			// Reported by the exists watch.
+		case fromChildren:
+			// Children watches also fire on data changes, which are reported
+			// by the exists watch.
+			if ev.Type == EventNodeChildrenChanged {
+				e.deliver(EventNodeChildrenChanged, n.path, ev)
+			}
+			err = e.retry(func() error { return e.armChildren(n, &ev) })
+		case ev.Type == EventNodeDeleted:
+			e.deliver(EventNodeDeleted, n.path, ev)
+			err = e.deleted(n, ev)
+		default:
+			e.deliver(ev.Type, n.path, ev)
+			var exists bool
+			err = e.retry(func() (err error) { exists, err = e.armData(n); return err })
+			if err == nil && !exists {
+				// Deleted before the watch was set again.
+				e.deliver(EventNodeDeleted, n.path, ev)
+				err = e.deleted(n, ev)
+			} else if err == nil && ev.Type == EventNodeCreated {
+				err = e.retry(func() error { return e.armChildren(n, &ev) })
+			}
+		}
+		if err == errNodeGone {
+			return
+		} else if err != nil && err != ErrNoNode {
+			if err != context.Canceled {
+				e.fail(err)
+			}
+			return
+		}
+	}
+}
+
+// errNodeGone is returned by deleted when a node below the watched path is
+// no longer watched by its goroutine.
+var errNodeGone = errors.New("zk: node gone")
+
+// deleted handles the deletion of n. The watched path is waited for to be
+// created again. A node below it is handed back to its parent, unless it
+// was created again already, before the parent's children watch was set
+// again, in which case it is watched anew.
+func (e *emulatedWatch) deleted(n *emulatedNode, ev Event) error {
+	if n.path != e.path {
+		e.removeWatches(n)
+		e.release(n.path)
+		if err := e.retry(func() error { return e.watch(n.path, &ev) }); err != nil {
+			return err
+		}
+		return errNodeGone
+	}
+	n.childCh = nil
+	if n.dataCh != nil {
+		// Already waiting for it to be created again.
+		return nil
+	}
+	var exists bool
+	err := e.retry(func() (err error) { exists, err = e.armData(n); return err })
+	if err == nil && exists {
+		e.deliver(EventNodeCreated, n.path, ev)
+		err = e.retry(func() error { return e.armChildren(n, &ev) })
+	}
+	return err
+}
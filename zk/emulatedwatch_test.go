@@ -0,0 +1,136 @@
+package zk
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		version string
+		ok      bool
+	}{
+		{"3.6.0", true},
+		{"3.7.1", true},
+		{"4.0.0", true},
+		{"3.5.9", false},
+		{"3.4.6", false},
+		{"", false},
+	} {
+		if ok := versionAtLeast(tt.version, 3, 6); ok != tt.ok {
+			t.Errorf("versionAtLeast(%q, 3, 6) = %v, expected %v", tt.version, ok, tt.ok)
+		}
+	}
+}
+
+func TestEmulatedWatch(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	nodes := map[string]bool{"/a": true, "/a/b": true}
+	// serve answers the next n requests from nodes, in whichever order the
+	// watch sends them.
+	serve := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			req, err := fc.NextRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rerr error
+			var res interface{}
+			switch req.Op {
+			case "exists":
+				if nodes[req.Path] {
+					res = &existsResponse{}
+				} else {
+					rerr = ErrNoNode
+				}
+			case "getChildren2":
+				var children []string
+				for p := range nodes {
+					if strings.HasPrefix(p, req.Path+"/") && !strings.Contains(p[len(req.Path)+1:], "/") {
+						children = append(children, p[len(req.Path)+1:])
+					}
+				}
+				sort.Strings(children)
+				res = &getChildren2Response{Children: children}
+			case "removeWatches":
+				res = &removeWatchesResponse{}
+			default:
+				t.Fatalf("Unexpected %s request", req.Op)
+			}
+			if err := fc.Reply(req, 1, rerr, res); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectEvent := func(ch <-chan Event, typ EventType, path string) {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			if ev.Type != typ || ev.Path != path {
+				t.Fatalf("Received %s on %s, expected %s on %s", ev.Type, ev.Path, typ, path)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatalf("No %s event on %s", typ, path)
+		}
+	}
+
+	type added struct {
+		ch  <-chan Event
+		err error
+	}
+	done := make(chan added, 1)
+	go func() {
+		ch, err := zk.AddEmulatedWatch("/a", WatchModePersistentRecursive)
+		done <- added{ch, err}
+	}()
+	serve(4)
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("AddEmulatedWatch returned error: %+v", res.err)
+	}
+	ch := res.ch
+
+	// A new child is found through the children watch of its parent.
+	nodes["/a/b/c"] = true
+	if err := fc.SendEvent(2, EventNodeChildrenChanged, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	serve(3)
+	expectEvent(ch, EventNodeCreated, "/a/b/c")
+
+	if err := fc.SendEvent(3, EventNodeDataChanged, "/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	serve(2)
+	expectEvent(ch, EventNodeDataChanged, "/a/b/c")
+
+	// Deleted nodes are checked for once more and then no longer watched.
+	delete(nodes, "/a/b/c")
+	if err := fc.SendEvent(4, EventNodeDeleted, "/a/b/c"); err != nil {
+		t.Fatal(err)
+	}
+	serve(2)
+	expectEvent(ch, EventNodeDeleted, "/a/b/c")
+	if err := fc.SendEvent(4, EventNodeChildrenChanged, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	serve(1)
+
+	if err := zk.RemoveWatches("/a", ch); err != nil {
+		t.Fatalf("RemoveWatches returned error: %+v", err)
+	}
+	expectEvent(ch, EventPersistentWatchRemoved, "/a")
+	if _, ok := <-ch; ok {
+		t.Fatal("Channel not closed after the watch was removed")
+	}
+	serve(4)
+}
@@ -0,0 +1,218 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// LatencyEvictionConfig configures WithLatencyEviction. Zero fields take
+// their defaults.
+type LatencyEvictionConfig struct {
+	// Interval is how often the servers are probed. It defaults to 30
+	// seconds.
+	Interval time.Duration
+	// Ratio is how many times the latency of the fastest other server the
+	// latency of the connected server must exceed for the session to move.
+	// It defaults to 3.
+	Ratio float64
+	// MinLatency is the latency below which the connected server is never
+	// abandoned, as differences at that scale are noise. It defaults to
+	// 50ms.
+	MinLatency time.Duration
+}
+
+// ServerEvictedError is the Err of the EventSession event with
+// StateDisconnected sent when WithLatencyEviction moves the session away from
+// a slow server.
+type ServerEvictedError struct {
+	// Server is the server the session moves from, and Latency its probed
+	// latency.
+	Server  string
+	Latency time.Duration
+	// To is the server the session moves to, and ToLatency its probed
+	// latency.
+	To        string
+	ToLatency time.Duration
+}
+
+func (e *ServerEvictedError) Error() string {
+	return fmt.Sprintf("zk: moving session from %s with latency %s to %s with latency %s", e.Server, e.Latency, e.To, e.ToLatency)
+}
+
+// WithLatencyEviction returns a connection option that moves the session to
+// another server when the connected server becomes much slower than the
+// fastest of the others, e.g. because the server is overloaded or far away
+// after a failover. Every configured server, the connected one included, is
+// probed with the ruok four letter word, timed from sending it to the first
+// byte of the reply, so that the latencies compared are measured the same
+// way whatever the requests of the session cost. The session only moves once
+// the latency exceeded the threshold on two checks in a row. The move is reported by an EventSession event with
+// StateDisconnected and a ServerEvictedError, after which the session is
+// resumed on the new server like after any reconnect: requests in flight
+// fail with ErrConnectionClosed. It has no effect with WithoutReconnect.
+func WithLatencyEviction(cfg LatencyEvictionConfig) connOption {
+	return func(c *Conn) {
+		if cfg.Interval <= 0 {
+			cfg.Interval = 30 * time.Second
+		}
+		if cfg.Ratio <= 0 {
+			cfg.Ratio = 3
+		}
+		if cfg.MinLatency <= 0 {
+			cfg.MinLatency = 50 * time.Millisecond
+		}
+		c.eviction = &latencyEviction{
+			LatencyEvictionConfig: cfg,
+			kick:                  make(chan struct{}, 1),
+			probe:                 c.probeLatency,
+		}
+	}
+}
+
+type latencyEviction struct {
+	LatencyEvictionConfig
+	kick  chan struct{} // tells the send loop to drop the connection
+	probe func(server string) (time.Duration, error)
+
+	degraded bool // the last check found the server slow, owned by runLatencyEviction
+}
+
+// reset forgets a request to drop the previous connection that came too
+// late. It is called before the session is established on a new connection.
+func (e *latencyEviction) reset() {
+	select {
+	case <-e.kick:
+	default:
+	}
+}
+
+// runLatencyEviction checks the latency every interval until the connection
+// is closed.
+func (c *Conn) runLatencyEviction() {
+	ticker := time.NewTicker(c.eviction.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkLatency()
+		case <-c.shouldQuit:
+			return
+		}
+	}
+}
+
+// checkLatency compares the probed latency of the connected server with that
+// of the other servers, and moves the session if it is too slow the second
+// time in a row.
+func (c *Conn) checkLatency() {
+	e := c.eviction
+	if c.noReconnect || c.State() != StateHasSession {
+		e.degraded = false
+		return
+	}
+	current := c.Server()
+	latency, err := e.probe(current)
+	if err != nil || latency < e.MinLatency {
+		e.degraded = false
+		return
+	}
+
+	var best string
+	var bestLatency time.Duration
+	for _, server := range c.configuredServers() {
+		if resolvesTo(server, current) {
+			continue
+		}
+		d, err := e.probe(server)
+		if err != nil {
+			continue
+		}
+		if best == "" || d < bestLatency {
+			best, bestLatency = server, d
+		}
+	}
+	if best == "" || float64(latency) <= e.Ratio*float64(bestLatency) {
+		e.degraded = false
+		return
+	}
+	if !e.degraded {
+		e.degraded = true
+		return
+	}
+	e.degraded = false
+
+	evicted := &ServerEvictedError{Server: current, Latency: latency, To: best, ToLatency: bestLatency}
+	c.logf(LogWarn, LogConnection, "Evicting server: %s", evicted)
+	c.serverMu.Lock()
+	c.moveTo, c.moveErr = best, evicted
+	c.serverMu.Unlock()
+	select {
+	case e.kick <- struct{}{}:
+	default:
+	}
+}
+
+// errServerEvicted is returned by the send loop when it drops the connection
+// for the latency eviction.
+var errServerEvicted = errors.New("zk: server evicted")
+
+// takeMoveErr returns why the session is moving to another server, if it
+// is, and forgets it.
+func (c *Conn) takeMoveErr() error {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+	err := c.moveErr
+	c.moveErr = nil
+	return err
+}
+
+// evictionKick returns the channel telling the send loop to drop the
+// connection, or nil.
+func (c *Conn) evictionKick() <-chan struct{} {
+	if c.eviction == nil {
+		return nil
+	}
+	return c.eviction.kick
+}
+
+// probeLatency times the reply of server to the ruok four letter word.
+func (c *Conn) probeLatency(server string) (time.Duration, error) {
+	conn, err := c.flwDialer("tcp", server, c.connectTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.connectTimeout))
+	start := time.Now()
+	if _, err := conn.Write([]byte("ruok")); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// resolvesTo reports whether server, as configured, is addr, as connected
+// to.
+func resolvesTo(server, addr string) bool {
+	if server == addr {
+		return true
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return false
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if net.JoinHostPort(a, port) == addr {
+			return true
+		}
+	}
+	return false
+}
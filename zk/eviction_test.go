@@ -0,0 +1,90 @@
+package zk
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLatencyEviction(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	dialed := make(chan string, 4)
+	dialer := s.Dialer()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181", "127.0.0.2:2181"}, 10*time.Second,
+		WithDialer(func(network, address string, timeout time.Duration) (net.Conn, error) {
+			dialed <- address
+			return dialer(network, address, timeout)
+		}),
+		WithLatencyEviction(LatencyEvictionConfig{Interval: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+	// Answer the pings until the connection is dropped.
+	go func() {
+		for {
+			if _, err := fc.NextRequest(); err != nil {
+				return
+			}
+		}
+	}()
+	current := <-dialed
+	other := "127.0.0.1:2181"
+	if current == other {
+		other = "127.0.0.2:2181"
+	}
+	probed := make(map[string]bool)
+	currentLatency := 10 * time.Millisecond
+	zk.eviction.probe = func(server string) (time.Duration, error) {
+		probed[server] = true
+		if server == zk.Server() {
+			return currentLatency, nil
+		}
+		return time.Millisecond, nil
+	}
+
+	// A fast server is kept without probing the others.
+	zk.checkLatency()
+	zk.checkLatency()
+	if len(probed) != 1 || !probed[zk.Server()] {
+		t.Fatalf("Probed %v below the minimum latency", probed)
+	}
+
+	// A slow one is left on the second check in a row.
+	currentLatency = 200 * time.Millisecond
+	zk.checkLatency()
+	if !probed[other] {
+		t.Fatalf("Probed %v, expected %s", probed, other)
+	}
+	zk.checkLatency()
+	deadline := time.After(fakeTimeout)
+	for {
+		var ev Event
+		select {
+		case ev = <-ch:
+		case <-deadline:
+			t.Fatal("Session not moved")
+		}
+		if ev.State != StateDisconnected {
+			continue
+		}
+		var evicted *ServerEvictedError
+		if !errors.As(ev.Err, &evicted) || evicted.Server != current || evicted.To != other || evicted.Latency != currentLatency || evicted.ToLatency != time.Millisecond {
+			t.Fatalf("Unexpected disconnection %+v", ev)
+		}
+		break
+	}
+
+	// The session resumes on the faster server.
+	moved := acceptFake(t, s, 1)
+	defer moved.Close()
+	waitForState(t, ch, StateHasSession)
+	if addr := <-dialed; addr != other {
+		t.Fatalf("Reconnected to %s instead of %s", addr, other)
+	}
+}
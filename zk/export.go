@@ -0,0 +1,49 @@
+package zk
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// exportConcurrency is the number of nodes read at the same time by
+// ExportJSON.
+const exportConcurrency = 8
+
+// ExportedNode is a node written by ExportJSON.
+type ExportedNode struct {
+	Path string `json:"path"`
+	Data []byte `json:"data"`
+	Stat Stat   `json:"stat"`
+}
+
+// Export is the document written by ExportJSON.
+type Export struct {
+	Time  time.Time      `json:"time"`
+	Paths []string       `json:"paths"`
+	Nodes []ExportedNode `json:"nodes"`
+}
+
+// ExportJSON writes the subtrees at paths to w as a JSON encoded Export, with
+// the nodes ordered by path. Nodes of overlapping subtrees are written once.
+// Like Prefetch, which it uses to read the subtrees, it does not take a
+// consistent snapshot.
+func (c *Conn) ExportJSON(w io.Writer, paths ...string) error {
+	export := Export{Time: time.Now().UTC(), Paths: paths, Nodes: []ExportedNode{}}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		nodes, err := c.Prefetch(path, -1, exportConcurrency)
+		if err != nil {
+			return err
+		}
+		for p, node := range nodes {
+			if !seen[p] {
+				seen[p] = true
+				export.Nodes = append(export.Nodes, ExportedNode{Path: p, Data: node.Data, Stat: *node.Stat})
+			}
+		}
+	}
+	sort.Slice(export.Nodes, func(i, j int) bool { return export.Nodes[i].Path < export.Nodes[j].Path })
+	return json.NewEncoder(w).Encode(&export)
+}
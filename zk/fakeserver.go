@@ -0,0 +1,255 @@
+package zk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrFakeServerClosed is returned by the dialer of a FakeServer and by
+// FakeServer.Accept once the server is closed.
+var ErrFakeServerClosed = errors.New("zk: fake server closed")
+
+// FakeServer is an in-memory stand-in for a ZooKeeper server that tests
+// drive frame by frame, so that the reconnect, xid and watch logic of a Conn
+// can be tested deterministically without a real server. Connect the Conn
+// with WithDialer(s.Dialer()), accept each connection it makes with Accept
+// and script the replies on the returned FakeConn. Compression, TLS and SASL
+// are not supported.
+type FakeServer struct {
+	conns chan net.Conn
+
+	mu      sync.Mutex
+	closed  bool
+	dialErr error
+}
+
+// NewFakeServer returns a FakeServer with no connections.
+func NewFakeServer() *FakeServer {
+	return &FakeServer{conns: make(chan net.Conn)}
+}
+
+// Dialer returns a Dialer that connects to the server over net.Pipe,
+// whatever the address dialed. Dials block until the connection is accepted
+// or timeout expires.
+func (s *FakeServer) Dialer() Dialer {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		s.mu.Lock()
+		closed, dialErr := s.closed, s.dialErr
+		s.mu.Unlock()
+		if closed {
+			return nil, ErrFakeServerClosed
+		}
+		if dialErr != nil {
+			return nil, dialErr
+		}
+
+		client, server := net.Pipe()
+		select {
+		case s.conns <- server:
+			return client, nil
+		case <-time.After(timeout):
+			client.Close()
+			server.Close()
+			return nil, fmt.Errorf("zk: fake server did not accept %s in time", address)
+		}
+	}
+}
+
+// SetDialError makes dials fail with err, or succeed again if err is nil.
+func (s *FakeServer) SetDialError(err error) {
+	s.mu.Lock()
+	s.dialErr = err
+	s.mu.Unlock()
+}
+
+// Accept waits up to timeout for the next connection from a client.
+func (s *FakeServer) Accept(timeout time.Duration) (*FakeConn, error) {
+	select {
+	case conn := <-s.conns:
+		return &FakeConn{conn: conn, buf: make([]byte, bufferSize), timeout: timeout}, nil
+	case <-time.After(timeout):
+		return nil, errors.New("zk: no connection to the fake server")
+	}
+}
+
+// Close makes further dials fail. Accepted connections stay open.
+func (s *FakeServer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+// FakeConnectRequest is the handshake sent by a client to a FakeServer.
+type FakeConnectRequest struct {
+	ProtocolVersion int32
+	LastZxidSeen    int64
+	TimeOut         int32
+	SessionID       int64
+	Passwd          []byte
+	ReadOnly        bool
+}
+
+// FakeRequest is a request sent by a client to a FakeServer.
+type FakeRequest struct {
+	Xid    int32
+	Opcode int32
+	// Op is the name of the operation, e.g. "getData".
+	Op string
+	// Path is the path of the request, if it has one.
+	Path string
+	// Body is the decoded request, e.g. a *CreateRequest, or nil for
+	// operations unknown to the client.
+	Body interface{}
+}
+
+// FakeConn is the server side of a connection to a FakeServer. Reads and
+// writes fail if the client does not keep up within the timeout passed to
+// Accept.
+type FakeConn struct {
+	conn    net.Conn
+	buf     []byte
+	timeout time.Duration
+}
+
+// ReadConnect reads the handshake that starts every connection.
+func (c *FakeConn) ReadConnect() (*FakeConnectRequest, error) {
+	n, err := readPacket(c.conn, c.buf, time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+	req := connectRequest{}
+	if _, err := decodePacket(c.buf[:n], &req); err != nil {
+		return nil, err
+	}
+	return &FakeConnectRequest{
+		ProtocolVersion: req.ProtocolVersion,
+		LastZxidSeen:    req.LastZxidSeen,
+		TimeOut:         req.TimeOut,
+		SessionID:       req.SessionID,
+		Passwd:          req.Passwd,
+		ReadOnly:        req.ReadOnly,
+	}, nil
+}
+
+// AcceptSession answers the handshake with a session, which the client then
+// reports as StateHasSession or, if readOnly is set, StateConnectedReadOnly.
+func (c *FakeConn) AcceptSession(sessionID int64, timeout time.Duration, passwd []byte, readOnly bool) error {
+	res := &connectResponse{TimeOut: int32(timeout / time.Millisecond), SessionID: sessionID, Passwd: passwd}
+	n, err := encodePacket(c.buf[4:], res)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		c.buf[4+n] = 1
+	} else {
+		c.buf[4+n] = 0
+	}
+	return c.write(n + 1)
+}
+
+// ExpireSession answers the handshake as a server that no longer knows the
+// session, which makes the client report StateExpired.
+func (c *FakeConn) ExpireSession() error {
+	return c.AcceptSession(0, 0, emptyPassword, false)
+}
+
+// ReadRequest reads the next request, including pings.
+func (c *FakeConn) ReadRequest() (*FakeRequest, error) {
+	n, err := readPacket(c.conn, c.buf, time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+	hdr := requestHeader{}
+	hn, err := decodePacket(c.buf[:n], &hdr)
+	if err != nil {
+		return nil, err
+	}
+	req := &FakeRequest{Xid: hdr.Xid, Opcode: hdr.Opcode, Op: opNames[hdr.Opcode]}
+	if body := requestStructForOp(hdr.Opcode); body != nil {
+		if _, err := decodePacket(c.buf[hn:n], body); err != nil {
+			return nil, err
+		}
+		req.Body = body
+		if paths := requestPaths(body); len(paths) == 1 {
+			req.Path = paths[0]
+		}
+	}
+	return req, nil
+}
+
+// NextRequest is like ReadRequest but answers pings itself and returns the
+// first other request.
+func (c *FakeConn) NextRequest() (*FakeRequest, error) {
+	for {
+		req, err := c.ReadRequest()
+		if err != nil || req.Opcode != opPing {
+			return req, err
+		}
+		if err := c.Reply(req, 0, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ExpectRequest is like NextRequest but fails unless the request is for op,
+// e.g. "getData".
+func (c *FakeConn) ExpectRequest(op string) (*FakeRequest, error) {
+	req, err := c.NextRequest()
+	if err != nil {
+		return nil, err
+	}
+	if req.Op != op {
+		return req, fmt.Errorf("zk: expected %s request, got %s", op, req.Op)
+	}
+	return req, nil
+}
+
+// Reply answers req at zxid. err is one of the errors returned by Conn, e.g.
+// ErrNoNode, or nil; res is the response body, whose fields are encoded in
+// order, e.g. &getDataResponse{} for getData, or nil for none. Errors
+// without a code, e.g. ErrConnectionClosed, are sent as ErrAPIError.
+func (c *FakeConn) Reply(req *FakeRequest, zxid int64, err error, res interface{}) error {
+	code := errorCode(err)
+	if code == 0 && err != nil {
+		code = errAPIError
+	}
+	return c.send(req.Xid, zxid, code, res)
+}
+
+// SendEvent delivers a watch event for path.
+func (c *FakeConn) SendEvent(zxid int64, typ EventType, path string) error {
+	// Servers send events with xid -1 and the SyncConnected keeper state.
+	return c.send(-1, zxid, 0, &watcherEvent{Type: typ, State: 3, Path: path})
+}
+
+// Close drops the connection, as a failing server would.
+func (c *FakeConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *FakeConn) send(xid int32, zxid int64, code ErrCode, res interface{}) error {
+	n, err := encodePacket(c.buf[4:], &responseHeader{Xid: xid, Zxid: zxid, Err: code})
+	if err != nil {
+		return err
+	}
+	if res != nil && code == 0 {
+		n2, err := encodePacket(c.buf[4+n:], res)
+		if err != nil {
+			return err
+		}
+		n += n2
+	}
+	return c.write(n)
+}
+
+func (c *FakeConn) write(n int) error {
+	binary.BigEndian.PutUint32(c.buf[:4], uint32(n))
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetWriteDeadline(time.Time{})
+	_, err := c.conn.Write(c.buf[:n+4])
+	return err
+}
@@ -0,0 +1,241 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const fakeTimeout = 5 * time.Second
+
+// connectFake connects to s and establishes session 1 on the first
+// connection.
+func connectFake(t *testing.T, s *FakeServer) (*Conn, <-chan Event, *FakeConn) {
+	t.Helper()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+	return zk, ch, fc
+}
+
+// acceptFake accepts the next connection, checks that it resumes sessionID
+// and accepts it as session 1.
+func acceptFake(t *testing.T, s *FakeServer, sessionID int64) *FakeConn {
+	t.Helper()
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := fc.ReadConnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.SessionID != sessionID {
+		t.Fatalf("Connect request for session %d, expected %d", req.SessionID, sessionID)
+	}
+	if err := fc.AcceptSession(1, 10*time.Second, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	return fc
+}
+
+// serveFake reads the next request on fc, checks that it is for op and,
+// unless path is empty, for path, and replies with err and res at zxid 1.
+// A setWatches request, which the client sends after a reconnect, is
+// answered and skipped.
+func serveFake(t *testing.T, fc *FakeConn, op, path string, err error, res interface{}) *FakeRequest {
+	t.Helper()
+	req, rerr := fc.NextRequest()
+	if rerr == nil && req.Op == "setWatches" && op != "setWatches" {
+		if rerr = fc.Reply(req, 1, nil, nil); rerr == nil {
+			req, rerr = fc.NextRequest()
+		}
+	}
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if req.Op != op {
+		t.Fatalf("Unexpected %s request, expected %s", req.Op, op)
+	}
+	if path != "" && req.Path != path {
+		t.Fatalf("%s request for %s, expected %s", op, req.Path, path)
+	}
+	if rerr := fc.Reply(req, 1, err, res); rerr != nil {
+		t.Fatal(rerr)
+	}
+	return req
+}
+
+func waitForState(t *testing.T, ch <-chan Event, state State) {
+	t.Helper()
+	deadline := time.After(fakeTimeout)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Type == EventSession && ev.State == state {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("Connection did not reach %s", state)
+		}
+	}
+}
+
+func TestFakeServerReplies(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	tests := []struct {
+		name  string
+		reply error
+	}{
+		{"ok", nil},
+		{"no node", ErrNoNode},
+		{"bad version", ErrBadVersion},
+		{"no auth", ErrNoAuth},
+	}
+	for _, tt := range tests {
+		done := make(chan error, 1)
+		go func() {
+			_, err := zk.Set("/gozk-test", []byte("data"), 3)
+			done <- err
+		}()
+		req, err := fc.ExpectRequest("setData")
+		if err != nil {
+			t.Fatalf("%s: %+v", tt.name, err)
+		}
+		body := req.Body.(*SetDataRequest)
+		if req.Path != "/gozk-test" || string(body.Data) != "data" || body.Version != 3 {
+			t.Fatalf("%s: unexpected request %+v", tt.name, body)
+		}
+		if err := fc.Reply(req, 10, tt.reply, &setDataResponse{Stat: Stat{Version: 4}}); err != nil {
+			t.Fatalf("%s: %+v", tt.name, err)
+		}
+		if err := <-done; err != tt.reply {
+			t.Errorf("%s: Set returned %v, expected %v", tt.name, err, tt.reply)
+		}
+	}
+}
+
+func TestFakeServerXids(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	// Replies answered out of order still reach the right callers.
+	results := make(chan string, 2)
+	for _, p := range []string{"/a", "/b"} {
+		p := p
+		go func() {
+			data, _, err := zk.Get(p)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- p + "=" + string(data)
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	first, err := fc.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := fc.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Xid <= first.Xid {
+		t.Fatalf("Xids %d and %d are not increasing", first.Xid, second.Xid)
+	}
+	for _, req := range []*FakeRequest{second, first} {
+		if err := fc.Reply(req, 1, nil, &getDataResponse{Data: []byte(req.Path[1:])}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := map[string]bool{<-results: true, <-results: true}
+	if !reflect.DeepEqual(got, map[string]bool{"/a=a": true, "/b=b": true}) {
+		t.Fatalf("Unexpected results %v", got)
+	}
+}
+
+func TestFakeServerReconnect(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+
+	type getW struct {
+		ch  <-chan Event
+		err error
+	}
+	done := make(chan getW, 1)
+	go func() {
+		_, _, wch, err := zk.GetW("/watched")
+		done <- getW{wch, err}
+	}()
+	req, err := fc.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 42, nil, &getDataResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	// The client resumes the session and re-registers the watch from the last
+	// zxid it saw.
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	fc = acceptFake(t, s, 1)
+	waitForState(t, ch, StateHasSession)
+	req, err = fc.ExpectRequest("setWatches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw := req.Body.(*setWatchesRequest)
+	if sw.RelativeZxid != 42 || !reflect.DeepEqual(sw.DataWatches, []string{"/watched"}) {
+		t.Fatalf("Unexpected setWatches request %+v", sw)
+	}
+	if err := fc.Reply(req, 42, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.SendEvent(43, EventNodeDataChanged, "/watched"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-res.ch:
+		if ev.Type != EventNodeDataChanged || ev.Path != "/watched" {
+			t.Fatalf("Unexpected watch event %+v", ev)
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Watch did not fire")
+	}
+
+	// An expired session is reported and the client starts a new one.
+	fc.Close()
+	fc, err = s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ExpireSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, ch, StateExpired)
+	acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+}
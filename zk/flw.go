@@ -3,6 +3,7 @@ package zk
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -20,7 +21,33 @@ import (
 // then the error happened before we started to obtain 'srvr' values. Otherwise, one of the
 // servers had an issue and the "Error" value in the struct should be inspected to determine
 // which server had the issue.
-func FLWSrvr(servers []string, timeout time.Duration) ([]*ServerStats, bool) {
+func FLWSrvr(servers []string, timeout time.Duration, opts ...FLWOption) ([]*ServerStats, bool) {
+	imOk := true
+	servers = FormatServers(servers)
+	dialer := flwDialer(opts)
+	ss := make([]*ServerStats, len(servers))
+
+	for i := range ss {
+		response, err := fourLetterWord(servers[i], "srvr", timeout, dialer)
+
+		if err != nil {
+			ss[i] = &ServerStats{Error: err}
+			imOk = false
+			continue
+		}
+
+		ss[i] = parseSrvr(response)
+		if ss[i].Error != nil {
+			imOk = false
+		}
+	}
+
+	return ss, imOk
+}
+
+// srvrRegexp matches the srvr output, and the stat output without its
+// clients.
+var srvrRegexp = func() *regexp.Regexp {
 	// different parts of the regular expression that are required to parse the srvr output
 	const (
 		zrVer   = `^Zookeeper version: ([A-Za-z0-9\.\-]+), built on (\d\d/\d\d/\d\d\d\d \d\d:\d\d [A-Za-z0-9:\+\-]+)`
@@ -30,115 +57,299 @@ func FLWSrvr(servers []string, timeout time.Duration) ([]*ServerStats, bool) {
 	)
 
 	// build the regex from the pieces above
-	re, err := regexp.Compile(fmt.Sprintf(`(?m:\A%v.*\n%v.*\n%v.*\n%v)`, zrVer, zrLat, zrNet, zrState))
+	return regexp.MustCompile(fmt.Sprintf(`(?m:\A%v.*\n%v.*\n%v.*\n%v)`, zrVer, zrLat, zrNet, zrState))
+}()
+
+// parseSrvr parses the srvr output of a server.
+func parseSrvr(response []byte) *ServerStats {
+	matches := srvrRegexp.FindAllStringSubmatch(string(response), -1)
+
+	if matches == nil {
+		err := fmt.Errorf("unable to parse fields from zookeeper response (no regex matches)")
+		return &ServerStats{Error: err}
+	}
+
+	match := matches[0][1:]
+
+	// determine current server
+	srvrMode := parseMode(match[10])
+
+	buildTime, err := time.Parse("01/02/2006 15:04 MST", match[1])
+
 	if err != nil {
-		return nil, false
+		return &ServerStats{Error: err}
 	}
 
+	parsedInt, err := strconv.ParseInt(match[9], 0, 64)
+
+	if err != nil {
+		return &ServerStats{Error: err}
+	}
+
+	// the ZxID value is an int64 with two int32s packed inside
+	// the high int32 is the epoch (i.e., number of leader elections)
+	// the low int32 is the counter
+	epoch := int32(parsedInt >> 32)
+	counter := int32(parsedInt & 0xFFFFFFFF)
+
+	// within the regex above, these values must be numerical
+	// so we can avoid useless checking of the error return value
+	minLatency, _ := strconv.ParseInt(match[2], 0, 64)
+	avgLatency, _ := strconv.ParseInt(match[3], 0, 64)
+	maxLatency, _ := strconv.ParseInt(match[4], 0, 64)
+	recv, _ := strconv.ParseInt(match[5], 0, 64)
+	sent, _ := strconv.ParseInt(match[6], 0, 64)
+	cons, _ := strconv.ParseInt(match[7], 0, 64)
+	outs, _ := strconv.ParseInt(match[8], 0, 64)
+	ncnt, _ := strconv.ParseInt(match[11], 0, 64)
+
+	return &ServerStats{
+		Sent:        sent,
+		Received:    recv,
+		NodeCount:   ncnt,
+		MinLatency:  minLatency,
+		AvgLatency:  avgLatency,
+		MaxLatency:  maxLatency,
+		Connections: cons,
+		Outstanding: outs,
+		Epoch:       epoch,
+		Counter:     counter,
+		BuildTime:   buildTime,
+		Mode:        srvrMode,
+		Version:     match[0],
+	}
+}
+
+// FLWStat is a FourLetterWord helper function. In particular, this function
+// pulls the stat output from each server, which is the srvr output along
+// with the address and packet counts of each connected client.
+//
+// As with FLWSrvr, the boolean value indicates whether one of the requests had
+// an issue. The ServerStatus struct has an Error value that can be checked.
+func FLWStat(servers []string, timeout time.Duration, opts ...FLWOption) ([]*ServerStatus, bool) {
 	imOk := true
 	servers = FormatServers(servers)
-	ss := make([]*ServerStats, len(servers))
+	dialer := flwDialer(opts)
+	ss := make([]*ServerStatus, len(servers))
 
 	for i := range ss {
-		response, err := fourLetterWord(servers[i], "srvr", timeout)
+		response, err := fourLetterWord(servers[i], "stat", timeout, dialer)
 
 		if err != nil {
-			ss[i] = &ServerStats{Error: err}
+			ss[i] = &ServerStatus{ServerStats: ServerStats{Error: err}}
 			imOk = false
 			continue
 		}
 
-		matches := re.FindAllStringSubmatch(string(response), -1)
-
-		if matches == nil {
-			err := fmt.Errorf("unable to parse fields from zookeeper response (no regex matches)")
-			ss[i] = &ServerStats{Error: err}
+		ss[i] = parseStat(response)
+		if ss[i].Error != nil {
 			imOk = false
-			continue
 		}
+	}
+
+	return ss, imOk
+}
 
-		match := matches[0][1:]
-
-		// determine current server
-		var srvrMode Mode
-		switch match[10] {
-		case "leader":
-			srvrMode = ModeLeader
-		case "follower":
-			srvrMode = ModeFollower
-		case "standalone":
-			srvrMode = ModeStandalone
+var statClientRegexp = regexp.MustCompile(`^ /(.+:\d+)\[\d+\]\(queued=(\d+),recved=(\d+),sent=(\d+)\)`)
+
+// parseStat parses the stat output of a server: the clients, between the
+// "Clients:" line and a blank line, are taken out and the rest is parsed
+// as the srvr output.
+func parseStat(response []byte) *ServerStatus {
+	var (
+		rest      bytes.Buffer
+		clients   []*ServerClient
+		inClients bool
+	)
+	scan := bufio.NewScanner(bytes.NewReader(response))
+	for scan.Scan() {
+		line := scan.Text()
+		switch {
+		case line == "Clients:":
+			inClients = true
+		case inClients && line == "":
+			inClients = false
+		case inClients:
+			m := statClientRegexp.FindStringSubmatch(line)
+			if m == nil {
+				err := fmt.Errorf("unable to parse client from zookeeper response: %q", line)
+				return &ServerStatus{ServerStats: ServerStats{Error: err}}
+			}
+			queued, _ := strconv.ParseInt(m[2], 0, 64)
+			recvd, _ := strconv.ParseInt(m[3], 0, 64)
+			sent, _ := strconv.ParseInt(m[4], 0, 64)
+			clients = append(clients, &ServerClient{
+				Queued:   queued,
+				Received: recvd,
+				Sent:     sent,
+				Addr:     m[1],
+			})
 		default:
-			srvrMode = ModeUnknown
+			rest.WriteString(line)
+			rest.WriteByte('\n')
 		}
+	}
+	return &ServerStatus{ServerStats: *parseSrvr(rest.Bytes()), Clients: clients}
+}
 
-		buildTime, err := time.Parse("01/02/2006 15:04 MST", match[1])
+// FLWMntr is a FourLetterWord helper function. In particular, this function
+// pulls the mntr output from each server and parses the tab separated
+// key/value pairs into a *MonitorStats. The followers are only reported by
+// the leader.
+//
+// As with FLWSrvr, the boolean value indicates whether one of the requests had
+// an issue. The MonitorStats struct has an Error value that can be checked.
+func FLWMntr(servers []string, timeout time.Duration, opts ...FLWOption) ([]*MonitorStats, bool) {
+	imOk := true
+	servers = FormatServers(servers)
+	dialer := flwDialer(opts)
+	ms := make([]*MonitorStats, len(servers))
+
+	for i := range ms {
+		response, err := fourLetterWord(servers[i], "mntr", timeout, dialer)
 
 		if err != nil {
-			ss[i] = &ServerStats{Error: err}
+			ms[i] = &MonitorStats{Error: err}
 			imOk = false
 			continue
 		}
 
-		parsedInt, err := strconv.ParseInt(match[9], 0, 64)
+		ms[i] = parseMntr(response)
+		if ms[i].Error != nil {
+			imOk = false
+		}
+	}
+
+	return ms, imOk
+}
 
+// parseMntr parses the mntr output of a server. Servers not serving
+// requests, or not allowing mntr, answer with a message instead, which has
+// no zk_server_state.
+func parseMntr(response []byte) *MonitorStats {
+	ms := &MonitorStats{Values: make(map[string]string)}
+	ints := map[string]*int64{
+		"zk_min_latency":                &ms.MinLatency,
+		"zk_max_latency":                &ms.MaxLatency,
+		"zk_packets_received":           &ms.PacketsReceived,
+		"zk_packets_sent":               &ms.PacketsSent,
+		"zk_num_alive_connections":      &ms.AliveConnections,
+		"zk_outstanding_requests":       &ms.OutstandingRequests,
+		"zk_znode_count":                &ms.ZnodeCount,
+		"zk_watch_count":                &ms.WatchCount,
+		"zk_ephemerals_count":           &ms.EphemeralsCount,
+		"zk_approximate_data_size":      &ms.ApproximateDataSize,
+		"zk_open_file_descriptor_count": &ms.OpenFileDescriptorCount,
+		"zk_max_file_descriptor_count":  &ms.MaxFileDescriptorCount,
+		"zk_followers":                  &ms.Followers,
+		"zk_synced_followers":           &ms.SyncedFollowers,
+		"zk_pending_syncs":              &ms.PendingSyncs,
+	}
+
+	scan := bufio.NewScanner(bytes.NewReader(response))
+	for scan.Scan() {
+		kv := strings.SplitN(scan.Text(), "\t", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], strings.TrimSpace(kv[1])
+		ms.Values[key] = value
+
+		var err error
+		switch key {
+		case "zk_version":
+			ms.Version = strings.TrimSpace(strings.SplitN(value, ",", 2)[0])
+		case "zk_server_state":
+			ms.Mode = parseMode(value)
+		case "zk_avg_latency":
+			ms.AvgLatency, err = strconv.ParseFloat(value, 64)
+		default:
+			if p, ok := ints[key]; ok {
+				*p, err = parseMntrInt(value)
+			}
+		}
 		if err != nil {
-			ss[i] = &ServerStats{Error: err}
+			return &MonitorStats{Error: fmt.Errorf("unable to parse %s from zookeeper response: %v", key, err)}
+		}
+	}
+
+	if _, ok := ms.Values["zk_server_state"]; !ok {
+		err := fmt.Errorf("unable to parse fields from zookeeper response (no zk_server_state)")
+		return &MonitorStats{Error: err}
+	}
+	return ms
+}
+
+// parseMntrInt parses an integer value of the mntr output. Servers since 3.6
+// report some of them as summaries, which may have a fraction.
+func parseMntrInt(s string) (int64, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return int64(f), err
+}
+
+// FLWWchs is a FourLetterWord helper function. In particular, this function
+// pulls the wchs output from each server: the number of connections with
+// watches, of watched paths and of watches.
+//
+// As with FLWSrvr, the boolean value indicates whether one of the requests had
+// an issue. The WatchStats struct has an Error value that can be checked.
+func FLWWchs(servers []string, timeout time.Duration, opts ...FLWOption) ([]*WatchStats, bool) {
+	imOk := true
+	servers = FormatServers(servers)
+	dialer := flwDialer(opts)
+	ws := make([]*WatchStats, len(servers))
+
+	for i := range ws {
+		response, err := fourLetterWord(servers[i], "wchs", timeout, dialer)
+
+		if err != nil {
+			ws[i] = &WatchStats{Error: err}
 			imOk = false
 			continue
 		}
 
-		// the ZxID value is an int64 with two int32s packed inside
-		// the high int32 is the epoch (i.e., number of leader elections)
-		// the low int32 is the counter
-		epoch := int32(parsedInt >> 32)
-		counter := int32(parsedInt & 0xFFFFFFFF)
-
-		// within the regex above, these values must be numerical
-		// so we can avoid useless checking of the error return value
-		minLatency, _ := strconv.ParseInt(match[2], 0, 64)
-		avgLatency, _ := strconv.ParseInt(match[3], 0, 64)
-		maxLatency, _ := strconv.ParseInt(match[4], 0, 64)
-		recv, _ := strconv.ParseInt(match[5], 0, 64)
-		sent, _ := strconv.ParseInt(match[6], 0, 64)
-		cons, _ := strconv.ParseInt(match[7], 0, 64)
-		outs, _ := strconv.ParseInt(match[8], 0, 64)
-		ncnt, _ := strconv.ParseInt(match[11], 0, 64)
-
-		ss[i] = &ServerStats{
-			Sent:        sent,
-			Received:    recv,
-			NodeCount:   ncnt,
-			MinLatency:  minLatency,
-			AvgLatency:  avgLatency,
-			MaxLatency:  maxLatency,
-			Connections: cons,
-			Outstanding: outs,
-			Epoch:       epoch,
-			Counter:     counter,
-			BuildTime:   buildTime,
-			Mode:        srvrMode,
-			Version:     match[0],
+		ws[i] = parseWchs(response)
+		if ws[i].Error != nil {
+			imOk = false
 		}
 	}
 
-	return ss, imOk
+	return ws, imOk
+}
+
+var wchsRegexp = regexp.MustCompile(`(?m:^(\d+) connections watching (\d+) paths\s*\n^Total watches:\s*(\d+))`)
+
+// parseWchs parses the wchs output of a server.
+func parseWchs(response []byte) *WatchStats {
+	m := wchsRegexp.FindStringSubmatch(string(response))
+	if m == nil {
+		err := fmt.Errorf("unable to parse fields from zookeeper response (no regex matches)")
+		return &WatchStats{Error: err}
+	}
+	conns, _ := strconv.ParseInt(m[1], 0, 64)
+	paths, _ := strconv.ParseInt(m[2], 0, 64)
+	watches, _ := strconv.ParseInt(m[3], 0, 64)
+	return &WatchStats{Connections: conns, Paths: paths, Watches: watches}
 }
 
 // FLWRuok is a FourLetterWord helper function. In particular, this function
 // pulls the ruok output from each server.
-func FLWRuok(servers []string, timeout time.Duration) []bool {
+func FLWRuok(servers []string, timeout time.Duration, opts ...FLWOption) []bool {
 	servers = FormatServers(servers)
+	dialer := flwDialer(opts)
 	oks := make([]bool, len(servers))
 
 	for i := range oks {
-		response, err := fourLetterWord(servers[i], "ruok", timeout)
+		response, err := fourLetterWord(servers[i], "ruok", timeout, dialer)
 
 		if err != nil {
 			continue
 		}
 
-		if bytes.Equal(response[:4], []byte("imok")) {
+		if bytes.HasPrefix(response, []byte("imok")) {
 			oks[i] = true
 		}
 	}
@@ -150,7 +361,7 @@ func FLWRuok(servers []string, timeout time.Duration) []bool {
 //
 // As with FLWSrvr, the boolean value indicates whether one of the requests had
 // an issue. The Clients struct has an Error value that can be checked.
-func FLWCons(servers []string, timeout time.Duration) ([]*ServerClients, bool) {
+func FLWCons(servers []string, timeout time.Duration, opts ...FLWOption) ([]*ServerClients, bool) {
 	const (
 		zrAddr = `^ /((?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?):(?:\d+))\[\d+\]`
 		zrPac  = `\(queued=(\d+),recved=(\d+),sent=(\d+),sid=(0x[A-Za-z0-9]+),lop=(\w+),est=(\d+),to=(\d+),`
@@ -163,11 +374,12 @@ func FLWCons(servers []string, timeout time.Duration) ([]*ServerClients, bool) {
 	}
 
 	servers = FormatServers(servers)
+	dialer := flwDialer(opts)
 	sc := make([]*ServerClients, len(servers))
 	imOk := true
 
 	for i := range sc {
-		response, err := fourLetterWord(servers[i], "cons", timeout)
+		response, err := fourLetterWord(servers[i], "cons", timeout, dialer)
 
 		if err != nil {
 			sc[i] = &ServerClients{Error: err}
@@ -245,8 +457,48 @@ func parseInt64(s string) (int64, error) {
 	return strconv.ParseInt(s, 0, 64)
 }
 
-func fourLetterWord(server, command string, timeout time.Duration) ([]byte, error) {
-	conn, err := net.DialTimeout("tcp", server, timeout)
+// FLWOption is an option of the FourLetterWord helper functions.
+type FLWOption func(*flwOptions)
+
+type flwOptions struct {
+	dialer    Dialer
+	tlsConfig *tls.Config
+}
+
+// FLWWithDialer returns an option of the FourLetterWord helper functions
+// that connects to the servers with dialer, as WithDialer does for
+// connections.
+func FLWWithDialer(dialer Dialer) FLWOption {
+	return func(o *flwOptions) {
+		o.dialer = dialer
+	}
+}
+
+// FLWWithTLSConfig returns an option of the FourLetterWord helper functions
+// that connects to the servers over TLS, for clusters exposing only the
+// secure client port, as WithTLSConfig does for connections. The handshake
+// is made over the connections of the FLWWithDialer dialer, if any, and
+// must complete within the timeout.
+func FLWWithTLSConfig(config *tls.Config) FLWOption {
+	return func(o *flwOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// flwDialer returns the dialer of opts.
+func flwDialer(opts []FLWOption) Dialer {
+	o := flwOptions{dialer: net.DialTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tlsConfig != nil {
+		return TLSDialer(o.dialer, o.tlsConfig)
+	}
+	return o.dialer
+}
+
+func fourLetterWord(server, command string, timeout time.Duration, dialer Dialer) ([]byte, error) {
+	conn, err := dialer("tcp", server, timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -1,7 +1,10 @@
 package zk
 
 import (
+	"crypto/tls"
 	"net"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -21,6 +24,42 @@ Node count: 306
  /10.55.33.98:34342[1](queued=0,recved=9338,sent=9350,sid=0x94c2989e0471731,lop=PING,est=1427238849319,to=20001,lcxid=0x55120944,lzxid=0xffffffffffffffff,lresp=1427259252294,llat=0,minlat=0,avglat=1,maxlat=18)
  /10.44.145.114:46556[1](queued=0,recved=109253,sent=109617,sid=0x94c2989e0471709,lop=DELE,est=1427238791305,to=20001,lcxid=0x55139618,lzxid=0x110a7b187d,lresp=1427259257423,llat=2,minlat=0,avglat=1,maxlat=23)
 
+`
+	zkStatOut = `Zookeeper version: 3.4.6-1569965, built on 02/20/2014 09:09 GMT
+Clients:
+ /10.42.45.231:45361[1](queued=0,recved=9435,sent=9457)
+ /127.0.0.1:56498[0](queued=0,recved=1,sent=0)
+
+Latency min/avg/max: 0/1/10
+Received: 4207
+Sent: 4220
+Connections: 81
+Outstanding: 1
+Zxid: 0x110a7a8f37
+Mode: leader
+Node count: 306
+`
+	zkMntrOut = "zk_version\t3.4.6-1569965, built on 02/20/2014 09:09 GMT\n" +
+		"zk_avg_latency\t0.5\n" +
+		"zk_max_latency\t10\n" +
+		"zk_min_latency\t0\n" +
+		"zk_packets_received\t4207\n" +
+		"zk_packets_sent\t4220\n" +
+		"zk_num_alive_connections\t81\n" +
+		"zk_outstanding_requests\t1\n" +
+		"zk_server_state\tleader\n" +
+		"zk_znode_count\t306\n" +
+		"zk_watch_count\t12\n" +
+		"zk_ephemerals_count\t3\n" +
+		"zk_approximate_data_size\t27\n" +
+		"zk_open_file_descriptor_count\t23\n" +
+		"zk_max_file_descriptor_count\t4096\n" +
+		"zk_followers\t2\n" +
+		"zk_synced_followers\t1\n" +
+		"zk_pending_syncs\t0\n" +
+		"zk_last_proposal_size\t-1\n"
+	zkWchsOut = `3 connections watching 2 paths
+Total watches:4
 `
 )
 
@@ -135,6 +174,117 @@ func TestFLWSrvr(t *testing.T) {
 	}
 }
 
+func TestFLWStat(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go tcpServer(l, "")
+
+	statuses, ok := FLWStat([]string{l.Addr().String()}, time.Second*10)
+	if !ok {
+		t.Fatalf("failure indicated on 'stat' parsing: %v", statuses[0].Error)
+	}
+
+	status := statuses[0]
+	if status.Sent != 4220 || status.NodeCount != 306 || status.Mode != ModeLeader || status.Version != "3.4.6-1569965" {
+		t.Errorf("unexpected stats %+v", status.ServerStats)
+	}
+	if len(status.Clients) != 2 {
+		t.Fatalf("%d clients, expected 2", len(status.Clients))
+	}
+	if c := status.Clients[0]; c.Addr != "10.42.45.231:45361" || c.Received != 9435 || c.Sent != 9457 {
+		t.Errorf("unexpected client %+v", c)
+	}
+	if c := status.Clients[1]; c.Addr != "127.0.0.1:56498" || c.Received != 1 || c.Sent != 0 {
+		t.Errorf("unexpected client %+v", c)
+	}
+}
+
+func TestFLWMntr(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go tcpServer(l, "")
+
+	statsSlice, ok := FLWMntr([]string{l.Addr().String()}, time.Second*10)
+	if !ok {
+		t.Fatalf("failure indicated on 'mntr' parsing: %v", statsSlice[0].Error)
+	}
+
+	expected := MonitorStats{
+		Version:                 "3.4.6-1569965",
+		AvgLatency:              0.5,
+		MinLatency:              0,
+		MaxLatency:              10,
+		PacketsReceived:         4207,
+		PacketsSent:             4220,
+		AliveConnections:        81,
+		OutstandingRequests:     1,
+		Mode:                    ModeLeader,
+		ZnodeCount:              306,
+		WatchCount:              12,
+		EphemeralsCount:         3,
+		ApproximateDataSize:     27,
+		OpenFileDescriptorCount: 23,
+		MaxFileDescriptorCount:  4096,
+		Followers:               2,
+		SyncedFollowers:         1,
+		PendingSyncs:            0,
+	}
+	stats := *statsSlice[0]
+	if v := stats.Values["zk_last_proposal_size"]; v != "-1" {
+		t.Errorf("zk_last_proposal_size = %q, expected -1", v)
+	}
+	stats.Values = nil
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("stats = %+v, expected %+v", stats, expected)
+	}
+
+	// A server not serving requests answers with a message.
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go tcpServer(l, "dead")
+
+	statsSlice, ok = FLWMntr([]string{l.Addr().String()}, time.Second*10)
+	if ok || statsSlice[0].Error == nil {
+		t.Errorf("no failure indicated for a dead server")
+	}
+	if stats := parseMntr([]byte("This ZooKeeper instance is not currently serving requests")); stats.Error == nil {
+		t.Errorf("no error parsing a server not serving requests")
+	}
+}
+
+func TestFLWWchs(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go tcpServer(l, "")
+
+	statsSlice, ok := FLWWchs([]string{l.Addr().String()}, time.Second*10)
+	if !ok {
+		t.Fatalf("failure indicated on 'wchs' parsing: %v", statsSlice[0].Error)
+	}
+	if stats := *statsSlice[0]; stats != (WatchStats{Connections: 3, Paths: 2, Watches: 4}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
 func TestFLWCons(t *testing.T) {
 	t.Parallel()
 	l, err := net.Listen("tcp", "127.0.0.1:0")
@@ -282,6 +432,50 @@ func TestFLWCons(t *testing.T) {
 	}
 }
 
+func TestFLWTLS(t *testing.T) {
+	t.Parallel()
+	cert, pool := newTestCertificate(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go tcpServer(l, "")
+
+	var dials int32
+	dialer := func(network, address string, timeout time.Duration) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return net.DialTimeout(network, address, timeout)
+	}
+	servers := []string{l.Addr().String()}
+	statsSlice, ok := FLWSrvr(servers, time.Second*10, FLWWithTLSConfig(&tls.Config{RootCAs: pool}), FLWWithDialer(dialer))
+	if !ok {
+		t.Fatalf("failure indicated on 'srvr' parsing over TLS: %v", statsSlice[0].Error)
+	}
+	if statsSlice[0].NodeCount != 306 {
+		t.Errorf("NodeCount != 306")
+	}
+	if oks := FLWRuok(servers, time.Second*10, FLWWithTLSConfig(&tls.Config{RootCAs: pool}), FLWWithDialer(dialer)); !oks[0] {
+		t.Errorf("instance should be marked as OK over TLS")
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Errorf("dialer called %d times, expected 2", n)
+	}
+
+	// The plaintext commands fail against the secure port.
+	if oks := FLWRuok(servers, time.Second); oks[0] {
+		t.Errorf("instance should be marked as not OK without TLS")
+	}
+	if _, ok := FLWMntr(servers, time.Second); ok {
+		t.Errorf("no failure indicated on 'mntr' without TLS")
+	}
+	// And so do those with a certificate that cannot be verified.
+	if _, ok := FLWMntr(servers, time.Second*10, FLWWithTLSConfig(&tls.Config{})); ok {
+		t.Errorf("no failure indicated on 'mntr' with an unverified certificate")
+	}
+}
+
 func tcpServer(listener net.Listener, thing string) {
 	for {
 		conn, err := listener.Accept()
@@ -324,6 +518,27 @@ func connHandler(conn net.Conn, thing string) {
 		default:
 			conn.Write([]byte(zkConsOut))
 		}
+	case "stat":
+		switch thing {
+		case "dead":
+			return
+		default:
+			conn.Write([]byte(zkStatOut))
+		}
+	case "mntr":
+		switch thing {
+		case "dead":
+			return
+		default:
+			conn.Write([]byte(zkMntrOut))
+		}
+	case "wchs":
+		switch thing {
+		case "dead":
+			return
+		default:
+			conn.Write([]byte(zkWchsOut))
+		}
 	default:
 		conn.Write([]byte("This ZooKeeper instance is not currently serving requests."))
 	}
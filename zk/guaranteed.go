@@ -0,0 +1,113 @@
+package zk
+
+import "time"
+
+// guaranteedDeleteInterval is how often pending guaranteed deletes are
+// retried while a session is established.
+var guaranteedDeleteInterval = time.Second
+
+// DeleteGuaranteed deletes path like Delete. If the delete fails because the
+// connection or session was lost, it is retried in the background until it
+// succeeds, the node no longer exists, or the connection is closed. This
+// keeps e.g. lock and queue nodes from being orphaned. The error of the first
+// attempt is still returned.
+func (c *Conn) DeleteGuaranteed(path string, version int32) error {
+	err := c.Delete(path, version)
+	if isConnectionError(err) {
+		c.queueGuaranteedDelete(path, version)
+	}
+	return err
+}
+
+// PendingDeletes returns the paths of the guaranteed deletes that are still
+// being retried.
+func (c *Conn) PendingDeletes() []string {
+	c.pendingDeletesLock.Lock()
+	defer c.pendingDeletesLock.Unlock()
+
+	paths := make([]string, 0, len(c.pendingDeletes))
+	for path := range c.pendingDeletes {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func isConnectionError(err error) bool {
+	return err == ErrConnectionClosed || err == ErrNoServer || err == ErrSessionExpired
+}
+
+func (c *Conn) queueGuaranteedDelete(path string, version int32) {
+	c.pendingDeletesLock.Lock()
+	defer c.pendingDeletesLock.Unlock()
+
+	if c.pendingDeletes == nil {
+		c.pendingDeletes = make(map[string]int32)
+	}
+	c.pendingDeletes[path] = version
+	if c.retryDeletes == nil {
+		c.retryDeletes = make(chan struct{}, 1)
+		go c.runGuaranteedDeletes(c.retryDeletes)
+	}
+}
+
+// kickGuaranteedDeletes retries the pending deletes right away, e.g. once a
+// session is re-established.
+func (c *Conn) kickGuaranteedDeletes() {
+	c.pendingDeletesLock.Lock()
+	defer c.pendingDeletesLock.Unlock()
+
+	if c.retryDeletes != nil {
+		select {
+		case c.retryDeletes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runGuaranteedDeletes retries the pending deletes until there are none left
+// or the connection is closed.
+func (c *Conn) runGuaranteedDeletes(kick <-chan struct{}) {
+	ticker := time.NewTicker(guaranteedDeleteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shouldQuit:
+			return
+		case <-kick:
+		case <-ticker.C:
+		}
+		if c.State() != StateHasSession {
+			continue
+		}
+
+		c.pendingDeletesLock.Lock()
+		pending := make(map[string]int32, len(c.pendingDeletes))
+		for path, version := range c.pendingDeletes {
+			pending[path] = version
+		}
+		c.pendingDeletesLock.Unlock()
+
+		for path, version := range pending {
+			err := c.Delete(path, version)
+			if isConnectionError(err) {
+				break
+			}
+			if err != nil && err != ErrNoNode {
+				c.logf(LogError, LogRequests, "Giving up guaranteed delete of %s: %s", path, err)
+			}
+			c.pendingDeletesLock.Lock()
+			if v, ok := c.pendingDeletes[path]; ok && v == version {
+				delete(c.pendingDeletes, path)
+			}
+			c.pendingDeletesLock.Unlock()
+		}
+
+		c.pendingDeletesLock.Lock()
+		if len(c.pendingDeletes) == 0 {
+			c.retryDeletes = nil
+			c.pendingDeletesLock.Unlock()
+			return
+		}
+		c.pendingDeletesLock.Unlock()
+	}
+}
@@ -0,0 +1,231 @@
+package zk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerHealth is the health of a single server in a HealthReport.
+type ServerHealth struct {
+	Server string
+	// OK is whether the server answered imok to ruok, i.e. is running.
+	OK   bool
+	Mode Mode
+	// Stats is the mntr output of the server, or nil if it could not be
+	// had, e.g. because the server is not serving requests or does not
+	// allow mntr in its 4lw.commands.whitelist.
+	Stats *MonitorStats
+	Err   error
+}
+
+// serving reports whether the server serves requests as a member of the
+// ensemble or on its own.
+func (s *ServerHealth) serving() bool {
+	return s.Mode == ModeLeader || s.Mode == ModeFollower || s.Mode == ModeStandalone
+}
+
+// HealthReport is the result of ClusterHealth.
+type HealthReport struct {
+	Servers []ServerHealth
+	// Leader is the address of the leader, if exactly one server is.
+	Leader string
+	// Voters is the number of servers that are not observers, counting
+	// those whose mode is unknown, and Serving how many of them serve
+	// requests.
+	Voters  int
+	Serving int
+	// Quorum is whether a majority of the voters serve requests under a
+	// single leader, or the only server runs standalone.
+	Quorum bool
+	// The followers as reported by the leader.
+	Followers       int64
+	SyncedFollowers int64
+	PendingSyncs    int64
+	// Problems describes every problem found, e.g. a server down or
+	// followers not in sync with the leader.
+	Problems []string
+}
+
+// Healthy reports whether no problem was found.
+func (r *HealthReport) Healthy() bool {
+	return len(r.Problems) == 0
+}
+
+// Err returns an error listing the problems found, or nil if none was.
+func (r *HealthReport) Err() error {
+	if r.Healthy() {
+		return nil
+	}
+	return errors.New("zk: unhealthy cluster: " + strings.Join(r.Problems, "; "))
+}
+
+// ClusterHealth probes every server of connectString with ruok and mntr,
+// all at once and each within timeout, and reports their modes, whether the
+// ensemble has a quorum, and whether the followers are in sync with the
+// leader. The servers must allow both commands in their
+// 4lw.commands.whitelist; opts are passed on to the FourLetterWord helper
+// functions, e.g. FLWWithTLSConfig for the secure client port. Only an
+// invalid connectString is returned as an error: the problems of the
+// cluster are in the report.
+func ClusterHealth(connectString string, timeout time.Duration, opts ...FLWOption) (*HealthReport, error) {
+	cfg, err := ParseConnectString(connectString)
+	if err != nil {
+		return nil, err
+	}
+	dialer := flwDialer(opts)
+	report := &HealthReport{Servers: make([]ServerHealth, len(cfg.Servers))}
+
+	var wg sync.WaitGroup
+	for i, server := range cfg.Servers {
+		wg.Add(1)
+		go func(s *ServerHealth, server string) {
+			defer wg.Done()
+			s.Server = server
+			response, err := fourLetterWord(server, "ruok", timeout, dialer)
+			if err != nil {
+				s.Err = err
+				return
+			}
+			s.OK = bytes.HasPrefix(response, []byte("imok"))
+			response, err = fourLetterWord(server, "mntr", timeout, dialer)
+			if err != nil {
+				s.Err = err
+				return
+			}
+			if stats := parseMntr(response); stats.Error != nil {
+				s.Err = stats.Error
+			} else {
+				s.Stats, s.Mode = stats, stats.Mode
+			}
+		}(&report.Servers[i], server)
+	}
+	wg.Wait()
+
+	report.check()
+	return report, nil
+}
+
+// check sets the fields of the report derived from its servers.
+func (r *HealthReport) check() {
+	var leaders []string
+	for i := range r.Servers {
+		s := &r.Servers[i]
+		switch {
+		case s.Err != nil:
+			r.problem("%s: %v", s.Server, s.Err)
+		case !s.OK:
+			r.problem("%s: did not answer imok to ruok", s.Server)
+		}
+		if s.Mode != ModeObserver {
+			r.Voters++
+			if s.serving() {
+				r.Serving++
+			}
+		}
+		if s.Mode == ModeLeader {
+			leaders = append(leaders, s.Server)
+			r.Followers = s.Stats.Followers
+			r.SyncedFollowers = s.Stats.SyncedFollowers
+			r.PendingSyncs = s.Stats.PendingSyncs
+		}
+	}
+
+	if len(r.Servers) == 1 && r.Servers[0].Mode == ModeStandalone {
+		r.Quorum = true
+		return
+	}
+	switch len(leaders) {
+	case 0:
+		r.problem("no leader")
+	case 1:
+		r.Leader = leaders[0]
+	default:
+		r.problem("several leaders: %s", strings.Join(leaders, ", "))
+	}
+	r.Quorum = len(leaders) == 1 && r.Serving > r.Voters/2
+	if r.Serving <= r.Voters/2 {
+		r.problem("no quorum: %d of %d voting servers serving requests", r.Serving, r.Voters)
+	}
+	if r.Leader == "" {
+		return
+	}
+	if expected := int64(r.Voters - 1); r.SyncedFollowers < expected {
+		r.problem("leader has %d of %d followers synced", r.SyncedFollowers, expected)
+	}
+	if r.PendingSyncs > 0 {
+		r.problem("leader has %d pending syncs", r.PendingSyncs)
+	}
+}
+
+func (r *HealthReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// healthJSON is the JSON form of a HealthReport served by
+// ClusterHealthHandler.
+type healthJSON struct {
+	Healthy         bool               `json:"healthy"`
+	Leader          string             `json:"leader,omitempty"`
+	Voters          int                `json:"voters"`
+	Serving         int                `json:"serving"`
+	Quorum          bool               `json:"quorum"`
+	Followers       int64              `json:"followers"`
+	SyncedFollowers int64              `json:"synced_followers"`
+	PendingSyncs    int64              `json:"pending_syncs"`
+	Problems        []string           `json:"problems,omitempty"`
+	Servers         []serverHealthJSON `json:"servers"`
+}
+
+type serverHealthJSON struct {
+	Server  string `json:"server"`
+	OK      bool   `json:"ok"`
+	Mode    string `json:"mode"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ClusterHealthHandler returns an http.Handler that runs ClusterHealth on
+// every request and serves the report as JSON, with the status 200 if the
+// cluster is healthy and 503 otherwise, e.g. for the HTTP checks of a
+// monitoring system.
+func ClusterHealthHandler(connectString string, timeout time.Duration, opts ...FLWOption) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r, err := ClusterHealth(connectString, timeout, opts...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h := healthJSON{
+			Healthy:         r.Healthy(),
+			Leader:          r.Leader,
+			Voters:          r.Voters,
+			Serving:         r.Serving,
+			Quorum:          r.Quorum,
+			Followers:       r.Followers,
+			SyncedFollowers: r.SyncedFollowers,
+			PendingSyncs:    r.PendingSyncs,
+			Problems:        r.Problems,
+		}
+		for _, s := range r.Servers {
+			sh := serverHealthJSON{Server: s.Server, OK: s.OK, Mode: s.Mode.String()}
+			if s.Stats != nil {
+				sh.Version = s.Stats.Version
+			}
+			if s.Err != nil {
+				sh.Error = s.Err.Error()
+			}
+			h.Servers = append(h.Servers, sh)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !r.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	})
+}
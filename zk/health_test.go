@@ -0,0 +1,154 @@
+package zk
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mntrServer answers ruok and mntr as a server in state, the leader also
+// reporting synced followers, until l is closed.
+func mntrServer(l net.Listener, state string, synced int) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 4)
+		conn.Read(buf)
+		switch string(buf) {
+		case "ruok":
+			conn.Write([]byte("imok"))
+		case "mntr":
+			out := "zk_version\t3.6.3--6401e4ad2087061bc6b9f80dec2d69f2e3c8660a, built on 04/08/2021 16:35 GMT\n" +
+				"zk_server_state\t" + state + "\n"
+			if state == "leader" {
+				out += fmt.Sprintf("zk_followers\t%d\nzk_synced_followers\t%d\nzk_pending_syncs\t0\n", synced, synced)
+			}
+			conn.Write([]byte(out))
+		}
+		conn.Close()
+	}
+}
+
+// startMntrServers starts a server for each state and returns their
+// addresses.
+func startMntrServers(t *testing.T, synced int, states ...string) []string {
+	var addrs []string
+	for _, state := range states {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go mntrServer(l, state, synced)
+		addrs = append(addrs, l.Addr().String())
+	}
+	return addrs
+}
+
+func TestClusterHealth(t *testing.T) {
+	t.Parallel()
+	addrs := startMntrServers(t, 2, "follower", "leader", "follower", "observer")
+	r, err := ClusterHealth(strings.Join(addrs, ",")+"/app", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Healthy() || r.Err() != nil {
+		t.Fatalf("cluster should be healthy: %v", r.Err())
+	}
+	if r.Leader != addrs[1] || !r.Quorum || r.Voters != 3 || r.Serving != 3 || r.SyncedFollowers != 2 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	for i, mode := range []Mode{ModeFollower, ModeLeader, ModeFollower, ModeObserver} {
+		if s := r.Servers[i]; s.Server != addrs[i] || !s.OK || s.Mode != mode || s.Stats == nil {
+			t.Errorf("unexpected server health %+v", s)
+		}
+	}
+
+	// A follower down, and so not synced.
+	addrs = startMntrServers(t, 1, "follower", "leader")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	addrs = append(addrs, l.Addr().String())
+	r, err = ClusterHealth(strings.Join(addrs, ","), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Healthy() || !r.Quorum || r.Voters != 3 || r.Serving != 2 || r.Servers[2].Err == nil {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if len(r.Problems) != 2 || !strings.Contains(r.Problems[1], "1 of 2 followers synced") {
+		t.Errorf("unexpected problems %q", r.Problems)
+	}
+
+	// The leader alone has no quorum.
+	addrs = startMntrServers(t, 0, "leader", "observer")
+	addrs = append(addrs, l.Addr().String(), l.Addr().String())
+	r, err = ClusterHealth(strings.Join(addrs, ","), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Quorum || r.Leader != addrs[0] {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "no quorum: 1 of 3 voting servers") {
+		t.Errorf("Err() = %v", err)
+	}
+
+	if _, err := ClusterHealth("", time.Second); err == nil {
+		t.Errorf("no error for an empty connect string")
+	}
+}
+
+func TestClusterHealthStandalone(t *testing.T) {
+	t.Parallel()
+	addrs := startMntrServers(t, 0, "standalone")
+	r, err := ClusterHealth(addrs[0], time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Healthy() || !r.Quorum {
+		t.Errorf("standalone server should be healthy: %v", r.Err())
+	}
+}
+
+func TestClusterHealthHandler(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		servers []string
+		status  int
+	}{
+		{startMntrServers(t, 1, "leader", "follower"), http.StatusOK},
+		// The follower is not synced.
+		{startMntrServers(t, 0, "leader", "follower"), http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		h := ClusterHealthHandler(strings.Join(tc.servers, ","), time.Second)
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		if w.Code != tc.status {
+			t.Errorf("status %d, expected %d: %s", w.Code, tc.status, w.Body)
+		}
+		var body struct {
+			Healthy bool `json:"healthy"`
+			Servers []struct {
+				Mode    string `json:"mode"`
+				Version string `json:"version"`
+			} `json:"servers"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Healthy != (tc.status == http.StatusOK) || body.Servers[0].Mode != "leader" || body.Servers[0].Version != "3.6.3--6401e4ad2087061bc6b9f80dec2d69f2e3c8660a" {
+			t.Errorf("unexpected body %s", w.Body)
+		}
+	}
+}
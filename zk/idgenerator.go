@@ -0,0 +1,78 @@
+package zk
+
+import "sync"
+
+// IDGenerator hands out 64-bit IDs that are unique among all the clients
+// using the same path, and increasing for each generator. Every ID, or batch
+// of IDs, is reserved by creating a sequential node, whose sequence number is
+// unique and increasing among the children of the path; the node is deleted
+// right away. As the sequence number is 32 bits and also advanced by the
+// deletes, batches make the IDs both cheaper and last longer.
+type IDGenerator struct {
+	c     *Conn
+	path  string
+	acl   []ACL
+	batch int64
+
+	mu   sync.Mutex // protects next and end
+	next int64
+	end  int64 // the end of the reserved batch
+}
+
+// NewIDGenerator creates a new ID generator using the provided connection,
+// path under which the sequential nodes are created, and acl.
+func NewIDGenerator(c *Conn, path string, acl []ACL) *IDGenerator {
+	return &IDGenerator{c: c, path: path, acl: acl, batch: 1}
+}
+
+// SetBatchSize sets how many IDs are reserved at once, 1 by default. The IDs
+// of a batch are handed out by this generator only, in order, so IDs from
+// different generators are not ordered by when they were handed out. All the
+// generators using a path must use the same batch size.
+func (g *IDGenerator) SetBatchSize(n int64) {
+	if n < 1 {
+		n = 1
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.batch = n
+}
+
+// NextID returns a new ID.
+func (g *IDGenerator) NextID() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.next >= g.end {
+		seq, err := g.reserve()
+		if err != nil {
+			return 0, err
+		}
+		g.next, g.end = seq*g.batch, (seq+1)*g.batch
+	}
+	id := g.next
+	g.next++
+	return id, nil
+}
+
+// reserve creates and deletes a sequential node, and returns its sequence
+// number.
+func (g *IDGenerator) reserve() (int64, error) {
+	prefix := childPath(g.path, "id-")
+	path, err := g.c.Create(prefix, []byte{}, FlagEphemeral|FlagSequence, g.acl)
+	if err == ErrNoNode {
+		if err := g.c.CreateParents(prefix, g.acl); err != nil {
+			return 0, err
+		}
+		path, err = g.c.Create(prefix, []byte{}, FlagEphemeral|FlagSequence, g.acl)
+	}
+	if err != nil {
+		return 0, err
+	}
+	seq, err := parseSeq(path)
+	if err != nil {
+		return 0, err
+	}
+	// Left to the session to delete if this fails.
+	g.c.Delete(path, -1)
+	return int64(seq), nil
+}
@@ -0,0 +1,60 @@
+package zk
+
+import "testing"
+
+func TestIDGenerator(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	g := NewIDGenerator(zk, "/ids", WorldACL(PermAll))
+	g.SetBatchSize(3)
+
+	reserve := func(seq string) {
+		t.Helper()
+		req, err := fc.ExpectRequest("create")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := req.Body.(*CreateRequest); body.Path != "/ids/id-" || body.Flags != FlagEphemeral|FlagSequence {
+			t.Fatalf("Reserved with %+v", body)
+		}
+		if err := fc.Reply(req, 1, nil, &createResponse{Path: "/ids/id-" + seq}); err != nil {
+			t.Fatal(err)
+		}
+		req, err = fc.ExpectRequest("delete")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Path != "/ids/id-"+seq {
+			t.Fatalf("Deleted %s", req.Path)
+		}
+		if err := fc.Reply(req, 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := make(chan int64, 4)
+	go func() {
+		for i := 0; i < 4; i++ {
+			id, err := g.NextID()
+			if err != nil {
+				t.Error(err)
+			}
+			ids <- id
+		}
+	}()
+	// A batch is reserved for the first three IDs, and another one for the
+	// fourth.
+	reserve("0000000004")
+	for _, want := range []int64{12, 13, 14} {
+		if id := <-ids; id != want {
+			t.Fatalf("ID %d, expected %d", id, want)
+		}
+	}
+	reserve("0000000006")
+	if id := <-ids; id != 18 {
+		t.Fatalf("ID %d, expected 18", id)
+	}
+}
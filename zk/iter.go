@@ -0,0 +1,165 @@
+package zk
+
+import "encoding/binary"
+
+// ChildrenIterator yields the children of a node one at a time. Unlike
+// Children it keeps the names encoded in the single buffer they were received
+// in, which saves building a string for each of them when a node has very
+// many children.
+type ChildrenIterator struct {
+	buf   []byte
+	count int
+	i     int
+	off   int
+	name  []byte
+}
+
+// ChildrenIter is like Children but returns an iterator over the names of the
+// children, in the order of the server.
+func (c *Conn) ChildrenIter(path string) (*ChildrenIterator, *Stat, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := &rawChildrenResponse{}
+	err = c.readRequest(opGetChildren2, &getChildren2Request{Path: path, Watch: false}, res)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &ChildrenIterator{buf: res.Children, count: res.Count}, &res.Stat, nil
+}
+
+// Len returns the number of children.
+func (it *ChildrenIterator) Len() int {
+	return it.count
+}
+
+// Next advances to the next child and reports whether there is one.
+func (it *ChildrenIterator) Next() bool {
+	if it.i >= it.count {
+		it.name = nil
+		return false
+	}
+	// The lengths were checked when the response was decoded.
+	l := int(binary.BigEndian.Uint32(it.buf[it.off:]))
+	it.name = it.buf[it.off+4 : it.off+4+l]
+	it.off += 4 + l
+	it.i++
+	return true
+}
+
+// NameBytes returns the name of the current child without copying it. The
+// bytes must not be modified.
+func (it *ChildrenIterator) NameBytes() []byte {
+	return it.name
+}
+
+// Name returns the name of the current child.
+func (it *ChildrenIterator) Name() string {
+	return string(it.name)
+}
+
+// WalkIterator yields the nodes of a subtree with their Stat one at a time,
+// in depth-first order with parents before their children. Only the
+// children of the nodes on the current branch are held in memory, so
+// subtrees with millions of nodes can be scanned in constant memory for a
+// given depth. Like Prefetch, it does not take a consistent snapshot, and
+// nodes deleted during the walk are left out.
+type WalkIterator struct {
+	c     *Conn
+	root  string
+	stack []walkFrame
+	path  []byte
+	stat  *Stat
+	err   error
+}
+
+type walkFrame struct {
+	pathLen  int // length of the path of the node whose children these are
+	children *ChildrenIterator
+}
+
+// WalkIter returns an iterator over the subtree at path. The first node that
+// Next advances to is the one at path itself.
+func (c *Conn) WalkIter(path string) (*WalkIterator, error) {
+	path, err := c.cleanPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return &WalkIterator{c: c, root: path}, nil
+}
+
+// Next advances to the next node and reports whether there is one. It stops
+// at the first error other than the node being deleted, which Err returns.
+func (it *WalkIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.root != "" {
+		root := it.root
+		it.root = ""
+		if !it.visit(root) {
+			return false
+		}
+		if it.stat != nil {
+			return true
+		}
+	}
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		if !top.children.Next() {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		it.path = it.path[:top.pathLen]
+		if top.pathLen > 1 {
+			it.path = append(it.path, '/')
+		}
+		it.path = append(it.path, top.children.NameBytes()...)
+		if !it.visit(string(it.path)) {
+			return false
+		}
+		if it.stat != nil {
+			return true
+		}
+	}
+	it.stat = nil
+	return false
+}
+
+// visit reads the node at p and pushes its children. It returns false on
+// error, and leaves stat nil if the node does not exist.
+func (it *WalkIterator) visit(p string) bool {
+	children, stat, err := it.c.ChildrenIter(p)
+	switch {
+	case err == ErrNoNode:
+		it.stat = nil
+		return true
+	case err != nil:
+		it.err = err
+		it.stat = nil
+		return false
+	}
+	it.path = append(it.path[:0], p...)
+	it.stat = stat
+	if children.Len() > 0 {
+		it.stack = append(it.stack, walkFrame{pathLen: len(it.path), children: children})
+	}
+	return true
+}
+
+// Path returns the path of the current node.
+func (it *WalkIterator) Path() string {
+	return string(it.path)
+}
+
+// Stat returns the Stat of the current node.
+func (it *WalkIterator) Stat() *Stat {
+	return it.stat
+}
+
+// Err returns the error that stopped the walk, if any.
+func (it *WalkIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,104 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRawChildrenResponse(t *testing.T) {
+	t.Parallel()
+	buf := make([]byte, 256)
+	for _, children := range [][]string{nil, {}, {"a", "bc", ""}} {
+		res := getChildren2Response{Children: children, Stat: Stat{Version: 3, NumChildren: int32(len(children))}}
+		n, err := encodePacket(buf, &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := &rawChildrenResponse{}
+		if m, err := decodePacket(buf[:n], raw); err != nil || m != n {
+			t.Fatalf("Decode returned %d, %+v for %d bytes", m, err, n)
+		}
+		if raw.Stat != res.Stat {
+			t.Fatalf("Decoded Stat %+v, expected %+v", raw.Stat, res.Stat)
+		}
+		it := &ChildrenIterator{buf: raw.Children, count: raw.Count}
+		var names []string
+		for it.Next() {
+			names = append(names, it.Name())
+		}
+		if len(names) != len(children) || (len(children) > 0 && !reflect.DeepEqual(names, children)) {
+			t.Fatalf("Iterated %q, expected %q", names, children)
+		}
+		// Cut in the length of the first child.
+		if len(children) > 0 {
+			if _, err := decodePacket(buf[:6:6], &rawChildrenResponse{}); err != ErrShortBuffer {
+				t.Fatalf("Decode of a truncated response returned %+v", err)
+			}
+		}
+	}
+}
+
+func TestWalkIter(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	tree := map[string][]string{
+		"/root":     {"a", "gone", "b"},
+		"/root/a":   {"x"},
+		"/root/a/x": nil,
+		"/root/b":   nil,
+	}
+	go func() {
+		for {
+			req, err := fc.ExpectRequest("getChildren2")
+			if err != nil {
+				return
+			}
+			p := req.Path
+			children, ok := tree[p]
+			if !ok {
+				fc.Reply(req, 1, ErrNoNode, nil)
+				continue
+			}
+			fc.Reply(req, 1, nil, &getChildren2Response{Children: children, Stat: Stat{NumChildren: int32(len(children)), Version: int32(len(p))}})
+		}
+	}()
+
+	it, err := zk.WalkIter("/root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for it.Next() {
+		if it.Stat().Version != int32(len(it.Path())) {
+			t.Fatalf("Stat of %s is %+v", it.Path(), it.Stat())
+		}
+		paths = append(paths, it.Path())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Walk failed: %+v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"/root", "/root/a", "/root/a/x", "/root/b"}) {
+		t.Fatalf("Walked %v", paths)
+	}
+
+	children, stat, err := zk.ChildrenIter("/root")
+	if err != nil || stat.NumChildren != 3 || children.Len() != 3 {
+		t.Fatalf("ChildrenIter returned %+v, %+v", stat, err)
+	}
+	for _, expected := range tree["/root"] {
+		if !children.Next() || string(children.NameBytes()) != expected {
+			t.Fatalf("Expected child %s, got %s", expected, children.Name())
+		}
+	}
+	if children.Next() {
+		t.Fatal("Next returned true past the last child")
+	}
+
+	if it, _ := zk.WalkIter("/missing"); it.Next() {
+		t.Fatal("Walk of a missing node returned a node")
+	}
+}
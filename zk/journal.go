@@ -0,0 +1,340 @@
+package zk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalEntry is a mutation recorded by a Journal before it was sent.
+type JournalEntry struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// Op is "create", "delete", "set" or "multi", or "check" for the
+	// version checks of a multi.
+	Op string `json:"op"`
+	// Path is the path passed to the operation, relative to the chroot of
+	// the connection. For sequential creates it is the prefix of the node.
+	Path    string `json:"path"`
+	Data    []byte `json:"data,omitempty"`
+	Flags   int32  `json:"flags,omitempty"`
+	Version int32  `json:"version,omitempty"`
+	// Ops are the operations of a multi.
+	Ops []JournalEntry `json:"ops,omitempty"`
+}
+
+// JournalOutcome is whether a pending journal entry took effect.
+type JournalOutcome int
+
+const (
+	// JournalApplied means the mutation took effect.
+	JournalApplied JournalOutcome = iota
+	// JournalNotApplied means the mutation did not take effect.
+	JournalNotApplied
+	// JournalUnknown means the outcome cannot be told, e.g. for a
+	// sequential create that is not protected, or a node that changed
+	// since.
+	JournalUnknown
+)
+
+var journalOutcomeNames = map[JournalOutcome]string{
+	JournalApplied:    "applied",
+	JournalNotApplied: "not applied",
+	JournalUnknown:    "unknown",
+}
+
+func (o JournalOutcome) String() string {
+	if name, ok := journalOutcomeNames[o]; ok {
+		return name
+	}
+	return "invalid"
+}
+
+// JournalResolution is the outcome of a pending entry found by
+// Journal.Reconcile.
+type JournalResolution struct {
+	Entry   JournalEntry
+	Outcome JournalOutcome
+	// Path is the node created by an applied create, so that it can be
+	// adopted or cleaned up.
+	Path string
+}
+
+// journalRecord is a line of the journal file.
+type journalRecord struct {
+	Begin *JournalEntry `json:"begin,omitempty"`
+	End   uint64        `json:"end,omitempty"`
+}
+
+// Journal records intended mutations in a local file before they are sent,
+// and their completion once the server answered. After a crash, the entries
+// that were never completed are those whose outcome is unknown to the
+// process, and Reconcile finds out which of them took effect. Use it with
+// WithJournal, and protected creates such as
+// CreateProtectedEphemeralSequential so that sequential nodes can be
+// found again.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	nextID  uint64
+	pending map[uint64]JournalEntry
+}
+
+// OpenJournal opens the journal file at path, creating it if needed. Entries
+// left pending by a previous process are kept, and the others dropped.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, pending: make(map[uint64]JournalEntry)}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var r journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A record cut short by a crash.
+			continue
+		}
+		if r.Begin != nil {
+			j.pending[r.Begin.ID] = *r.Begin
+			if r.Begin.ID >= j.nextID {
+				j.nextID = r.Begin.ID
+			}
+		}
+		delete(j.pending, r.End)
+	}
+
+	// Rewrite the file with the pending entries only.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+	for _, e := range j.Pending() {
+		e := e
+		if err := j.write(journalRecord{Begin: &e}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Pending returns the entries whose outcome is not known, ordered by ID.
+func (j *Journal) Pending() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].ID < entries[b].ID })
+	return entries
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// begin records e and returns its ID once it is on disk.
+func (j *Journal) begin(e JournalEntry) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.nextID++
+	e.ID = j.nextID
+	e.Time = time.Now()
+	if err := j.write(journalRecord{Begin: &e}); err != nil {
+		return 0, err
+	}
+	j.pending[e.ID] = e
+	return e.ID, nil
+}
+
+// end records that the outcome of the entry id is known.
+func (j *Journal) end(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[id]; !ok {
+		return nil
+	}
+	delete(j.pending, id)
+	if len(j.pending) == 0 {
+		// Nothing to recover, start over with an empty file.
+		if err := j.f.Truncate(0); err != nil {
+			return err
+		}
+		_, err := j.f.Seek(0, 0)
+		return err
+	}
+	return j.write(journalRecord{End: id})
+}
+
+// write appends r to the file and syncs it. The caller must hold mu.
+func (j *Journal) write(r journalRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// Reconcile finds out whether each pending entry took effect, using c, which
+// must have the chroot of the connection the entries were recorded on. The
+// entries are then no longer pending, and the caller decides from the
+// resolutions what to clean up, e.g. ephemeral nodes of the session of a
+// crashed process, and what to adopt.
+func (j *Journal) Reconcile(c *Conn) ([]JournalResolution, error) {
+	var resolutions []JournalResolution
+	for _, e := range j.Pending() {
+		r, err := reconcileEntry(c, e)
+		if err != nil {
+			return resolutions, err
+		}
+		if err := j.end(e.ID); err != nil {
+			return resolutions, err
+		}
+		resolutions = append(resolutions, r)
+	}
+	return resolutions, nil
+}
+
+func reconcileEntry(c *Conn, e JournalEntry) (JournalResolution, error) {
+	r := JournalResolution{Entry: e, Outcome: JournalUnknown}
+	switch e.Op {
+	case "create":
+		parent, name := parentPath(e.Path), e.Path[strings.LastIndex(e.Path, "/")+1:]
+		if strings.HasPrefix(name, protectedPrefix) && len(name) >= len(protectedPrefix)+32 {
+			guid := name[len(protectedPrefix) : len(protectedPrefix)+32]
+			children, _, err := c.Children(parent)
+			if err != nil && err != ErrNoNode {
+				return r, err
+			}
+			r.Outcome = JournalNotApplied
+			for _, child := range children {
+				if strings.HasPrefix(child, protectedPrefix+guid) {
+					r.Outcome, r.Path = JournalApplied, childPath(parent, child)
+				}
+			}
+			return r, nil
+		}
+		if e.Flags&FlagSequence != 0 {
+			return r, nil
+		}
+		data, _, err := c.Get(e.Path)
+		switch {
+		case err == ErrNoNode:
+			r.Outcome = JournalNotApplied
+		case err != nil:
+			return r, err
+		case bytes.Equal(data, e.Data):
+			r.Outcome, r.Path = JournalApplied, e.Path
+		}
+	case "delete":
+		ok, _, err := c.Exists(e.Path)
+		if err != nil {
+			return r, err
+		}
+		if !ok {
+			r.Outcome = JournalApplied
+		} else {
+			r.Outcome = JournalNotApplied
+		}
+	case "set":
+		data, stat, err := c.Get(e.Path)
+		switch {
+		case err == ErrNoNode:
+		case err != nil:
+			return r, err
+		case bytes.Equal(data, e.Data):
+			r.Outcome = JournalApplied
+		case e.Version >= 0 && stat.Version == e.Version:
+			r.Outcome = JournalNotApplied
+		}
+	case "multi":
+		var applied, notApplied bool
+		for _, op := range e.Ops {
+			if op.Op == "check" {
+				continue
+			}
+			sub, err := reconcileEntry(c, op)
+			if err != nil {
+				return r, err
+			}
+			applied = applied || sub.Outcome == JournalApplied
+			notApplied = notApplied || sub.Outcome == JournalNotApplied
+		}
+		// The operations of a multi take effect together or not at all.
+		if applied != notApplied {
+			r.Outcome = JournalNotApplied
+			if applied {
+				r.Outcome = JournalApplied
+			}
+		}
+	}
+	return r, nil
+}
+
+// WithJournal returns a connection option that records every Create,
+// Create2, CreateContainer, CreateTTL, Delete, Set and Multi in j before it
+// is sent, and so those of the helpers built on them, such as DeleteIfEmpty.
+// Entries are completed once the server answered, and stay pending if the
+// connection or session was lost or closed before, or the request failed
+// without an answer, in which case the outcome is unknown until
+// j.Reconcile is called.
+func WithJournal(j *Journal) connOption {
+	return func(c *Conn) {
+		c.journal = j
+	}
+}
+
+// journalBegin records an intended mutation, if the connection has a
+// journal.
+func (c *Conn) journalBegin(e JournalEntry) (uint64, error) {
+	if c.journal == nil {
+		return 0, nil
+	}
+	return c.journal.begin(e)
+}
+
+// journalEnd completes the entry id unless err leaves its outcome unknown.
+func (c *Conn) journalEnd(id uint64, err error) {
+	if id == 0 || journalUnknown(err) {
+		return
+	}
+	if err := c.journal.end(id); err != nil {
+		c.logf(LogWarn, LogRecipes, "Failed to complete journal entry %d: %s", id, err)
+	}
+}
+
+// journalUnknown reports whether err leaves the outcome of a mutation
+// unknown: the request may have reached the server, but its answer was lost
+// along with the connection or session, or to Close, or the request failed
+// locally, e.g. to be written, without an answer.
+func journalUnknown(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case ErrConnectionClosed, ErrSessionExpired, ErrClosing, ErrNoServer:
+		return true
+	}
+	return errorCode(err) == 0
+}
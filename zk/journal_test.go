@@ -0,0 +1,230 @@
+package zk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalFile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := j.begin(JournalEntry{Op: "create", Path: "/a", Data: []byte("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := j.begin(JournalEntry{Op: "delete", Path: "/b", Version: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.end(first); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// A record cut short by a crash is ignored.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"begin":{"id":3,"op":"cre`))
+	f.Close()
+
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	pending := j.Pending()
+	if len(pending) != 1 || pending[0].ID != second || pending[0].Op != "delete" || pending[0].Version != -1 {
+		t.Fatalf("Unexpected pending entries %+v", pending)
+	}
+	if id, err := j.begin(JournalEntry{Op: "set", Path: "/c"}); err != nil || id <= second {
+		t.Fatalf("begin returned %d, %+v after reopening", id, err)
+	}
+	for _, e := range j.Pending() {
+		if err := j.end(e.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+		t.Fatalf("Journal not emptied once nothing is pending: %+v, %+v", fi, err)
+	}
+}
+
+func TestJournalReconcile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := OpenJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithJournal(j))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	// An answered request is completed, even if it failed.
+	done := make(chan error, 1)
+	go func() {
+		_, err := zk.Set("/node", []byte("data"), 3)
+		done <- err
+	}()
+	req, err := fc.ExpectRequest("setData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.Reply(req, 1, ErrBadVersion, nil)
+	if err := <-done; err != ErrBadVersion {
+		t.Fatalf("Set returned %+v", err)
+	}
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Fatalf("Answered request left pending: %+v", pending)
+	}
+
+	// A request in flight when the connection is lost stays pending.
+	go func() { done <- zk.Delete("/node", -1) }()
+	if _, err := fc.ExpectRequest("delete"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Close()
+	if err := <-done; err != ErrConnectionClosed {
+		t.Fatalf("Delete returned %+v", err)
+	}
+	if pending := j.Pending(); len(pending) != 1 || pending[0].Op != "delete" || pending[0].Path != "/node" {
+		t.Fatalf("Unexpected pending entries %+v", pending)
+	}
+
+	fc = acceptFake(t, s, 1)
+	waitForState(t, ch, StateHasSession)
+	type reconciled struct {
+		res []JournalResolution
+		err error
+	}
+	rdone := make(chan reconciled, 1)
+	go func() {
+		res, err := j.Reconcile(zk)
+		rdone <- reconciled{res, err}
+	}()
+	if req, err = fc.ExpectRequest("exists"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Reply(req, 2, ErrNoNode, nil)
+	r := <-rdone
+	if r.err != nil || len(r.res) != 1 || r.res[0].Outcome != JournalApplied {
+		t.Fatalf("Reconcile returned %+v, %+v", r.res, r.err)
+	}
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Fatalf("Reconciled entries left pending: %+v", pending)
+	}
+}
+
+func TestJournalMulti(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j, err := OpenJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithJournal(j))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := zk.Multi(&CreateRequest{Path: "/a", Data: []byte("a"), Acl: WorldACL(PermAll)}, &DeleteRequest{Path: "/b", Version: -1})
+		done <- err
+	}()
+	if _, err := fc.ExpectRequest("multi"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Close()
+	if err := <-done; err != ErrConnectionClosed {
+		t.Fatalf("Multi returned %+v", err)
+	}
+	pending := j.Pending()
+	if len(pending) != 1 || pending[0].Op != "multi" || len(pending[0].Ops) != 2 ||
+		pending[0].Ops[0].Op != "create" || pending[0].Ops[1].Path != "/b" {
+		t.Fatalf("Unexpected pending entries %+v", pending)
+	}
+
+	fc = acceptFake(t, s, 1)
+	waitForState(t, ch, StateHasSession)
+	type reconciled struct {
+		res []JournalResolution
+		err error
+	}
+	rdone := make(chan reconciled, 1)
+	go func() {
+		res, err := j.Reconcile(zk)
+		rdone <- reconciled{res, err}
+	}()
+	req, err := fc.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.Reply(req, 2, nil, &getDataResponse{Data: []byte("a")})
+	if req, err = fc.ExpectRequest("exists"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Reply(req, 2, ErrNoNode, nil)
+	r := <-rdone
+	if r.err != nil || len(r.res) != 1 || r.res[0].Outcome != JournalApplied {
+		t.Fatalf("Reconcile returned %+v, %+v", r.res, r.err)
+	}
+
+	// A request in flight when the connection is closed stays pending.
+	go func() {
+		_, _, err := zk.Create2("/c", nil, 0, WorldACL(PermAll))
+		done <- err
+	}()
+	if _, err := fc.ExpectRequest("create2"); err != nil {
+		t.Fatal(err)
+	}
+	go zk.Close()
+	if req, err = fc.ExpectRequest("close"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Reply(req, 3, nil, nil)
+	if err := <-done; err != ErrClosing {
+		t.Fatalf("Create2 returned %+v", err)
+	}
+	if pending := j.Pending(); len(pending) != 1 || pending[0].Op != "create" || pending[0].Path != "/c" {
+		t.Fatalf("Unexpected pending entries %+v", pending)
+	}
+}
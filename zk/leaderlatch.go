@@ -0,0 +1,268 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// latchRetryInterval is how long a LeaderLatch waits before trying again
+// after an unexpected error.
+const latchRetryInterval = time.Second
+
+// ErrLatchStarted is returned by LeaderLatch.Start if the latch was started
+// already.
+var ErrLatchStarted = errors.New("zk: leader latch already started")
+
+// LeaderLatch elects a leader among the latches on the same path, for
+// services that only need to know whether they lead. Each started latch
+// queues with an ephemeral sequential node, and the one with the lowest
+// sequence number has leadership. Leadership is given up while the
+// connection is lost, as the session may expire meanwhile, and checked again
+// once it is re-established. After the session expired the latch queues
+// again with a new node.
+type LeaderLatch struct {
+	c     *Conn
+	path  string
+	acl   []ACL
+	owner OwnerInfo
+	ch    chan bool
+
+	mu      sync.Mutex // protects leader, started and stopped
+	leader  bool
+	started bool
+	stopped bool
+
+	node   string // owned by run
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewLeaderLatch creates a latch using the provided connection, path, and
+// acl. The path must be a node that is only used by latches. The latch takes
+// part in the election once started.
+func NewLeaderLatch(c *Conn, path string, acl []ACL) *LeaderLatch {
+	l := &LeaderLatch{
+		c:     c,
+		path:  path,
+		acl:   acl,
+		owner: NewOwnerInfo(nil),
+		ch:    make(chan bool, 1),
+		done:  make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	return l
+}
+
+// SetOwner sets the owner info written into the node of the latch. It
+// defaults to NewOwnerInfo(nil) and must be set before Start.
+func (l *LeaderLatch) SetOwner(owner OwnerInfo) {
+	l.owner = owner
+}
+
+// Start makes the latch take part in the election in the background. A
+// latch can only be started once.
+func (l *LeaderLatch) Start() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.started {
+		return ErrLatchStarted
+	}
+	l.started = true
+	go l.run(l.c.Subscribe())
+	return nil
+}
+
+// Stop gives up leadership and leaves the election, deleting the node of the
+// latch. The channel returned by Leadership is closed once it returns.
+func (l *LeaderLatch) Stop() error {
+	l.mu.Lock()
+	stop := l.started && !l.stopped
+	l.stopped = true
+	l.mu.Unlock()
+	if !stop {
+		return nil
+	}
+	l.cancel()
+	<-l.done
+	l.setLeader(false)
+	close(l.ch)
+	if l.node == "" {
+		return nil
+	}
+	// Closing the connection deletes the node too.
+	if err := l.c.DeleteGuaranteed(l.node, -1); err != nil && err != ErrNoNode && err != ErrClosing && !isConnectionError(err) {
+		return err
+	}
+	return nil
+}
+
+// HasLeadership reports whether the latch has leadership.
+func (l *LeaderLatch) HasLeadership() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// Leadership returns a channel receiving true when the latch gains
+// leadership and false when it loses it. Only the latest change is kept for
+// a slow reader.
+func (l *LeaderLatch) Leadership() <-chan bool {
+	return l.ch
+}
+
+func (l *LeaderLatch) setLeader(leader bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.leader == leader {
+		return
+	}
+	l.leader = leader
+	select {
+	case <-l.ch:
+	default:
+	}
+	l.ch <- leader
+}
+
+func (l *LeaderLatch) run(session <-chan Event) {
+	defer close(l.done)
+	defer l.c.Unsubscribe(session)
+	for l.ctx.Err() == nil {
+		watched, ch, err := l.check()
+		if err != nil {
+			l.setLeader(false)
+			if err == ErrClosing {
+				return
+			} else if isConnectionError(err) {
+				l.c.WaitForSession(l.ctx)
+			} else {
+				l.c.logf(LogWarn, LogRecipes, "Leader latch on %s failed: %s", l.path, err)
+				select {
+				case <-time.After(latchRetryInterval):
+				case <-l.ctx.Done():
+				}
+			}
+			continue
+		}
+		if ch == nil {
+			continue
+		}
+		l.wait(ch, session)
+		l.c.RemoveWatches(watched, ch)
+	}
+}
+
+// wait waits for the watched node to change, or the session to be
+// re-established after leadership was given up.
+func (l *LeaderLatch) wait(ch <-chan Event, session <-chan Event) {
+	for {
+		select {
+		case <-ch:
+			return
+		case ev, ok := <-session:
+			if !ok {
+				// The connection is closed.
+				l.setLeader(false)
+				session = nil
+				continue
+			}
+			if ev.State == StateHasSession {
+				return
+			}
+			if ev.State == StateDisconnected || ev.State == StateExpired {
+				l.setLeader(false)
+			}
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// check queues the latch if it is not, and sets leadership according to its
+// place in the queue. It returns the node to watch for a change of
+// leadership, the node of the latch if it leads or the one before it
+// otherwise, or a nil channel to check again.
+func (l *LeaderLatch) check() (string, <-chan Event, error) {
+	if l.node != "" {
+		exists, _, err := l.c.Exists(l.node)
+		if err != nil {
+			return "", nil, err
+		}
+		if !exists {
+			// The session that created it expired.
+			l.node = ""
+		}
+	}
+	if l.node == "" {
+		if err := l.create(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	children, _, err := l.c.Children(l.path)
+	if err != nil {
+		return "", nil, err
+	}
+	seq, err := parseSeq(l.node)
+	if err != nil {
+		return "", nil, err
+	}
+	found := false
+	prevSeq := -1
+	prev := ""
+	for _, p := range children {
+		s, err := parseSeq(p)
+		if err != nil {
+			continue
+		}
+		if s == seq {
+			found = true
+		} else if s < seq && s > prevSeq {
+			prevSeq, prev = s, l.path+"/"+p
+		}
+	}
+	if !found {
+		l.node = ""
+		return "", nil, nil
+	}
+
+	watched := l.node
+	if prev != "" {
+		watched = prev
+	}
+	l.setLeader(prev == "")
+	exists, _, ch, err := l.c.ExistsW(watched)
+	if err != nil {
+		return "", nil, err
+	}
+	if !exists {
+		l.c.RemoveWatches(watched, ch)
+		return "", nil, nil
+	}
+	return watched, ch, nil
+}
+
+// create queues the latch with a new node, creating the parents if needed.
+func (l *LeaderLatch) create() error {
+	data, err := l.owner.Marshal()
+	if err != nil {
+		return err
+	}
+	prefix := fmt.Sprintf("%s/latch-", l.path)
+	node, err := l.c.CreateProtectedEphemeralSequential(prefix, data, l.acl)
+	if err == ErrNoNode {
+		if err := l.c.CreateParents(prefix, l.acl); err != nil {
+			return err
+		}
+		node, err = l.c.CreateProtectedEphemeralSequential(prefix, data, l.acl)
+	}
+	if err != nil {
+		return err
+	}
+	l.node = node
+	return nil
+}
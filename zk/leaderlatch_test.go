@@ -0,0 +1,66 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderLatch(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	l := NewLeaderLatch(zk, "/latch", WorldACL(PermAll))
+
+	expectLeadership := func(leader bool) {
+		t.Helper()
+		select {
+		case got := <-l.Leadership():
+			if got != leader {
+				t.Fatalf("Leadership %v, expected %v", got, leader)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatalf("Leadership did not change to %v", leader)
+		}
+		if l.HasLeadership() != leader {
+			t.Fatalf("HasLeadership is %v, expected %v", !leader, leader)
+		}
+	}
+
+	// Another latch leads, the one before this one is watched.
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(); err != ErrLatchStarted {
+		t.Fatalf("Starting again returned %+v", err)
+	}
+	serveFake(t, fc, "create", "", nil, &createResponse{Path: "/latch/_c_a-latch-0000000002"})
+	serveFake(t, fc, "getChildren2", "/latch", nil, &getChildren2Response{Children: []string{"_c_b-latch-0000000001", "_c_a-latch-0000000002"}})
+	serveFake(t, fc, "exists", "/latch/_c_b-latch-0000000001", nil, &existsResponse{})
+	if l.HasLeadership() {
+		t.Fatal("Latch leads while another one is first")
+	}
+
+	// It leads once the other one is gone.
+	if err := fc.SendEvent(2, EventNodeDeleted, "/latch/_c_b-latch-0000000001"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "exists", "/latch/_c_a-latch-0000000002", nil, &existsResponse{})
+	serveFake(t, fc, "getChildren2", "/latch", nil, &getChildren2Response{Children: []string{"_c_a-latch-0000000002"}})
+	serveFake(t, fc, "exists", "/latch/_c_a-latch-0000000002", nil, &existsResponse{})
+	expectLeadership(true)
+
+	// Stopping gives up leadership and deletes the node.
+	done := make(chan error, 1)
+	go func() { done <- l.Stop() }()
+	serveFake(t, fc, "removeWatches", "/latch/_c_a-latch-0000000002", nil, &removeWatchesResponse{})
+	serveFake(t, fc, "delete", "/latch/_c_a-latch-0000000002", nil, nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expectLeadership(false)
+	if _, ok := <-l.Leadership(); ok {
+		t.Fatal("Leadership channel not closed")
+	}
+}
@@ -0,0 +1,131 @@
+package zk
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// LocalityHostProvider is a HostProvider that prefers local servers, e.g.
+// those in the same availability zone as the client, and only falls back to
+// the remote ones once every local server failed. Local and remote servers
+// are each tried in random order. Hosts are resolved from DNS once during the
+// call to Init. A connection that fell back to a remote server stays there
+// until it is lost; the next reconnect starts with the local servers again.
+type LocalityHostProvider struct {
+	// Local reports whether a server is local. It is called with the
+	// servers as passed to Init, before they are resolved, so it can match
+	// on host names. If it is nil all servers are local.
+	Local func(server string) bool
+
+	mu         sync.Mutex
+	servers    []string // local servers first, then remote ones
+	local      int      // number of local servers
+	offset     int      // local server to start the next round with
+	curr       int
+	attempts   int                            // attempts since the last successful connection
+	lookupHost func(string) ([]string, error) // Override of net.LookupHost, for testing.
+}
+
+// WithLocality returns a connection option that connects to local servers,
+// as reported by local, whenever one of them is reachable. It replaces the
+// HostProvider with a LocalityHostProvider.
+func WithLocality(local func(server string) bool) connOption {
+	return func(c *Conn) {
+		c.hostProvider = &LocalityHostProvider{Local: local}
+	}
+}
+
+// MatchHostLabel returns a function reporting whether the host name of a
+// server has label as one of its dot-separated labels, for use with
+// WithLocality and LocalityHostProvider, e.g. MatchHostLabel("us-east-1a")
+// matches zk1.us-east-1a.example.com:2181.
+func MatchHostLabel(label string) func(server string) bool {
+	return func(server string) bool {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		for _, l := range strings.Split(host, ".") {
+			if strings.EqualFold(l, label) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Init is called first, with the servers specified in the connection
+// string. It splits them into local and remote servers, uses DNS to look up
+// addresses for each, then shuffles each group.
+func (hp *LocalityHostProvider) Init(servers []string) error {
+	var local, remote []string
+	for _, server := range servers {
+		if hp.Local == nil || hp.Local(server) {
+			local = append(local, server)
+		} else {
+			remote = append(remote, server)
+		}
+	}
+	var localAddrs, remoteAddrs []string
+	var err error
+	if len(local) > 0 {
+		if localAddrs, err = resolveServers(local, hp.lookupHost); err != nil {
+			return err
+		}
+	}
+	if len(remote) > 0 {
+		if remoteAddrs, err = resolveServers(remote, hp.lookupHost); err != nil {
+			return err
+		}
+	}
+	stringShuffle(localAddrs)
+	stringShuffle(remoteAddrs)
+
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.servers = append(localAddrs, remoteAddrs...)
+	hp.local = len(localAddrs)
+	hp.offset = 0
+	hp.curr = -1
+	hp.attempts = 0
+	return nil
+}
+
+// Len returns the number of servers available
+func (hp *LocalityHostProvider) Len() int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return len(hp.servers)
+}
+
+// Next returns the next server to connect to: each local server once, then
+// each remote server once. retryStart will be true when this starts over
+// without Connected() being called.
+func (hp *LocalityHostProvider) Next() (server string, retryStart bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if hp.attempts >= len(hp.servers) {
+		hp.attempts = 0
+		retryStart = true
+	}
+	if hp.attempts < hp.local {
+		hp.curr = (hp.offset + hp.attempts) % hp.local
+	} else {
+		hp.curr = hp.attempts
+	}
+	hp.attempts++
+	return hp.servers[hp.curr], retryStart
+}
+
+// Connected notifies the HostProvider of a successful connection.
+func (hp *LocalityHostProvider) Connected() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.attempts = 0
+	if hp.curr >= 0 && hp.curr < hp.local {
+		// Start with the next local server if this one goes away.
+		hp.offset = hp.curr + 1
+	}
+}
@@ -0,0 +1,95 @@
+package zk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchHostLabel(t *testing.T) {
+	t.Parallel()
+	match := MatchHostLabel("us-east-1a")
+	for server, expected := range map[string]bool{
+		"zk1.us-east-1a.example.com:2181": true,
+		"zk1.US-EAST-1A.example.com":      true,
+		"zk1.us-east-1b.example.com:2181": false,
+		"us-east-1ab.example.com:2181":    false,
+		"192.0.2.1:2181":                  false,
+	} {
+		if match(server) != expected {
+			t.Errorf("MatchHostLabel(%q) = %v, expected %v", server, !expected, expected)
+		}
+	}
+}
+
+func TestLocalityHostProvider(t *testing.T) {
+	t.Parallel()
+	hp := &LocalityHostProvider{
+		Local: MatchHostLabel("local"),
+		lookupHost: func(host string) ([]string, error) {
+			if strings.HasSuffix(host, ".local.example.com") {
+				return []string{"192.0.2.1", "192.0.2.2"}, nil
+			}
+			return []string{"198.51.100.1"}, nil
+		},
+	}
+	if err := hp.Init([]string{"remote.example.com:2181", "zk.local.example.com:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	if hp.Len() != 3 {
+		t.Fatalf("Expected 3 servers, got %d", hp.Len())
+	}
+
+	isLocal := func(server string) bool { return strings.HasPrefix(server, "192.0.2.") }
+	var tried []string
+	for i := 0; i < 3; i++ {
+		server, retryStart := hp.Next()
+		if retryStart {
+			t.Fatalf("retryStart before trying all servers: %v", tried)
+		}
+		tried = append(tried, server)
+	}
+	if !isLocal(tried[0]) || !isLocal(tried[1]) || tried[0] == tried[1] || tried[2] != "198.51.100.1:2181" {
+		t.Fatalf("Remote server not tried last: %v", tried)
+	}
+	if server, retryStart := hp.Next(); !retryStart || server != tried[0] {
+		t.Fatalf("Next returned %s, %v after trying all servers", server, retryStart)
+	}
+
+	// Connected to the remote server, the next reconnect tries the local
+	// servers first again.
+	hp.Next()
+	hp.Next()
+	hp.Connected()
+	if server, _ := hp.Next(); !isLocal(server) {
+		t.Fatalf("Reconnect after falling back started with %s", server)
+	}
+
+	// Connected to a local server, the next reconnect starts with the other.
+	hp.Connected()
+	first, _ := hp.Next()
+	hp.Connected()
+	if server, _ := hp.Next(); server == first || !isLocal(server) {
+		t.Fatalf("Reconnect from %s went to %s", first, server)
+	}
+}
+
+func TestLocalityHostProviderAllRemote(t *testing.T) {
+	t.Parallel()
+	hp := &LocalityHostProvider{
+		Local: func(string) bool { return false },
+		lookupHost: func(host string) ([]string, error) {
+			return []string{"198.51.100.1"}, nil
+		},
+	}
+	if err := hp.Init([]string{"a.example.com:2181", "b.example.com:2182"}); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		server, _ := hp.Next()
+		seen[server] = true
+	}
+	if !seen["198.51.100.1:2181"] || !seen["198.51.100.1:2182"] {
+		t.Fatalf("Not all remote servers tried: %v", seen)
+	}
+}
@@ -1,6 +1,7 @@
 package zk
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -21,6 +22,7 @@ type Lock struct {
 	acl      []ACL
 	lockPath string
 	seq      int
+	owner    OwnerInfo
 }
 
 // NewLock creates a new lock instance using the provided connection, path, and acl.
@@ -28,12 +30,47 @@ type Lock struct {
 // unlocked until Lock() is called.
 func NewLock(c *Conn, path string, acl []ACL) *Lock {
 	return &Lock{
-		c:    c,
-		path: path,
-		acl:  acl,
+		c:     c,
+		path:  path,
+		acl:   acl,
+		owner: NewOwnerInfo(nil),
 	}
 }
 
+// SetOwner sets the owner info written into the lock node when the lock is
+// acquired. It defaults to NewOwnerInfo(nil).
+func (l *Lock) SetOwner(owner OwnerInfo) {
+	l.owner = owner
+}
+
+// Holder returns the owner info of the current holder of the lock, or
+// ErrNotLocked if nobody holds it.
+func (l *Lock) Holder() (*OwnerInfo, error) {
+	children, _, err := l.c.Children(l.path)
+	if err == ErrNoNode {
+		return nil, ErrNotLocked
+	} else if err != nil {
+		return nil, err
+	}
+
+	lowestSeq := -1
+	lowestPath := ""
+	for _, p := range children {
+		s, err := parseSeq(p)
+		if err != nil {
+			continue
+		}
+		if lowestSeq == -1 || s < lowestSeq {
+			lowestSeq = s
+			lowestPath = p
+		}
+	}
+	if lowestPath == "" {
+		return nil, ErrNotLocked
+	}
+	return ReadOwnerInfo(l.c, l.path+"/"+lowestPath)
+}
+
 func parseSeq(path string) (int, error) {
 	parts := strings.Split(path, "-")
 	return strconv.Atoi(parts[len(parts)-1])
@@ -43,46 +80,66 @@ func parseSeq(path string) (int, error) {
 // is acquired or an error occurs. If this instance already has the lock
 // then ErrDeadlock is returned.
 func (l *Lock) Lock() error {
+	_, err := l.lock(context.Background(), false)
+	return err
+}
+
+// LockContext is like Lock but gives up waiting once ctx is done, in which
+// case the place of the instance in the queue is abandoned, its node deleted,
+// and the error of ctx returned.
+func (l *Lock) LockContext(ctx context.Context) error {
+	_, err := l.lock(ctx, false)
+	return err
+}
+
+// TryLock attempts to acquire the lock without waiting for it. It returns
+// false if the lock is held by someone else or others are queued before this
+// instance, in which case the node of the instance is deleted again.
+func (l *Lock) TryLock() (bool, error) {
+	return l.lock(context.Background(), true)
+}
+
+func (l *Lock) lock(ctx context.Context, try bool) (bool, error) {
 	if l.lockPath != "" {
-		return ErrDeadlock
+		return false, ErrDeadlock
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 
 	prefix := fmt.Sprintf("%s/lock-", l.path)
+	data, err := l.owner.Marshal()
+	if err != nil {
+		return false, err
+	}
 
 	path := ""
-	var err error
 	for i := 0; i < 3; i++ {
-		path, err = l.c.CreateProtectedEphemeralSequential(prefix, []byte{}, l.acl)
+		path, err = l.c.CreateProtectedEphemeralSequential(prefix, data, l.acl)
 		if err == ErrNoNode {
 			// Create parent node.
-			parts := strings.Split(l.path, "/")
-			pth := ""
-			for _, p := range parts[1:] {
-				pth += "/" + p
-				_, err := l.c.Create(pth, []byte{}, 0, l.acl)
-				if err != nil && err != ErrNodeExists {
-					return err
-				}
+			if err := l.c.CreateParents(prefix, l.acl); err != nil {
+				return false, err
 			}
 		} else if err == nil {
 			break
 		} else {
-			return err
+			return false, err
 		}
 	}
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	seq, err := parseSeq(path)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	for {
 		children, _, err := l.c.Children(l.path)
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		lowestSeq := seq
@@ -91,7 +148,7 @@ func (l *Lock) Lock() error {
 		for _, p := range children {
 			s, err := parseSeq(p)
 			if err != nil {
-				return err
+				return false, err
 			}
 			if s < lowestSeq {
 				lowestSeq = s
@@ -106,24 +163,43 @@ func (l *Lock) Lock() error {
 			// Acquired the lock
 			break
 		}
+		if try {
+			return false, l.abandon(path)
+		}
 
 		// Wait on the node next in line for the lock
 		_, _, ch, err := l.c.GetW(l.path + "/" + prevSeqPath)
 		if err != nil && err != ErrNoNode {
-			return err
+			return false, err
 		} else if err != nil && err == ErrNoNode {
 			// try again
 			continue
 		}
 
-		ev := <-ch
-		if ev.Err != nil {
-			return ev.Err
+		select {
+		case ev := <-ch:
+			if ev.Err != nil {
+				return false, ev.Err
+			}
+		case <-ctx.Done():
+			l.c.RemoveWatches(l.path+"/"+prevSeqPath, ch)
+			if err := l.abandon(path); err != nil {
+				return false, err
+			}
+			return false, ctx.Err()
 		}
 	}
 
 	l.seq = seq
 	l.lockPath = path
+	return true, nil
+}
+
+// abandon deletes the node queued for the lock at path.
+func (l *Lock) abandon(path string) error {
+	if err := l.c.DeleteGuaranteed(path, -1); err != nil && err != ErrNoNode && !isConnectionError(err) {
+		return err
+	}
 	return nil
 }
 
@@ -133,7 +209,9 @@ func (l *Lock) Unlock() error {
 	if l.lockPath == "" {
 		return ErrNotLocked
 	}
-	if err := l.c.Delete(l.lockPath, -1); err != nil {
+	// If the connection is lost the lock node is deleted in the background,
+	// so it does not block other contenders once the connection recovers.
+	if err := l.c.DeleteGuaranteed(l.lockPath, -1); err != nil && !isConnectionError(err) {
 		return err
 	}
 	l.lockPath = ""
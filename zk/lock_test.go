@@ -1,6 +1,7 @@
 package zk
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -92,3 +93,102 @@ func TestMultiLevelLock(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLockHolder(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	l := NewLock(zk, "/test-holder", WorldACL(PermAll))
+	if _, err := l.Holder(); err != ErrNotLocked {
+		t.Fatalf("Holder returned %+v instead of ErrNotLocked", err)
+	}
+
+	l.SetOwner(NewOwnerInfo(map[string]string{"job": "test"}))
+	if err := l.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+
+	owner, err := NewLock(zk, "/test-holder", WorldACL(PermAll)).Holder()
+	if err != nil {
+		t.Fatalf("Holder returned error: %+v", err)
+	}
+	if owner.Tags["job"] != "test" {
+		t.Fatalf("Holder returned %+v without the job tag", owner)
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	l := NewLock(zk, "/lock", WorldACL(PermAll))
+
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+
+	// Someone is queued first, the node is deleted again.
+	go func() {
+		ok, err := l.TryLock()
+		done <- result{ok, err}
+	}()
+	serveFake(t, fc, "create", "", nil, &createResponse{Path: "/lock/_c_a-lock-0000000002"})
+	serveFake(t, fc, "getChildren2", "/lock", nil, &getChildren2Response{Children: []string{"_c_b-lock-0000000001", "_c_a-lock-0000000002"}})
+	serveFake(t, fc, "delete", "/lock/_c_a-lock-0000000002", nil, nil)
+	if r := <-done; r.ok || r.err != nil {
+		t.Fatalf("TryLock returned %v, %+v", r.ok, r.err)
+	}
+
+	go func() {
+		ok, err := l.TryLock()
+		done <- result{ok, err}
+	}()
+	serveFake(t, fc, "create", "", nil, &createResponse{Path: "/lock/_c_c-lock-0000000003"})
+	serveFake(t, fc, "getChildren2", "/lock", nil, &getChildren2Response{Children: []string{"_c_c-lock-0000000003"}})
+	if r := <-done; !r.ok || r.err != nil {
+		t.Fatalf("TryLock returned %v, %+v", r.ok, r.err)
+	}
+	if ok, err := l.TryLock(); ok || err != ErrDeadlock {
+		t.Fatalf("TryLock of a held lock returned %v, %+v", ok, err)
+	}
+}
+
+func TestLockContext(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	l := NewLock(zk, "/lock", WorldACL(PermAll))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.LockContext(ctx) }()
+	serveFake(t, fc, "create", "", nil, &createResponse{Path: "/lock/_c_a-lock-0000000002"})
+	serveFake(t, fc, "getChildren2", "/lock", nil, &getChildren2Response{Children: []string{"_c_b-lock-0000000001", "_c_a-lock-0000000002"}})
+	serveFake(t, fc, "getData", "/lock/_c_b-lock-0000000001", nil, &getDataResponse{})
+
+	// Canceling leaves the queue.
+	cancel()
+	serveFake(t, fc, "removeWatches", "/lock/_c_b-lock-0000000001", nil, &removeWatchesResponse{})
+	serveFake(t, fc, "delete", "/lock/_c_a-lock-0000000002", nil, nil)
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("LockContext returned %+v", err)
+	}
+	if err := l.Unlock(); err != ErrNotLocked {
+		t.Fatalf("Unlock returned %+v", err)
+	}
+}
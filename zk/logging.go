@@ -0,0 +1,144 @@
+package zk
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel is the severity of a log message. Messages below the level set
+// for their component with SetLogLevel are not logged.
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota - 1
+	LogInfo           // the default level
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	}
+	return "Unknown"
+}
+
+// LogComponent is the part of the client a log message comes from.
+type LogComponent int
+
+const (
+	// LogConnection is for the connection attempts, sessions and
+	// authentication.
+	LogConnection LogComponent = iota
+	// LogRequests is for the requests and their replies.
+	LogRequests
+	// LogWatches is for the watches and their events.
+	LogWatches
+	// LogRecipes is for the locks, elections, caches and other recipes.
+	LogRecipes
+
+	numLogComponents
+)
+
+var logComponentNames = [numLogComponents]string{"connection", "requests", "watches", "recipes"}
+
+func (c LogComponent) String() string {
+	if c >= 0 && c < numLogComponents {
+		return logComponentNames[c]
+	}
+	return "Unknown"
+}
+
+// StructuredLogger is a Logger that also takes structured fields, as
+// alternating keys and values, e.g. to log with log/slog. The connection
+// passes the fields of its messages, such as the server or the xid of a
+// request, to Log instead of formatting them into the message; the other
+// messages still go to Printf.
+type StructuredLogger interface {
+	Logger
+	Log(msg string, keysAndValues ...interface{})
+}
+
+// LeveledLogger is a StructuredLogger that also takes the level and the
+// component of messages. The connection passes all its messages to LogAt,
+// formatted if they have no fields.
+type LeveledLogger interface {
+	StructuredLogger
+	LogAt(level LogLevel, component LogComponent, msg string, keysAndValues ...interface{})
+}
+
+// WithLogLevel returns a connection option that sets the level of messages
+// logged, as SetLogLevel does.
+func WithLogLevel(level LogLevel, components ...LogComponent) connOption {
+	return func(c *Conn) {
+		c.SetLogLevel(level, components...)
+	}
+}
+
+// SetLogLevel sets the level below which the messages of components are not
+// logged, or of all the components if none is given. The level is LogInfo
+// by default. It may be called at any time, e.g. to turn on the reconnect
+// diagnostics with SetLogLevel(LogDebug, LogConnection) while investigating
+// a problem, without the debug messages of the other components.
+func (c *Conn) SetLogLevel(level LogLevel, components ...LogComponent) {
+	if len(components) == 0 {
+		for comp := LogComponent(0); comp < numLogComponents; comp++ {
+			components = append(components, comp)
+		}
+	}
+	for _, comp := range components {
+		if comp >= 0 && comp < numLogComponents {
+			atomic.StoreInt32(&c.logLevels[comp], int32(level))
+		}
+	}
+}
+
+// logEnabled reports whether messages of level are logged for component.
+func (c *Conn) logEnabled(level LogLevel, component LogComponent) bool {
+	return int32(level) >= atomic.LoadInt32(&c.logLevels[component])
+}
+
+// logf logs a message formatted as with Printf.
+func (c *Conn) logf(level LogLevel, component LogComponent, format string, args ...interface{}) {
+	if !c.logEnabled(level, component) {
+		return
+	}
+	if l, ok := c.logger.(LeveledLogger); ok {
+		l.LogAt(level, component, fmt.Sprintf(format, args...))
+		return
+	}
+	c.logger.Printf(format, args...)
+}
+
+// logw logs msg with fields. Loggers that are not StructuredLoggers get the
+// fields formatted after msg as key=value.
+func (c *Conn) logw(level LogLevel, component LogComponent, msg string, keysAndValues ...interface{}) {
+	if !c.logEnabled(level, component) {
+		return
+	}
+	switch l := c.logger.(type) {
+	case LeveledLogger:
+		l.LogAt(level, component, msg, keysAndValues...)
+	case StructuredLogger:
+		l.Log(msg, keysAndValues...)
+	default:
+		c.logger.Printf("%s", formatFields(msg, keysAndValues))
+	}
+}
+
+func formatFields(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return b.String()
+}
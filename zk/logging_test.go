@@ -0,0 +1,71 @@
+package zk
+
+import "testing"
+
+type recordingStructuredLogger struct {
+	recordingLogger
+	msgs   []string
+	fields [][]interface{}
+}
+
+func (l *recordingStructuredLogger) Log(msg string, keysAndValues ...interface{}) {
+	l.msgs = append(l.msgs, msg)
+	l.fields = append(l.fields, keysAndValues)
+}
+
+func TestLogw(t *testing.T) {
+	t.Parallel()
+	l := &recordingLogger{}
+	c := &Conn{logger: l}
+	c.logw(LogWarn, LogRequests, "Response for unknown request", "xid", 3, "server", "zk1:2181")
+	if len(l.lines) != 1 || l.lines[0] != "Response for unknown request xid=3 server=zk1:2181" {
+		t.Fatalf("Logged %q", l.lines)
+	}
+
+	sl := &recordingStructuredLogger{}
+	c.logger = sl
+	c.logw(LogInfo, LogConnection, "Connected", "server", "zk1:2181")
+	if len(sl.lines) != 0 || len(sl.msgs) != 1 || sl.msgs[0] != "Connected" || len(sl.fields[0]) != 2 {
+		t.Fatalf("Logged %q with %v", sl.msgs, sl.fields)
+	}
+}
+
+type recordingLeveledLogger struct {
+	recordingStructuredLogger
+	levels []LogLevel
+}
+
+func (l *recordingLeveledLogger) LogAt(level LogLevel, component LogComponent, msg string, keysAndValues ...interface{}) {
+	l.levels = append(l.levels, level)
+	l.Log(component.String()+": "+msg, keysAndValues...)
+}
+
+func TestLogLevels(t *testing.T) {
+	t.Parallel()
+	l := &recordingLeveledLogger{}
+	c := &Conn{logger: l}
+	c.logf(LogDebug, LogConnection, "dropped")
+	c.logw(LogInfo, LogConnection, "Connected", "server", "zk1:2181")
+	c.SetLogLevel(LogDebug, LogConnection)
+	c.logf(LogDebug, LogConnection, "Connecting to %s", "zk2:2181")
+	c.logf(LogDebug, LogRequests, "dropped")
+	c.SetLogLevel(LogError)
+	c.logf(LogWarn, LogConnection, "dropped")
+	c.logf(LogError, LogRecipes, "Backup failed: %s", ErrNoNode)
+
+	expected := []string{"connection: Connected", "connection: Connecting to zk2:2181", "recipes: Backup failed: " + ErrNoNode.Error()}
+	if len(l.msgs) != len(expected) {
+		t.Fatalf("Logged %q, expected %q", l.msgs, expected)
+	}
+	for i, msg := range expected {
+		if l.msgs[i] != msg {
+			t.Errorf("Logged %q, expected %q", l.msgs[i], msg)
+		}
+	}
+	if len(l.levels) != 3 || l.levels[0] != LogInfo || l.levels[1] != LogDebug || l.levels[2] != LogError {
+		t.Fatalf("Logged at %v", l.levels)
+	}
+	if len(l.lines) != 0 {
+		t.Fatalf("Leveled logger got Printf calls %q", l.lines)
+	}
+}
@@ -0,0 +1,217 @@
+package zk
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics describes the requests and the sessions of a connection, e.g. to
+// export them to a monitoring system.
+type Metrics struct {
+	// Requests is the number of requests sent by operation name, e.g.
+	// "getData", pings excluded, and Errors the number of replies by error
+	// code, ErrNoNode included.
+	Requests map[string]uint64
+	Errors   map[ErrCode]uint64
+	// Reconnects is the number of connections made to a server after the
+	// first one, and SessionExpirations the number of sessions that expired.
+	Reconnects         uint64
+	SessionExpirations uint64
+	// Watches is the number of paths watched on the server, persistent
+	// watches included, and Outstanding the number of requests waiting for
+	// their reply.
+	Watches     int
+	Outstanding int
+	// Watchers is the number of watchers registered, by type: "data",
+	// "exist" and "child" for the one-time watches, "persistent" and
+	// "persistentRecursive" for the persistent ones. Watchers of the same
+	// path share the watch on the server.
+	Watchers map[string]int
+}
+
+// watchTypeNames are the names of the watch types in Metrics.Watchers and
+// the type label of MetricWatchers.
+var watchTypeNames = [numWatchTypes]string{
+	watchTypeData:                "data",
+	watchTypeExist:               "exist",
+	watchTypeChild:               "child",
+	watchTypePersistent:          "persistent",
+	watchTypePersistentRecursive: "persistentRecursive",
+}
+
+// The names of the metrics passed to a MetricsReceiver, with the labels they
+// have.
+const (
+	MetricRequests            = "requests"                // counter: op
+	MetricErrors              = "errors"                  // counter: op, code
+	MetricRequestLatency      = "request_latency_seconds" // histogram: op
+	MetricOutstandingRequests = "outstanding_requests"    // gauge
+	MetricWatchEvents         = "watch_events"            // counter: type
+	MetricWatchers            = "watchers"                // gauge: type
+	MetricReconnects          = "reconnects"              // counter
+	MetricSessionExpirations  = "session_expirations"     // counter
+)
+
+// MetricLabel is a label of a metric passed to a MetricsReceiver.
+type MetricLabel struct {
+	Name  string
+	Value string
+}
+
+// MetricsReceiver receives the metrics of a connection as they change, to
+// bridge them to a metrics library such as statsd, Prometheus or expvar. Its
+// methods are called from the loops sending and receiving the requests, so
+// they must be safe for concurrent use and must not block. The adapter to
+// the library belongs to the application, so that the zk package does not
+// depend on any of them.
+type MetricsReceiver interface {
+	// IncCounter adds delta to a counter.
+	IncCounter(name string, delta float64, labels ...MetricLabel)
+	// SetGauge sets a gauge to value.
+	SetGauge(name string, value float64, labels ...MetricLabel)
+	// ObserveHistogram adds value to a histogram.
+	ObserveHistogram(name string, value float64, labels ...MetricLabel)
+}
+
+// WithMetricsReceiver returns a connection option that passes the metrics of
+// the connection to r. The names of the metrics are the Metric constants.
+func WithMetricsReceiver(r MetricsReceiver) connOption {
+	return func(c *Conn) {
+		c.metrics.receiver = r
+	}
+}
+
+// connMetrics counts what Metrics reports that the connection does not
+// keep track of anyway, and passes the metrics to the receiver, if any.
+type connMetrics struct {
+	receiver MetricsReceiver
+
+	mu          sync.Mutex
+	connects    uint64
+	expirations uint64
+	requests    map[int32]uint64
+	errors      map[ErrCode]uint64
+}
+
+func (m *connMetrics) connect() {
+	m.mu.Lock()
+	m.connects++
+	reconnect := m.connects > 1
+	m.mu.Unlock()
+	if m.receiver != nil && reconnect {
+		m.receiver.IncCounter(MetricReconnects, 1)
+	}
+}
+
+func (m *connMetrics) expire() {
+	m.mu.Lock()
+	m.expirations++
+	m.mu.Unlock()
+	if m.receiver != nil {
+		m.receiver.IncCounter(MetricSessionExpirations, 1)
+	}
+}
+
+// sessions returns the number of reconnects and session expirations.
+func (m *connMetrics) sessions() (reconnects, expirations uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.connects > 0 {
+		reconnects = m.connects - 1
+	}
+	return reconnects, m.expirations
+}
+
+// request counts a request sent, which leaves outstanding requests waiting
+// for their reply.
+func (m *connMetrics) request(opcode int32, outstanding int) {
+	m.mu.Lock()
+	if m.requests == nil {
+		m.requests = make(map[int32]uint64)
+	}
+	m.requests[opcode]++
+	m.mu.Unlock()
+	if m.receiver != nil {
+		m.receiver.IncCounter(MetricRequests, 1, MetricLabel{"op", opNames[opcode]})
+		m.receiver.SetGauge(MetricOutstandingRequests, float64(outstanding))
+	}
+}
+
+// reply counts the reply to a request, received after latency.
+func (m *connMetrics) reply(opcode int32, code ErrCode, latency time.Duration, outstanding int) {
+	if code != 0 {
+		m.mu.Lock()
+		if m.errors == nil {
+			m.errors = make(map[ErrCode]uint64)
+		}
+		m.errors[code]++
+		m.mu.Unlock()
+	}
+	if m.receiver != nil {
+		op := MetricLabel{"op", opNames[opcode]}
+		if code != 0 {
+			m.receiver.IncCounter(MetricErrors, 1, op, MetricLabel{"code", strconv.Itoa(int(code))})
+		}
+		m.receiver.ObserveHistogram(MetricRequestLatency, latency.Seconds(), op)
+		m.receiver.SetGauge(MetricOutstandingRequests, float64(outstanding))
+	}
+}
+
+// flush reports that the requests waiting for their reply were failed.
+func (m *connMetrics) flush() {
+	if m.receiver != nil {
+		m.receiver.SetGauge(MetricOutstandingRequests, 0)
+	}
+}
+
+func (m *connMetrics) watchers(wt watchType, n int) {
+	if m.receiver != nil {
+		m.receiver.SetGauge(MetricWatchers, float64(n), MetricLabel{"type", watchTypeNames[wt]})
+	}
+}
+
+func (m *connMetrics) watchEvent(typ EventType) {
+	if m.receiver != nil {
+		m.receiver.IncCounter(MetricWatchEvents, 1, MetricLabel{"type", typ.String()})
+	}
+}
+
+// Metrics returns the metrics of the connection.
+func (c *Conn) Metrics() Metrics {
+	m := Metrics{
+		Requests: make(map[string]uint64),
+		Errors:   make(map[ErrCode]uint64),
+	}
+	m.Reconnects, m.SessionExpirations = c.metrics.sessions()
+	c.metrics.mu.Lock()
+	for opcode, n := range c.metrics.requests {
+		m.Requests[opNames[opcode]] += n
+	}
+	for code, n := range c.metrics.errors {
+		m.Errors[code] = n
+	}
+	c.metrics.mu.Unlock()
+
+	c.watchersLock.Lock()
+	m.Watches = len(c.watchers) + len(c.persistentWatchers)
+	m.Watchers = make(map[string]int, numWatchTypes)
+	for wt, n := range c.watcherCounts {
+		m.Watchers[watchTypeNames[wt]] = n
+	}
+	c.watchersLock.Unlock()
+	c.requestsLock.Lock()
+	m.Outstanding = len(c.requests)
+	c.requestsLock.Unlock()
+	return m
+}
+
+// countWatchers adds delta to the number of watchers of type wt. The caller
+// must hold watchersLock.
+func (c *Conn) countWatchers(wt watchType, delta int) {
+	if delta == 0 {
+		return
+	}
+	c.watcherCounts[wt] += delta
+	c.metrics.watchers(wt, c.watcherCounts[wt])
+}
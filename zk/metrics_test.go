@@ -0,0 +1,160 @@
+package zk
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+
+	done := make(chan struct{})
+	go func() {
+		zk.GetW("/a")
+		zk.Get("/b")
+		close(done)
+	}()
+	serveFake(t, fc, "getData", "", nil, &getDataResponse{Data: []byte("a")})
+	serveFake(t, fc, "getData", "", ErrNoNode, nil)
+	<-done
+
+	m := zk.Metrics()
+	if m.Requests["getData"] != 2 || m.Errors[errNoNode] != 1 {
+		t.Fatalf("Requests %v, errors %v", m.Requests, m.Errors)
+	}
+	if m.Watches != 1 || m.Watchers["data"] != 1 || m.Watchers["child"] != 0 || m.Outstanding != 0 || m.Reconnects != 0 {
+		t.Fatalf("Metrics %+v", m)
+	}
+
+	// The session expires on reconnecting, and the client starts a new one.
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ExpireSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, ch, StateExpired)
+	acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	m = zk.Metrics()
+	if m.Reconnects != 2 || m.SessionExpirations != 1 || m.Watches != 0 || m.Watchers["data"] != 0 {
+		t.Fatalf("Metrics %+v", m)
+	}
+}
+
+type recordingReceiver struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+	observed map[string]int
+}
+
+// metricKey joins name and the values of labels.
+func metricKey(name string, labels []MetricLabel) string {
+	for _, l := range labels {
+		name += "," + l.Name + "=" + l.Value
+	}
+	return name
+}
+
+func (r *recordingReceiver) IncCounter(name string, delta float64, labels ...MetricLabel) {
+	r.mu.Lock()
+	r.counters[metricKey(name, labels)] += delta
+	r.mu.Unlock()
+}
+
+func (r *recordingReceiver) SetGauge(name string, value float64, labels ...MetricLabel) {
+	r.mu.Lock()
+	r.gauges[metricKey(name, labels)] = value
+	r.mu.Unlock()
+}
+
+func (r *recordingReceiver) ObserveHistogram(name string, value float64, labels ...MetricLabel) {
+	r.mu.Lock()
+	r.observed[metricKey(name, labels)]++
+	r.mu.Unlock()
+}
+
+func TestMetricsReceiver(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	r := &recordingReceiver{counters: make(map[string]float64), gauges: make(map[string]float64), observed: make(map[string]int)}
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithMetricsReceiver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	done := make(chan struct{})
+	go func() {
+		zk.ExistsW("/a")
+		close(done)
+	}()
+	req, err := fc.ExpectRequest("exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	if n := r.gauges[MetricOutstandingRequests]; n != 1 {
+		t.Errorf("%v outstanding requests, expected 1", n)
+	}
+	r.mu.Unlock()
+	if err := fc.Reply(req, 1, ErrNoNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	r.mu.Lock()
+	if n := r.gauges[MetricWatchers+",type=exist"]; n != 1 {
+		t.Errorf("%v exist watchers, expected 1", n)
+	}
+	r.mu.Unlock()
+	if err := fc.SendEvent(2, EventNodeCreated, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent := time.Now().Add(fakeTimeout)
+	for {
+		r.mu.Lock()
+		n := r.counters[MetricWatchEvents+",type=EventNodeCreated"]
+		r.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(waitForEvent) {
+			t.Fatal("Watch event not counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := r.counters[MetricRequests+",op=exists"]; n != 1 {
+		t.Fatalf("%v exists requests counted, expected 1", n)
+	}
+	if n := r.counters[MetricErrors+",op=exists,code=-101"]; n != 1 {
+		t.Fatalf("%v exists errors counted, expected 1", n)
+	}
+	if n := r.observed[MetricRequestLatency+",op=exists"]; n != 1 {
+		t.Fatalf("%d exists latencies observed, expected 1", n)
+	}
+	if n := r.gauges[MetricOutstandingRequests]; n != 0 {
+		t.Fatalf("%v outstanding requests, expected 0", n)
+	}
+	if n := r.gauges[MetricWatchers+",type=exist"]; n != 0 {
+		t.Fatalf("%v exist watchers once fired, expected 0", n)
+	}
+}
@@ -0,0 +1,243 @@
+package zk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NamespaceSpec declares the tenants of a shared ensemble. Each tenant gets
+// a node below Root, to be used as the chroot of its applications, with its
+// own ACL and quota.
+type NamespaceSpec struct {
+	// Root is the parent of the tenant nodes.
+	Root string `json:"root"`
+	// ACL is the ACL of Root if it has to be created. It defaults to
+	// WorldACL(PermAll).
+	ACL     []ACL        `json:"acl,omitempty"`
+	Tenants []TenantSpec `json:"tenants"`
+}
+
+// TenantSpec declares one tenant of a NamespaceSpec.
+type TenantSpec struct {
+	// Name is the name of the tenant node below the root.
+	Name string `json:"name"`
+	// ACL is the ACL of the tenant node. It defaults to
+	// WorldACL(PermAll). ACLs of the auth scheme are expanded by the server
+	// and would be reset on every pass, so use digest ones instead.
+	ACL []ACL `json:"acl,omitempty"`
+	// Quota is the quota of the tenant subtree, or nil for none.
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Validate checks that the spec can be reconciled.
+func (s *NamespaceSpec) Validate() error {
+	if err := validatePath(s.Root, false); err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(s.Tenants))
+	for _, t := range s.Tenants {
+		if t.Name == "" || strings.Contains(t.Name, "/") || t.Name == "." || t.Name == ".." {
+			return fmt.Errorf("zk: invalid tenant name %q", t.Name)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("zk: duplicate tenant %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return nil
+}
+
+// TenantPath returns the path of the node of the tenant with the given name,
+// to be used as its chroot.
+func (s *NamespaceSpec) TenantPath(name string) string {
+	if s.Root == "/" {
+		return "/" + name
+	}
+	return s.Root + "/" + name
+}
+
+// DriftKind is a way in which an ensemble differs from a NamespaceSpec.
+type DriftKind int
+
+const (
+	// DriftMissing is a tenant node that did not exist and was created.
+	DriftMissing DriftKind = iota
+	// DriftACL is a tenant node whose ACL was reset.
+	DriftACL
+	// DriftQuota is a tenant subtree whose quota was set or removed.
+	DriftQuota
+	// DriftUnmanaged is a node below the root that is not a tenant of the
+	// spec. It is reported but left alone.
+	DriftUnmanaged
+)
+
+var driftKindNames = map[DriftKind]string{
+	DriftMissing:   "missing",
+	DriftACL:       "acl",
+	DriftQuota:     "quota",
+	DriftUnmanaged: "unmanaged",
+}
+
+func (k DriftKind) String() string {
+	if name, ok := driftKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// NamespaceDrift is a difference between the ensemble and the spec found by
+// NamespaceManager.Reconcile.
+type NamespaceDrift struct {
+	Tenant string
+	Path   string
+	Kind   DriftKind
+}
+
+// NamespaceManager provisions the tenants of a NamespaceSpec on an ensemble
+// and keeps their ACLs and quotas as declared. It never deletes tenants,
+// including the ones removed from the spec. As quotas live outside of any
+// chroot, the connection must not have one.
+type NamespaceManager struct {
+	conn *Conn
+	spec NamespaceSpec
+}
+
+// NewNamespaceManager creates a manager of the tenants of spec on c. It does
+// nothing until Reconcile or Run is called.
+func NewNamespaceManager(c *Conn, spec NamespaceSpec) (*NamespaceManager, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &NamespaceManager{conn: c, spec: spec}, nil
+}
+
+// Reconcile makes the ensemble match the spec once and returns the drift it
+// found and corrected. On error it returns the drift corrected so far.
+func (m *NamespaceManager) Reconcile() ([]NamespaceDrift, error) {
+	if m.conn.chroot != "" {
+		return nil, ErrQuotaChroot
+	}
+	var drift []NamespaceDrift
+
+	rootACL := m.spec.ACL
+	if len(rootACL) == 0 {
+		rootACL = WorldACL(PermAll)
+	}
+	if err := m.createAll(m.spec.Root, rootACL); err != nil {
+		return drift, err
+	}
+
+	tenants := make(map[string]bool, len(m.spec.Tenants))
+	for _, t := range m.spec.Tenants {
+		tenants[t.Name] = true
+		p := m.spec.TenantPath(t.Name)
+		acl := t.ACL
+		if len(acl) == 0 {
+			acl = WorldACL(PermAll)
+		}
+
+		_, err := m.conn.Create(p, nil, 0, acl)
+		switch err {
+		case nil:
+			drift = append(drift, NamespaceDrift{t.Name, p, DriftMissing})
+		case ErrNodeExists:
+			current, _, err := m.conn.GetACL(p)
+			if err != nil {
+				return drift, err
+			}
+			if !sameACL(current, acl) {
+				if _, err := m.conn.SetACL(p, acl, -1); err != nil {
+					return drift, err
+				}
+				drift = append(drift, NamespaceDrift{t.Name, p, DriftACL})
+			}
+		default:
+			return drift, err
+		}
+
+		limit, _, err := m.conn.GetQuota(p)
+		switch {
+		case err == ErrNoNode && t.Quota != nil:
+			err = m.conn.SetQuota(p, *t.Quota)
+		case err == nil && t.Quota == nil:
+			err = m.conn.DeleteQuota(p)
+		case err == nil && limit != *t.Quota:
+			err = m.conn.SetQuota(p, *t.Quota)
+		case err == ErrNoNode || err == nil:
+			continue
+		default:
+			return drift, err
+		}
+		if err != nil {
+			return drift, err
+		}
+		drift = append(drift, NamespaceDrift{t.Name, p, DriftQuota})
+	}
+
+	children, _, err := m.conn.Children(m.spec.Root)
+	if err != nil {
+		return drift, err
+	}
+	sort.Strings(children)
+	for _, name := range children {
+		if !tenants[name] && m.spec.TenantPath(name) != "/zookeeper" {
+			drift = append(drift, NamespaceDrift{name, m.spec.TenantPath(name), DriftUnmanaged})
+		}
+	}
+	return drift, nil
+}
+
+// Run reconciles right away and then every interval until ctx is done. The
+// drift and errors of each pass are logged with the logger of the
+// connection.
+func (m *NamespaceManager) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		drift, err := m.Reconcile()
+		for _, d := range drift {
+			if d.Kind != DriftUnmanaged {
+				m.conn.logf(LogInfo, LogRecipes, "Corrected %s drift of tenant %s at %s", d.Kind, d.Tenant, d.Path)
+			}
+		}
+		if err != nil {
+			m.conn.logf(LogWarn, LogRecipes, "Failed to reconcile namespaces below %s: %s", m.spec.Root, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// createAll creates the node at p with acl, and its missing parents with the
+// same ACL, unless it exists.
+func (m *NamespaceManager) createAll(p string, acl []ACL) error {
+	if p == "/" {
+		return nil
+	}
+	if err := m.conn.CreateParents(p, acl); err != nil {
+		return err
+	}
+	if _, err := m.conn.Create(p, nil, 0, acl); err != nil && err != ErrNodeExists {
+		return err
+	}
+	return nil
+}
+
+// sameACL reports whether a and b hold the same entries in any order.
+func sameACL(a, b []ACL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, acl := range a {
+		if !containsACL(b, acl) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceSpecValidate(t *testing.T) {
+	t.Parallel()
+	ok := NamespaceSpec{Root: "/tenants", Tenants: []TenantSpec{{Name: "a"}, {Name: "b"}}}
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("Validate returned error: %+v", err)
+	}
+	if p := ok.TenantPath("a"); p != "/tenants/a" {
+		t.Fatalf("TenantPath returned %q", p)
+	}
+	for _, spec := range []NamespaceSpec{
+		{Root: "tenants"},
+		{Root: "/tenants", Tenants: []TenantSpec{{Name: ""}}},
+		{Root: "/tenants", Tenants: []TenantSpec{{Name: "a/b"}}},
+		{Root: "/tenants", Tenants: []TenantSpec{{Name: "a"}, {Name: "a"}}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", spec)
+		}
+	}
+}
+
+func TestNamespaceManager(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	readOnly := WorldACL(PermRead)
+	spec := NamespaceSpec{Root: "/gozk-test-tenants", Tenants: []TenantSpec{
+		{Name: "a", Quota: &Quota{Count: 100, Bytes: -1}},
+		{Name: "b", ACL: readOnly},
+	}}
+	m, err := NewNamespaceManager(zk, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drift, err := m.Reconcile()
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %+v", err)
+	}
+	expected := []NamespaceDrift{
+		{"a", "/gozk-test-tenants/a", DriftMissing},
+		{"a", "/gozk-test-tenants/a", DriftQuota},
+		{"b", "/gozk-test-tenants/b", DriftMissing},
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Fatalf("Reconcile returned %+v", drift)
+	}
+	if limit, usage, err := zk.GetQuota("/gozk-test-tenants/a"); err != nil || limit != *spec.Tenants[0].Quota || usage.Count != 1 {
+		t.Fatalf("GetQuota returned %+v, %+v, %+v", limit, usage, err)
+	}
+
+	// Drift is corrected, unmanaged nodes are only reported.
+	if _, err := zk.SetACL("/gozk-test-tenants/b", WorldACL(PermAll), -1); err != nil {
+		t.Fatalf("SetACL returned error: %+v", err)
+	}
+	if err := zk.DeleteQuota("/gozk-test-tenants/a"); err != nil {
+		t.Fatalf("DeleteQuota returned error: %+v", err)
+	}
+	if _, err := zk.Create("/gozk-test-tenants/c", nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	drift, err = m.Reconcile()
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %+v", err)
+	}
+	expected = []NamespaceDrift{
+		{"a", "/gozk-test-tenants/a", DriftQuota},
+		{"b", "/gozk-test-tenants/b", DriftACL},
+		{"c", "/gozk-test-tenants/c", DriftUnmanaged},
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Fatalf("Reconcile returned %+v", drift)
+	}
+	if acl, _, err := zk.GetACL("/gozk-test-tenants/b"); err != nil || !reflect.DeepEqual(acl, readOnly) {
+		t.Fatalf("GetACL returned %+v, %+v", acl, err)
+	}
+	if drift, err := m.Reconcile(); err != nil || len(drift) != 1 {
+		t.Fatalf("Reconcile of a matching ensemble returned %+v, %+v", drift, err)
+	}
+}
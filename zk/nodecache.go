@@ -0,0 +1,177 @@
+package zk
+
+import (
+	"context"
+	"sync"
+)
+
+// nodeChanged reports whether node is another version of the node than old.
+// Either may be nil for a node that does not exist.
+func nodeChanged(old, node *NodeData) bool {
+	if node == nil || old == nil {
+		return node != old
+	}
+	return node.Stat.Czxid != old.Stat.Czxid || node.Stat.Mzxid != old.Stat.Mzxid
+}
+
+func copyNodeData(node *NodeData) *NodeData {
+	stat := *node.Stat
+	return &NodeData{Data: append([]byte(nil), node.Data...), Stat: &stat}
+}
+
+// NodeCache keeps the data and stat of a node in memory, like Curator's
+// NodeCache. It is kept up to date by a watch, which is set again after every
+// change and after the session expired, and the node is read again after the
+// connection was lost, so the copy is never left stale.
+type NodeCache struct {
+	c    *Conn
+	path string
+
+	mu        sync.Mutex // protects node, listeners, nextID and err
+	node      *NodeData  // nil while the node does not exist
+	listeners map[int]func(node *NodeData)
+	nextID    int
+	notifyMu  sync.Mutex // runs the listeners one at a time
+
+	ready     chan struct{} // closed once the node was read, or failed to be
+	readyOnce sync.Once
+	err       error // why the node was not read
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewNodeCache creates a new cache of the node at path using the provided
+// connection.
+func NewNodeCache(c *Conn, path string) *NodeCache {
+	n := &NodeCache{
+		c:         c,
+		path:      path,
+		listeners: make(map[int]func(*NodeData)),
+		ready:     make(chan struct{}),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	return n
+}
+
+// Start starts watching the node. It returns once the node was read, or with
+// the error that prevented it, e.g. ErrNoAuth.
+func (n *NodeCache) Start() error {
+	if _, err := n.c.processPath(n.path, false); err != nil {
+		return err
+	}
+	go n.run(n.c.Subscribe())
+	<-n.ready
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// Close stops watching the node. The copy is not updated anymore.
+func (n *NodeCache) Close() {
+	n.cancel()
+	n.setReady(ErrClosing)
+}
+
+// Current returns the cached data and stat of the node, or nil if it does not
+// exist.
+func (n *NodeCache) Current() *NodeData {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.node == nil {
+		return nil
+	}
+	return copyNodeData(n.node)
+}
+
+// AddListener calls fn with the new data and stat of the node whenever it
+// changed, or with nil once it was deleted. The listeners run one at a time,
+// in the order of the changes. The returned function removes the listener.
+func (n *NodeCache) AddListener(fn func(node *NodeData)) (remove func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	id := n.nextID
+	n.nextID++
+	n.listeners[id] = fn
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.listeners, id)
+	}
+}
+
+// setReady ends waiting for the node to be read, with err if it was not.
+func (n *NodeCache) setReady(err error) {
+	n.readyOnce.Do(func() {
+		n.mu.Lock()
+		n.err = err
+		n.mu.Unlock()
+		close(n.ready)
+	})
+}
+
+func (n *NodeCache) run(session <-chan Event) {
+	defer n.c.Unsubscribe(session)
+	disconnected := false
+	for {
+		data, stat, ch, err := n.c.armDataWatch(n.ctx, n.path)
+		if err != nil {
+			n.setReady(err)
+			return
+		}
+		var node *NodeData
+		if stat != nil {
+			node = &NodeData{Data: data, Stat: stat}
+		}
+		n.update(node)
+		n.setReady(nil)
+
+	wait:
+		for {
+			select {
+			case <-ch:
+				// Changed, or lost with the session.
+				break wait
+			case ev, ok := <-session:
+				if !ok {
+					session = nil
+					continue
+				}
+				if ev.State == StateDisconnected {
+					disconnected = true
+				} else if ev.State == StateHasSession && disconnected {
+					// Changes may have been missed by the watch, read the node
+					// again.
+					disconnected = false
+					n.c.RemoveWatches(n.path, ch)
+					break wait
+				}
+			case <-n.ctx.Done():
+				n.c.RemoveWatches(n.path, ch)
+				return
+			}
+		}
+	}
+}
+
+// update replaces the cached copy and calls the listeners if it changed.
+func (n *NodeCache) update(node *NodeData) {
+	n.notifyMu.Lock()
+	defer n.notifyMu.Unlock()
+	n.mu.Lock()
+	if !nodeChanged(n.node, node) {
+		n.mu.Unlock()
+		return
+	}
+	n.node = node
+	listeners := make([]func(*NodeData), 0, len(n.listeners))
+	for _, fn := range n.listeners {
+		listeners = append(listeners, fn)
+	}
+	n.mu.Unlock()
+	if n.ctx.Err() != nil {
+		return
+	}
+	for _, fn := range listeners {
+		fn(n.Current())
+	}
+}
@@ -0,0 +1,83 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodeCache(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+	n := NewNodeCache(zk, "/node")
+	defer n.Close()
+
+	changes := make(chan *NodeData, 4)
+	expectChange := func(data string, deleted bool) {
+		t.Helper()
+		select {
+		case node := <-changes:
+			if deleted && node != nil || !deleted && (node == nil || string(node.Data) != data) {
+				t.Fatalf("Listener called with %+v, expected %q", node, data)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatal("Listener not called")
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- n.Start() }()
+	serveFake(t, fc, "getData", "/node", nil, &getDataResponse{Data: []byte("a"), Stat: Stat{Czxid: 1, Mzxid: 1}})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if node := n.Current(); node == nil || string(node.Data) != "a" {
+		t.Fatalf("Current returned %+v", node)
+	}
+	n.AddListener(func(node *NodeData) { changes <- node })
+
+	// Changes and deletions are seen through the watch.
+	if err := fc.SendEvent(2, EventNodeDataChanged, "/node"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/node", nil, &getDataResponse{Data: []byte("b"), Stat: Stat{Czxid: 1, Mzxid: 2}})
+	expectChange("b", false)
+	if err := fc.SendEvent(3, EventNodeDeleted, "/node"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/node", ErrNoNode, nil)
+	serveFake(t, fc, "exists", "/node", ErrNoNode, nil)
+	expectChange("", true)
+	if node := n.Current(); node != nil {
+		t.Fatalf("Current returned %+v for a deleted node", node)
+	}
+
+	// The node is read again after a reconnect, as it may have been created
+	// while the watch was not registered.
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	fc = acceptFake(t, s, 1)
+	for read := false; !read; {
+		req, err := fc.NextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch req.Op {
+		case "setWatches":
+			err = fc.Reply(req, 3, nil, nil)
+		case "removeWatches":
+			err = fc.Reply(req, 3, nil, &removeWatchesResponse{})
+		case "getData":
+			err = fc.Reply(req, 4, nil, &getDataResponse{Data: []byte("c"), Stat: Stat{Czxid: 4, Mzxid: 4}})
+			read = true
+		default:
+			t.Fatalf("Unexpected %s request", req.Op)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectChange("c", false)
+}
@@ -0,0 +1,62 @@
+package zk
+
+// Operation describes a request passed to an OperationTracer.
+type Operation struct {
+	Op     string // the name of the operation, e.g. "getData"
+	Opcode int32
+	Xid    int32
+	// Path is the path the request refers to, or that of the first
+	// operation of a multi request, and empty for requests without one.
+	Path string
+	// Server is the server the connection was to when the request was
+	// queued.
+	Server string
+}
+
+// OperationResult is the outcome of an Operation.
+type OperationResult struct {
+	Zxid int64
+	Err  error
+	// Code is the ZooKeeper error code of Err, or 0 if Err is nil or has
+	// none, e.g. ErrConnectionClosed.
+	Code ErrCode
+}
+
+// OperationTracer is notified of the requests and the session events of a
+// connection, e.g. to create spans with a tracing library such as
+// OpenTelemetry. Its methods are called from the goroutines making requests
+// and from the connection loop, so they must be safe for concurrent use and
+// must not block. As for a MetricsReceiver, the adapter to the library
+// belongs to the application.
+type OperationTracer interface {
+	// StartOperation is called before a request is queued, and returns a
+	// function called with its result.
+	StartOperation(op Operation) func(OperationResult)
+	// SessionEvent is called on every session event, from the connection
+	// attempts to the expiry of the session.
+	SessionEvent(ev Event)
+}
+
+// WithOperationTracer returns a connection option that passes the requests
+// and the session events of the connection to t. Pings and the request
+// closing the session are not traced.
+func WithOperationTracer(t OperationTracer) connOption {
+	return func(c *Conn) {
+		c.opTracer = t
+	}
+}
+
+// traceOperation sends a request as request does, between the calls to
+// the OperationTracer.
+func (c *Conn) traceOperation(opcode int32, req interface{}, res interface{}, recvFunc func(*request, *responseHeader, error)) response {
+	rq := c.newRequest(opcode, req, res, recvFunc)
+	op := Operation{Op: opNames[opcode], Opcode: opcode, Xid: rq.xid, Server: c.Server()}
+	if paths := requestPaths(req); len(paths) > 0 {
+		op.Path = c.clientPath(paths[0])
+	}
+	end := c.opTracer.StartOperation(op)
+	c.sendChan <- rq
+	r := c.wait(rq.recvChan)
+	end(OperationResult{Zxid: r.zxid, Err: r.err, Code: errorCode(r.err)})
+	return r
+}
@@ -0,0 +1,74 @@
+package zk
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingTracer struct {
+	mu      sync.Mutex
+	ops     []Operation
+	results []OperationResult
+	states  []State
+}
+
+func (t *recordingTracer) StartOperation(op Operation) func(OperationResult) {
+	t.mu.Lock()
+	t.ops = append(t.ops, op)
+	t.mu.Unlock()
+	return func(res OperationResult) {
+		t.mu.Lock()
+		t.results = append(t.results, res)
+		t.mu.Unlock()
+	}
+}
+
+func (t *recordingTracer) SessionEvent(ev Event) {
+	t.mu.Lock()
+	t.states = append(t.states, ev.State)
+	t.mu.Unlock()
+}
+
+func TestOperationTracer(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	tracer := &recordingTracer{}
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithOperationTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := zk.Get("/a")
+		done <- err
+	}()
+	req, err := fc.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, ErrNoNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrNoNode {
+		t.Fatalf("Get returned %v", err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.states) == 0 || tracer.states[len(tracer.states)-1] != StateHasSession {
+		t.Fatalf("Session events %v", tracer.states)
+	}
+	want := Operation{Op: "getData", Opcode: opGetData, Xid: req.Xid, Path: "/a", Server: "127.0.0.1:2181"}
+	if len(tracer.ops) != 1 || tracer.ops[0] != want {
+		t.Fatalf("Operations %+v, expected %+v", tracer.ops, want)
+	}
+	if len(tracer.results) != 1 || tracer.results[0].Err != ErrNoNode || tracer.results[0].Code != errNoNode {
+		t.Fatalf("Results %+v", tracer.results)
+	}
+}
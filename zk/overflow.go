@@ -0,0 +1,166 @@
+package zk
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// OverflowPolicy tells what happens to an event that does not fit in a full
+// event buffer.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNew drops the event. It is the default, and what the
+	// channel returned by Connect always did.
+	OverflowDropNew OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest event of the buffer to make room
+	// for the new one.
+	OverflowDropOldest
+	// OverflowBlock waits for room in the buffer. This blocks the receive
+	// loop, and with it every request of the connection, until the consumer
+	// catches up. Once the connection is closing nothing waits anymore.
+	OverflowBlock
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropNew:
+		return "drop-new"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowBlock:
+		return "block"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// EventOverflowError is the Err of an EventWatcherOverflow event, which takes
+// the place of the events a buffer dropped.
+type EventOverflowError struct {
+	Dropped uint64
+}
+
+func (e *EventOverflowError) Error() string {
+	return fmt.Sprintf("zk: %d events dropped", e.Dropped)
+}
+
+// WithEventBuffer returns a connection option that sets the size of the
+// channel returned by Connect and of the channels returned by Subscribe, and
+// what happens to events when they are full. The channel returned by Connect
+// holds 6 events and drops new ones by default, the channels returned by
+// Subscribe are not bounded.
+func WithEventBuffer(size int, policy OverflowPolicy) connOption {
+	return func(c *Conn) {
+		c.eventBuffer = size
+		c.eventPolicy = policy
+	}
+}
+
+// WithWatchBuffer returns a connection option that bounds the events queued
+// for each persistent watch, as added with AddWatch, AddWatchFor,
+// AddEmulatedWatch or OpenWatchStream, and sets what happens to events once
+// size are queued. By default they are not bounded. Watch streams report
+// dropped events as a gap. The one-time watches of GetW, ChildrenW and
+// ExistsW receive a single event and never overflow.
+func WithWatchBuffer(size int, policy OverflowPolicy) connOption {
+	return func(c *Conn) {
+		c.watchBuffer = size
+		c.watchPolicy = policy
+	}
+}
+
+// DroppedEvents returns how many events were dropped because a buffer was
+// full, over all the event channels of the connection.
+func (c *Conn) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&c.droppedEvents)
+}
+
+// overflowEvent returns the event taking the place of dropped events.
+func (c *Conn) overflowEvent(dropped uint64) Event {
+	return Event{Type: EventWatcherOverflow, State: c.State(), Server: c.Server(), Err: &EventOverflowError{Dropped: dropped}}
+}
+
+// eventChanCap returns the capacity of the channel returned by Connect.
+func (c *Conn) eventChanCap() int {
+	switch {
+	case c.eventBuffer <= 0:
+		return eventChanSize
+	case c.eventPolicy == OverflowDropOldest && c.eventBuffer < 2:
+		// Room for the overflow event and the event that follows it.
+		return 2
+	}
+	return c.eventBuffer
+}
+
+// sendEvent sends ev on the channel returned by Connect, applying its
+// overflow policy. Dropped events are reported by an EventWatcherOverflow
+// event as soon as there is room for it.
+func (c *Conn) sendEvent(ev Event) {
+	c.eventLock.Lock()
+	defer c.eventLock.Unlock()
+
+	if c.eventPolicy == OverflowBlock {
+		select {
+		case c.eventChan <- ev:
+			return
+		case <-c.shouldQuit:
+		}
+	} else if c.eventPolicy == OverflowDropOldest {
+		need := 1
+		if c.eventPending > 0 {
+			need = 2
+		}
+		for len(c.eventChan)+need > cap(c.eventChan) && c.dropOldestEvent() {
+			need = 2
+		}
+	}
+
+	if c.eventPending > 0 {
+		select {
+		case c.eventChan <- c.overflowEvent(c.eventPending):
+			c.eventPending = 0
+		default:
+			c.dropEvent()
+			return
+		}
+	}
+	select {
+	case c.eventChan <- ev:
+	default:
+		c.dropEvent()
+	}
+}
+
+// dropOldestEvent drops the oldest event of the channel returned by Connect
+// and reports whether there was one. The caller must hold eventLock.
+func (c *Conn) dropOldestEvent() bool {
+	select {
+	case old := <-c.eventChan:
+		if err, ok := old.Err.(*EventOverflowError); ok && old.Type == EventWatcherOverflow {
+			// Counted already, it is reported again by the next one.
+			c.eventPending += err.Dropped
+		} else {
+			c.dropEvent()
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// dropEvent counts an event dropped from the channel returned by Connect.
+// The caller must hold eventLock.
+func (c *Conn) dropEvent() {
+	c.eventPending++
+	atomic.AddUint64(&c.droppedEvents, 1)
+}
+
+// limitWatcher bounds the queue of w to limit events with policy. It must be
+// called before w receives events.
+func (c *Conn) limitWatcher(w *persistentWatcher, limit int, policy OverflowPolicy) *persistentWatcher {
+	w.limit = limit
+	w.policy = policy
+	w.quit = c.shouldQuit
+	w.dropped = &c.droppedEvents
+	return w
+}
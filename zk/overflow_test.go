@@ -0,0 +1,167 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPersistentWatcherOverflow(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		policy OverflowPolicy
+		want   []string // paths received, "!n" for n events dropped
+	}{
+		{OverflowDropNew, []string{"1", "2", "3", "4", "!2"}},
+		{OverflowDropOldest, []string{"1", "!2", "4", "5", "6"}},
+	} {
+		c := &Conn{shouldQuit: make(chan struct{})}
+		w := c.limitWatcher(newPersistentWatcher(nil), 3, tc.policy)
+		w.deliver(Event{Type: EventNodeDataChanged, Path: "1"}, -1)
+		// Wait for the first event to leave the queue.
+		for {
+			w.mu.Lock()
+			n := len(w.queue)
+			w.mu.Unlock()
+			if n == 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		for _, p := range []string{"2", "3", "4", "5", "6"} {
+			w.deliver(Event{Type: EventNodeDataChanged, Path: p}, -1)
+		}
+		w.close()
+
+		var got []string
+		for ev := range w.ch {
+			if ev.Type == EventWatcherOverflow {
+				got = append(got, "!"+string(rune('0'+ev.Err.(*EventOverflowError).Dropped)))
+			} else {
+				got = append(got, ev.Path)
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: received %v, expected %v", tc.policy, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s: received %v, expected %v", tc.policy, got, tc.want)
+			}
+		}
+		if n := c.DroppedEvents(); n != 2 {
+			t.Fatalf("%s: %d events dropped, expected 2", tc.policy, n)
+		}
+	}
+}
+
+func TestPersistentWatcherOverflowBlock(t *testing.T) {
+	t.Parallel()
+	c := &Conn{shouldQuit: make(chan struct{})}
+	w := c.limitWatcher(newPersistentWatcher(nil), 1, OverflowBlock)
+	w.deliver(Event{Path: "1"}, -1)
+	w.deliver(Event{Path: "2"}, -1)
+
+	delivered := make(chan struct{})
+	go func() {
+		w.deliver(Event{Path: "3"}, -1)
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+		t.Fatal("Delivering to a full watcher did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if ev := <-w.ch; ev.Path != "1" {
+		t.Fatalf("Received %+v", ev)
+	}
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Delivering did not resume")
+	}
+
+	// Once the connection is closing nothing blocks.
+	close(c.shouldQuit)
+	w.deliver(Event{Path: "4"}, -1)
+	w.close()
+	for _, p := range []string{"2", "3", "4"} {
+		if ev := <-w.ch; ev.Path != p {
+			t.Fatalf("Received %+v instead of %s", ev, p)
+		}
+	}
+	if n := c.DroppedEvents(); n != 0 {
+		t.Fatalf("%d events dropped", n)
+	}
+}
+
+func TestSendEventOverflow(t *testing.T) {
+	t.Parallel()
+	receive := func(t *testing.T, c *Conn, want ...string) {
+		t.Helper()
+		for _, p := range want {
+			var ev Event
+			select {
+			case ev = <-c.eventChan:
+			default:
+				t.Fatalf("No event, expected %s", p)
+			}
+			got := ev.Path
+			if ev.Type == EventWatcherOverflow {
+				got = "!" + string(rune('0'+ev.Err.(*EventOverflowError).Dropped))
+			}
+			if got != p {
+				t.Fatalf("Received %s, expected %s", got, p)
+			}
+		}
+		if len(c.eventChan) != 0 {
+			t.Fatalf("%d more events", len(c.eventChan))
+		}
+	}
+
+	t.Run("drop new", func(t *testing.T) {
+		c := &Conn{eventChan: make(chan Event, 2), shouldQuit: make(chan struct{})}
+		for _, p := range []string{"1", "2", "3", "4"} {
+			c.sendEvent(Event{Path: p})
+		}
+		if ev := <-c.eventChan; ev.Path != "1" {
+			t.Fatalf("Received %+v", ev)
+		}
+		// The overflow event fits, the next event does not.
+		c.sendEvent(Event{Path: "5"})
+		receive(t, c, "2", "!2")
+		c.sendEvent(Event{Path: "6"})
+		receive(t, c, "!1", "6")
+		if n := c.DroppedEvents(); n != 3 {
+			t.Fatalf("%d events dropped, expected 3", n)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		c := &Conn{eventChan: make(chan Event, 3), eventPolicy: OverflowDropOldest, shouldQuit: make(chan struct{})}
+		for _, p := range []string{"1", "2", "3", "4", "5"} {
+			c.sendEvent(Event{Path: p})
+		}
+		receive(t, c, "4", "!3", "5")
+		if n := c.DroppedEvents(); n != 3 {
+			t.Fatalf("%d events dropped, expected 3", n)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		c := &Conn{eventChan: make(chan Event, 1), eventPolicy: OverflowBlock, shouldQuit: make(chan struct{})}
+		c.sendEvent(Event{Path: "1"})
+		sent := make(chan struct{})
+		go func() {
+			c.sendEvent(Event{Path: "2"})
+			close(sent)
+		}()
+		select {
+		case <-sent:
+			t.Fatal("Sending on a full channel did not block")
+		case <-time.After(50 * time.Millisecond):
+		}
+		<-c.eventChan
+		<-sent
+		receive(t, c, "2")
+	})
+}
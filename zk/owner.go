@@ -0,0 +1,62 @@
+package zk
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrNoOwnerInfo is returned when a node does not carry owner metadata, e.g.
+// because it was created by an older client.
+var ErrNoOwnerInfo = errors.New("zk: node has no owner info")
+
+var processStartTime = time.Now()
+
+// OwnerInfo is the metadata written by recipes (locks, queues, etc.) into the
+// nodes they create, so that operators can attribute any recipe node to the
+// process that created it.
+type OwnerInfo struct {
+	Hostname  string            `json:"hostname"`
+	PID       int               `json:"pid"`
+	StartTime time.Time         `json:"start_time"` // When the owning process started.
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+// NewOwnerInfo returns the OwnerInfo of the current process with the given
+// user-supplied tags.
+func NewOwnerInfo(tags map[string]string) OwnerInfo {
+	hostname, _ := os.Hostname()
+	return OwnerInfo{
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		StartTime: processStartTime,
+		Tags:      tags,
+	}
+}
+
+// Marshal encodes the owner info as node data.
+func (o OwnerInfo) Marshal() ([]byte, error) {
+	return json.Marshal(o)
+}
+
+// ParseOwnerInfo decodes owner info from node data.
+func ParseOwnerInfo(data []byte) (*OwnerInfo, error) {
+	if len(data) == 0 {
+		return nil, ErrNoOwnerInfo
+	}
+	o := &OwnerInfo{}
+	if err := json.Unmarshal(data, o); err != nil {
+		return nil, ErrNoOwnerInfo
+	}
+	return o, nil
+}
+
+// ReadOwnerInfo returns the owner info stored in the node at path.
+func ReadOwnerInfo(c *Conn, path string) (*OwnerInfo, error) {
+	data, _, err := c.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOwnerInfo(data)
+}
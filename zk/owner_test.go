@@ -0,0 +1,30 @@
+package zk
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOwnerInfoMarshal(t *testing.T) {
+	t.Parallel()
+	o := NewOwnerInfo(map[string]string{"service": "indexer"})
+	if o.PID != os.Getpid() {
+		t.Fatalf("PID is %d instead of %d", o.PID, os.Getpid())
+	}
+	data, err := o.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal returned error: %+v", err)
+	}
+	o2, err := ParseOwnerInfo(data)
+	if err != nil {
+		t.Fatalf("ParseOwnerInfo returned error: %+v", err)
+	}
+	if !o2.StartTime.Equal(o.StartTime) || o2.Hostname != o.Hostname || o2.PID != o.PID || !reflect.DeepEqual(o2.Tags, o.Tags) {
+		t.Fatalf("Owner info mismatch: %+v != %+v", o2, o)
+	}
+
+	if _, err := ParseOwnerInfo([]byte{}); err != ErrNoOwnerInfo {
+		t.Fatalf("ParseOwnerInfo returned %+v instead of ErrNoOwnerInfo for empty data", err)
+	}
+}
@@ -0,0 +1,482 @@
+package zk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ChildEventType is the type of a ChildEvent.
+type ChildEventType int
+
+const (
+	// ChildAdded is sent for a child seen for the first time, including the
+	// children read by Start.
+	ChildAdded ChildEventType = iota
+	// ChildUpdated is sent when the data of a child changed.
+	ChildUpdated
+	// ChildRemoved is sent when a child was deleted, with its last data.
+	ChildRemoved
+	// ChildrenInitialized is sent once, after the ChildAdded events of the
+	// children read by Start.
+	ChildrenInitialized
+)
+
+var childEventNames = map[ChildEventType]string{
+	ChildAdded:          "ChildAdded",
+	ChildUpdated:        "ChildUpdated",
+	ChildRemoved:        "ChildRemoved",
+	ChildrenInitialized: "ChildrenInitialized",
+}
+
+func (t ChildEventType) String() string {
+	if name := childEventNames[t]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("ChildEventType(%d)", int(t))
+}
+
+// ChildEvent is a change of the children seen by a PathChildrenCache.
+type ChildEvent struct {
+	Type ChildEventType
+	Path string    // of the child, empty for ChildrenInitialized
+	Node *NodeData // nil for ChildrenInitialized
+}
+
+// PathChildrenCache keeps the children of a node and their data and stat in
+// memory, like Curator's PathChildrenCache. It is kept up to date by a watch
+// on the node and one on every child, which are set again once they fired or
+// were lost with the session, and everything is read again after the
+// connection was lost, so the copy is never left stale. Only the direct
+// children are cached; the node itself may not exist.
+//
+// As with a TreeCache, the copy can be saved with SaveState and restored
+// with RestoreState to only read the children modified meanwhile.
+type PathChildrenCache struct {
+	c    *Conn
+	path string
+
+	mu        sync.Mutex // protects nodes, listeners and nextID
+	nodes     map[string]*NodeData
+	listeners map[int]func(ChildEvent)
+	nextID    int
+
+	// Only used by the goroutine keeping the cache, or by Start before it runs.
+	names     map[string]bool         // the children last listed
+	watches   map[string]<-chan Event // the data watches of the children
+	childrenW <-chan Event            // the watch of the node
+	restored  map[string]*NodeData    // the restored children not read yet
+	ctx       context.Context
+	cancel    context.CancelFunc
+	events    chan childrenCacheEvent
+}
+
+// childrenCacheEvent is a watch having fired: the watch of the node if name is
+// empty, or of its child name.
+type childrenCacheEvent struct {
+	name string
+	err  error
+}
+
+// NewPathChildrenCache creates a new cache of the children of the node at
+// path using the provided connection.
+func NewPathChildrenCache(c *Conn, path string) *PathChildrenCache {
+	p := &PathChildrenCache{
+		c:         c,
+		path:      path,
+		nodes:     make(map[string]*NodeData),
+		listeners: make(map[int]func(ChildEvent)),
+		names:     make(map[string]bool),
+		watches:   make(map[string]<-chan Event),
+		events:    make(chan childrenCacheEvent),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+// SaveState writes the cached children to w, for RestoreState.
+func (p *PathChildrenCache) SaveState(w io.Writer) error {
+	p.mu.Lock()
+	nodes := make(map[string]*NodeData, len(p.nodes))
+	for name, node := range p.nodes {
+		nodes[name] = node
+	}
+	p.mu.Unlock()
+	return writeCacheState(w, p.path, nodes)
+}
+
+// RestoreState reads the children written by SaveState for a cache of the
+// same path, without notifying the listeners. Start then only reads the
+// stat of the restored children, and their data if their mzxid changed, and
+// notifies the listeners of the children added, updated and removed since
+// the state was saved. It must be called before Start.
+func (p *PathChildrenCache) RestoreState(r io.Reader) error {
+	nodes, err := readCacheState(r, p.path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.restored = nodes
+	for name, node := range nodes {
+		p.nodes[name] = node
+	}
+	return nil
+}
+
+// Start reads the children and starts watching them. The listeners added
+// before receive a ChildAdded event for every child, or for restored
+// children the events of their changes, and then a ChildrenInitialized
+// event, before Start returns. It returns the error that
+// prevented listing the children, e.g. ErrNoAuth.
+func (p *PathChildrenCache) Start() error {
+	if _, err := p.c.processPath(p.path, false); err != nil {
+		return err
+	}
+	session := p.c.Subscribe()
+	if err := p.watchChildren(); err != nil {
+		p.c.Unsubscribe(session)
+		return err
+	}
+	p.notify(ChildEvent{Type: ChildrenInitialized})
+	go p.run(session)
+	return nil
+}
+
+// Close stops watching the children. The copy is not updated anymore.
+func (p *PathChildrenCache) Close() {
+	p.cancel()
+}
+
+// Children returns the cached children by name.
+func (p *PathChildrenCache) Children() map[string]*NodeData {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	nodes := make(map[string]*NodeData, len(p.nodes))
+	for name, node := range p.nodes {
+		nodes[name] = copyNodeData(node)
+	}
+	return nodes
+}
+
+// Child returns the cached data and stat of the child name, or nil if it was
+// not seen.
+func (p *PathChildrenCache) Child(name string) *NodeData {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if node := p.nodes[name]; node != nil {
+		return copyNodeData(node)
+	}
+	return nil
+}
+
+// AddListener calls fn with every change of the children. The listeners run
+// one at a time, in the order of the changes. The returned function removes
+// the listener.
+func (p *PathChildrenCache) AddListener(fn func(ChildEvent)) (remove func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.nextID
+	p.nextID++
+	p.listeners[id] = fn
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.listeners, id)
+	}
+}
+
+func (p *PathChildrenCache) run(session <-chan Event) {
+	defer p.c.Unsubscribe(session)
+	disconnected := false
+	for {
+		var err error
+		select {
+		case ev := <-p.events:
+			if ev.err == ErrClosing {
+				return
+			}
+			if ev.name == "" {
+				p.childrenW = nil
+				err = p.watchChildren()
+			} else {
+				delete(p.watches, ev.name)
+				if p.names[ev.name] {
+					err = p.watchChild(ev.name)
+				}
+			}
+		case ev, ok := <-session:
+			if !ok {
+				session = nil
+				continue
+			}
+			if ev.State == StateDisconnected {
+				disconnected = true
+			} else if ev.State == StateHasSession && disconnected {
+				// Changes may have been missed by the watches.
+				disconnected = false
+				err = p.resync()
+			}
+		case <-p.ctx.Done():
+			p.removeWatches()
+			return
+		}
+		if err == ErrClosing || err == context.Canceled {
+			p.removeWatches()
+			return
+		}
+	}
+}
+
+// removeWatches removes the watches left once the cache is closed.
+func (p *PathChildrenCache) removeWatches() {
+	if p.childrenW != nil {
+		p.c.RemoveWatches(p.path, p.childrenW)
+	}
+	for name, ch := range p.watches {
+		p.c.RemoveWatches(childPath(p.path, name), ch)
+	}
+}
+
+// forward hands the event of a watch to run once it fired.
+func (p *PathChildrenCache) forward(name string, ch <-chan Event) {
+	select {
+	case ev := <-ch:
+		select {
+		case p.events <- childrenCacheEvent{name: name, err: ev.Err}:
+		case <-p.ctx.Done():
+		}
+	case <-p.ctx.Done():
+	}
+}
+
+// retry calls f until it returns something else than a connection error,
+// waiting for the session in between.
+func (p *PathChildrenCache) retry(f func() error) error {
+	return p.c.retryInSession(p.ctx, f)
+}
+
+// retryInSession calls f until it returns something else than a connection
+// error, waiting for the session in between until ctx is done.
+func (c *Conn) retryInSession(ctx context.Context, f func() error) error {
+	for {
+		err := f()
+		if err == nil || !isConnectionError(err) {
+			return err
+		}
+		if err := c.WaitForSession(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// watchChildren lists the children and watches the node, or waits for it to
+// be created. New children are read and watched, and those gone are removed.
+func (p *PathChildrenCache) watchChildren() error {
+	var names []string
+	var ch <-chan Event
+	err := p.retry(func() (err error) {
+		for {
+			names, _, ch, err = p.c.ChildrenW(p.path)
+			if err != ErrNoNode {
+				return err
+			}
+			var exists bool
+			exists, _, ch, err = p.c.ExistsW(p.path)
+			if err != nil || !exists {
+				return err
+			}
+			// Created in between, its children are needed.
+			p.c.RemoveWatches(p.path, ch)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	p.childrenW = ch
+	go p.forward("", ch)
+	return p.update(names, false)
+}
+
+// resync lists and reads the children again, without setting watches again
+// but for the new children.
+func (p *PathChildrenCache) resync() error {
+	var names []string
+	err := p.retry(func() (err error) {
+		names, _, err = p.c.Children(p.path)
+		if err == ErrNoNode {
+			names, err = nil, nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return p.update(names, true)
+}
+
+// update records names as the children, removing those gone, and reads the
+// children not watched yet, or all of them if reread.
+func (p *PathChildrenCache) update(names []string, reread bool) error {
+	p.names = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.names[name] = true
+	}
+	p.mu.Lock()
+	var removed []ChildEvent
+	for name, node := range p.nodes {
+		if !p.names[name] {
+			delete(p.nodes, name)
+			delete(p.restored, name)
+			removed = append(removed, ChildEvent{Type: ChildRemoved, Path: childPath(p.path, name), Node: node})
+		}
+	}
+	p.mu.Unlock()
+	for _, ev := range removed {
+		p.notify(ev)
+	}
+
+	for _, name := range names {
+		var err error
+		if _, ok := p.watches[name]; !ok {
+			err = p.watchChild(name)
+		} else if reread {
+			err = p.readChild(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchChild reads the child name and watches it.
+func (p *PathChildrenCache) watchChild(name string) error {
+	if old := p.restored[name]; old != nil {
+		delete(p.restored, name)
+		return p.checkRestored(name, old)
+	}
+	var data []byte
+	var stat *Stat
+	var ch <-chan Event
+	err := p.retry(func() (err error) {
+		data, stat, ch, err = p.c.GetW(childPath(p.path, name))
+		return err
+	})
+	if err == ErrNoNode {
+		p.set(name, nil)
+		return nil
+	} else if err != nil {
+		return p.childError(err)
+	}
+	p.watches[name] = ch
+	go p.forward(name, ch)
+	p.set(name, &NodeData{Data: data, Stat: stat})
+	return nil
+}
+
+// checkRestored reads the stat of the child name, restored as old, and
+// watches it. Its data is only read if it was modified since.
+func (p *PathChildrenCache) checkRestored(name string, old *NodeData) error {
+	var exists bool
+	var stat *Stat
+	var ch <-chan Event
+	err := p.retry(func() (err error) {
+		exists, stat, ch, err = p.c.ExistsW(childPath(p.path, name))
+		return err
+	})
+	if err != nil {
+		if err = p.childError(err); err != nil {
+			return err
+		}
+		return p.watchChild(name)
+	}
+	if !exists {
+		p.c.RemoveWatches(childPath(p.path, name), ch)
+		p.set(name, nil)
+		return nil
+	}
+	p.watches[name] = ch
+	go p.forward(name, ch)
+	if node := restoredNode(old, stat); node != nil {
+		p.set(name, node)
+		return nil
+	}
+	return p.readChild(name)
+}
+
+// readChild reads the child name again, which is already watched.
+func (p *PathChildrenCache) readChild(name string) error {
+	var data []byte
+	var stat *Stat
+	err := p.retry(func() (err error) {
+		data, stat, err = p.c.Get(childPath(p.path, name))
+		return err
+	})
+	if err == ErrNoNode {
+		p.set(name, nil)
+		return nil
+	} else if err != nil {
+		return p.childError(err)
+	}
+	p.set(name, &NodeData{Data: data, Stat: stat})
+	return nil
+}
+
+// childError returns the error reading a child if it ends the cache. Other
+// errors, e.g. ErrNoAuth, leave the child out.
+func (p *PathChildrenCache) childError(err error) error {
+	if err == ErrClosing || err == context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// set replaces the cached child name, or removes it if node is nil, and
+// notifies the listeners of the change. An unchanged child only gets its
+// newer stat.
+func (p *PathChildrenCache) set(name string, node *NodeData) {
+	p.mu.Lock()
+	old := p.nodes[name]
+	if !nodeChanged(old, node) {
+		if node != nil {
+			p.nodes[name] = node
+		}
+		p.mu.Unlock()
+		return
+	}
+	ev := ChildEvent{Path: childPath(p.path, name), Node: node}
+	switch {
+	case node == nil:
+		delete(p.nodes, name)
+		ev.Type, ev.Node = ChildRemoved, old
+	case old == nil:
+		p.nodes[name] = node
+		ev.Type = ChildAdded
+	default:
+		p.nodes[name] = node
+		ev.Type = ChildUpdated
+	}
+	p.mu.Unlock()
+	p.notify(ev)
+}
+
+// notify calls the listeners with ev.
+func (p *PathChildrenCache) notify(ev ChildEvent) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.mu.Lock()
+	listeners := make([]func(ChildEvent), 0, len(p.listeners))
+	for _, fn := range p.listeners {
+		listeners = append(listeners, fn)
+	}
+	p.mu.Unlock()
+	if ev.Node != nil {
+		ev.Node = copyNodeData(ev.Node)
+	}
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
@@ -0,0 +1,131 @@
+package zk
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPathChildrenCache(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+	p := NewPathChildrenCache(zk, "/parent")
+	defer p.Close()
+
+	child := func(data string, mzxid int64) *getDataResponse {
+		return &getDataResponse{Data: []byte(data), Stat: Stat{Czxid: 1, Mzxid: mzxid}}
+	}
+	events := make(chan ChildEvent, 8)
+	p.AddListener(func(ev ChildEvent) { events <- ev })
+	expect := func(typ ChildEventType, path, data string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Type != typ || ev.Path != path || ev.Node != nil && string(ev.Node.Data) != data {
+				t.Fatalf("Unexpected event %+v, expected %s for %s", ev, typ, path)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatalf("No %s event for %s", typ, path)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Start() }()
+	serveFake(t, fc, "getChildren2", "/parent", nil, &getChildren2Response{Children: []string{"a", "b"}})
+	serveFake(t, fc, "getData", "/parent/a", nil, child("a", 1))
+	serveFake(t, fc, "getData", "/parent/b", nil, child("b", 1))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expect(ChildAdded, "/parent/a", "a")
+	expect(ChildAdded, "/parent/b", "b")
+	expect(ChildrenInitialized, "", "")
+	if children := p.Children(); len(children) != 2 || string(children["b"].Data) != "b" {
+		t.Fatalf("Children returned %+v", children)
+	}
+
+	// Children added, removed and updated are seen through the watches.
+	if err := fc.SendEvent(2, EventNodeChildrenChanged, "/parent"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getChildren2", "/parent", nil, &getChildren2Response{Children: []string{"b", "c"}})
+	expect(ChildRemoved, "/parent/a", "a")
+	serveFake(t, fc, "getData", "/parent/c", nil, child("c", 2))
+	expect(ChildAdded, "/parent/c", "c")
+	if err := fc.SendEvent(3, EventNodeDataChanged, "/parent/b"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/parent/b", nil, child("b2", 3))
+	expect(ChildUpdated, "/parent/b", "b2")
+	if node := p.Child("a"); node != nil {
+		t.Fatalf("Child returned %+v for a removed child", node)
+	}
+
+	// Everything is read again after a reconnect.
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	fc = acceptFake(t, s, 1)
+	serveFake(t, fc, "getChildren2", "/parent", nil, &getChildren2Response{Children: []string{"b", "c"}})
+	serveFake(t, fc, "getData", "/parent/b", nil, child("b2", 3))
+	serveFake(t, fc, "getData", "/parent/c", nil, child("c2", 4))
+	expect(ChildUpdated, "/parent/c", "c2")
+}
+
+func TestPathChildrenCacheRestoreState(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	stat := func(mzxid int64) Stat {
+		return Stat{Czxid: 1, Mzxid: mzxid}
+	}
+	start := func(p *PathChildrenCache, serveStart func()) {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() { done <- p.Start() }()
+		serveStart()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewPathChildrenCache(zk, "/parent")
+	defer p.Close()
+	start(p, func() {
+		serveFake(t, fc, "getChildren2", "/parent", nil, &getChildren2Response{Children: []string{"a", "b"}})
+		serveFake(t, fc, "getData", "/parent/a", nil, &getDataResponse{Data: []byte("a"), Stat: stat(1)})
+		serveFake(t, fc, "getData", "/parent/b", nil, &getDataResponse{Data: []byte("b"), Stat: stat(1)})
+	})
+	var state bytes.Buffer
+	if err := p.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewPathChildrenCache(zk, "/parent")
+	defer restored.Close()
+	if err := restored.RestoreState(&state); err != nil {
+		t.Fatal(err)
+	}
+	var events []ChildEvent
+	restored.AddListener(func(ev ChildEvent) { events = append(events, ev) })
+	start(restored, func() {
+		serveFake(t, fc, "getChildren2", "/parent", nil, &getChildren2Response{Children: []string{"a", "b", "c"}})
+		serveFake(t, fc, "exists", "/parent/a", nil, &existsResponse{Stat: stat(1)})
+		serveFake(t, fc, "exists", "/parent/b", nil, &existsResponse{Stat: stat(2)})
+		serveFake(t, fc, "getData", "/parent/b", nil, &getDataResponse{Data: []byte("b2"), Stat: stat(2)})
+		serveFake(t, fc, "getData", "/parent/c", nil, &getDataResponse{Data: []byte("c"), Stat: stat(2)})
+	})
+	// Start notifies the listeners before it returns.
+	if len(events) != 3 || events[0].Type != ChildUpdated || events[0].Path != "/parent/b" ||
+		events[1].Type != ChildAdded || events[1].Path != "/parent/c" || events[2].Type != ChildrenInitialized {
+		t.Fatalf("Unexpected events %+v", events)
+	}
+	if child := restored.Child("a"); child == nil || string(child.Data) != "a" {
+		t.Fatalf("Child returned %+v for the unmodified child", child)
+	}
+}
@@ -0,0 +1,83 @@
+package zk
+
+import "sync"
+
+// persistentEphemeralEventsSize is the capacity of the channel returned by
+// PersistentEphemeral.Events.
+const persistentEphemeralEventsSize = 16
+
+// PersistentEphemeralEvent reports an attempt of a PersistentEphemeral to
+// create one of its nodes again.
+type PersistentEphemeralEvent struct {
+	Path string
+	// Err is nil if the node was created, or why creating it failed, in which
+	// case it is retried.
+	Err error
+}
+
+// PersistentEphemeral keeps a set of ephemeral nodes, each with its own data
+// and ACL, in existence across sessions: after the session expires they are
+// created again in the new one, and retried until that succeeds. It is a
+// PresenceSet whose attempts to create the nodes again are reported on a
+// channel.
+type PersistentEphemeral struct {
+	set       *PresenceSet
+	events    chan PersistentEphemeralEvent
+	closeOnce sync.Once
+}
+
+// NewPersistentEphemeral returns an empty PersistentEphemeral creating its
+// nodes on c. Close it to delete them.
+func NewPersistentEphemeral(c *Conn) *PersistentEphemeral {
+	e := &PersistentEphemeral{
+		events: make(chan PersistentEphemeralEvent, persistentEphemeralEventsSize),
+	}
+	e.set = newPresenceSet(c, nil, e.recreated)
+	return e
+}
+
+// Add creates the ephemeral node at path with data and acl, or sets its data
+// if it is added already. Missing parents are created as persistent nodes
+// with acl. If creating the node fails, it is kept and retried in the
+// background, and the error of the first attempt is returned.
+func (e *PersistentEphemeral) Add(path string, data []byte, acl []ACL) error {
+	return e.set.register(path, data, acl)
+}
+
+// Set sets the data of an added node, which is also used when it is created
+// again.
+func (e *PersistentEphemeral) Set(path string, data []byte) error {
+	return e.set.Update(path, data)
+}
+
+// Remove stops keeping the node at path and deletes it.
+func (e *PersistentEphemeral) Remove(path string) error {
+	return e.set.Deregister(path)
+}
+
+// Nodes returns the status of the added nodes, sorted by path.
+func (e *PersistentEphemeral) Nodes() []PresenceStatus {
+	return e.set.Status()
+}
+
+// Events returns the channel on which the attempts to create the nodes again
+// in the background are reported. Events are dropped while it is full, and
+// it is closed by Close.
+func (e *PersistentEphemeral) Events() <-chan PersistentEphemeralEvent {
+	return e.events
+}
+
+// Close stops keeping the nodes and deletes them. It returns the first error
+// deleting them.
+func (e *PersistentEphemeral) Close() error {
+	err := e.set.Close()
+	e.closeOnce.Do(func() { close(e.events) })
+	return err
+}
+
+func (e *PersistentEphemeral) recreated(path string, err error) {
+	select {
+	case e.events <- PersistentEphemeralEvent{Path: path, Err: err}:
+	default:
+	}
+}
@@ -0,0 +1,76 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPersistentEphemeral(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+	e := NewPersistentEphemeral(zk)
+
+	expectEvent := func(path string, err error) {
+		t.Helper()
+		select {
+		case ev := <-e.Events():
+			if ev.Path != path || ev.Err != err {
+				t.Fatalf("Unexpected event %+v", ev)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatal("No event")
+		}
+	}
+
+	acl := DigestACL(PermAll, "user", "password")
+	done := make(chan error, 1)
+	go func() { done <- e.Add("/node", []byte("1"), acl) }()
+	serveFake(t, fc, "create", "/node", nil, &createResponse{Path: "/node"})
+	if err := <-done; err != nil {
+		t.Fatalf("Add returned error: %+v", err)
+	}
+
+	// The node is created again with its ACL in a new session, and the
+	// attempts are reported.
+	fc.Close()
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ExpireSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, ch, StateExpired)
+	if fc, err = s.Accept(fakeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.AcceptSession(2, 10*time.Second, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "create", "/node", ErrNodeExists, nil)
+	serveFake(t, fc, "exists", "/node", nil, &existsResponse{Stat: Stat{EphemeralOwner: 1}})
+	expectEvent("/node", ErrNodeExists)
+	req := serveFake(t, fc, "create", "/node", nil, &createResponse{Path: "/node"})
+	if r := req.Body.(*CreateRequest); string(r.Data) != "1" || len(r.Acl) != 1 || r.Acl[0] != acl[0] {
+		t.Fatalf("Node created again with %q and %+v", r.Data, r.Acl)
+	}
+	expectEvent("/node", nil)
+
+	go func() { done <- e.Close() }()
+	serveFake(t, fc, "delete", "/node", nil, nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Close returned error: %+v", err)
+	}
+	if _, ok := <-e.Events(); ok {
+		t.Fatal("Events not closed")
+	}
+}
@@ -0,0 +1,100 @@
+package zk
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pingRTTMargin is how many round trips the reply to a ping is given to
+	// arrive before the receive timeout expires.
+	pingRTTMargin = 4
+	// maxPingBackoff caps how many times the ping interval is halved after
+	// consecutive missed pings.
+	maxPingBackoff = 3
+)
+
+// PingStats describes the pings of a connection.
+type PingStats struct {
+	// Interval is the current time between pings.
+	Interval time.Duration
+	// RTT is the smoothed round trip time of pings, and LastRTT the round
+	// trip time of the last one.
+	RTT     time.Duration
+	LastRTT time.Duration
+	// Sent is the number of pings sent, and Missed the number of times a
+	// ping was due while the previous one was still unanswered.
+	Sent   uint64
+	Missed uint64
+}
+
+// PingStats returns statistics about the pings of the connection, e.g. to
+// monitor links on which the session is at risk of expiring.
+func (c *Conn) PingStats() PingStats {
+	return PingStats{
+		Interval: c.nextPingInterval(),
+		RTT:      time.Duration(atomic.LoadInt64(&c.pingRTT)),
+		LastRTT:  time.Duration(atomic.LoadInt64(&c.pingLastRTT)),
+		Sent:     atomic.LoadUint64(&c.pingsSent),
+		Missed:   atomic.LoadUint64(&c.pingsMissed),
+	}
+}
+
+// adaptivePingInterval returns the time to wait before the next ping. It is
+// half the receive timeout, as long as that leaves pingRTTMargin round trips
+// of time for the reply to arrive before the timeout expires, and shorter
+// otherwise and after missed pings, to detect a failing link and keep the
+// session alive on a slow one.
+func adaptivePingInterval(recvTimeout, rtt time.Duration, missed int) time.Duration {
+	interval := recvTimeout / 2
+	if limit := recvTimeout - pingRTTMargin*rtt; limit < interval {
+		interval = limit
+	}
+	if missed > maxPingBackoff {
+		missed = maxPingBackoff
+	}
+	interval >>= uint(missed)
+	if min := recvTimeout / 10; interval < min {
+		interval = min
+	}
+	return interval
+}
+
+func (c *Conn) nextPingInterval() time.Duration {
+	return adaptivePingInterval(c.recvTimeout, time.Duration(atomic.LoadInt64(&c.pingRTT)), int(atomic.LoadInt32(&c.pingsMissedInRow)))
+}
+
+// resetPings forgets the ping in flight on a previous connection.
+func (c *Conn) resetPings() {
+	atomic.StoreInt64(&c.pingSentAt, 0)
+	atomic.StoreInt32(&c.pingsMissedInRow, 0)
+}
+
+// pingSent records that a ping is sent at now. The round trip is measured
+// from the oldest unanswered ping.
+func (c *Conn) pingSent(now time.Time) {
+	atomic.AddUint64(&c.pingsSent, 1)
+	if !atomic.CompareAndSwapInt64(&c.pingSentAt, 0, now.UnixNano()) {
+		atomic.AddUint64(&c.pingsMissed, 1)
+		atomic.AddInt32(&c.pingsMissedInRow, 1)
+	}
+}
+
+// pingReceived records the reply to a ping at now.
+func (c *Conn) pingReceived(now time.Time) {
+	sent := atomic.SwapInt64(&c.pingSentAt, 0)
+	if sent == 0 {
+		return
+	}
+	atomic.StoreInt32(&c.pingsMissedInRow, 0)
+	rtt := now.Sub(time.Unix(0, sent))
+	atomic.StoreInt64(&c.pingLastRTT, int64(rtt))
+	// Smoothed like the TCP round trip time estimate of RFC 6298.
+	srtt := time.Duration(atomic.LoadInt64(&c.pingRTT))
+	if srtt == 0 {
+		srtt = rtt
+	} else {
+		srtt = srtt*7/8 + rtt/8
+	}
+	atomic.StoreInt64(&c.pingRTT, int64(srtt))
+}
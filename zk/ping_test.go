@@ -0,0 +1,82 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptivePingInterval(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		recvTimeout, rtt time.Duration
+		missed           int
+		interval         time.Duration
+	}{
+		{10 * time.Second, 0, 0, 5 * time.Second},
+		{10 * time.Second, time.Second, 0, 5 * time.Second},
+		{10 * time.Second, 2 * time.Second, 0, 2 * time.Second},
+		{10 * time.Second, 5 * time.Second, 0, time.Second},
+		{10 * time.Second, 0, 1, 2500 * time.Millisecond},
+		{10 * time.Second, 0, 2, 1250 * time.Millisecond},
+		{10 * time.Second, 0, 10, time.Second},
+	}
+	for _, tt := range tests {
+		if interval := adaptivePingInterval(tt.recvTimeout, tt.rtt, tt.missed); interval != tt.interval {
+			t.Errorf("adaptivePingInterval(%s, %s, %d) = %s, expected %s", tt.recvTimeout, tt.rtt, tt.missed, interval, tt.interval)
+		}
+	}
+}
+
+func TestPingStats(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, err := Connect([]string{"127.0.0.1:2181"}, 3*time.Second, WithDialer(s.Dialer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.AcceptSession(1, 3*time.Second, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow reply is measured.
+	req, err := fc.ReadRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Op != "ping" {
+		t.Fatalf("Expected a ping, got %s", req.Op)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := fc.Reply(req, 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	stats := zk.PingStats()
+	if stats.Sent != 1 || stats.LastRTT < 200*time.Millisecond || stats.RTT != stats.LastRTT {
+		t.Fatalf("Unexpected stats after a slow ping %+v", stats)
+	}
+
+	// An unanswered ping is missed when the next one is due, which comes
+	// sooner.
+	for i := 0; i < 2; i++ {
+		if req, err = fc.ReadRequest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if req.Op != "ping" {
+		t.Fatalf("Expected a ping, got %s", req.Op)
+	}
+	stats = zk.PingStats()
+	if stats.Missed != 1 || stats.Interval >= 1*time.Second {
+		t.Fatalf("Unexpected stats after a missed ping %+v", stats)
+	}
+}
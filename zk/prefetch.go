@@ -0,0 +1,83 @@
+package zk
+
+import "sync"
+
+// NodeData is the data and Stat of a node, as read by Prefetch or kept by
+// NodeCache.
+type NodeData struct {
+	Data []byte
+	Stat *Stat
+}
+
+// Prefetch reads the node at path and its descendants down to depth levels
+// below it and returns them by path. A depth of 0 reads path only and a
+// negative depth reads the whole subtree. Up to concurrency nodes are read
+// at the same time, so the requests are pipelined on the connection instead
+// of waiting for each other. Nodes deleted during the walk are left out; any
+// other error aborts it.
+//
+// The result is not a consistent snapshot: nodes may change while the
+// subtree is read, as their Stat shows.
+func (c *Conn) Prefetch(path string, depth, concurrency int) (map[string]NodeData, error) {
+	path, err := c.cleanPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, concurrency)
+		nodes    = make(map[string]NodeData)
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	var visit func(path string, level int)
+	visit = func(path string, level int) {
+		defer wg.Done()
+		if failed() {
+			return
+		}
+
+		sem <- struct{}{}
+		data, stat, err := c.Get(path)
+		var children []string
+		if err == nil && stat.NumChildren > 0 && (depth < 0 || level < depth) {
+			children, _, err = c.Children(path)
+		}
+		<-sem
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == ErrNoNode:
+			return
+		case err != nil:
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		nodes[path] = NodeData{Data: data, Stat: stat}
+		for _, name := range children {
+			wg.Add(1)
+			go visit(childPath(path, name), level+1)
+		}
+	}
+
+	wg.Add(1)
+	visit(path, 0)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nodes, nil
+}
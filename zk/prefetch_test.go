@@ -0,0 +1,52 @@
+package zk
+
+import "testing"
+
+func TestPrefetch(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	root := "/gozk-test-prefetch"
+	paths := []string{root}
+	for _, a := range []string{"a", "b", "c"} {
+		paths = append(paths, root+"/"+a)
+		for _, b := range []string{"x", "y"} {
+			paths = append(paths, root+"/"+a+"/"+b)
+		}
+	}
+	for _, path := range paths {
+		if _, err := zk.Create(path, []byte(path), 0, WorldACL(PermAll)); err != nil {
+			t.Fatalf("Create returned error: %+v", err)
+		}
+	}
+
+	nodes, err := zk.Prefetch(root, -1, 4)
+	if err != nil {
+		t.Fatalf("Prefetch returned error: %+v", err)
+	}
+	if len(nodes) != len(paths) {
+		t.Fatalf("Prefetch returned %d nodes instead of %d", len(nodes), len(paths))
+	}
+	for _, path := range paths {
+		if n, ok := nodes[path]; !ok || string(n.Data) != path || n.Stat == nil {
+			t.Fatalf("Wrong node %+v for %s", n, path)
+		}
+	}
+
+	if nodes, err := zk.Prefetch(root, 1, 1); err != nil {
+		t.Fatalf("Prefetch returned error: %+v", err)
+	} else if len(nodes) != 4 {
+		t.Fatalf("Prefetch with depth 1 returned %d nodes instead of 4", len(nodes))
+	}
+	if _, err := zk.Prefetch(root+"/missing", -1, 4); err != nil {
+		t.Fatalf("Prefetch of a missing node returned error: %+v", err)
+	}
+}
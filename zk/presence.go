@@ -0,0 +1,330 @@
+package zk
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// presenceRetryInterval is how often a PresenceSet retries the keys it failed
+// to create while a session is established.
+const presenceRetryInterval = time.Second
+
+// ErrNotRegistered is returned by a PresenceSet for a key that is not
+// registered.
+var ErrNotRegistered = errors.New("zk: presence key not registered")
+
+// PresenceStatus describes a key of a PresenceSet.
+type PresenceStatus struct {
+	Path string
+	Data []byte
+	// Registered is whether the node was created by the current session.
+	Registered bool
+	// Err is the error of the last attempt to create or update the node, if
+	// it failed.
+	Err error
+	// Updated is when the node was last created or written.
+	Updated time.Time
+}
+
+// PresenceSet manages ephemeral nodes announcing the presence of the session,
+// as used for service discovery, group membership and heartbeats. The nodes
+// are created again with their current data in each new session after the
+// previous one expired, retried while creating them fails, and deleted when
+// deregistered or when the set is closed. Missing parents are created as
+// persistent nodes.
+type PresenceSet struct {
+	c   *Conn
+	acl []ACL
+
+	opMu sync.Mutex // serializes changes to the nodes
+	mu   sync.Mutex // protects keys and closed
+	keys map[string]*presenceKey
+
+	closed bool
+	kick   chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+
+	// recreated, if set, is called by run with the result of every attempt
+	// to create a missing node.
+	recreated func(path string, err error)
+}
+
+type presenceKey struct {
+	data      []byte
+	acl       []ACL
+	sessionID int64 // session that created the node, or 0
+	err       error
+	updated   time.Time
+}
+
+// NewPresenceSet returns an empty PresenceSet whose nodes are created on c
+// with acl. Close it to delete them.
+func NewPresenceSet(c *Conn, acl []ACL) *PresenceSet {
+	return newPresenceSet(c, acl, nil)
+}
+
+func newPresenceSet(c *Conn, acl []ACL, recreated func(path string, err error)) *PresenceSet {
+	p := &PresenceSet{
+		c:         c,
+		acl:       acl,
+		keys:      make(map[string]*presenceKey),
+		recreated: recreated,
+		kick:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Register creates the ephemeral node at path with data, or sets its data if
+// it is registered already. If creating it fails, the key is kept and
+// retried in the background, and the error of the first attempt is
+// returned. It returns ErrClosing once the set is closed.
+func (p *PresenceSet) Register(path string, data []byte) error {
+	return p.register(path, data, p.acl)
+}
+
+func (p *PresenceSet) register(path string, data []byte, acl []ACL) error {
+	if err := validatePath(path, false); err != nil {
+		return err
+	}
+	p.opMu.Lock()
+	defer p.opMu.Unlock()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosing
+	}
+	k, ok := p.keys[path]
+	if !ok {
+		k = &presenceKey{}
+		p.keys[path] = k
+	}
+	k.acl = acl
+	p.mu.Unlock()
+	err := p.write(path, k, data)
+	if err != nil {
+		p.kickRun()
+	}
+	return err
+}
+
+// Update sets the data of the node of a registered key.
+func (p *PresenceSet) Update(path string, data []byte) error {
+	p.opMu.Lock()
+	defer p.opMu.Unlock()
+	p.mu.Lock()
+	k, ok := p.keys[path]
+	p.mu.Unlock()
+	if !ok {
+		return ErrNotRegistered
+	}
+	err := p.write(path, k, data)
+	if err != nil {
+		p.kickRun()
+	}
+	return err
+}
+
+// Deregister stops managing the key at path and deletes its node. If that
+// fails because the connection was lost, it is deleted with DeleteGuaranteed.
+func (p *PresenceSet) Deregister(path string) error {
+	p.opMu.Lock()
+	defer p.opMu.Unlock()
+	p.mu.Lock()
+	k, ok := p.keys[path]
+	delete(p.keys, path)
+	p.mu.Unlock()
+	if !ok {
+		return ErrNotRegistered
+	}
+	return p.remove(path, k)
+}
+
+// Status returns the status of the registered keys, sorted by path.
+func (p *PresenceSet) Status() []PresenceStatus {
+	sessionID := p.c.SessionID()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]PresenceStatus, 0, len(p.keys))
+	for path, k := range p.keys {
+		status = append(status, PresenceStatus{
+			Path:       path,
+			Data:       k.data,
+			Registered: k.sessionID != 0 && k.sessionID == sessionID,
+			Err:        k.err,
+			Updated:    k.updated,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Path < status[j].Path })
+	return status
+}
+
+// Close stops managing the keys and deletes their nodes. It returns the first
+// error deleting them.
+func (p *PresenceSet) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.quit)
+	p.mu.Unlock()
+	<-p.done
+
+	p.opMu.Lock()
+	defer p.opMu.Unlock()
+	p.mu.Lock()
+	keys := p.keys
+	p.keys = make(map[string]*presenceKey)
+	p.mu.Unlock()
+	var firstErr error
+	for path, k := range keys {
+		if err := p.remove(path, k); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write sets the data of k, creating its node unless the current session did
+// already. It must be called with opMu held.
+func (p *PresenceSet) write(path string, k *presenceKey, data []byte) error {
+	sessionID := p.c.SessionID()
+	var err error
+	if k.sessionID != 0 && k.sessionID == sessionID {
+		if _, err = p.c.Set(path, data, -1); err == ErrNoNode {
+			// Deleted by someone else, create it again.
+			err = p.create(path, data, k.acl, sessionID)
+		}
+	} else {
+		err = p.create(path, data, k.acl, sessionID)
+	}
+
+	p.mu.Lock()
+	k.data = data
+	k.err = err
+	if err == nil {
+		k.sessionID = sessionID
+		k.updated = time.Now()
+	} else if err == ErrNoNode || isConnectionError(err) {
+		k.sessionID = 0
+	}
+	p.mu.Unlock()
+	return err
+}
+
+// create creates the node at path, or takes it over if it was created by
+// sessionID already, e.g. by a create whose reply was lost.
+func (p *PresenceSet) create(path string, data []byte, acl []ACL, sessionID int64) error {
+	for i := 0; i < 3; i++ {
+		_, err := p.c.Create(path, data, FlagEphemeral, acl)
+		switch err {
+		case ErrNoNode:
+			if err := p.c.CreateParents(path, acl); err != nil {
+				return err
+			}
+			continue
+		case ErrNodeExists:
+			ok, stat, err := p.c.Exists(path)
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			} else if sessionID == 0 || stat.EphemeralOwner != sessionID {
+				return ErrNodeExists
+			}
+			_, err = p.c.Set(path, data, -1)
+			return err
+		}
+		return err
+	}
+	return ErrNoNode
+}
+
+// remove deletes the node of k if the current session created it. It must be
+// called with opMu held.
+func (p *PresenceSet) remove(path string, k *presenceKey) error {
+	if k.sessionID == 0 || k.sessionID != p.c.SessionID() {
+		return nil
+	}
+	err := p.c.DeleteGuaranteed(path, -1)
+	if err == ErrNoNode || isConnectionError(err) {
+		return nil
+	}
+	return err
+}
+
+func (p *PresenceSet) kickRun() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+// run creates the nodes that the current session did not create yet, when
+// a session is established and then every presenceRetryInterval until all
+// of them are.
+func (p *PresenceSet) run() {
+	defer close(p.done)
+	for {
+		state, changed := p.c.stateAndChange()
+		retry := time.NewTimer(presenceRetryInterval)
+		if state != StateHasSession || p.sync() {
+			retry.Stop()
+		}
+		select {
+		case <-changed:
+		case <-p.kick:
+		case <-retry.C:
+		case <-p.quit:
+			retry.Stop()
+			return
+		case <-p.c.shouldQuit:
+			retry.Stop()
+			return
+		}
+		retry.Stop()
+	}
+}
+
+// sync creates the missing nodes and reports whether all of them exist.
+func (p *PresenceSet) sync() bool {
+	p.opMu.Lock()
+	defer p.opMu.Unlock()
+	sessionID := p.c.SessionID()
+	p.mu.Lock()
+	missing := make(map[string]*presenceKey)
+	for path, k := range p.keys {
+		if k.sessionID == 0 || k.sessionID != sessionID {
+			missing[path] = k
+		}
+	}
+	p.mu.Unlock()
+
+	ok := true
+	for path, k := range missing {
+		select {
+		case <-p.quit:
+			return true
+		default:
+		}
+		p.mu.Lock()
+		data := k.data
+		p.mu.Unlock()
+		err := p.write(path, k, data)
+		if err != nil {
+			p.c.logf(LogWarn, LogRecipes, "Failed to create presence node %s: %+v", path, err)
+			ok = false
+		}
+		if p.recreated != nil {
+			p.recreated(path, err)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,84 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresenceSet(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+	p := NewPresenceSet(zk, WorldACL(PermAll))
+
+	async := func(f func() error) <-chan error {
+		done := make(chan error, 1)
+		go func() { done <- f() }()
+		return done
+	}
+
+	// Missing parents are created.
+	done := async(func() error { return p.Register("/svc/a", []byte("1")) })
+	serveFake(t, fc, "create", "/svc/a", ErrNoNode, nil)
+	serveFake(t, fc, "create", "/svc", nil, &createResponse{Path: "/svc"})
+	serveFake(t, fc, "create", "/svc/a", nil, &createResponse{Path: "/svc/a"})
+	if err := <-done; err != nil {
+		t.Fatalf("Register returned error: %+v", err)
+	}
+	done = async(func() error { return p.Update("/svc/a", []byte("2")) })
+	serveFake(t, fc, "setData", "/svc/a", nil, &setDataResponse{})
+	if err := <-done; err != nil {
+		t.Fatalf("Update returned error: %+v", err)
+	}
+	if err := p.Update("/svc/b", nil); err != ErrNotRegistered {
+		t.Fatalf("Update of an unknown key returned %+v instead of ErrNotRegistered", err)
+	}
+	if status := p.Status(); len(status) != 1 || !status[0].Registered || string(status[0].Data) != "2" {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	// The node is created again with its current data in a new session.
+	fc.Close()
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ExpireSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, ch, StateExpired)
+	if fc, err = s.Accept(fakeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.AcceptSession(2, 10*time.Second, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	req := serveFake(t, fc, "create", "/svc/a", nil, &createResponse{Path: "/svc/a"})
+	if data := req.Body.(*CreateRequest).Data; string(data) != "2" {
+		t.Fatalf("Node created again with %q", data)
+	}
+	deadline := time.Now().Add(fakeTimeout)
+	for status := p.Status(); !status[0].Registered; status = p.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Node not registered in the new session: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done = async(p.Close)
+	serveFake(t, fc, "delete", "/svc/a", nil, nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Close returned error: %+v", err)
+	}
+	if err := p.Register("/svc/a", nil); err != ErrClosing {
+		t.Fatalf("Register after Close returned %+v instead of ErrClosing", err)
+	}
+}
@@ -0,0 +1,241 @@
+package zk
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startEchoServer starts a server echoing back what it receives.
+func startEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+// startProxy starts a proxy that runs handshake on each connection, which
+// returns the address requested, and then relays to that address.
+func startProxy(t *testing.T, handshake func(conn net.Conn, br *bufio.Reader) (string, error)) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				addr, err := handshake(conn, br)
+				if err != nil {
+					return
+				}
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, br)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+func socks5Server(user, password string) func(net.Conn, *bufio.Reader) (string, error) {
+	return func(conn net.Conn, br *bufio.Reader) (string, error) {
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return "", err
+		}
+		methods := make([]byte, hdr[1])
+		if _, err := io.ReadFull(br, methods); err != nil {
+			return "", err
+		}
+		if user == "" {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+			auth := make([]byte, 2)
+			io.ReadFull(br, auth)
+			u := make([]byte, auth[1])
+			io.ReadFull(br, u)
+			n, _ := br.ReadByte()
+			p := make([]byte, n)
+			io.ReadFull(br, p)
+			if string(u) != user || string(p) != password {
+				conn.Write([]byte{1, 1})
+				return "", io.EOF
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(br, req); err != nil {
+			return "", err
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(br, ip)
+			host = net.IP(ip).String()
+		case 3:
+			n, _ := br.ReadByte()
+			name := make([]byte, n)
+			io.ReadFull(br, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(br, port)
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+	}
+}
+
+func httpConnectServer(auth string) func(net.Conn, *bufio.Reader) (string, error) {
+	return func(conn net.Conn, br *bufio.Reader) (string, error) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return "", err
+		}
+		if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != auth {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return "", io.EOF
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return req.Host, nil
+	}
+}
+
+func TestProxyDialer(t *testing.T) {
+	t.Parallel()
+	echo := startEchoServer(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	socks := startProxy(t, socks5Server("", ""))
+	defer socks.Close()
+	socksAuth := startProxy(t, socks5Server("user", "secret"))
+	defer socksAuth.Close()
+	httpProxy := startProxy(t, httpConnectServer(""))
+	defer httpProxy.Close()
+	httpAuth := startProxy(t, httpConnectServer("Basic dXNlcjpzZWNyZXQ="))
+	defer httpAuth.Close()
+
+	tests := []struct {
+		proxy string
+		ok    bool
+	}{
+		{"socks5://" + socks.Addr().String(), true},
+		{"socks5h://user:secret@" + socksAuth.Addr().String(), true},
+		{"socks5://user:wrong@" + socksAuth.Addr().String(), false},
+		{"http://" + httpProxy.Addr().String(), true},
+		{"http://user:secret@" + httpAuth.Addr().String(), true},
+		{"http://" + httpAuth.Addr().String(), false},
+		{"ftp://" + httpProxy.Addr().String(), false},
+	}
+	for _, tt := range tests {
+		proxyURL, err := url.Parse(tt.proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dial := ProxyDialer(net.DialTimeout, func(string) (*url.URL, error) { return proxyURL, nil })
+		for _, addr := range []string{"127.0.0.1:" + echoPort, "localhost:" + echoPort} {
+			conn, err := dial("tcp", addr, 5*time.Second)
+			if !tt.ok {
+				if err == nil {
+					conn.Close()
+					t.Errorf("Dial of %s through %s succeeded", addr, tt.proxy)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Dial of %s through %s returned error: %+v", addr, tt.proxy, err)
+				continue
+			}
+			conn.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("Echo of %s through %s returned %q, %v", addr, tt.proxy, buf, err)
+			}
+			conn.Close()
+		}
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		host, list string
+		direct     bool
+	}{
+		{"zk1.example.com", "", false},
+		{"zk1.example.com", "*", true},
+		{"zk1.example.com", "example.com", true},
+		{"zk1.example.com", ".example.com", true},
+		{"zk1.example.com", "other.com, zk1.example.com:2181", true},
+		{"notexample.com", "example.com", false},
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"11.1.2.3", "10.0.0.0/8", false},
+		{"::1", "[::1]", true},
+		{"127.0.0.1", "localhost", false},
+	}
+	for _, tt := range tests {
+		if direct := noProxy(tt.host, tt.list); direct != tt.direct {
+			t.Errorf("noProxy(%q, %q) = %t, expected %t", tt.host, tt.list, direct, tt.direct)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"} {
+		if v, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, v)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+
+	if u, err := ProxyFromEnvironment("zk1:2181"); u != nil || err != nil {
+		t.Fatalf("ProxyFromEnvironment without proxy = %v, %v", u, err)
+	}
+	os.Setenv("HTTP_PROXY", "proxy:3128")
+	os.Setenv("ALL_PROXY", "socks5://socks:1080")
+	os.Setenv("NO_PROXY", "internal")
+	if u, err := ProxyFromEnvironment("zk1:2181"); err != nil || u.String() != "socks5://socks:1080" {
+		t.Fatalf("ProxyFromEnvironment = %v, %v, expected ALL_PROXY", u, err)
+	}
+	if u, _ := ProxyFromEnvironment("zk.internal:2181"); u != nil {
+		t.Fatalf("ProxyFromEnvironment ignored NO_PROXY: %v", u)
+	}
+	os.Unsetenv("ALL_PROXY")
+	if u, err := ProxyFromEnvironment("zk1:2181"); err != nil || u.String() != "http://proxy:3128" {
+		t.Fatalf("ProxyFromEnvironment = %v, %v, expected HTTP_PROXY", u, err)
+	}
+}
@@ -0,0 +1,278 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrEmptyQueue is returned by TryClaim when no item can be claimed.
+	ErrEmptyQueue = errors.New("zk: queue is empty")
+	// ErrClaimLost is returned when acknowledging or releasing a claim whose
+	// node no longer belongs to the session, e.g. because the session expired
+	// and the item was claimed by another consumer.
+	ErrClaimLost = errors.New("zk: claim lost")
+)
+
+// Queue is a distributed FIFO work queue shared by a group of consumers,
+// optionally with priorities. Items are sequential nodes under path/items,
+// whose names sort in the order the items are claimed. A consumer claims an item by
+// creating an ephemeral node under path/claims, so that no other consumer of
+// the group works on it, and acknowledges it once done, which deletes both.
+// If the consumer dies before acknowledging, its claim goes away with its
+// session and the item is claimed by another consumer, giving at-least-once
+// processing.
+type Queue struct {
+	c          *Conn
+	path       string
+	itemsPath  string
+	claimsPath string
+	acl        []ACL
+	owner      OwnerInfo
+
+	// due, if set, returns when the item called name can be claimed.
+	due func(name string) time.Time
+}
+
+// NewQueue creates a new queue instance using the provided connection, path,
+// and acl. The path must be a node that is only used by this queue.
+func NewQueue(c *Conn, path string, acl []ACL) *Queue {
+	return &Queue{
+		c:          c,
+		path:       path,
+		itemsPath:  path + "/items",
+		claimsPath: path + "/claims",
+		acl:        acl,
+		owner:      NewOwnerInfo(nil),
+	}
+}
+
+// SetOwner sets the owner info written into the claim nodes of the consumer.
+// It defaults to NewOwnerInfo(nil).
+func (q *Queue) SetOwner(owner OwnerInfo) {
+	q.owner = owner
+}
+
+// Put adds an item with the given data to the tail of the queue and returns
+// its path. It has priority 0.
+func (q *Queue) Put(data []byte) (string, error) {
+	return q.PutPriority(data, 0)
+}
+
+// PutPriority adds an item with the given data and priority and returns its
+// path. Items with a lower priority value are claimed first, and items of
+// the same priority in the order they were put. The priority is encoded in
+// the node name the way Curator's DistributedPriorityQueue does it, so the
+// items sort the same.
+func (q *Queue) PutPriority(data []byte, priority int32) (string, error) {
+	return q.put("item-"+priorityToString(priority), data)
+}
+
+// put creates a sequential item node whose name starts with name, creating
+// the queue nodes first if they do not exist.
+func (q *Queue) put(name string, data []byte) (string, error) {
+	prefix := q.itemsPath + "/" + name
+	path, err := q.c.Create(prefix, data, FlagSequence, q.acl)
+	if err == ErrNoNode {
+		if err := q.createNodes(); err != nil {
+			return "", err
+		}
+		path, err = q.c.Create(prefix, data, FlagSequence, q.acl)
+	}
+	return path, err
+}
+
+// priorityToString encodes priority as the fixed width hexadecimal value of
+// its bits, prefixed with 0 for negative values and 1 otherwise, so the
+// strings sort like the numbers.
+func priorityToString(priority int32) string {
+	sign := "1"
+	if priority < 0 {
+		sign = "0"
+	}
+	return fmt.Sprintf("%s%08X", sign, uint32(priority))
+}
+
+// Len returns the number of items in the queue, including claimed ones.
+func (q *Queue) Len() (int, error) {
+	_, stat, err := q.c.Exists(q.itemsPath)
+	return int(stat.NumChildren), err
+}
+
+// Claim claims the first item, by priority and then age, that is not claimed
+// by another consumer, waiting for one if there is none.
+func (q *Queue) Claim() (*Claim, error) {
+	// A watch stays registered until it fires, so it is only set again once
+	// it did, not every time the first item becomes due.
+	var itemsCh, claimsCh <-chan Event
+	for {
+		var items []string
+		var err error
+		if itemsCh == nil {
+			items, _, itemsCh, err = q.c.ChildrenW(q.itemsPath)
+		} else {
+			items, _, err = q.c.Children(q.itemsPath)
+		}
+		if err == ErrNoNode {
+			if err := q.createNodes(); err != nil {
+				return nil, err
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if claimsCh == nil {
+			// Claims of dead consumers going away make their items
+			// available.
+			_, _, claimsCh, err = q.c.ChildrenW(q.claimsPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cl, next, err := q.claimFirst(items)
+		if err != ErrEmptyQueue {
+			return cl, err
+		}
+
+		// Wait for the first item to be due, if it is not yet.
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		var ev Event
+		select {
+		case ev = <-itemsCh:
+			itemsCh = nil
+		case ev = <-claimsCh:
+			claimsCh = nil
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ev.Err != nil {
+			return nil, ev.Err
+		}
+	}
+}
+
+// TryClaim claims the first item, by priority and then age, that is not
+// claimed by another consumer, or returns ErrEmptyQueue if there is none.
+func (q *Queue) TryClaim() (*Claim, error) {
+	items, _, err := q.c.Children(q.itemsPath)
+	if err == ErrNoNode {
+		return nil, ErrEmptyQueue
+	} else if err != nil {
+		return nil, err
+	}
+	cl, _, err := q.claimFirst(items)
+	return cl, err
+}
+
+// claimFirst claims the first of items that can be claimed. If none can, it
+// returns ErrEmptyQueue along with when the first item not due yet is, if
+// any.
+func (q *Queue) claimFirst(items []string) (*Claim, time.Time, error) {
+	// Priorities, due times and sequence numbers have a fixed width, so the
+	// names sort in order.
+	sort.Strings(items)
+	for _, name := range items {
+		if q.due != nil {
+			if due := q.due(name); due.After(time.Now()) {
+				return nil, due, ErrEmptyQueue
+			}
+		}
+		cl, err := q.claim(name)
+		if err == nil {
+			return cl, time.Time{}, nil
+		}
+		if err != ErrNodeExists && err != ErrNoNode {
+			return nil, time.Time{}, err
+		}
+	}
+	return nil, time.Time{}, ErrEmptyQueue
+}
+
+// claim claims the item called name. It returns ErrNodeExists if the item is
+// claimed already and ErrNoNode if it was acknowledged.
+func (q *Queue) claim(name string) (*Claim, error) {
+	owner, err := q.owner.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	claimPath := q.claimsPath + "/" + name
+	if _, err := q.c.Create(claimPath, owner, FlagEphemeral, q.acl); err != nil {
+		return nil, err
+	}
+
+	data, _, err := q.c.Get(q.itemsPath + "/" + name)
+	if err != nil {
+		q.c.Delete(claimPath, -1)
+		return nil, err
+	}
+	return &Claim{q: q, Path: q.itemsPath + "/" + name, Data: data, claimPath: claimPath}, nil
+}
+
+func (q *Queue) createNodes() error {
+	if err := q.c.CreateParents(q.itemsPath, q.acl); err != nil {
+		return err
+	}
+	for _, p := range []string{q.itemsPath, q.claimsPath} {
+		if _, err := q.c.Create(p, []byte{}, 0, q.acl); err != nil && err != ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim is an item of a Queue claimed by a consumer.
+type Claim struct {
+	q         *Queue
+	claimPath string
+
+	// Path is the path of the item.
+	Path string
+	// Data is the data of the item.
+	Data []byte
+}
+
+// Ack acknowledges that the item was processed, removing it from the queue.
+func (cl *Claim) Ack() error {
+	version, err := cl.version()
+	if err != nil {
+		return err
+	}
+	_, err = cl.q.c.Multi(
+		&DeleteRequest{Path: cl.Path, Version: -1},
+		&DeleteRequest{Path: cl.claimPath, Version: version},
+	)
+	return err
+}
+
+// Release gives up the claim without acknowledging the item, so that another
+// consumer can claim it.
+func (cl *Claim) Release() error {
+	version, err := cl.version()
+	if err != nil {
+		return err
+	}
+	return cl.q.c.Delete(cl.claimPath, version)
+}
+
+// version returns the version of the claim node, or ErrClaimLost if it does
+// not belong to the session anymore.
+func (cl *Claim) version() (int32, error) {
+	ok, stat, err := cl.q.c.Exists(cl.claimPath)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || stat.EphemeralOwner != cl.q.c.SessionID() {
+		return 0, ErrClaimLost
+	}
+	return stat.Version, nil
+}
@@ -0,0 +1,154 @@
+package zk
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	q := NewQueue(zk, "/test-queue/work", WorldACL(PermAll))
+	if _, err := q.TryClaim(); err != ErrEmptyQueue {
+		t.Fatalf("TryClaim of an empty queue returned %v instead of ErrEmptyQueue", err)
+	}
+	for i := byte(1); i <= 3; i++ {
+		if _, err := q.Put([]byte{i}); err != nil {
+			t.Fatalf("Put returned error: %+v", err)
+		}
+	}
+
+	first, err := q.Claim()
+	if err != nil {
+		t.Fatalf("Claim returned error: %+v", err)
+	}
+	second, err := q.Claim()
+	if err != nil {
+		t.Fatalf("Claim returned error: %+v", err)
+	}
+	if !bytes.Equal(first.Data, []byte{1}) || !bytes.Equal(second.Data, []byte{2}) {
+		t.Fatalf("Claimed %v and %v instead of the oldest items", first.Data, second.Data)
+	}
+
+	if err := first.Ack(); err != nil {
+		t.Fatalf("Ack returned error: %+v", err)
+	}
+	if err := first.Ack(); err != ErrClaimLost {
+		t.Fatalf("second Ack returned %v instead of ErrClaimLost", err)
+	}
+	if err := second.Release(); err != nil {
+		t.Fatalf("Release returned error: %+v", err)
+	}
+	if n, err := q.Len(); err != nil || n != 2 {
+		t.Fatalf("Len returned %d, %v instead of 2", n, err)
+	}
+
+	again, err := q.TryClaim()
+	if err != nil {
+		t.Fatalf("TryClaim returned error: %+v", err)
+	}
+	if !bytes.Equal(again.Data, []byte{2}) {
+		t.Fatalf("Released item was not claimed again, got %v", again.Data)
+	}
+}
+
+func TestQueueReclaim(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	consumer1, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer consumer1.Close()
+	consumer2, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer consumer2.Close()
+
+	q1 := NewQueue(consumer1, "/test-queue", WorldACL(PermAll))
+	q2 := NewQueue(consumer2, "/test-queue", WorldACL(PermAll))
+	if _, err := q1.Put([]byte{1}); err != nil {
+		t.Fatalf("Put returned error: %+v", err)
+	}
+	if _, err := q1.Claim(); err != nil {
+		t.Fatalf("Claim returned error: %+v", err)
+	}
+
+	claimed := make(chan *Claim, 1)
+	go func() {
+		cl, err := q2.Claim()
+		if err != nil {
+			t.Errorf("Claim returned error: %+v", err)
+		}
+		claimed <- cl
+	}()
+	select {
+	case <-claimed:
+		t.Fatal("Item was claimed by two consumers")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The first consumer dies without acknowledging the item.
+	consumer1.Close()
+	select {
+	case cl := <-claimed:
+		if cl == nil || !bytes.Equal(cl.Data, []byte{1}) {
+			t.Fatalf("Reclaimed %+v instead of the item of the dead consumer", cl)
+		}
+		if err := cl.Ack(); err != nil {
+			t.Fatalf("Ack returned error: %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Item of a dead consumer was not reclaimed")
+	}
+}
+
+func TestPriorityToString(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		priority int32
+		want     string
+	}{
+		{0, "100000000"},
+		{1, "100000001"},
+		{-1, "0FFFFFFFF"},
+		{math.MaxInt32, "17FFFFFFF"},
+		{math.MinInt32, "080000000"},
+	} {
+		if got := priorityToString(tc.priority); got != tc.want {
+			t.Errorf("priorityToString(%d) = %s, expected %s", tc.priority, got, tc.want)
+		}
+	}
+
+	// The names of items sort by priority and then sequence number.
+	priorities := []int32{5, math.MinInt32, 0, -3, math.MaxInt32, 0, -3}
+	names := make([]string, len(priorities))
+	for i, p := range priorities {
+		names[i] = fmt.Sprintf("item-%s%010d", priorityToString(p), i)
+	}
+	sort.Strings(names)
+	want := []int{1, 3, 6, 2, 5, 0, 4}
+	for i, name := range names {
+		if expected := fmt.Sprintf("%010d", want[i]); !strings.HasSuffix(name, expected) {
+			t.Fatalf("Sorted names %v", names)
+		}
+	}
+}
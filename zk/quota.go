@@ -0,0 +1,147 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	quotaPath  = "/zookeeper/quota"
+	limitsNode = "zookeeper_limits"
+	statsNode  = "zookeeper_stats"
+)
+
+// ErrQuotaChroot is returned by the quota methods of a connection with a chroot.
+var ErrQuotaChroot = errors.New("zk: quotas cannot be managed through a chroot")
+
+// Quota is a limit on, or the usage of, the number of nodes and bytes of
+// data in a subtree. ZooKeeper only logs a warning when a quota is exceeded.
+type Quota struct {
+	// Count is the number of nodes, including the root of the subtree, or
+	// -1 for no limit.
+	Count int64 `json:"count"`
+	// Bytes is the total size of the data of the nodes, or -1 for no limit.
+	Bytes int64 `json:"bytes"`
+}
+
+// String returns the quota in the format stored by ZooKeeper.
+func (q Quota) String() string {
+	return fmt.Sprintf("count=%d,bytes=%d", q.Count, q.Bytes)
+}
+
+// ParseQuota parses a quota in the format stored by ZooKeeper. Fields other
+// than count and bytes, e.g. the hard limits of ZooKeeper 3.7, are ignored.
+func ParseQuota(s string) (Quota, error) {
+	q := Quota{Count: -1, Bytes: -1}
+	for _, field := range strings.Split(strings.TrimSpace(s), ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return q, fmt.Errorf("zk: invalid quota %q", s)
+		}
+		var dst *int64
+		switch kv[0] {
+		case "count":
+			dst = &q.Count
+		case "bytes":
+			dst = &q.Bytes
+		default:
+			continue
+		}
+		n, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("zk: invalid quota %q", s)
+		}
+		*dst = n
+	}
+	return q, nil
+}
+
+// SetQuota sets the quota of the subtree at path, which must exist. Quotas
+// live outside of any chroot, so the connection must not have one.
+func (c *Conn) SetQuota(path string, q Quota) error {
+	qp, err := c.quotaNode(path)
+	if err != nil {
+		return err
+	}
+	if ok, _, err := c.Exists(path); err != nil {
+		return err
+	} else if !ok {
+		return ErrNoNode
+	}
+
+	if err := c.CreateParents(qp+"/"+statsNode, WorldACL(PermAll)); err != nil {
+		return err
+	}
+	// The server computes the usage once the limits are created.
+	if _, err := c.Create(qp+"/"+statsNode, []byte(Quota{}.String()), 0, WorldACL(PermAll)); err != nil && err != ErrNodeExists {
+		return err
+	}
+	_, err = c.Create(qp+"/"+limitsNode, []byte(q.String()), 0, WorldACL(PermAll))
+	if err == ErrNodeExists {
+		_, err = c.Set(qp+"/"+limitsNode, []byte(q.String()), -1)
+	}
+	return err
+}
+
+// GetQuota returns the quota of the subtree at path and its current usage as
+// tracked by the server. It returns ErrNoNode if the subtree has no quota.
+func (c *Conn) GetQuota(path string) (limit, usage Quota, err error) {
+	qp, err := c.quotaNode(path)
+	if err != nil {
+		return limit, usage, err
+	}
+	data, _, err := c.Get(qp + "/" + limitsNode)
+	if err != nil {
+		return limit, usage, err
+	}
+	if limit, err = ParseQuota(string(data)); err != nil {
+		return limit, usage, err
+	}
+	data, _, err = c.Get(qp + "/" + statsNode)
+	if err == ErrNoNode {
+		return limit, Quota{}, nil
+	} else if err != nil {
+		return limit, usage, err
+	}
+	usage, err = ParseQuota(string(data))
+	return limit, usage, err
+}
+
+// DeleteQuota removes the quota of the subtree at path, if it has one.
+func (c *Conn) DeleteQuota(path string) error {
+	qp, err := c.quotaNode(path)
+	if err != nil {
+		return err
+	}
+	for _, node := range []string{limitsNode, statsNode} {
+		if err := c.Delete(qp+"/"+node, -1); err != nil && err != ErrNoNode {
+			return err
+		}
+	}
+	// Remove the nodes that only held this quota.
+	for p := qp; p != quotaPath; p = parentPath(p) {
+		if err := c.Delete(p, -1); err == ErrNotEmpty {
+			break
+		} else if err != nil && err != ErrNoNode {
+			return err
+		}
+	}
+	return nil
+}
+
+// quotaNode returns the node holding the quota of the subtree at path.
+func (c *Conn) quotaNode(path string) (string, error) {
+	if c.chroot != "" {
+		return "", ErrQuotaChroot
+	}
+	path, err := c.cleanPath(path, false)
+	if err != nil {
+		return "", err
+	}
+	if path == "/" || path == "/zookeeper" || strings.HasPrefix(path, "/zookeeper/") {
+		return "", fmt.Errorf("zk: cannot set a quota on %s", path)
+	}
+	return quotaPath + path, nil
+}
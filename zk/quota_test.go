@@ -0,0 +1,45 @@
+package zk
+
+import "testing"
+
+func TestParseQuota(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		s     string
+		quota Quota
+		ok    bool
+	}{
+		{"count=10,bytes=100", Quota{10, 100}, true},
+		{"count=-1,bytes=1024\n", Quota{-1, 1024}, true},
+		{"count=5,bytes=-1,countHardLimit=-1,byteHardLimit=-1", Quota{5, -1}, true},
+		{"count=5", Quota{5, -1}, true},
+		{"count=x,bytes=1", Quota{}, false},
+		{"count", Quota{}, false},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuota(tt.s)
+		if (err == nil) != tt.ok || (tt.ok && q != tt.quota) {
+			t.Errorf("ParseQuota(%q) = %+v, %v", tt.s, q, err)
+		}
+	}
+	if s := (Quota{10, -1}).String(); s != "count=10,bytes=-1" {
+		t.Fatalf("Quota.String() = %q", s)
+	}
+}
+
+func TestQuotaNode(t *testing.T) {
+	t.Parallel()
+	c := &Conn{}
+	if p, err := c.quotaNode("/app/a"); err != nil || p != "/zookeeper/quota/app/a" {
+		t.Fatalf("quotaNode returned %q, %v", p, err)
+	}
+	for _, p := range []string{"/", "/zookeeper", "/zookeeper/config", "app"} {
+		if _, err := c.quotaNode(p); err == nil {
+			t.Errorf("quotaNode(%q) succeeded", p)
+		}
+	}
+	c.chroot = "/tenant"
+	if _, err := c.quotaNode("/app"); err != ErrQuotaChroot {
+		t.Fatalf("quotaNode with a chroot returned %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package zk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// retryConnLoss calls f until it returns something else than a connection
+// error, which it does while the client fails over to another server.
+func retryConnLoss(f func() error) error {
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		err := f()
+		if err == nil || !isConnectionError(err) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// phaseAvailable reports whether requests are served in phase.
+func phaseAvailable(s Scenario, phase ScenarioPhase) bool {
+	return phase != PhaseDisrupted || s.Quorum
+}
+
+// recipeChecks returns, for each recipe, the check to run in every phase of s
+// with a connection to the cluster.
+func recipeChecks(t *testing.T, zk *Conn, s Scenario) map[string]func(ScenarioPhase) error {
+	acl := WorldACL(PermAll)
+	root := fmt.Sprintf("/recipes-%d", time.Now().UnixNano())
+
+	lock := NewLock(zk, root+"/lock", acl)
+	queue := NewQueue(zk, root+"/queue", acl)
+	var queued []string
+	presence := NewPresenceSet(zk, acl)
+	ephemeral := NewPersistentEphemeral(zk)
+	latch := NewLeaderLatch(zk, root+"/latch", acl)
+	t.Cleanup(func() {
+		presence.Close()
+		ephemeral.Close()
+		latch.Stop()
+	})
+
+	exists := func(path string) error {
+		return retryConnLoss(func() error {
+			ok, _, err := zk.Exists(path)
+			if err == nil && !ok {
+				err = fmt.Errorf("%s does not exist", path)
+			}
+			return err
+		})
+	}
+
+	return map[string]func(ScenarioPhase) error{
+		"lock": func(phase ScenarioPhase) error {
+			if !phaseAvailable(s, phase) {
+				return nil
+			}
+			if err := retryConnLoss(lock.Lock); err != nil {
+				return err
+			}
+			if h, err := lock.Holder(); err != nil || h == nil {
+				return fmt.Errorf("holder %+v, %v", h, err)
+			}
+			return retryConnLoss(lock.Unlock)
+		},
+		"queue": func(phase ScenarioPhase) error {
+			if !phaseAvailable(s, phase) {
+				return nil
+			}
+			data := phase.String()
+			if err := retryConnLoss(func() error {
+				_, err := queue.Put([]byte(data))
+				return err
+			}); err != nil {
+				return err
+			}
+			queued = append(queued, data)
+			if phase != PhaseRestored {
+				return nil
+			}
+			// Every item put survived, in order.
+			for _, want := range queued {
+				var cl *Claim
+				if err := retryConnLoss(func() (err error) {
+					cl, err = queue.TryClaim()
+					return err
+				}); err != nil {
+					return err
+				}
+				if string(cl.Data) != want {
+					return fmt.Errorf("claimed %q, expected %q", cl.Data, want)
+				}
+				if err := retryConnLoss(cl.Ack); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"presence": func(phase ScenarioPhase) error {
+			path := root + "/presence/" + phase.String()
+			if phaseAvailable(s, phase) {
+				if err := retryConnLoss(func() error { return presence.Register(path, nil) }); err != nil {
+					return err
+				}
+			} else if err := presence.Register(path, nil); err != nil && !isConnectionError(err) {
+				// Kept and created once the cluster is back.
+				return err
+			}
+			if phase != PhaseRestored {
+				return nil
+			}
+			for _, p := range presence.Status() {
+				if err := exists(p.Path); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"leader latch": func(phase ScenarioPhase) error {
+			if phase == PhaseBefore {
+				if err := latch.Start(); err != nil {
+					return err
+				}
+			}
+			if !phaseAvailable(s, phase) {
+				return nil
+			}
+			// The only latch leads again once connected.
+			deadline := time.Now().Add(15 * time.Second)
+			for !latch.HasLeadership() {
+				if time.Now().After(deadline) {
+					return fmt.Errorf("no leadership")
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			return nil
+		},
+		"persistent ephemeral": func(phase ScenarioPhase) error {
+			path := root + "/ephemeral"
+			switch phase {
+			case PhaseBefore:
+				return retryConnLoss(func() error { return ephemeral.Add(path, []byte("1"), acl) })
+			case PhaseRestored:
+				return exists(path)
+			}
+			return nil
+		},
+	}
+}
+
+func TestRecipeScenarios(t *testing.T) {
+	for _, s := range Scenarios() {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			tc, err := StartTestCluster(3, nil, logWriter{t: t, p: "[ZKERR] "})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tc.Stop()
+			zk, _, err := tc.ConnectAllTimeout(15 * time.Second)
+			if err != nil {
+				t.Fatalf("Connect returned error: %+v", err)
+			}
+			defer zk.Close()
+
+			checks := recipeChecks(t, zk, s)
+			err = tc.RunScenario(s, func(phase ScenarioPhase) error {
+				for name, check := range checks {
+					if err := check(phase); err != nil {
+						return fmt.Errorf("%s: %s", name, err)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package zk
+
+import (
+	"context"
+	"sync"
+)
+
+// ReentrantLock is a Lock that the same owner can acquire several times
+// without deadlocking itself, as nested code paths of a process do. Owners
+// are identified by a string chosen by the caller, e.g. a request ID. The
+// lock node is created on the first acquisition and deleted once the owner
+// released the lock as many times as it acquired it. Other owners in the
+// same process wait for that before they queue for the lock.
+type ReentrantLock struct {
+	lock *Lock
+	sem  chan struct{} // held by the owner in this process
+
+	mu    sync.Mutex // protects owner and count
+	owner string
+	count int
+}
+
+// NewReentrantLock creates a new reentrant lock using the provided
+// connection, path, and acl, like NewLock.
+func NewReentrantLock(c *Conn, path string, acl []ACL) *ReentrantLock {
+	return &ReentrantLock{
+		lock: NewLock(c, path, acl),
+		sem:  make(chan struct{}, 1),
+	}
+}
+
+// SetOwner sets the owner info written into the lock node, see Lock.SetOwner.
+func (r *ReentrantLock) SetOwner(owner OwnerInfo) {
+	r.lock.SetOwner(owner)
+}
+
+// Holder returns the owner info of the current holder of the lock, see
+// Lock.Holder.
+func (r *ReentrantLock) Holder() (*OwnerInfo, error) {
+	return r.lock.Holder()
+}
+
+// Lock acquires the lock for owner, waiting until it is acquired or an error
+// occurs. If owner holds the lock already, it only counts the acquisition.
+func (r *ReentrantLock) Lock(owner string) error {
+	return r.LockContext(context.Background(), owner)
+}
+
+// LockContext is like Lock but gives up waiting once ctx is done, see
+// Lock.LockContext.
+func (r *ReentrantLock) LockContext(ctx context.Context, owner string) error {
+	if r.reenter(owner) {
+		return nil
+	}
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := r.lock.LockContext(ctx); err != nil {
+		<-r.sem
+		return err
+	}
+	r.mu.Lock()
+	r.owner, r.count = owner, 1
+	r.mu.Unlock()
+	return nil
+}
+
+// reenter counts an acquisition if owner holds the lock.
+func (r *ReentrantLock) reenter(owner string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.count == 0 || r.owner != owner {
+		return false
+	}
+	r.count++
+	return true
+}
+
+// Unlock releases one acquisition of the lock by owner, and the lock once
+// none is left. ErrNotLocked is returned if owner does not hold the lock.
+func (r *ReentrantLock) Unlock(owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.count == 0 || r.owner != owner {
+		return ErrNotLocked
+	}
+	if r.count > 1 {
+		r.count--
+		return nil
+	}
+	if err := r.lock.Unlock(); err != nil {
+		return err
+	}
+	r.owner, r.count = "", 0
+	<-r.sem
+	return nil
+}
+
+// HoldCount returns how many times owner acquired the lock without
+// releasing it.
+func (r *ReentrantLock) HoldCount(owner string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owner != owner {
+		return 0
+	}
+	return r.count
+}
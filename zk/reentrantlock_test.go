@@ -0,0 +1,57 @@
+package zk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReentrantLock(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	l := NewReentrantLock(zk, "/lock", WorldACL(PermAll))
+
+	done := make(chan error, 1)
+	go func() { done <- l.Lock("a") }()
+	serveFake(t, fc, "create", "", nil, &createResponse{Path: "/lock/_c_a-lock-0000000001"})
+	serveFake(t, fc, "getChildren2", "/lock", nil, &getChildren2Response{Children: []string{"_c_a-lock-0000000001"}})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Acquiring again only counts.
+	if err := l.Lock("a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := l.HoldCount("a"); n != 2 {
+		t.Fatalf("Hold count %d, expected 2", n)
+	}
+
+	// Another owner waits, without queuing while the lock is held here.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.LockContext(ctx, "b"); err != context.DeadlineExceeded {
+		t.Fatalf("LockContext returned %+v", err)
+	}
+	if err := l.Unlock("b"); err != ErrNotLocked {
+		t.Fatalf("Unlock by another owner returned %+v", err)
+	}
+
+	if err := l.Unlock("a"); err != nil {
+		t.Fatal(err)
+	}
+	go func() { done <- l.Unlock("a") }()
+	serveFake(t, fc, "delete", "/lock/_c_a-lock-0000000001", nil, nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := l.HoldCount("a"); n != 0 {
+		t.Fatalf("Hold count %d after unlocking", n)
+	}
+	if err := l.Unlock("a"); err != ErrNotLocked {
+		t.Fatalf("Unlock of a released lock returned %+v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package zk
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnInfo describes a connection registered with a Registry.
+type ConnInfo struct {
+	Name      string   `json:"name"`
+	SessionID int64    `json:"session_id"`
+	State     string   `json:"state"`
+	Server    string   `json:"server"`  // server currently connected to
+	Servers   []string `json:"servers"` // configured servers
+	TLS       bool     `json:"tls"`
+
+	PendingRequests    int `json:"pending_requests"`
+	Watchers           int `json:"watchers"`
+	PersistentWatchers int `json:"persistent_watchers"`
+	PendingDeletes     int `json:"pending_deletes"`
+
+	LastZxid           int64  `json:"last_zxid"`
+	Reconnects         uint64 `json:"reconnects"`
+	SessionExpirations uint64 `json:"session_expirations"`
+}
+
+// Registry keeps track of live connections so that applications using
+// several of them, e.g. pools, can inspect all of them in one place.
+// Connections are added with WithRegistry and removed once closed.
+//
+// A Registry is an expvar.Var, so it can be published with expvar.Publish,
+// and an http.Handler serving the same JSON.
+type Registry struct {
+	mu    sync.Mutex
+	conns map[*Conn]string // conn -> name
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{conns: make(map[*Conn]string)}
+}
+
+// WithRegistry returns a connection option that adds the connection to r
+// under name until it is closed. Names need not be unique.
+func WithRegistry(r *Registry, name string) connOption {
+	return func(c *Conn) {
+		c.registry = r
+		c.registryName = name
+	}
+}
+
+func (r *Registry) add(c *Conn, name string) {
+	r.mu.Lock()
+	r.conns[c] = name
+	r.mu.Unlock()
+}
+
+func (r *Registry) remove(c *Conn) {
+	r.mu.Lock()
+	delete(r.conns, c)
+	r.mu.Unlock()
+}
+
+// Conns returns information about the registered connections, ordered by
+// name.
+func (r *Registry) Conns() []ConnInfo {
+	r.mu.Lock()
+	conns := make(map[*Conn]string, len(r.conns))
+	for c, name := range r.conns {
+		conns[c] = name
+	}
+	r.mu.Unlock()
+
+	infos := make([]ConnInfo, 0, len(conns))
+	for c, name := range conns {
+		infos = append(infos, c.info(name))
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// String returns the registered connections as JSON.
+func (r *Registry) String() string {
+	b, err := json.Marshal(r.Conns())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+// ServeHTTP serves the registered connections as JSON.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(r.String()))
+}
+
+// info returns information about the connection.
+func (c *Conn) info(name string) ConnInfo {
+	info := ConnInfo{
+		Name:      name,
+		SessionID: c.SessionID(),
+		State:     c.State().String(),
+		Server:    c.Server(),
+		Servers:   c.configuredServers(),
+		TLS:       c.tlsConfig != nil,
+	}
+
+	c.requestsLock.Lock()
+	info.PendingRequests = len(c.requests)
+	c.requestsLock.Unlock()
+
+	c.watchersLock.Lock()
+	for _, watchers := range c.watchers {
+		info.Watchers += len(watchers)
+	}
+	for _, watchers := range c.persistentWatchers {
+		info.PersistentWatchers += len(watchers)
+	}
+	c.watchersLock.Unlock()
+
+	c.pendingDeletesLock.Lock()
+	info.PendingDeletes = len(c.pendingDeletes)
+	c.pendingDeletesLock.Unlock()
+
+	info.LastZxid = atomic.LoadInt64(&c.lastZxid)
+	info.Reconnects, info.SessionExpirations = c.metrics.sessions()
+	return info
+}
+
+// DebugSnapshot returns information about the connection, as a Registry
+// reports it, e.g. for the health page of an application. The name is the
+// one given to WithRegistry, if any. To serve it at /debug/vars without a
+// Registry, publish it with expvar.Publish(name, expvar.Func(...)).
+func (c *Conn) DebugSnapshot() ConnInfo {
+	return c.info(c.registryName)
+}
@@ -0,0 +1,81 @@
+package zk
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	r := NewRegistry()
+	zk, _, err := Connect([]string{"127.0.0.1:32444"}, time.Second*15, WithRegistry(r, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	other, _, err := Connect([]string{"127.0.0.1:32445"}, time.Second*15, WithRegistry(r, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := r.Conns()
+	if len(infos) != 2 || infos[0].Name != "a" || infos[1].Name != "b" {
+		t.Fatalf("Conns returned %+v", infos)
+	}
+	if len(infos[1].Servers) != 1 || infos[1].Servers[0] != "127.0.0.1:32444" {
+		t.Fatalf("Conns returned the wrong servers %v", infos[1].Servers)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/zk", nil))
+	var served []ConnInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatalf("ServeHTTP served invalid JSON %q: %+v", rec.Body.String(), err)
+	}
+	if len(served) != 2 {
+		t.Fatalf("ServeHTTP served %+v", served)
+	}
+
+	other.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.Conns()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Closed connection was not removed from the registry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDebugSnapshot(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	go zk.Exists("/a")
+	req, err := fc.ExpectRequest("exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := zk.DebugSnapshot(); info.PendingRequests != 1 || info.State != "StateHasSession" || info.SessionID != 1 {
+		t.Fatalf("DebugSnapshot returned %+v", info)
+	}
+	if err := fc.Reply(req, 7, nil, &existsResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(fakeTimeout)
+	for zk.DebugSnapshot().PendingRequests != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Request still pending")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	info := zk.DebugSnapshot()
+	if info.LastZxid != 7 || info.Reconnects != 0 || info.Server != "127.0.0.1:2181" {
+		t.Fatalf("DebugSnapshot returned %+v", info)
+	}
+}
@@ -0,0 +1,319 @@
+package zk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReplicationConflict is returned by Replicator.Run with ConflictStop when
+// a destination node was changed by someone other than the replicator.
+var ErrReplicationConflict = errors.New("zk: destination node changed outside the replicator")
+
+// replicatorRetryDelay is how long a Replicator waits before re-syncing after
+// a connection error on the destination.
+var replicatorRetryDelay = time.Second
+
+// ConflictPolicy decides what a Replicator does with a destination node that
+// was changed by someone other than the replicator.
+type ConflictPolicy int
+
+const (
+	// ConflictSourceWins overwrites or deletes the destination node to match
+	// the source.
+	ConflictSourceWins ConflictPolicy = iota
+	// ConflictDestinationWins leaves the destination node alone.
+	ConflictDestinationWins
+	// ConflictStop makes Run return ErrReplicationConflict.
+	ConflictStop
+)
+
+// ReplicatorStats are the counters and lag of a Replicator.
+type ReplicatorStats struct {
+	Applied   uint64 // nodes created or updated on the destination
+	Deleted   uint64 // nodes deleted from the destination
+	Conflicts uint64 // destination nodes changed outside the replicator
+	Resyncs   uint64 // full comparisons of the subtrees
+
+	// LastZxid is the Mzxid of the last source change applied.
+	LastZxid int64
+	// Lag is the time between the last source change applied and its
+	// application, as measured against the clock of the source server.
+	Lag time.Duration
+}
+
+// Replicator copies a subtree of one ensemble to another and keeps it up to
+// date, e.g. to migrate clients from one ensemble to another. It watches the
+// source with a recursive persistent watch and compares the whole subtrees
+// whenever changes may have been missed, so it requires ZooKeeper 3.6 or
+// later on the source. Ephemeral nodes are not replicated, as they belong to
+// sessions of the source ensemble.
+type Replicator struct {
+	src, dst         *Conn
+	srcPath, dstPath string
+	acl              []ACL
+	policy           ConflictPolicy
+
+	mu      sync.Mutex
+	stats   ReplicatorStats
+	written map[string]int32 // destination path -> version last written
+}
+
+// NewReplicator creates a replicator copying the subtree at srcPath on src to
+// dstPath on dst. It does nothing until Run is called.
+func NewReplicator(src, dst *Conn, srcPath, dstPath string) *Replicator {
+	return &Replicator{
+		src:     src,
+		dst:     dst,
+		srcPath: srcPath,
+		dstPath: dstPath,
+		acl:     WorldACL(PermAll),
+		written: make(map[string]int32),
+	}
+}
+
+// SetConflictPolicy sets what happens with destination nodes changed outside
+// the replicator. It defaults to ConflictSourceWins.
+func (r *Replicator) SetConflictPolicy(policy ConflictPolicy) {
+	r.policy = policy
+}
+
+// SetACL sets the ACL of the nodes created on the destination. It defaults
+// to WorldACL(PermAll).
+func (r *Replicator) SetACL(acl []ACL) {
+	r.acl = acl
+}
+
+// Stats returns the current counters and lag.
+func (r *Replicator) Stats() ReplicatorStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Run replicates until ctx is done, the watch on the source is lost or, with
+// ConflictStop, a conflict is found. It starts with a full comparison of the
+// subtrees.
+func (r *Replicator) Run(ctx context.Context) error {
+	ch, err := r.src.AddWatch(r.srcPath, WatchModePersistentRecursive)
+	if err != nil {
+		return err
+	}
+	defer r.src.RemoveWatches(r.srcPath, ch)
+
+	var retry <-chan time.Time
+	handle := func(err error) error {
+		if isConnectionError(err) {
+			// Compare everything again once the connection is back.
+			retry = time.After(replicatorRetryDelay)
+			return nil
+		}
+		return err
+	}
+	if err := handle(r.resync()); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-retry:
+			retry = nil
+			if err := handle(r.resync()); err != nil {
+				return err
+			}
+		case ev, ok := <-ch:
+			if !ok {
+				return ErrNoServer
+			}
+			switch ev.Type {
+			case EventNotWatching:
+				return ev.Err
+			case EventSession:
+				if ev.State == StateHasSession {
+					err = r.resync()
+				}
+			case EventNodeCreated, EventNodeDataChanged:
+				err = r.update(ev.Path)
+			case EventNodeDeleted:
+				err = r.delete(r.destination(ev.Path))
+			}
+			if err := handle(err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// destination returns the destination path of the source path p.
+func (r *Replicator) destination(p string) string {
+	rel := strings.TrimPrefix(p, r.srcPath)
+	switch {
+	case rel == "":
+		return r.dstPath
+	case r.srcPath == "/":
+		rel = "/" + rel
+	}
+	if r.dstPath == "/" {
+		return rel
+	}
+	return r.dstPath + rel
+}
+
+// resync makes the destination subtree match the source subtree.
+func (r *Replicator) resync() error {
+	r.mu.Lock()
+	r.stats.Resyncs++
+	r.mu.Unlock()
+
+	srcNodes, err := r.src.Prefetch(r.srcPath, -1, exportConcurrency)
+	if err != nil {
+		return err
+	}
+	dstNodes, err := r.dst.Prefetch(r.dstPath, -1, exportConcurrency)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(srcNodes))
+	paths := make([]string, 0, len(srcNodes))
+	for p, node := range srcNodes {
+		if node.Stat.EphemeralOwner == 0 {
+			paths = append(paths, p)
+		}
+	}
+	// Parents sort before their children.
+	sort.Strings(paths)
+	for _, p := range paths {
+		keep[r.destination(p)] = true
+		if err := r.apply(r.destination(p), srcNodes[p].Data, srcNodes[p].Stat); err != nil {
+			return err
+		}
+	}
+
+	var stale []string
+	for p := range dstNodes {
+		if !keep[p] {
+			stale = append(stale, p)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(stale)))
+	for _, p := range stale {
+		if err := r.delete(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update copies the source node at p to the destination.
+func (r *Replicator) update(p string) error {
+	data, stat, err := r.src.Get(p)
+	if err == ErrNoNode {
+		// The deletion is reported next.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if stat.EphemeralOwner != 0 {
+		return nil
+	}
+	return r.apply(r.destination(p), data, stat)
+}
+
+// apply sets the destination node at p to data, from the source node with
+// stat.
+func (r *Replicator) apply(p string, data []byte, stat *Stat) error {
+	current, dstStat, err := r.dst.Get(p)
+	if err == ErrNoNode {
+		_, err = r.dst.Create(p, data, 0, r.acl)
+		if err == ErrNoNode {
+			if err = r.dst.CreateParents(p, r.acl); err == nil {
+				_, err = r.dst.Create(p, data, 0, r.acl)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		r.applied(p, 0, stat)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if bytes.Equal(current, data) {
+		r.mu.Lock()
+		r.written[p] = dstStat.Version
+		r.mu.Unlock()
+		return nil
+	}
+	if skip, err := r.resolve(p, dstStat.Version); skip {
+		return err
+	}
+	if dstStat, err = r.dst.Set(p, data, dstStat.Version); err != nil {
+		return err
+	}
+	r.applied(p, dstStat.Version, stat)
+	return nil
+}
+
+// delete deletes the destination node at p.
+func (r *Replicator) delete(p string) error {
+	ok, stat, err := r.dst.Exists(p)
+	if err != nil || !ok {
+		return err
+	}
+	if skip, err := r.resolve(p, stat.Version); skip {
+		return err
+	}
+	switch err := r.dst.Delete(p, stat.Version); err {
+	case nil, ErrNoNode:
+	case ErrNotEmpty:
+		// Only possible with children kept by ConflictDestinationWins.
+		return nil
+	default:
+		return err
+	}
+	r.mu.Lock()
+	delete(r.written, p)
+	r.stats.Deleted++
+	r.mu.Unlock()
+	return nil
+}
+
+// resolve checks whether the destination node at p, now at version, was
+// changed since the replicator last wrote it, and applies the conflict policy
+// if so. It reports whether the node is to be left alone. Nodes the
+// replicator has not written yet always conflict.
+func (r *Replicator) resolve(p string, version int32) (skip bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if written, ok := r.written[p]; ok && written == version {
+		return false, nil
+	}
+	r.stats.Conflicts++
+	switch r.policy {
+	case ConflictDestinationWins:
+		return true, nil
+	case ConflictStop:
+		return true, ErrReplicationConflict
+	default:
+		return false, nil
+	}
+}
+
+// applied records that p was written at version from the source node with
+// stat.
+func (r *Replicator) applied(p string, version int32, stat *Stat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.written[p] = version
+	r.stats.Applied++
+	if stat.Mzxid > r.stats.LastZxid {
+		r.stats.LastZxid = stat.Mzxid
+		r.stats.Lag = time.Since(time.Unix(0, stat.Mtime*int64(time.Millisecond)))
+	}
+}
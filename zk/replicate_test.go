@@ -0,0 +1,133 @@
+package zk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplicatorDestination(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		src, dst, in, out string
+	}{
+		{"/a", "/b", "/a", "/b"},
+		{"/a", "/b", "/a/x/y", "/b/x/y"},
+		{"/", "/b", "/", "/b"},
+		{"/", "/b", "/x", "/b/x"},
+		{"/a", "/", "/a", "/"},
+		{"/a", "/", "/a/x", "/x"},
+		{"/", "/", "/x", "/x"},
+	}
+	for _, tt := range tests {
+		r := NewReplicator(nil, nil, tt.src, tt.dst)
+		if out := r.destination(tt.in); out != tt.out {
+			t.Errorf("destination(%q) from %s to %s = %q, expected %q", tt.in, tt.src, tt.dst, out, tt.out)
+		}
+	}
+}
+
+func TestReplicator(t *testing.T) {
+	src, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Stop()
+	dst, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Stop()
+	srcConn, _, err := src.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer srcConn.Close()
+	dstConn, _, err := dst.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer dstConn.Close()
+
+	for _, p := range []string{"/gozk-test-src", "/gozk-test-src/a", "/gozk-test-src/a/b"} {
+		if _, err := srcConn.Create(p, []byte(p), 0, WorldACL(PermAll)); err != nil {
+			t.Fatalf("Create returned error: %+v", err)
+		}
+	}
+	if _, err := srcConn.Create("/gozk-test-src/eph", nil, FlagEphemeral, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	for _, p := range []string{"/gozk-test-dst", "/gozk-test-dst/stale"} {
+		if _, err := dstConn.Create(p, nil, 0, WorldACL(PermAll)); err != nil {
+			t.Fatalf("Create returned error: %+v", err)
+		}
+	}
+
+	r := NewReplicator(srcConn, dstConn, "/gozk-test-src", "/gozk-test-dst")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	waitFor := func(path string, data string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, _, err := dstConn.Get(path)
+			if data == "" && err == ErrNoNode || err == nil && string(got) == data {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Destination %s is %q, %v, expected %q", path, got, err, data)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("/gozk-test-dst/a/b", "/gozk-test-src/a/b")
+	waitFor("/gozk-test-dst/stale", "")
+	if ok, _, _ := dstConn.Exists("/gozk-test-dst/eph"); ok {
+		t.Fatal("Ephemeral node was replicated")
+	}
+
+	if _, err := srcConn.Set("/gozk-test-src/a", []byte("changed"), -1); err != nil {
+		t.Fatalf("Set returned error: %+v", err)
+	}
+	waitFor("/gozk-test-dst/a", "changed")
+	if err := srcConn.Delete("/gozk-test-src/a/b", -1); err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	waitFor("/gozk-test-dst/a/b", "")
+
+	stats := r.Stats()
+	if stats.Applied < 4 || stats.Deleted != 2 || stats.Resyncs != 1 || stats.LastZxid == 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Run returned %+v, expected context.Canceled", err)
+	}
+
+	// A node changed on the destination behind the replicator's back stops it
+	// under ConflictStop.
+	if _, err := dstConn.Set("/gozk-test-dst/a", []byte("local"), -1); err != nil {
+		t.Fatalf("Set returned error: %+v", err)
+	}
+	r = NewReplicator(srcConn, dstConn, "/gozk-test-src", "/gozk-test-dst")
+	r.SetConflictPolicy(ConflictStop)
+	if err := r.Run(context.Background()); err != ErrReplicationConflict {
+		t.Fatalf("Run returned %+v, expected ErrReplicationConflict", err)
+	}
+
+	r = NewReplicator(srcConn, dstConn, "/gozk-test-src", "/gozk-test-dst")
+	r.SetConflictPolicy(ConflictDestinationWins)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Run returned %+v, expected context.DeadlineExceeded", err)
+	}
+	if data, _, err := dstConn.Get("/gozk-test-dst/a"); err != nil || string(data) != "local" {
+		t.Fatalf("Destination was overwritten with %q, %+v", data, err)
+	}
+	if stats := r.Stats(); stats.Conflicts != 1 {
+		t.Fatalf("Expected 1 conflict, got %+v", stats)
+	}
+}
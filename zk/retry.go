@@ -0,0 +1,147 @@
+package zk
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides whether to try again after a failure and how long to
+// wait before, like the RetryPolicy of Curator. It is used for reconnects with
+// WithReconnectPolicy and for reads with WithReadRetry.
+type RetryPolicy interface {
+	// AllowRetry is called before retry number retries, counting from 1,
+	// with the time elapsed since the first attempt. It returns how long to
+	// wait before the retry, and false to give up instead.
+	AllowRetry(retries int, elapsed time.Duration) (delay time.Duration, ok bool)
+}
+
+// ExponentialBackoff is a RetryPolicy that waits BaseDelay before the first
+// retry and doubles the delay for each retry after it, up to MaxDelay, with
+// up to a quarter of random jitter so that clients do not retry in lockstep.
+type ExponentialBackoff struct {
+	BaseDelay time.Duration
+	// MaxDelay caps the delay. It is unlimited if 0.
+	MaxDelay time.Duration
+	// MaxRetries is the number of retries before giving up. It retries
+	// forever if 0.
+	MaxRetries int
+}
+
+// AllowRetry implements RetryPolicy.
+func (p ExponentialBackoff) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	if p.MaxRetries > 0 && retries > p.MaxRetries {
+		return 0, false
+	}
+	delay := p.BaseDelay
+	for i := 1; i < retries && (p.MaxDelay <= 0 || delay < p.MaxDelay) && delay < math.MaxInt64/2; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay > 0 {
+		delay -= time.Duration(rand.Int63n(int64(delay)/4 + 1))
+	}
+	return delay, true
+}
+
+// BoundedRetries is a RetryPolicy that retries up to MaxRetries times,
+// waiting Delay before each retry.
+type BoundedRetries struct {
+	MaxRetries int
+	Delay      time.Duration
+}
+
+// AllowRetry implements RetryPolicy.
+func (p BoundedRetries) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	return p.Delay, retries <= p.MaxRetries
+}
+
+// RetryForever is a RetryPolicy that never gives up, waiting Delay before
+// each retry.
+type RetryForever struct {
+	Delay time.Duration
+}
+
+// AllowRetry implements RetryPolicy.
+func (p RetryForever) AllowRetry(retries int, elapsed time.Duration) (time.Duration, bool) {
+	return p.Delay, true
+}
+
+// WithReconnectPolicy returns a connection option that consults policy after
+// every round of failed connection attempts to all servers, instead of
+// always waiting a second before the next round. A retry is one more round.
+// If the policy gives up, the connection is closed: pending and further
+// requests fail with ErrClosing.
+func WithReconnectPolicy(policy RetryPolicy) connOption {
+	return func(c *Conn) {
+		c.reconnectPolicy = policy
+	}
+}
+
+// WithoutReconnect returns a connection option that disables reconnecting,
+// for applications that supervise the client themselves and decide when to
+// connect again. The connection tries each server once to establish its
+// session, and is closed as soon as it fails to or the session is lost. The
+// last event on the event channel, which is closed after it, is then an
+// EventSession event with StateDisconnected and the cause in Err:
+// ErrNoServer, ErrConnectionClosed or ErrSessionExpired. Pending and further
+// requests fail.
+func WithoutReconnect() connOption {
+	return func(c *Conn) {
+		c.noReconnect = true
+	}
+}
+
+// terminate closes the connection from the loop instead of reconnecting,
+// reporting err in a last session event.
+func (c *Conn) terminate(err error) {
+	c.closeOnce.Do(func() { close(c.shouldQuit) })
+	c.setStateErr(StateDisconnected, err)
+}
+
+// WithReadRetry returns a connection option that transparently retries
+// Get, Children, Exists and GetACL, and the iterators built on them, when
+// they fail with ErrConnectionClosed, for as long as policy allows. Reads
+// setting a watch are not retried.
+func WithReadRetry(policy RetryPolicy) connOption {
+	return func(c *Conn) {
+		c.readRetry = policy
+	}
+}
+
+// reconnectDelayFor returns how long to wait before another round of
+// connection attempts, the rounds so far having failed since start.
+func (c *Conn) reconnectDelayFor(rounds int, start time.Time) (time.Duration, bool) {
+	if c.noReconnect {
+		return 0, false
+	}
+	if c.reconnectPolicy == nil {
+		return time.Second, true
+	}
+	return c.reconnectPolicy.AllowRetry(rounds, time.Since(start))
+}
+
+// readRequest sends a read request, retrying it according to the read retry
+// policy.
+func (c *Conn) readRequest(opcode int32, req interface{}, res interface{}) error {
+	_, err := c.request(opcode, req, res, nil)
+	if c.readRetry == nil {
+		return err
+	}
+	start := time.Now()
+	for retries := 1; err == ErrConnectionClosed; retries++ {
+		delay, ok := c.readRetry.AllowRetry(retries, time.Since(start))
+		if !ok {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-c.shouldQuit:
+			return ErrClosing
+		}
+		_, err = c.request(opcode, req, res, nil)
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package zk
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	t.Parallel()
+	backoff := ExponentialBackoff{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, MaxRetries: 5}
+	for retries, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second} {
+		delay, ok := backoff.AllowRetry(retries, 0)
+		if !ok || delay > max || delay < max*3/4 {
+			t.Errorf("ExponentialBackoff.AllowRetry(%d) = %s, %v, expected about %s", retries, delay, ok, max)
+		}
+	}
+	if _, ok := backoff.AllowRetry(6, 0); ok {
+		t.Error("ExponentialBackoff allowed more than MaxRetries")
+	}
+	if delay, ok := (ExponentialBackoff{BaseDelay: time.Millisecond}).AllowRetry(100, 0); !ok || delay <= 0 {
+		t.Errorf("Unbounded ExponentialBackoff returned %s, %v", delay, ok)
+	}
+
+	bounded := BoundedRetries{MaxRetries: 2, Delay: time.Millisecond}
+	if delay, ok := bounded.AllowRetry(2, 0); !ok || delay != time.Millisecond {
+		t.Errorf("BoundedRetries.AllowRetry(2) = %s, %v", delay, ok)
+	}
+	if _, ok := bounded.AllowRetry(3, 0); ok {
+		t.Error("BoundedRetries allowed more than MaxRetries")
+	}
+	if _, ok := (RetryForever{}).AllowRetry(1000, time.Hour); !ok {
+		t.Error("RetryForever gave up")
+	}
+}
+
+func TestReadRetry(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()),
+		WithReadRetry(BoundedRetries{MaxRetries: 3, Delay: 10 * time.Millisecond}),
+		WithReconnectPolicy(RetryForever{Delay: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	type get struct {
+		data []byte
+		err  error
+	}
+	done := make(chan get, 1)
+	go func() {
+		data, _, err := zk.Get("/node")
+		done <- get{data, err}
+	}()
+	if _, err := fc.ExpectRequest("getData"); err != nil {
+		t.Fatal(err)
+	}
+	// The connection is lost before the reply, and the read is sent again
+	// once the session is resumed.
+	fc.Close()
+	fc = acceptFake(t, s, 1)
+	req, err := fc.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, nil, &getDataResponse{Data: []byte("data")}); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-done; res.err != nil || string(res.data) != "data" {
+		t.Fatalf("Get returned %q, %+v", res.data, res.err)
+	}
+}
+
+func TestReconnectPolicyGivesUp(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()),
+		WithReconnectPolicy(BoundedRetries{MaxRetries: 2, Delay: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	s.SetDialError(errors.New("connection refused"))
+	fc.Close()
+	deadline := time.After(fakeTimeout)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if _, _, err := zk.Get("/node"); err != ErrClosing {
+					t.Fatalf("Get after giving up returned %+v instead of ErrClosing", err)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("Connection not closed after the reconnect policy gave up")
+		}
+	}
+}
+
+func TestWithoutReconnect(t *testing.T) {
+	t.Parallel()
+	last := func(ch <-chan Event) Event {
+		var ev Event
+		deadline := time.After(fakeTimeout)
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return ev
+				}
+				ev = e
+			case <-deadline:
+				t.Fatal("Event channel not closed")
+			}
+		}
+	}
+
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithoutReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	fc.Close()
+	if ev := last(ch); ev.State != StateDisconnected || ev.Err != ErrConnectionClosed {
+		t.Fatalf("Unexpected last event %+v", ev)
+	}
+	if _, _, err := zk.Get("/node"); err != ErrClosing {
+		t.Fatalf("Get after the connection closed returned %+v instead of ErrClosing", err)
+	}
+	if _, err := s.Accept(100 * time.Millisecond); err == nil {
+		t.Fatal("Connection reconnected")
+	}
+
+	// Failing to connect in the first place is terminal too.
+	s.SetDialError(errors.New("connection refused"))
+	zk2, ch2, err := Connect([]string{"127.0.0.1:2181", "127.0.0.2:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithoutReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk2.Close()
+	if ev := last(ch2); ev.State != StateDisconnected || ev.Err != ErrNoServer {
+		t.Fatalf("Unexpected last event %+v", ev)
+	}
+}
@@ -0,0 +1,152 @@
+package zk
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ErrSASLMechanism is returned when a SASL mechanism receives a message it
+// cannot process.
+var ErrSASLMechanism = errors.New("zk: unexpected SASL message")
+
+// SASLMechanism implements the client side of a SASL authentication
+// mechanism. The exchange is run every time a session is established, before
+// any other request is sent over the connection.
+type SASLMechanism interface {
+	// Start begins a new exchange with server (in host:port form),
+	// discarding any previous state. It returns the initial response, which
+	// may be empty.
+	Start(server string) ([]byte, error)
+	// Next processes a challenge from the server and returns the response
+	// to send back. A nil response with Complete returning true ends the
+	// exchange without sending anything.
+	Next(challenge []byte) ([]byte, error)
+	// Complete reports whether the mechanism has finished authenticating.
+	Complete() bool
+}
+
+// WithSASL returns a connection option that authenticates every session using
+// the given SASL mechanism. If the server rejects the credentials the state
+// changes to StateAuthFailed and the connection is retried.
+func WithSASL(mechanism SASLMechanism) connOption {
+	return func(c *Conn) {
+		c.saslMechanism = mechanism
+	}
+}
+
+// SASLACL produces an ACL list containing a single ACL which uses the
+// provided permissions, with the scheme "sasl", and the authenticated
+// principal (e.g. "user@EXAMPLE.COM") as ID.
+func SASLACL(perms int32, principal string) []ACL {
+	return []ACL{{perms, "sasl", principal}}
+}
+
+// saslAuthenticate runs the SASL exchange over the freshly authenticated
+// connection. It must be called before the send and receive loops start.
+func (c *Conn) saslAuthenticate() error {
+	mech := c.saslMechanism
+	token, err := mech.Start(c.Server())
+	if err != nil {
+		return err
+	}
+	for {
+		challenge, err := c.saslRoundTrip(token)
+		if err != nil {
+			if err == ErrAuthFailed {
+				c.setState(StateAuthFailed)
+			}
+			return err
+		}
+		if mech.Complete() {
+			break
+		}
+		token, err = mech.Next(challenge)
+		if err != nil {
+			return err
+		}
+		if token == nil && mech.Complete() {
+			break
+		}
+	}
+
+	c.sendEvent(Event{Type: EventSession, State: StateSaslAuthenticated, Server: c.Server()})
+	return nil
+}
+
+// saslRoundTrip sends token to the server and waits for its reply.
+func (c *Conn) saslRoundTrip(token []byte) ([]byte, error) {
+	if token == nil {
+		token = []byte{}
+	}
+	r := setSaslResponse{}
+	if err := c.handshakeRoundTrip(opSasl, &getSaslRequest{Token: token}, &r); err != nil {
+		return nil, err
+	}
+	return r.Token, nil
+}
+
+// handshakeRoundTrip sends a request over the freshly authenticated
+// connection and decodes its response into res. It must be called before the
+// send and receive loops start.
+func (c *Conn) handshakeRoundTrip(opcode int32, req, res interface{}) error {
+	xid := c.nextXid()
+	buf := make([]byte, bufferSize)
+	n, err := encodePacket(buf[4:], &requestHeader{Xid: xid, Opcode: opcode})
+	if err != nil {
+		return err
+	}
+	n2, err := encodePacket(buf[4+n:], req)
+	if err != nil {
+		return err
+	}
+	n += n2
+	binary.BigEndian.PutUint32(buf[:4], uint32(n))
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.recvTimeout * 10))
+	_, err = c.conn.Write(buf[:n+4])
+	c.conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		blen, err := readPacket(c.conn, buf, time.Now().Add(c.recvTimeout*10))
+		if err != nil {
+			return err
+		}
+		hdr := responseHeader{}
+		if _, err := decodePacket(buf[:16], &hdr); err != nil {
+			return err
+		}
+		if hdr.Xid != xid {
+			// Ping or notification, nothing is expected before the
+			// handshake completes.
+			continue
+		}
+		if hdr.Err != 0 {
+			return hdr.Err.toError()
+		}
+		_, err = decodePacket(buf[16:blen], res)
+		return err
+	}
+}
+
+// readPacket reads a length prefixed packet into buf and returns its length.
+func readPacket(conn net.Conn, buf []byte, deadline time.Time) (int, error) {
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return 0, err
+	}
+	blen := int(binary.BigEndian.Uint32(buf[:4]))
+	if len(buf) < blen {
+		return 0, ErrShortBuffer
+	}
+	if _, err := io.ReadFull(conn, buf[:blen]); err != nil {
+		return 0, err
+	}
+	return blen, nil
+}
@@ -0,0 +1,107 @@
+package zk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDigestMD5Mechanism(t *testing.T) {
+	t.Parallel()
+	// Example exchange from RFC 2831 section 4.
+	m := &DigestMD5Mechanism{
+		Username:    "chris",
+		Password:    "secret",
+		ServiceName: "imap",
+		Host:        "elwood.innosoft.com",
+		cnonce:      "OA6MHXh6VqTrRk",
+	}
+	if token, err := m.Start("127.0.0.1:2181"); err != nil {
+		t.Fatalf("Start returned error: %+v", err)
+	} else if len(token) != 0 {
+		t.Fatalf("Start returned %q instead of an empty token", token)
+	}
+
+	challenge := `realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth",algorithm=md5-sess,charset=utf-8`
+	token, err := m.Next([]byte(challenge))
+	if err != nil {
+		t.Fatalf("Next returned error: %+v", err)
+	}
+	res := parseDigestChallenge(string(token))
+	if res["response"] != "d388dad90d4bbd760a152321f2143af7" {
+		t.Fatalf("Wrong digest response in %q", token)
+	}
+	if res["digest-uri"] != "imap/elwood.innosoft.com" || res["username"] != "chris" {
+		t.Fatalf("Wrong digest-uri or username in %q", token)
+	}
+
+	if _, err := m.Next([]byte("rspauth=00000000000000000000000000000000")); err != ErrAuthFailed {
+		t.Fatalf("Next returned %+v instead of ErrAuthFailed for a bad rspauth", err)
+	}
+	if token, err := m.Next([]byte("rspauth=ea40f60335c427b5527b84dbabcdfffd")); err != nil {
+		t.Fatalf("Next returned error: %+v", err)
+	} else if token != nil {
+		t.Fatalf("Next returned %q instead of nil after rspauth", token)
+	}
+	if !m.Complete() {
+		t.Fatal("Mechanism should be complete")
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in  string
+		out map[string]string
+	}{
+		// The challenge of RFC 2831 section 4, with the quoted lists.
+		{`realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth,auth-int",algorithm=md5-sess,charset=utf-8`, map[string]string{
+			"realm":     "elwood.innosoft.com",
+			"nonce":     "OA6MG9tEQGm2hh",
+			"qop":       "auth,auth-int",
+			"algorithm": "md5-sess",
+			"charset":   "utf-8",
+		}},
+		{`rspauth=ea40f60335c427b5527b84dbabcdfffd`, map[string]string{"rspauth": "ea40f60335c427b5527b84dbabcdfffd"}},
+		// Linear white space around the separators, and escaped quotes.
+		{` realm = "a \"quoted\" realm" ,, nonce="x\\y", stale=true `, map[string]string{
+			"realm": `a "quoted" realm`,
+			"nonce": `x\y`,
+			"stale": "true",
+		}},
+		{`nonce="unterminated`, map[string]string{"nonce": "unterminated"}},
+		{``, map[string]string{}},
+		{`garbage`, map[string]string{}},
+	}
+	for _, tt := range tests {
+		if out := parseDigestChallenge(tt.in); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("parseDigestChallenge(%q) = %q, expected %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestDigestMD5ResponseAuth(t *testing.T) {
+	t.Parallel()
+	// The response-auth of RFC 2831 section 4, which the server proves it
+	// knows the password with.
+	m := &DigestMD5Mechanism{
+		Username:    "chris",
+		Password:    "secret",
+		ServiceName: "imap",
+		Host:        "elwood.innosoft.com",
+		cnonce:      "OA6MHXh6VqTrRk",
+	}
+	if _, err := m.Next([]byte(`realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth",algorithm=md5-sess,charset=utf-8`)); err != nil {
+		t.Fatalf("Next returned error: %+v", err)
+	}
+	if expected := "ea40f60335c427b5527b84dbabcdfffd"; m.rspauth != expected {
+		t.Fatalf("Expected rspauth %s, computed %s", expected, m.rspauth)
+	}
+
+	// A challenge without a nonce or offering no auth qop is refused.
+	for _, challenge := range []string{`realm="r",qop="auth"`, `nonce="n",qop="auth-int,auth-conf"`} {
+		m := &DigestMD5Mechanism{Username: "u", Password: "p"}
+		if _, err := m.Next([]byte(challenge)); err != ErrSASLMechanism {
+			t.Errorf("Next(%q) returned %v instead of ErrSASLMechanism", challenge, err)
+		}
+	}
+}
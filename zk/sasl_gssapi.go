@@ -0,0 +1,198 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// GSSAPIClient is a client-side Kerberos security context as provided by a
+// Kerberos library. The library is responsible for logging in the client
+// principal, e.g. from a keytab or credential cache, so this package does not
+// depend on any particular Kerberos implementation.
+type GSSAPIClient interface {
+	// InitSecContext starts or continues establishing a security context
+	// with the service principal target (e.g. "zookeeper/zk1.example.com").
+	// input is nil on the first call and the server's token afterwards. It
+	// returns the token to send to the server and whether the context is
+	// established.
+	InitSecContext(target string, input []byte) (output []byte, established bool, err error)
+	// Wrap protects message using the established context.
+	Wrap(message []byte) ([]byte, error)
+	// Unwrap verifies and unwraps a token produced by the server.
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// GSSAPILogin logs principal in with a Kerberos library and returns its
+// security context. The key of the principal is read from the keytab file at
+// keytab, or taken from the credential cache if keytab is empty.
+type GSSAPILogin func(principal, keytab string) (GSSAPIClient, error)
+
+// KerberosConfig is the Kerberos configuration of a connection, as in the
+// Client section of the JAAS configuration of the Java client.
+type KerberosConfig struct {
+	// Principal is the client principal, with its realm, e.g.
+	// "app/host.example.com@EXAMPLE.COM".
+	Principal string
+	// Keytab is the path of the keytab holding the key of Principal, or
+	// empty to use the credential cache.
+	Keytab string
+	// ServiceName is the primary of the server principal, "zookeeper" if
+	// empty, as zookeeper.sasl.client.username of the Java client.
+	ServiceName string
+	// Host is the instance of the server principal, the host of the server
+	// connected to if empty.
+	Host string
+}
+
+// validateKerberos checks that principal has a realm and that keytab, if
+// set, is a readable file.
+func validateKerberos(principal, keytab string) error {
+	at := strings.LastIndex(principal, "@")
+	if at <= 0 || at == len(principal)-1 {
+		return fmt.Errorf("zk: invalid Kerberos principal %q: must be primary[/instance]@REALM", principal)
+	}
+	if keytab != "" {
+		f, err := os.Open(keytab)
+		if err != nil {
+			return fmt.Errorf("zk: keytab of %s: %v", principal, err)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// WithKerberos returns a connection option that authenticates every session
+// with the GSSAPI mechanism as cfg.Principal, which is logged in with login
+// on the first connection. If the principal or keytab is invalid, or login
+// fails, the connection is retried and logged in again. SASLACL gives the
+// principal access to nodes.
+func WithKerberos(cfg KerberosConfig, login GSSAPILogin) connOption {
+	return WithSASL(&GSSAPIMechanism{
+		Principal:   cfg.Principal,
+		Keytab:      cfg.Keytab,
+		Login:       login,
+		ServiceName: cfg.ServiceName,
+		Host:        cfg.Host,
+	})
+}
+
+// GSSAPIMechanism implements the SASL GSSAPI mechanism (RFC 4752) used to
+// authenticate to Kerberized ensembles.
+type GSSAPIMechanism struct {
+	// Client provides the Kerberos security context. If nil, it is logged
+	// in with Login as Principal from Keytab on the first Start.
+	Client    GSSAPIClient
+	Principal string
+	Keytab    string
+	Login     GSSAPILogin
+	// ServiceName is the primary of the server principal. It defaults to
+	// "zookeeper", matching the Java client.
+	ServiceName string
+	// Host is the instance of the server principal. It defaults to the host
+	// of the server being connected to.
+	Host string
+	// AuthzID is an optional authorization identity.
+	AuthzID string
+
+	target      string
+	established bool
+	complete    bool
+}
+
+// errNoGSSAPIClient is returned by Start if the mechanism has neither a
+// Client nor a Login.
+var errNoGSSAPIClient = errors.New("zk: GSSAPI mechanism without a client or login")
+
+// client returns the security context, logging the principal in if needed.
+func (m *GSSAPIMechanism) client() (GSSAPIClient, error) {
+	if m.Client != nil {
+		return m.Client, nil
+	}
+	if m.Login == nil {
+		return nil, errNoGSSAPIClient
+	}
+	if err := validateKerberos(m.Principal, m.Keytab); err != nil {
+		return nil, err
+	}
+	client, err := m.Login(m.Principal, m.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("zk: Kerberos login of %s: %v", m.Principal, err)
+	}
+	m.Client = client
+	return client, nil
+}
+
+// Start begins a new security context with server.
+func (m *GSSAPIMechanism) Start(server string) ([]byte, error) {
+	client, err := m.client()
+	if err != nil {
+		return nil, err
+	}
+	service := m.ServiceName
+	if service == "" {
+		service = "zookeeper"
+	}
+	host := m.Host
+	if host == "" {
+		h, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, err
+		}
+		host = h
+	}
+	m.target = service + "/" + host
+	m.established = false
+	m.complete = false
+
+	output, established, err := client.InitSecContext(m.target, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.established = established
+	return output, nil
+}
+
+// Next processes a challenge from the server.
+func (m *GSSAPIMechanism) Next(challenge []byte) ([]byte, error) {
+	if m.complete {
+		return nil, nil
+	}
+	if !m.established {
+		output, established, err := m.Client.InitSecContext(m.target, challenge)
+		if err != nil {
+			return nil, err
+		}
+		m.established = established
+		if output == nil {
+			output = []byte{}
+		}
+		return output, nil
+	}
+
+	// The context is established: the server offers its security layers and
+	// maximum message size. No security layer is used beyond authentication.
+	msg, err := m.Client.Unwrap(challenge)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) != 4 || msg[0]&gssapiNoSecurityLayer == 0 {
+		return nil, ErrSASLMechanism
+	}
+	reply := append([]byte{gssapiNoSecurityLayer, 0, 0, 0}, m.AuthzID...)
+	wrapped, err := m.Client.Wrap(reply)
+	if err != nil {
+		return nil, err
+	}
+	m.complete = true
+	return wrapped, nil
+}
+
+// Complete reports whether authentication has finished.
+func (m *GSSAPIMechanism) Complete() bool {
+	return m.complete
+}
+
+const gssapiNoSecurityLayer = 1
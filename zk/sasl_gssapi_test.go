@@ -0,0 +1,158 @@
+package zk
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeGSSAPIClient establishes a context after a single round trip and
+// wraps messages by prefixing them with "wrap:".
+type fakeGSSAPIClient struct {
+	target string
+}
+
+func (c *fakeGSSAPIClient) InitSecContext(target string, input []byte) ([]byte, bool, error) {
+	c.target = target
+	if input == nil {
+		return []byte("ap-req"), false, nil
+	}
+	if string(input) != "ap-rep" {
+		return nil, false, ErrSASLMechanism
+	}
+	return nil, true, nil
+}
+
+func (c *fakeGSSAPIClient) Wrap(message []byte) ([]byte, error) {
+	return append([]byte("wrap:"), message...), nil
+}
+
+func (c *fakeGSSAPIClient) Unwrap(token []byte) ([]byte, error) {
+	if !bytes.HasPrefix(token, []byte("wrap:")) {
+		return nil, ErrSASLMechanism
+	}
+	return token[5:], nil
+}
+
+func TestGSSAPIMechanism(t *testing.T) {
+	t.Parallel()
+	client := &fakeGSSAPIClient{}
+	m := &GSSAPIMechanism{Client: client, AuthzID: "user"}
+
+	if token, err := m.Start("zk1.example.com:2181"); err != nil {
+		t.Fatalf("Start returned error: %+v", err)
+	} else if string(token) != "ap-req" {
+		t.Fatalf("Start returned %q instead of ap-req", token)
+	}
+	if client.target != "zookeeper/zk1.example.com" {
+		t.Fatalf("Wrong service principal %s", client.target)
+	}
+
+	if token, err := m.Next([]byte("ap-rep")); err != nil {
+		t.Fatalf("Next returned error: %+v", err)
+	} else if token == nil || len(token) != 0 {
+		t.Fatalf("Next returned %q instead of an empty token", token)
+	}
+	if m.Complete() {
+		t.Fatal("Mechanism should not be complete before security layer negotiation")
+	}
+
+	token, err := m.Next([]byte("wrap:\x07\x00\x10\x00"))
+	if err != nil {
+		t.Fatalf("Next returned error: %+v", err)
+	} else if expected := "wrap:\x01\x00\x00\x00user"; string(token) != expected {
+		t.Fatalf("Next returned %q instead of %q", token, expected)
+	}
+	if !m.Complete() {
+		t.Fatal("Mechanism should be complete")
+	}
+}
+
+func TestGSSAPILogin(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk-keytab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keytab := filepath.Join(dir, "client.keytab")
+	if err := ioutil.WriteFile(keytab, []byte{5, 2}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logins []string
+	login := func(principal, keytab string) (GSSAPIClient, error) {
+		logins = append(logins, principal+" "+keytab)
+		if principal == "unknown@EXAMPLE.COM" {
+			return nil, errors.New("client not found in Kerberos database")
+		}
+		return &fakeGSSAPIClient{}, nil
+	}
+	for _, m := range []*GSSAPIMechanism{
+		{},
+		{Login: login, Principal: "app"},
+		{Login: login, Principal: "app@"},
+		{Login: login, Principal: "app@EXAMPLE.COM", Keytab: filepath.Join(dir, "missing.keytab")},
+		{Login: login, Principal: "unknown@EXAMPLE.COM", Keytab: keytab},
+	} {
+		if _, err := m.Start("zk1:2181"); err == nil {
+			t.Errorf("Start as %q from %q returned no error", m.Principal, m.Keytab)
+		}
+	}
+	if len(logins) != 1 {
+		t.Fatalf("Logged in %v, expected only the unknown principal", logins)
+	}
+
+	logins = nil
+	m := &GSSAPIMechanism{Login: login, Principal: "app/host@EXAMPLE.COM", Keytab: keytab}
+	for i := 0; i < 2; i++ {
+		if token, err := m.Start("zk1:2181"); err != nil || string(token) != "ap-req" {
+			t.Fatalf("Start returned %q, %+v", token, err)
+		}
+	}
+	if len(logins) != 1 || logins[0] != "app/host@EXAMPLE.COM "+keytab {
+		t.Fatalf("Logged in %v instead of once from the keytab", logins)
+	}
+}
+
+func TestKerberosSession(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	client := &fakeGSSAPIClient{}
+	login := func(principal, keytab string) (GSSAPIClient, error) { return client, nil }
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()),
+		WithKerberos(KerberosConfig{Principal: "app@EXAMPLE.COM", ServiceName: "zk", Host: "zk1.example.com"}, login))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+
+	// The tokens of the GSSAPI exchange of RFC 4752, with the fake security
+	// context of fakeGSSAPIClient.
+	for _, exchange := range []struct{ token, challenge string }{
+		{"ap-req", "ap-rep"},
+		{"", "wrap:\x07\x00\x10\x00"},
+		{"wrap:\x01\x00\x00\x00", ""},
+	} {
+		req, err := fc.ExpectRequest("sasl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token := req.Body.(*getSaslRequest).Token; !bytes.Equal(token, []byte(exchange.token)) {
+			t.Fatalf("Sent token %q instead of %q", token, exchange.token)
+		}
+		if err := fc.Reply(req, 0, nil, &setSaslResponse{Token: []byte(exchange.challenge)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForState(t, ch, StateSaslAuthenticated)
+	if client.target != "zk/zk1.example.com" {
+		t.Fatalf("Wrong service principal %s", client.target)
+	}
+}
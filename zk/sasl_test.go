@@ -0,0 +1,11 @@
+package zk
+
+import (
+	"testing"
+)
+
+func TestEncodeDecodeSASL(t *testing.T) {
+	t.Parallel()
+	encodeDecodeTest(t, &getSaslRequest{[]byte("token")})
+	encodeDecodeTest(t, &setSaslResponse{[]byte("token")})
+}
@@ -0,0 +1,170 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// scenarioQuorumTimeout is how long RunScenario waits for the cluster to
+// elect a leader after a disruption.
+const scenarioQuorumTimeout = 30 * time.Second
+
+// ErrNoLeader is returned by the scenario helpers of TestCluster when no
+// server of the cluster is the leader.
+var ErrNoLeader = errors.New("zk: no leader in the test cluster")
+
+// ScenarioPhase is the state of the cluster in which RunScenario calls its
+// check.
+type ScenarioPhase int
+
+const (
+	// PhaseBefore is before the cluster is disrupted.
+	PhaseBefore ScenarioPhase = iota
+	// PhaseDisrupted is while the cluster is disrupted. Clients lose their
+	// connections to the servers stopped, and all of them if the scenario
+	// leaves no quorum.
+	PhaseDisrupted
+	// PhaseRestored is once the disruption was undone and the cluster has
+	// a leader again.
+	PhaseRestored
+)
+
+func (p ScenarioPhase) String() string {
+	switch p {
+	case PhaseBefore:
+		return "before"
+	case PhaseDisrupted:
+		return "disrupted"
+	case PhaseRestored:
+		return "restored"
+	}
+	return "unknown"
+}
+
+// Scenario is a disruption of a TestCluster to test recipes against, with
+// RunScenario. The cluster must have at least three servers.
+type Scenario struct {
+	Name string
+	// Quorum is whether the cluster keeps a quorum while disrupted, so that
+	// requests are still served once clients reconnected.
+	Quorum bool
+	// Disrupt disrupts the cluster and returns the function undoing it.
+	Disrupt func(tc *TestCluster) (restore func() error, err error)
+}
+
+var (
+	// ScenarioKillLeader stops the leader and starts it again once the
+	// other servers elected a new one, like TestClientClusterFailover.
+	ScenarioKillLeader = Scenario{Name: "kill leader", Quorum: true, Disrupt: killLeader}
+	// ScenarioLoseQuorum stops the leader and then servers until only a
+	// minority is left, like TestNoQuorum, and starts them again.
+	ScenarioLoseQuorum = Scenario{Name: "lose quorum", Quorum: false, Disrupt: loseQuorum}
+)
+
+// Scenarios returns every predefined scenario.
+func Scenarios() []Scenario {
+	return []Scenario{ScenarioKillLeader, ScenarioLoseQuorum}
+}
+
+// RunScenario calls check before s disrupts the cluster, while it is
+// disrupted and once it is restored and has a leader again. It returns the
+// first error of check, prefixed with the scenario and the phase, or of
+// disrupting or restoring the cluster. The cluster is restored even if check
+// fails while it is disrupted.
+func (tc *TestCluster) RunScenario(s Scenario, check func(ScenarioPhase) error) error {
+	if err := tc.WaitForLeader(scenarioQuorumTimeout); err != nil {
+		return err
+	}
+	if err := check(PhaseBefore); err != nil {
+		return fmt.Errorf("%s, %s: %s", s.Name, PhaseBefore, err)
+	}
+	restore, err := s.Disrupt(tc)
+	if err != nil {
+		return fmt.Errorf("%s: disrupting the cluster: %s", s.Name, err)
+	}
+	checkErr := check(PhaseDisrupted)
+	if err := restore(); err != nil {
+		return fmt.Errorf("%s: restoring the cluster: %s", s.Name, err)
+	}
+	if checkErr != nil {
+		return fmt.Errorf("%s, %s: %s", s.Name, PhaseDisrupted, checkErr)
+	}
+	if err := check(PhaseRestored); err != nil {
+		return fmt.Errorf("%s, %s: %s", s.Name, PhaseRestored, err)
+	}
+	return nil
+}
+
+// Leader returns the client address of the server that is the leader, as
+// reported by the srvr four letter word, or ErrNoLeader.
+func (tc *TestCluster) Leader() (string, error) {
+	addrs := make([]string, len(tc.Servers))
+	for i, s := range tc.Servers {
+		addrs[i] = s.Addr()
+	}
+	stats, _ := FLWSrvr(addrs, time.Second)
+	for i, s := range stats {
+		if s != nil && s.Error == nil && (s.Mode == ModeLeader || s.Mode == ModeStandalone) {
+			return addrs[i], nil
+		}
+	}
+	return "", ErrNoLeader
+}
+
+// WaitForLeader waits up to timeout for a server of the cluster to be the
+// leader.
+func (tc *TestCluster) WaitForLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := tc.Leader()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func killLeader(tc *TestCluster) (func() error, error) {
+	leader, err := tc.Leader()
+	if err != nil {
+		return nil, err
+	}
+	tc.StopServer(leader)
+	if err := tc.WaitForLeader(scenarioQuorumTimeout); err != nil {
+		return nil, err
+	}
+	return func() error {
+		if err := tc.testServer(leader).Srv.Start(); err != nil {
+			return err
+		}
+		return tc.WaitForLeader(scenarioQuorumTimeout)
+	}, nil
+}
+
+func loseQuorum(tc *TestCluster) (func() error, error) {
+	leader, err := tc.Leader()
+	if err != nil {
+		return nil, err
+	}
+	stopped := []string{leader}
+	for _, s := range tc.Servers {
+		if len(stopped) > len(tc.Servers)/2 {
+			break
+		}
+		if s.Addr() != leader {
+			stopped = append(stopped, s.Addr())
+		}
+	}
+	for _, server := range stopped {
+		tc.StopServer(server)
+	}
+	return func() error {
+		for _, server := range stopped {
+			if err := tc.testServer(server).Srv.Start(); err != nil {
+				return err
+			}
+		}
+		return tc.WaitForLeader(scenarioQuorumTimeout)
+	}, nil
+}
@@ -5,9 +5,13 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,14 +20,29 @@ func init() {
 }
 
 type TestServer struct {
-	Port int
-	Path string
-	Srv  *Server
+	Port               int    // client port
+	AdminPort          int    // AdminServer port
+	PeerPort           int    // quorum port
+	LeaderElectionPort int    // leader election port
+	Path               string // data dir
+	ConfigPath         string // zoo.cfg
+	LogPath            string // output of the server process
+	Srv                *Server
+
+	log *os.File
+}
+
+// Addr returns the client address of the server.
+func (s *TestServer) Addr() string {
+	return fmt.Sprintf("127.0.0.1:%d", s.Port)
 }
 
 type TestCluster struct {
 	Path    string
 	Servers []TestServer
+
+	dialsLock sync.Mutex
+	dials     map[string]int // server address -> connection attempts
 }
 
 func StartTestCluster(size int, stdout, stderr io.Writer) (*TestCluster, error) {
@@ -44,17 +63,18 @@ func StartTestCluster(size int, stdout, stderr io.Writer) (*TestCluster, error)
 		if err := os.Mkdir(srvPath, 0700); err != nil {
 			return nil, err
 		}
-		port := startPort + serverN*3
+		port := startPort + serverN*4
 		cfg := ServerConfig{
-			ClientPort: port,
-			DataDir:    srvPath,
+			ClientPort:      port,
+			DataDir:         srvPath,
+			AdminServerPort: port + 3,
 		}
 		for i := 0; i < size; i++ {
 			cfg.Servers = append(cfg.Servers, ServerConfigServer{
 				ID:                 i + 1,
 				Host:               "127.0.0.1",
-				PeerPort:           startPort + i*3 + 1,
-				LeaderElectionPort: startPort + i*3 + 2,
+				PeerPort:           startPort + i*4 + 1,
+				LeaderElectionPort: startPort + i*4 + 2,
 			})
 		}
 		cfgPath := filepath.Join(srvPath, "zoo.cfg")
@@ -78,19 +98,30 @@ func StartTestCluster(size int, stdout, stderr io.Writer) (*TestCluster, error)
 			return nil, err
 		}
 
+		logPath := filepath.Join(srvPath, "zookeeper.out")
+		log, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
 		srv := &Server{
 			ConfigPath: cfgPath,
-			Stdout:     stdout,
-			Stderr:     stderr,
+			Stdout:     teeWriter(log, stdout),
+			Stderr:     teeWriter(log, stderr),
 		}
+		cluster.Servers = append(cluster.Servers, TestServer{
+			Port:               cfg.ClientPort,
+			AdminPort:          cfg.AdminServerPort,
+			PeerPort:           cfg.Servers[serverN].PeerPort,
+			LeaderElectionPort: cfg.Servers[serverN].LeaderElectionPort,
+			Path:               srvPath,
+			ConfigPath:         cfgPath,
+			LogPath:            logPath,
+			Srv:                srv,
+			log:                log,
+		})
 		if err := srv.Start(); err != nil {
 			return nil, err
 		}
-		cluster.Servers = append(cluster.Servers, TestServer{
-			Path: srvPath,
-			Port: cfg.ClientPort,
-			Srv:  srv,
-		})
 	}
 	if err := cluster.waitForStart(10, time.Second); err != nil {
 		return nil, err
@@ -100,7 +131,7 @@ func StartTestCluster(size int, stdout, stderr io.Writer) (*TestCluster, error)
 }
 
 func (ts *TestCluster) Connect(idx int) (*Conn, error) {
-	zk, _, err := Connect([]string{fmt.Sprintf("127.0.0.1:%d", ts.Servers[idx].Port)}, time.Second*15)
+	zk, _, err := Connect([]string{fmt.Sprintf("127.0.0.1:%d", ts.Servers[idx].Port)}, time.Second*15, WithDialer(ts.dial))
 	return zk, err
 }
 
@@ -113,13 +144,44 @@ func (ts *TestCluster) ConnectAllTimeout(sessionTimeout time.Duration) (*Conn, <
 	for i, srv := range ts.Servers {
 		hosts[i] = fmt.Sprintf("127.0.0.1:%d", srv.Port)
 	}
-	zk, ch, err := Connect(hosts, sessionTimeout)
+	zk, ch, err := Connect(hosts, sessionTimeout, WithDialer(ts.dial))
 	return zk, ch, err
 }
 
+// dial is the Dialer used by clients connected through the cluster. It
+// counts the connection attempts made to each server.
+func (ts *TestCluster) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	ts.dialsLock.Lock()
+	if ts.dials == nil {
+		ts.dials = make(map[string]int)
+	}
+	ts.dials[address]++
+	ts.dialsLock.Unlock()
+	return net.DialTimeout(network, address, timeout)
+}
+
+// ConnectionAttempts returns the number of times clients connected through
+// the cluster have tried to connect to server, whether or not the attempt
+// succeeded. It allows tests to assert on reconnect storm behavior.
+func (ts *TestCluster) ConnectionAttempts(server string) int {
+	ts.dialsLock.Lock()
+	defer ts.dialsLock.Unlock()
+	return ts.dials[server]
+}
+
+// ResetConnectionAttempts clears the connection attempt counters.
+func (ts *TestCluster) ResetConnectionAttempts() {
+	ts.dialsLock.Lock()
+	ts.dials = nil
+	ts.dialsLock.Unlock()
+}
+
 func (ts *TestCluster) Stop() error {
 	for _, srv := range ts.Servers {
-		srv.Srv.Stop()
+		if srv.Srv.cmd != nil {
+			srv.Srv.Stop()
+		}
+		srv.log.Close()
 	}
 	defer os.RemoveAll(ts.Path)
 	return ts.waitForStop(5, time.Second)
@@ -170,21 +232,98 @@ func (ts *TestCluster) waitForStop(maxRetry int, interval time.Duration) error {
 }
 
 func (tc *TestCluster) StartServer(server string) {
-	for _, s := range tc.Servers {
+	tc.testServer(server).Srv.Start()
+}
+
+func (tc *TestCluster) StopServer(server string) {
+	tc.testServer(server).Srv.Stop()
+}
+
+// Server returns the server of the cluster with the client address server.
+// It panics if there is none.
+func (tc *TestCluster) Server(server string) *TestServer {
+	return tc.testServer(server)
+}
+
+// ServerLog returns a reader of the output the server process wrote so far,
+// so tests can assert on what the server logged.
+func (tc *TestCluster) ServerLog(server string) (io.ReadCloser, error) {
+	return os.Open(tc.testServer(server).LogPath)
+}
+
+func (tc *TestCluster) testServer(server string) *TestServer {
+	for i, s := range tc.Servers {
 		if strings.HasSuffix(server, fmt.Sprintf(":%d", s.Port)) {
-			s.Srv.Start()
-			return
+			return &tc.Servers[i]
 		}
 	}
 	panic(fmt.Sprintf("Unknown server: %s", server))
 }
 
-func (tc *TestCluster) StopServer(server string) {
-	for _, s := range tc.Servers {
-		if strings.HasSuffix(server, fmt.Sprintf(":%d", s.Port)) {
-			s.Srv.Stop()
-			return
+// ForceSnapshot makes a running server write a snapshot of its data by
+// restarting it, as servers take a snapshot when they load their data. It
+// returns once the server serves requests again.
+func (tc *TestCluster) ForceSnapshot(server string) error {
+	s := tc.testServer(server)
+	s.Srv.Stop()
+	if err := s.Srv.Start(); err != nil {
+		return err
+	}
+	addr := []string{fmt.Sprintf("127.0.0.1:%d", s.Port)}
+	for i := 0; i < 10; i++ {
+		if _, ok := FLWSrvr(addr, time.Second); ok {
+			return nil
 		}
+		time.Sleep(time.Second)
 	}
-	panic(fmt.Sprintf("Unknown server: %s", server))
+	return fmt.Errorf("unable to verify health of server %s", server)
+}
+
+// Snapshots returns the paths of the snapshots of a server, oldest first.
+func (tc *TestCluster) Snapshots(server string) ([]string, error) {
+	return dataFiles(tc.testServer(server).Path, "snapshot.")
+}
+
+// TruncateTxnLog truncates the newest transaction log of a stopped server to
+// size bytes, losing the transactions logged after that point.
+func (tc *TestCluster) TruncateTxnLog(server string, size int64) error {
+	logs, err := dataFiles(tc.testServer(server).Path, "log.")
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("zk: no transaction log for server %s", server)
+	}
+	return os.Truncate(logs[len(logs)-1], size)
+}
+
+// DeleteServerData deletes the snapshots and transaction logs of a stopped
+// server, so that it starts over with an empty database. Its configuration
+// and id are kept.
+func (tc *TestCluster) DeleteServerData(server string) error {
+	return os.RemoveAll(filepath.Join(tc.testServer(server).Path, "version-2"))
+}
+
+// teeWriter returns a writer to the log of a server that also writes to w,
+// if it is not nil.
+func teeWriter(log *os.File, w io.Writer) io.Writer {
+	if w == nil {
+		return log
+	}
+	return io.MultiWriter(log, w)
+}
+
+// dataFiles returns the files of a server data dir with the given prefix,
+// ordered by the zxid they are named after.
+func dataFiles(dataDir, prefix string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dataDir, "version-2", prefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	zxid := func(path string) uint64 {
+		z, _ := strconv.ParseUint(strings.TrimPrefix(filepath.Base(path), prefix), 16, 64)
+		return z
+	}
+	sort.Slice(paths, func(i, j int) bool { return zxid(paths[i]) < zxid(paths[j]) })
+	return paths, nil
 }
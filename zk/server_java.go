@@ -38,6 +38,7 @@ type ServerConfig struct {
 	ClientPort               int    // Port at which clients will connect
 	AutoPurgeSnapRetainCount int    // Number of snapshots to retain in dataDir
 	AutoPurgePurgeInterval   int    // Purge task internal in hours (0 to disable auto purge)
+	AdminServerPort          int    // Port of the AdminServer (0 to use the server default)
 	Servers                  []ServerConfigServer
 }
 
@@ -69,6 +70,9 @@ func (sc ServerConfig) Marshall(w io.Writer) error {
 		fmt.Fprintf(w, "autopurge.snapRetainCount=%d\n", sc.AutoPurgeSnapRetainCount)
 		fmt.Fprintf(w, "autopurge.purgeInterval=%d\n", sc.AutoPurgePurgeInterval)
 	}
+	if sc.AdminServerPort > 0 {
+		fmt.Fprintf(w, "admin.serverPort=%d\n", sc.AdminServerPort)
+	}
 	if len(sc.Servers) > 0 {
 		for _, srv := range sc.Servers {
 			if srv.PeerPort <= 0 {
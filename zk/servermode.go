@@ -0,0 +1,103 @@
+package zk
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// parseMode returns the Mode named name, as reported by srvr.
+func parseMode(name string) Mode {
+	for m, n := range modeNames {
+		if n == name {
+			return m
+		}
+	}
+	return ModeUnknown
+}
+
+// serverMode asks server for its mode with the srvr four letter word.
+func serverMode(server string, timeout time.Duration) (Mode, error) {
+	mode, _, err := serverInfo(server, timeout, net.DialTimeout)
+	return mode, err
+}
+
+// serverInfo asks server for its mode and version with the srvr four letter
+// word. The version is e.g. "3.6.3", without the revision and build date.
+func serverInfo(server string, timeout time.Duration, dialer Dialer) (Mode, string, error) {
+	response, err := fourLetterWord(server, "srvr", timeout, dialer)
+	if err != nil {
+		return ModeUnknown, "", err
+	}
+	mode, version := ModeUnknown, ""
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v := strings.TrimPrefix(line, "Zookeeper version: "); v != line {
+			if fields := strings.FieldsFunc(v, func(r rune) bool { return r == '-' || r == ',' }); len(fields) > 0 {
+				version = strings.TrimSpace(fields[0])
+			}
+		} else if m := strings.TrimPrefix(line, "Mode: "); m != line {
+			mode, found = parseMode(strings.TrimSpace(m)), true
+		}
+	}
+	if !found {
+		return ModeUnknown, "", fmt.Errorf("zk: no mode in the srvr response of %s", server)
+	}
+	return mode, version, nil
+}
+
+// ServerMode returns the mode of the server the connection is connected to,
+// e.g. ModeObserver. The connect response does not tell, so the server is
+// asked with the srvr four letter word, which must be allowed by its
+// 4lw.commands.whitelist, through the dialer, proxy and TLS configuration
+// of the connection, but without its compression. The answer is
+// remembered until the connection moves to another server.
+func (c *Conn) ServerMode() (Mode, error) {
+	mode, _, err := c.serverInfo()
+	return mode, err
+}
+
+// ServerVersion returns the ZooKeeper version of the server the connection
+// is connected to, e.g. "3.6.3", asking it like ServerMode.
+func (c *Conn) ServerVersion() (string, error) {
+	_, version, err := c.serverInfo()
+	return version, err
+}
+
+func (c *Conn) serverInfo() (Mode, string, error) {
+	server := c.Server()
+	c.serverMu.Lock()
+	if c.modeServer == server {
+		mode, version := c.mode, c.version
+		c.serverMu.Unlock()
+		return mode, version, nil
+	}
+	c.serverMu.Unlock()
+
+	mode, version, err := serverInfo(server, c.connectTimeout, c.flwDialer)
+	if err != nil {
+		return ModeUnknown, "", err
+	}
+	c.serverMu.Lock()
+	c.modeServer, c.mode, c.version = server, mode, version
+	c.serverMu.Unlock()
+	return mode, version, nil
+}
+
+// WithPreferObservers returns a connection option that connects to the
+// observers of the ensemble whenever one of them is reachable, to keep
+// watch-heavy, read-mostly clients off the voting members. The servers are
+// asked for their mode with the srvr four letter word when the host list is
+// set, each within timeout, and the others are only used if no observer can
+// be connected to. It replaces the HostProvider with a LocalityHostProvider.
+func WithPreferObservers(timeout time.Duration) connOption {
+	return WithLocality(func(server string) bool {
+		mode, err := serverMode(server, timeout)
+		return err == nil && mode == ModeObserver
+	})
+}
@@ -0,0 +1,96 @@
+package zk
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// srvrServer answers srvr with the given mode until l is closed.
+func srvrServer(l net.Listener, mode string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 4)
+		conn.Read(buf)
+		conn.Write([]byte(strings.Replace(zkSrvrOut, "Mode: leader", "Mode: "+mode, 1)))
+		conn.Close()
+	}
+}
+
+func TestServerMode(t *testing.T) {
+	t.Parallel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go srvrServer(l, "observer")
+
+	if mode, err := serverMode(l.Addr().String(), time.Second); err != nil || mode != ModeObserver {
+		t.Fatalf("serverMode returned %s, %+v", mode, err)
+	}
+	if _, version, err := serverInfo(l.Addr().String(), time.Second, net.DialTimeout); err != nil || version != "3.4.6" {
+		t.Fatalf("serverInfo returned version %q, %+v", version, err)
+	}
+	if ss, ok := FLWSrvr([]string{l.Addr().String()}, time.Second); !ok || ss[0].Mode != ModeObserver {
+		t.Fatalf("FLWSrvr did not report an observer: %+v", ss[0])
+	}
+
+	// The fake server accepts the session whatever the address, and srvr
+	// is answered by the listener through the same dialer.
+	s := NewFakeServer()
+	defer s.Close()
+	fake := s.Dialer()
+	var dials int32
+	dialer := func(network, address string, timeout time.Duration) (net.Conn, error) {
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return fake(network, address, timeout)
+		}
+		return net.DialTimeout(network, address, timeout)
+	}
+	zk, ch, err := Connect([]string{l.Addr().String()}, 10*time.Second, WithDialer(dialer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+	if mode, err := zk.ServerMode(); err != nil || mode != ModeObserver {
+		t.Fatalf("ServerMode returned %s, %+v", mode, err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("Dialer called %d times instead of 2", n)
+	}
+	l.Close()
+	if mode, err := zk.ServerMode(); err != nil || mode != ModeObserver {
+		t.Fatalf("ServerMode not remembered: %s, %+v", mode, err)
+	}
+}
+
+func TestPreferObservers(t *testing.T) {
+	t.Parallel()
+	var addrs []string
+	for _, mode := range []string{"follower", "observer", "leader"} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go srvrServer(l, mode)
+		addrs = append(addrs, l.Addr().String())
+	}
+
+	c := &Conn{}
+	WithPreferObservers(time.Second)(c)
+	if err := c.hostProvider.Init(addrs); err != nil {
+		t.Fatal(err)
+	}
+	if server, _ := c.hostProvider.Next(); server != addrs[1] {
+		t.Fatalf("Next returned %s instead of the observer %s", server, addrs[1])
+	}
+}
@@ -0,0 +1,345 @@
+package servicediscovery
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ErrNoInstance is returned by ServiceProvider.Instance when the service has
+// no enabled instance that is up.
+var ErrNoInstance = errors.New("servicediscovery: no instance available")
+
+const (
+	// Defaults of the down instance policy, as in Curator.
+	defaultDownTimeout    = 30 * time.Second
+	defaultErrorThreshold = 2
+
+	// watchRetryDelay is how long to wait before setting a watch again after
+	// it failed, e.g. because the connection was lost.
+	watchRetryDelay = time.Second
+)
+
+// ProviderStrategy chooses which instance a ServiceProvider hands out.
+type ProviderStrategy interface {
+	// Choose returns one of instances, which are sorted by ID and not empty.
+	Choose(instances []*ServiceInstance) *ServiceInstance
+}
+
+// RoundRobinStrategy hands out the instances in turn. The zero value is ready
+// to use.
+type RoundRobinStrategy struct {
+	next uint32
+}
+
+// Choose implements ProviderStrategy.
+func (s *RoundRobinStrategy) Choose(instances []*ServiceInstance) *ServiceInstance {
+	n := atomic.AddUint32(&s.next, 1) - 1
+	return instances[int(n%uint32(len(instances)))]
+}
+
+// RandomStrategy hands out a random instance.
+type RandomStrategy struct{}
+
+// Choose implements ProviderStrategy.
+func (RandomStrategy) Choose(instances []*ServiceInstance) *ServiceInstance {
+	return instances[rand.Intn(len(instances))]
+}
+
+// StickyStrategy hands out the same instance for as long as it is available,
+// and chooses another one with the strategy it wraps once it is not.
+type StickyStrategy struct {
+	master  ProviderStrategy
+	mu      sync.Mutex
+	current *ServiceInstance
+}
+
+// NewStickyStrategy returns a StickyStrategy choosing instances with master.
+func NewStickyStrategy(master ProviderStrategy) *StickyStrategy {
+	return &StickyStrategy{master: master}
+}
+
+// Choose implements ProviderStrategy.
+func (s *StickyStrategy) Choose(instances []*ServiceInstance) *ServiceInstance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		for _, inst := range instances {
+			if inst.ID == s.current.ID {
+				s.current = inst
+				return inst
+			}
+		}
+	}
+	s.current = s.master.Choose(instances)
+	return s.current
+}
+
+// ServiceProvider hands out instances of a service, chosen by a
+// ProviderStrategy among the enabled instances that are up. The instances are
+// cached and kept up to date with watches. Instances with errors, reported
+// with NoteError, are considered down for a while.
+type ServiceProvider struct {
+	d        *ServiceDiscovery
+	service  string
+	strategy ProviderStrategy
+
+	mu             sync.Mutex // protects instances, errors and the policy
+	instances      map[string]*ServiceInstance
+	errors         map[string]*instanceErrors
+	downTimeout    time.Duration
+	errorThreshold int
+
+	// Only used by the goroutine keeping the cache, or by Start before it runs.
+	children map[string]bool // ids of the instance nodes
+	watched  map[string]bool // ids of the instance nodes with a data watch
+
+	events    chan providerEvent
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+type instanceErrors struct {
+	count int
+	until time.Time // down until then, once count reached the threshold
+}
+
+// providerEvent is a watch having fired, or to be set again after it failed:
+// the watch of the children of the service if id is empty, or of the
+// instance with id.
+type providerEvent struct {
+	id    string
+	err   error
+	retry bool
+}
+
+// NewServiceProvider returns a provider of instances of service registered
+// with d, chosen with strategy, or round-robin if it is nil.
+func NewServiceProvider(d *ServiceDiscovery, service string, strategy ProviderStrategy) *ServiceProvider {
+	if strategy == nil {
+		strategy = &RoundRobinStrategy{}
+	}
+	return &ServiceProvider{
+		d:              d,
+		service:        service,
+		strategy:       strategy,
+		instances:      make(map[string]*ServiceInstance),
+		errors:         make(map[string]*instanceErrors),
+		downTimeout:    defaultDownTimeout,
+		errorThreshold: defaultErrorThreshold,
+		children:       make(map[string]bool),
+		watched:        make(map[string]bool),
+		events:         make(chan providerEvent),
+		quit:           make(chan struct{}),
+	}
+}
+
+// SetDownInstancePolicy sets after how many errors an instance is considered
+// down, and for how long. It defaults to 2 errors and 30 seconds.
+func (p *ServiceProvider) SetDownInstancePolicy(timeout time.Duration, errorThreshold int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.downTimeout = timeout
+	p.errorThreshold = errorThreshold
+}
+
+// Start reads the instances of the service and starts keeping them up to
+// date.
+func (p *ServiceProvider) Start() error {
+	if err := p.watchChildren(); err != nil {
+		return err
+	}
+	go p.run()
+	return nil
+}
+
+// Close stops keeping the instances up to date.
+func (p *ServiceProvider) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+	})
+}
+
+// Instance returns an instance chosen by the strategy, or ErrNoInstance.
+func (p *ServiceProvider) Instance() (*ServiceInstance, error) {
+	now := time.Now()
+	p.mu.Lock()
+	var instances []*ServiceInstance
+	for id, inst := range p.instances {
+		if !inst.Enabled {
+			continue
+		}
+		if e := p.errors[id]; e != nil && !e.until.IsZero() {
+			if now.Before(e.until) {
+				continue
+			}
+			// Up again, and given a new chance.
+			delete(p.errors, id)
+		}
+		instances = append(instances, inst)
+	}
+	p.mu.Unlock()
+	if len(instances) == 0 {
+		return nil, ErrNoInstance
+	}
+	sortInstances(instances)
+	return p.strategy.Choose(instances), nil
+}
+
+// Instances returns all the cached instances, sorted by ID, including the
+// disabled ones and those that are down.
+func (p *ServiceProvider) Instances() []*ServiceInstance {
+	p.mu.Lock()
+	instances := make([]*ServiceInstance, 0, len(p.instances))
+	for _, inst := range p.instances {
+		instances = append(instances, inst)
+	}
+	p.mu.Unlock()
+	sortInstances(instances)
+	return instances
+}
+
+// NoteError reports an error using inst. Once the error threshold of the down
+// instance policy is reached, inst is not handed out until the timeout of
+// the policy passed.
+func (p *ServiceProvider) NoteError(inst *ServiceInstance) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := p.errors[inst.ID]
+	if e == nil {
+		e = &instanceErrors{}
+		p.errors[inst.ID] = e
+	}
+	e.count++
+	if e.count >= p.errorThreshold {
+		e.until = time.Now().Add(p.downTimeout)
+	}
+}
+
+func sortInstances(instances []*ServiceInstance) {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+}
+
+func (p *ServiceProvider) run() {
+	for {
+		select {
+		case <-p.quit:
+			return
+		case ev := <-p.events:
+			if ev.err == zk.ErrClosing {
+				return
+			}
+			var err error
+			if ev.id == "" {
+				err = p.watchChildren()
+			} else if !ev.retry || !p.watched[ev.id] {
+				p.watched[ev.id] = false
+				if p.children[ev.id] {
+					err = p.watchInstance(ev.id)
+				}
+			}
+			if err == zk.ErrClosing {
+				return
+			} else if err != nil {
+				p.retry(ev.id)
+			}
+		}
+	}
+}
+
+// notify hands ev to run, unless the provider is closed.
+func (p *ServiceProvider) notify(ev providerEvent) {
+	select {
+	case p.events <- ev:
+	case <-p.quit:
+	}
+}
+
+// retry sets the watch of id again later, after it failed.
+func (p *ServiceProvider) retry(id string) {
+	time.AfterFunc(watchRetryDelay, func() { p.notify(providerEvent{id: id, retry: true}) })
+}
+
+// forward notifies run with the id of a watch once it fired.
+func (p *ServiceProvider) forward(id string, ch <-chan zk.Event) {
+	select {
+	case ev := <-ch:
+		p.notify(providerEvent{id: id, err: ev.Err})
+	case <-p.quit:
+	}
+}
+
+// watchChildren reads the instance nodes of the service and watches them.
+// New instances are read and watched, and removed ones are dropped.
+func (p *ServiceProvider) watchChildren() error {
+	path := p.d.servicePath(p.service)
+	ids, _, ch, err := p.d.c.ChildrenW(path)
+	if err == zk.ErrNoNode {
+		// Wait for the first instance.
+		var ok bool
+		ok, _, ch, err = p.d.c.ExistsW(path)
+		if err == nil && ok {
+			return p.watchChildren()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	go p.forward("", ch)
+
+	children := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		children[id] = true
+	}
+	p.children = children
+	p.mu.Lock()
+	for id := range p.instances {
+		if !children[id] {
+			delete(p.instances, id)
+			delete(p.errors, id)
+		}
+	}
+	p.mu.Unlock()
+	for _, id := range ids {
+		if p.watched[id] {
+			continue
+		}
+		if err := p.watchInstance(id); err == zk.ErrClosing {
+			return err
+		} else if err != nil {
+			p.retry(id)
+		}
+	}
+	return nil
+}
+
+// watchInstance reads the instance with id and watches it.
+func (p *ServiceProvider) watchInstance(id string) error {
+	data, _, ch, err := p.d.c.GetW(p.d.servicePath(p.service) + "/" + id)
+	if err == zk.ErrNoNode {
+		p.mu.Lock()
+		delete(p.instances, id)
+		p.mu.Unlock()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	p.watched[id] = true
+	go p.forward(id, ch)
+
+	inst, err := decodeInstance(data)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		// Not an instance, ignored until it changes.
+		delete(p.instances, id)
+		return nil
+	}
+	p.instances[id] = inst
+	return nil
+}
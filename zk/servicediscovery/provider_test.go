@@ -0,0 +1,130 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func testInstances(ids ...string) []*ServiceInstance {
+	instances := make([]*ServiceInstance, len(ids))
+	for i, id := range ids {
+		instances[i] = &ServiceInstance{Name: "api", ID: id, Enabled: true}
+	}
+	return instances
+}
+
+func TestStrategies(t *testing.T) {
+	instances := testInstances("a", "b", "c")
+
+	rr := &RoundRobinStrategy{}
+	for i, want := range []string{"a", "b", "c", "a"} {
+		if id := rr.Choose(instances).ID; id != want {
+			t.Fatalf("Round-robin choice %d is %s, expected %s", i, id, want)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if inst := (RandomStrategy{}).Choose(instances); inst == nil {
+			t.Fatal("Random strategy chose nothing")
+		}
+	}
+
+	sticky := NewStickyStrategy(&RoundRobinStrategy{})
+	for i := 0; i < 3; i++ {
+		if id := sticky.Choose(instances).ID; id != "a" {
+			t.Fatalf("Sticky choice %d is %s, expected a", i, id)
+		}
+	}
+	// Another one is chosen once it is gone.
+	if id := sticky.Choose(instances[1:]).ID; id != "c" {
+		t.Fatalf("Sticky choice is %s, expected c", id)
+	}
+	if id := sticky.Choose(instances).ID; id != "c" {
+		t.Fatalf("Sticky choice is %s, expected c", id)
+	}
+}
+
+func TestServiceProvider(t *testing.T) {
+	c, fc, done := connectFake(t)
+	defer done()
+	d := New(c, "/services", zk.WorldACL(zk.PermAll))
+	p := NewServiceProvider(d, "api", nil)
+	p.SetDownInstancePolicy(time.Hour, 2)
+	defer p.Close()
+
+	instances := testInstances("a", "b", "c")
+	instances[2].Enabled = false
+	data := make(map[string][]byte)
+	for _, inst := range instances {
+		data[inst.ID], _ = json.Marshal(inst)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- p.Start() }()
+	serve(t, fc, "getChildren2", "/services/api", nil, &getChildren2Response{Children: []string{"a", "b", "c"}})
+	for _, id := range []string{"a", "b", "c"} {
+		serve(t, fc, "getData", "/services/api/"+id, nil, &getDataResponse{Data: data[id]})
+	}
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Instances(); len(got) != 3 {
+		t.Fatalf("Cached %d instances, expected 3", len(got))
+	}
+
+	// The disabled instance is not handed out.
+	for i, want := range []string{"a", "b", "a"} {
+		inst, err := p.Instance()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inst.ID != want {
+			t.Fatalf("Instance %d is %s, expected %s", i, inst.ID, want)
+		}
+	}
+
+	// Nor is an instance that is down.
+	p.NoteError(instances[0])
+	p.NoteError(instances[0])
+	for i := 0; i < 2; i++ {
+		if inst, err := p.Instance(); err != nil || inst.ID != "b" {
+			t.Fatalf("Instance returned %+v, %+v, expected b", inst, err)
+		}
+	}
+
+	// Removed instances are dropped from the cache.
+	if err := fc.SendEvent(2, zk.EventNodeChildrenChanged, "/services/api"); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, fc, "getChildren2", "/services/api", nil, &getChildren2Response{Children: []string{"a", "c"}})
+	deadline := time.Now().Add(fakeTimeout)
+	for len(p.Instances()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Cached %d instances, expected 2", len(p.Instances()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inst, err := p.Instance(); err != ErrNoInstance {
+		t.Fatalf("Instance returned %+v, %+v, expected ErrNoInstance", inst, err)
+	}
+
+	// Changed instances are read again.
+	instances[2].Enabled = true
+	data["c"], _ = json.Marshal(instances[2])
+	if err := fc.SendEvent(3, zk.EventNodeDataChanged, "/services/api/c"); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, fc, "getData", "/services/api/c", nil, &getDataResponse{Data: data["c"]})
+	for {
+		if inst, err := p.Instance(); err == nil && inst.ID == "c" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Instance c not enabled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
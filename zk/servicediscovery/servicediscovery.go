@@ -0,0 +1,320 @@
+// Package servicediscovery registers and looks up instances of services in
+// ZooKeeper, using the same layout as Curator's ServiceDiscovery so that Go
+// and Java services can discover each other: each instance is a node at
+// basePath/service/id whose data is the instance as JSON.
+package servicediscovery
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	// ErrNotRegistered is returned when updating or unregistering an
+	// instance that was not registered with the ServiceDiscovery.
+	ErrNotRegistered = errors.New("servicediscovery: instance not registered")
+	// ErrUnsupportedServiceType is returned when registering an instance
+	// of type DynamicSequential, which is not supported.
+	ErrUnsupportedServiceType = errors.New("servicediscovery: unsupported service type")
+)
+
+// ServiceType is the kind of a ServiceInstance, as in Curator.
+type ServiceType string
+
+const (
+	// Dynamic instances are ephemeral nodes that go away with the session
+	// of the ServiceDiscovery. It is the default.
+	Dynamic ServiceType = "DYNAMIC"
+	// Static instances are persistent nodes, deleted when unregistered or
+	// when the ServiceDiscovery is closed.
+	Static ServiceType = "STATIC"
+	// Permanent instances are persistent nodes that outlive the
+	// ServiceDiscovery.
+	Permanent ServiceType = "PERMANENT"
+	// DynamicSequential instances are ephemeral sequential nodes in Curator.
+	// They can be queried but not registered.
+	DynamicSequential ServiceType = "DYNAMIC_SEQUENTIAL"
+)
+
+// ServiceInstance is an instance of a service, encoded like Curator's
+// JsonInstanceSerializer does.
+type ServiceInstance struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Port    *int   `json:"port"`
+	SSLPort *int   `json:"sslPort"`
+	// Payload is arbitrary JSON describing the instance.
+	Payload json.RawMessage `json:"payload"`
+	// RegistrationTimeUTC is when the instance was created, in milliseconds
+	// since the Unix epoch.
+	RegistrationTimeUTC int64       `json:"registrationTimeUTC"`
+	ServiceType         ServiceType `json:"serviceType"`
+	// URISpec is the UriSpec of Curator as JSON, or null.
+	URISpec json.RawMessage `json:"uriSpec"`
+	// Enabled is false for instances that should not be handed out. It is
+	// true for instances registered by versions of Curator without it.
+	Enabled bool `json:"enabled"`
+}
+
+// NewServiceInstance returns a Dynamic instance of service at host and port
+// with a random ID. payload is marshaled to JSON unless it is nil.
+func NewServiceInstance(service, host string, port int, payload interface{}) (*ServiceInstance, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	inst := &ServiceInstance{
+		Name:                service,
+		ID:                  id,
+		Address:             host,
+		Port:                &port,
+		RegistrationTimeUTC: time.Now().UnixNano() / int64(time.Millisecond),
+		ServiceType:         Dynamic,
+		Enabled:             true,
+	}
+	if payload != nil {
+		if inst.Payload, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// newID returns a random UUID, like the IDs Curator generates.
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// ServiceDiscovery registers instances of services under a base path and
+// queries them. Dynamic instances are created again after the session
+// expired, and are deleted when unregistered or when the ServiceDiscovery is
+// closed.
+type ServiceDiscovery struct {
+	c        *zk.Conn
+	basePath string
+	acl      []zk.ACL
+	dynamic  *zk.PersistentEphemeral
+
+	mu         sync.Mutex
+	registered map[string]*ServiceInstance // path -> instance
+}
+
+// New returns a ServiceDiscovery using the provided connection, base path,
+// and acl for the nodes it creates.
+func New(c *zk.Conn, basePath string, acl []zk.ACL) *ServiceDiscovery {
+	return &ServiceDiscovery{
+		c:          c,
+		basePath:   basePath,
+		acl:        acl,
+		dynamic:    zk.NewPersistentEphemeral(c),
+		registered: make(map[string]*ServiceInstance),
+	}
+}
+
+func (d *ServiceDiscovery) servicePath(service string) string {
+	return d.basePath + "/" + service
+}
+
+func (d *ServiceDiscovery) instancePath(inst *ServiceInstance) string {
+	return d.servicePath(inst.Name) + "/" + inst.ID
+}
+
+// RegisterInstance registers a new Dynamic instance of service at host and
+// port, see NewServiceInstance, and returns it.
+func (d *ServiceDiscovery) RegisterInstance(service, host string, port int, payload interface{}) (*ServiceInstance, error) {
+	inst, err := NewServiceInstance(service, host, port, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Register(inst); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// Register registers inst. A Dynamic instance is an ephemeral node, kept in
+// existence across sessions until it is unregistered; if creating it fails
+// it is retried in the background and the error of the first attempt is
+// returned. Static and Permanent instances are persistent nodes, which are
+// overwritten if they exist.
+func (d *ServiceDiscovery) Register(inst *ServiceInstance) error {
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	path := d.instancePath(inst)
+	switch inst.ServiceType {
+	case Dynamic, "":
+		// Kept and retried if it fails.
+		err = d.dynamic.Add(path, data, d.acl)
+	case Static, Permanent:
+		if err := d.createPersistent(path, data); err != nil {
+			return err
+		}
+	default:
+		return ErrUnsupportedServiceType
+	}
+	d.mu.Lock()
+	d.registered[path] = inst
+	d.mu.Unlock()
+	return err
+}
+
+// Update writes inst again, e.g. after its payload changed. It must have been
+// registered.
+func (d *ServiceDiscovery) Update(inst *ServiceInstance) error {
+	path := d.instancePath(inst)
+	d.mu.Lock()
+	registered, ok := d.registered[path]
+	d.mu.Unlock()
+	if !ok {
+		return ErrNotRegistered
+	}
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	switch registered.ServiceType {
+	case Static, Permanent:
+		_, err = d.c.Set(path, data, -1)
+	default:
+		err = d.dynamic.Set(path, data)
+	}
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.registered[path] = inst
+	d.mu.Unlock()
+	return nil
+}
+
+// Unregister deletes the node of inst. It must have been registered.
+func (d *ServiceDiscovery) Unregister(inst *ServiceInstance) error {
+	path := d.instancePath(inst)
+	d.mu.Lock()
+	registered, ok := d.registered[path]
+	delete(d.registered, path)
+	d.mu.Unlock()
+	if !ok {
+		return ErrNotRegistered
+	}
+	return d.unregister(path, registered)
+}
+
+func (d *ServiceDiscovery) unregister(path string, inst *ServiceInstance) error {
+	switch inst.ServiceType {
+	case Static, Permanent:
+		if err := d.c.Delete(path, -1); err != nil && err != zk.ErrNoNode {
+			return err
+		}
+		return nil
+	default:
+		return d.dynamic.Remove(path)
+	}
+}
+
+// Close unregisters the instances registered with d, and returns the first
+// error. Permanent instances are kept.
+func (d *ServiceDiscovery) Close() error {
+	d.mu.Lock()
+	registered := d.registered
+	d.registered = make(map[string]*ServiceInstance)
+	d.mu.Unlock()
+	var firstErr error
+	for path, inst := range registered {
+		if inst.ServiceType == Permanent || inst.ServiceType == Dynamic || inst.ServiceType == "" {
+			continue
+		}
+		if err := d.unregister(path, inst); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := d.dynamic.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// QueryForNames returns the names of the services with instances, sorted.
+func (d *ServiceDiscovery) QueryForNames() ([]string, error) {
+	names, _, err := d.c.Children(d.basePath)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// QueryForInstances returns the instances of service, sorted by ID.
+func (d *ServiceDiscovery) QueryForInstances(service string) ([]*ServiceInstance, error) {
+	ids, _, err := d.c.Children(d.servicePath(service))
+	if err == zk.ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	instances := make([]*ServiceInstance, 0, len(ids))
+	for _, id := range ids {
+		inst, err := d.QueryForInstance(service, id)
+		if err == zk.ErrNoNode {
+			// Unregistered meanwhile.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+	return instances, nil
+}
+
+// QueryForInstance returns the instance of service with id, or zk.ErrNoNode.
+func (d *ServiceDiscovery) QueryForInstance(service, id string) (*ServiceInstance, error) {
+	data, _, err := d.c.Get(d.servicePath(service) + "/" + id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeInstance(data)
+}
+
+// decodeInstance decodes the data of an instance node.
+func decodeInstance(data []byte) (*ServiceInstance, error) {
+	inst := &ServiceInstance{Enabled: true}
+	if err := json.Unmarshal(data, inst); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// createPersistent creates a persistent node with data at path, and its
+// parents, or sets its data if it exists.
+func (d *ServiceDiscovery) createPersistent(path string, data []byte) error {
+	_, err := d.c.Create(path, data, 0, d.acl)
+	if err == zk.ErrNoNode {
+		if err := d.c.CreateParents(path, d.acl); err != nil {
+			return err
+		}
+		_, err = d.c.Create(path, data, 0, d.acl)
+	}
+	if err == zk.ErrNodeExists {
+		_, err = d.c.Set(path, data, -1)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package servicediscovery
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const fakeTimeout = 5 * time.Second
+
+// The response bodies of the fake server, whose fields are encoded in order.
+type (
+	createResponse struct {
+		Path string
+	}
+	getChildren2Response struct {
+		Children []string
+		Stat     zk.Stat
+	}
+	getDataResponse struct {
+		Data []byte
+		Stat zk.Stat
+	}
+)
+
+func connectFake(t *testing.T) (*zk.Conn, *zk.FakeConn, func()) {
+	t.Helper()
+	s := zk.NewFakeServer()
+	c, ch, err := zk.Connect([]string{"127.0.0.1:2181"}, 10*time.Second, zk.WithDialer(s.Dialer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.AcceptSession(1, 10*time.Second, []byte{1}, false); err != nil {
+		t.Fatal(err)
+	}
+	for ev := range ch {
+		if ev.State == zk.StateHasSession {
+			break
+		}
+	}
+	return c, fc, func() {
+		c.Close()
+		s.Close()
+	}
+}
+
+func serve(t *testing.T, fc *zk.FakeConn, op, path string, err error, res interface{}) *zk.FakeRequest {
+	t.Helper()
+	req, rerr := fc.ExpectRequest(op)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if req.Path != path {
+		t.Fatalf("%s request for %s, expected %s", op, req.Path, path)
+	}
+	if rerr := fc.Reply(req, 1, err, res); rerr != nil {
+		t.Fatal(rerr)
+	}
+	return req
+}
+
+func TestInstanceJSON(t *testing.T) {
+	// As written by Curator 4.
+	data := []byte(`{"name":"api","id":"9f1c","address":"10.0.0.1","port":8080,"sslPort":null,"payload":{"zone":"a"},"registrationTimeUTC":1600000000000,"serviceType":"DYNAMIC","uriSpec":null,"enabled":true}`)
+	inst, err := decodeInstance(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Name != "api" || inst.ID != "9f1c" || inst.Address != "10.0.0.1" || *inst.Port != 8080 || inst.SSLPort != nil || inst.ServiceType != Dynamic || !inst.Enabled {
+		t.Fatalf("Decoded %+v", inst)
+	}
+	encoded, err := json.Marshal(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b map[string]interface{}
+	json.Unmarshal(data, &a)
+	json.Unmarshal(encoded, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("Encoded %s, expected %s", encoded, data)
+	}
+
+	// Older versions have no enabled field.
+	if inst, err := decodeInstance([]byte(`{"name":"api","id":"1"}`)); err != nil || !inst.Enabled {
+		t.Fatalf("Decoded %+v, %+v", inst, err)
+	}
+}
+
+func TestRegisterInstance(t *testing.T) {
+	c, fc, done := connectFake(t)
+	defer done()
+	d := New(c, "/services", zk.WorldACL(zk.PermAll))
+
+	type result struct {
+		inst *ServiceInstance
+		err  error
+	}
+	registered := make(chan result, 1)
+	go func() {
+		inst, err := d.RegisterInstance("api", "10.0.0.1", 8080, map[string]string{"zone": "a"})
+		registered <- result{inst, err}
+	}()
+
+	// The instance is an ephemeral node, created with its parents.
+	req, err := fc.ExpectRequest("create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := req.Path
+	if err := fc.Reply(req, 1, zk.ErrNoNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, fc, "create", "/services", nil, &createResponse{Path: "/services"})
+	serve(t, fc, "create", "/services/api", nil, &createResponse{Path: "/services/api"})
+	req = serve(t, fc, "create", path, nil, &createResponse{Path: path})
+	r := <-registered
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if path != "/services/api/"+r.inst.ID {
+		t.Fatalf("Instance created at %s", path)
+	}
+	body := req.Body.(*zk.CreateRequest)
+	if body.Flags != zk.FlagEphemeral {
+		t.Fatalf("Instance created with flags %d", body.Flags)
+	}
+	if string(r.inst.Payload) != `{"zone":"a"}` {
+		t.Fatalf("Payload %s", r.inst.Payload)
+	}
+
+	// It can be queried.
+	instances := make(chan []*ServiceInstance, 1)
+	go func() {
+		list, err := d.QueryForInstances("api")
+		if err != nil {
+			t.Error(err)
+		}
+		instances <- list
+	}()
+	serve(t, fc, "getChildren2", "/services/api", nil, &getChildren2Response{Children: []string{r.inst.ID}})
+	serve(t, fc, "getData", path, nil, &getDataResponse{Data: body.Data})
+	list := <-instances
+	if len(list) != 1 || list[0].ID != r.inst.ID || *list[0].Port != 8080 || string(list[0].Payload) != `{"zone":"a"}` {
+		t.Fatalf("Queried %+v, expected %+v", list, r.inst)
+	}
+
+	// Closing deregisters it.
+	closed := make(chan error, 1)
+	go func() { closed <- d.Close() }()
+	serve(t, fc, "delete", path, nil, nil)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unregister(r.inst); err != ErrNotRegistered {
+		t.Fatalf("Unregister after Close returned %+v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package zk
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrInvalidSession is returned by ParseSession for data that does not hold
+// a session.
+var ErrInvalidSession = errors.New("zk: invalid session data")
+
+// Session identifies a session so that another connection can resume it,
+// e.g. a process restarting quickly enough to keep its ephemeral nodes
+// alive. Anyone holding it can take over the session, so store it like a
+// credential.
+type Session struct {
+	ID     int64  `json:"id"`
+	Passwd []byte `json:"passwd"`
+	// LastZxid is the last zxid the connection had seen, which keeps the
+	// session from being resumed on a server that lags behind it.
+	LastZxid int64 `json:"last_zxid"`
+}
+
+// Session returns the current session of the connection, whose ID is 0 if
+// there is none.
+func (c *Conn) Session() Session {
+	c.sessionLock.Lock()
+	passwd := c.passwd
+	c.sessionLock.Unlock()
+	return Session{
+		ID:       c.SessionID(),
+		Passwd:   passwd,
+		LastZxid: atomic.LoadInt64(&c.lastZxid),
+	}
+}
+
+// Marshal encodes the session, e.g. to save it to a file.
+func (s Session) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// ParseSession decodes a session encoded with Marshal.
+func ParseSession(data []byte) (Session, error) {
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil || s.ID == 0 {
+		return Session{}, ErrInvalidSession
+	}
+	return s, nil
+}
+
+// WithSession returns a connection option that resumes s instead of starting
+// a new session. Only the session is resumed: the watches of the previous
+// connection are gone, and auth data must be added again. If the session
+// expired meanwhile, the connection reports an EventSession event with
+// StateExpired and ErrSessionExpired in Err and starts a new session, or is
+// closed with that error if reconnecting is disabled with WithoutReconnect.
+func WithSession(s Session) connOption {
+	return func(c *Conn) {
+		if s.ID == 0 {
+			return
+		}
+		c.sessionID = s.ID
+		c.passwd = s.Passwd
+		c.lastZxid = s.LastZxid
+	}
+}
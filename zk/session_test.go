@@ -0,0 +1,88 @@
+package zk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionMarshal(t *testing.T) {
+	t.Parallel()
+	s := Session{ID: 42, Passwd: []byte{1, 2, 3}, LastZxid: 7}
+	data, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := ParseSession(data); err != nil || !reflect.DeepEqual(parsed, s) {
+		t.Fatalf("ParseSession returned %+v, %+v", parsed, err)
+	}
+	for _, data := range []string{"", "{}", "garbage"} {
+		if _, err := ParseSession([]byte(data)); err != ErrInvalidSession {
+			t.Errorf("ParseSession(%q) returned %+v instead of ErrInvalidSession", data, err)
+		}
+	}
+}
+
+func TestWithSession(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	saved := Session{ID: 5, Passwd: []byte{9, 9}, LastZxid: 7}
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithSession(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+
+	// The saved session is resumed.
+	fc, err := s.Accept(fakeTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := fc.ReadConnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.SessionID != 5 || !bytes.Equal(req.Passwd, saved.Passwd) || req.LastZxidSeen != 7 {
+		t.Fatalf("Unexpected connect request %+v", req)
+	}
+	if err := fc.AcceptSession(5, 10*time.Second, saved.Passwd, false); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, ch, StateHasSession)
+	if got := zk.Session(); !reflect.DeepEqual(got, saved) {
+		t.Fatalf("Session returned %+v, expected %+v", got, saved)
+	}
+
+	// A session that is gone is reported as expired before a new one starts.
+	fc.Close()
+	if fc, err = s.Accept(fakeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.ReadConnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ExpireSession(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(fakeTimeout)
+	for expired := false; !expired; {
+		select {
+		case ev := <-ch:
+			if ev.State == StateExpired {
+				if ev.Err != ErrSessionExpired {
+					t.Fatalf("Expired event has error %+v", ev.Err)
+				}
+				expired = true
+			}
+		case <-deadline:
+			t.Fatal("Session not reported as expired")
+		}
+	}
+	acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+	if id := zk.Session().ID; id != 1 {
+		t.Fatalf("Session ID %d after starting a new session", id)
+	}
+}
@@ -0,0 +1,71 @@
+package zk
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// stateAndChange returns the current state along with a channel that is
+// closed on the next state change.
+func (c *Conn) stateAndChange() (State, <-chan struct{}) {
+	c.stateChangedLock.Lock()
+	defer c.stateChangedLock.Unlock()
+	return State(atomic.LoadInt32((*int32)(&c.state))), c.stateChanged
+}
+
+// SessionContext returns a copy of parent that is canceled as soon as the
+// connection no longer has a session, i.e. on a disconnect, session
+// expiration or Close, so that code working on behalf of the session can
+// select on ctx.Done() instead of watching the event channel. The context is
+// canceled right away if there is no session when it is created. Call cancel
+// to release its resources when done with it.
+func (c *Conn) SessionContext(parent context.Context) (ctx context.Context, cancel context.CancelFunc) {
+	ctx, cancel = context.WithCancel(parent)
+	state, changed := c.stateAndChange()
+	if state != StateHasSession {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-changed:
+			case <-c.shouldQuit:
+				return
+			case <-ctx.Done():
+				return
+			}
+			if state, changed = c.stateAndChange(); state != StateHasSession {
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// WaitForSession blocks until the connection has a session, ctx is done or
+// the connection is closed. It returns nil once there is a session,
+// ctx.Err() if ctx is done first and ErrClosing if the connection is closed.
+// Together with SessionContext it lets a worker wait for the session to come
+// back after it was lost.
+func (c *Conn) WaitForSession(ctx context.Context) error {
+	for {
+		select {
+		case <-c.shouldQuit:
+			return ErrClosing
+		default:
+		}
+		state, changed := c.stateAndChange()
+		if state == StateHasSession {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-c.shouldQuit:
+			return ErrClosing
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
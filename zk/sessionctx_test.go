@@ -0,0 +1,57 @@
+package zk
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionContext(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+
+	ctx, cancel := zk.SessionContext(context.Background())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatal("Session context canceled while the session is up")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Losing the connection cancels the context, and a new one obtained
+	// without a session starts out canceled.
+	fc.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(fakeTimeout):
+		t.Fatal("Session context not canceled after a disconnect")
+	}
+	waitForState(t, ch, StateDisconnected)
+	if ctx, cancel := zk.SessionContext(context.Background()); ctx.Err() == nil {
+		cancel()
+		t.Fatal("Session context not canceled without a session")
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), fakeTimeout)
+	defer waitCancel()
+	done := make(chan error, 1)
+	go func() { done <- zk.WaitForSession(waitCtx) }()
+	acceptFake(t, s, 1)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForSession returned %+v", err)
+	}
+
+	ctx, cancel = zk.SessionContext(context.Background())
+	defer cancel()
+	zk.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(fakeTimeout):
+		t.Fatal("Session context not canceled after Close")
+	}
+	if err := zk.WaitForSession(context.Background()); err != ErrClosing {
+		t.Fatalf("WaitForSession after Close returned %+v instead of ErrClosing", err)
+	}
+}
@@ -0,0 +1,197 @@
+package zk
+
+import (
+	"reflect"
+	"sort"
+	"sync/atomic"
+)
+
+// shadowMaxInFlight caps the mirrored requests waiting for the shadow
+// ensemble. Requests beyond it are dropped rather than queued, so that a
+// slow shadow never slows down the primary traffic.
+const shadowMaxInFlight = 64
+
+// ShadowDivergence describes a request whose result on the shadow ensemble
+// differs from the one on the primary ensemble.
+type ShadowDivergence struct {
+	Op   string
+	Path string
+	// Primary and Shadow are the compared parts of the results: the data for
+	// getData, the sorted children for getChildren, the ACL for getACL and
+	// the created path for create requests, or nil for other requests, of
+	// which only the errors are compared.
+	Primary, Shadow       interface{}
+	PrimaryErr, ShadowErr error
+}
+
+// ShadowConfig configures WithShadow.
+type ShadowConfig struct {
+	// Conn is the connection to the shadow ensemble. Requests are sent to it
+	// with the paths of the primary connection, after its chroot.
+	Conn *Conn
+	// Writes mirrors write requests too. They are applied to the shadow
+	// ensemble like to the primary one, so only enable it on an ensemble
+	// that is a disposable copy. Sequential nodes get different names on
+	// either side and are reported as divergences.
+	Writes bool
+	// Diverged, if set, is called for every divergence, from the goroutine
+	// of the mirrored request.
+	Diverged func(ShadowDivergence)
+}
+
+// ShadowStats counts the requests mirrored by WithShadow.
+type ShadowStats struct {
+	// Mirrored is the number of requests answered by both ensembles, and
+	// Diverged the number of those whose results differed.
+	Mirrored uint64
+	Diverged uint64
+	// Failed is the number of requests the shadow ensemble did not answer
+	// because its connection was lost, and Dropped the number of requests
+	// not mirrored because too many were in flight.
+	Failed  uint64
+	Dropped uint64
+}
+
+type shadow struct {
+	ShadowConfig
+	inFlight chan struct{}
+
+	mirrored uint64
+	diverged uint64
+	failed   uint64
+	dropped  uint64
+}
+
+// WithShadow returns a connection option that mirrors reads, and writes if
+// cfg.Writes is set, to a second ensemble and compares the results, to
+// validate a new ensemble under real traffic before migrating to it. The
+// requests are mirrored once the primary ensemble answered them, in the
+// background, and watches are not set on the shadow ensemble.
+func WithShadow(cfg ShadowConfig) connOption {
+	return func(c *Conn) {
+		c.shadow = &shadow{ShadowConfig: cfg, inFlight: make(chan struct{}, shadowMaxInFlight)}
+	}
+}
+
+// ShadowStats returns the counts of the requests mirrored with WithShadow.
+func (c *Conn) ShadowStats() ShadowStats {
+	if c.shadow == nil {
+		return ShadowStats{}
+	}
+	return ShadowStats{
+		Mirrored: atomic.LoadUint64(&c.shadow.mirrored),
+		Diverged: atomic.LoadUint64(&c.shadow.diverged),
+		Failed:   atomic.LoadUint64(&c.shadow.failed),
+		Dropped:  atomic.LoadUint64(&c.shadow.dropped),
+	}
+}
+
+// shadowedOp reports whether requests of opcode are mirrored, and whether
+// they are writes.
+func shadowedOp(opcode int32) (ok bool, write bool) {
+	switch opcode {
+	case opGetData, opExists, opGetChildren, opGetChildren2, opGetAcl, opGetAllChildrenNumber:
+		return true, false
+	case opCreate, opCreate2, opCreateContainer, opCreateTTL, opDelete, opSetData, opSetAcl, opMulti:
+		return true, true
+	}
+	return false, false
+}
+
+// mirror sends a request answered by the primary ensemble with err to the
+// shadow ensemble, unless it is not mirrored.
+func (s *shadow) mirror(opcode int32, req, res interface{}, err error) {
+	ok, write := shadowedOp(opcode)
+	if !ok || write && !s.Writes || isConnectionError(err) || err == ErrClosing {
+		return
+	}
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	primary := shadowResult(res, err)
+	req = unwatchedRequest(req)
+	var shadowRes interface{}
+	if res != nil {
+		shadowRes = reflect.New(reflect.TypeOf(res).Elem()).Interface()
+	}
+	go func() {
+		defer func() { <-s.inFlight }()
+		_, shadowErr := s.Conn.request(opcode, req, shadowRes, nil)
+		if isConnectionError(shadowErr) || shadowErr == ErrClosing {
+			atomic.AddUint64(&s.failed, 1)
+			return
+		}
+		atomic.AddUint64(&s.mirrored, 1)
+		other := shadowResult(shadowRes, shadowErr)
+		if shadowErr == err && reflect.DeepEqual(primary, other) {
+			return
+		}
+		atomic.AddUint64(&s.diverged, 1)
+		if s.Diverged == nil {
+			return
+		}
+		var path string
+		if paths := requestPaths(req); len(paths) > 0 {
+			path = paths[0]
+		}
+		s.Diverged(ShadowDivergence{
+			Op:         opNames[opcode],
+			Path:       path,
+			Primary:    primary,
+			Shadow:     other,
+			PrimaryErr: err,
+			ShadowErr:  shadowErr,
+		})
+	}()
+}
+
+// shadowResult returns the part of a result that is compared between the
+// ensembles, copied so that the caller may keep using res. Stats are left
+// out, as zxids and times differ between ensembles.
+func shadowResult(res interface{}, err error) interface{} {
+	if err != nil {
+		return nil
+	}
+	switch r := res.(type) {
+	case *getDataResponse:
+		return string(r.Data)
+	case *getChildrenResponse:
+		return sortedCopy(r.Children)
+	case *getChildren2Response:
+		return sortedCopy(r.Children)
+	case *getAclResponse:
+		return append([]ACL(nil), r.Acl...)
+	case *createResponse:
+		return r.Path
+	case *create2Response:
+		return r.Path
+	}
+	return nil
+}
+
+func sortedCopy(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
+
+// unwatchedRequest returns req, or a copy of it without the watch flag, as
+// the shadow connection has no watchers for the events.
+func unwatchedRequest(req interface{}) interface{} {
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return req
+	}
+	watch := v.Elem().FieldByName("Watch")
+	if !watch.IsValid() || watch.Kind() != reflect.Bool || !watch.Bool() {
+		return req
+	}
+	cp := reflect.New(v.Elem().Type())
+	cp.Elem().Set(v.Elem())
+	cp.Elem().FieldByName("Watch").SetBool(false)
+	return cp.Interface()
+}
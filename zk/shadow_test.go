@@ -0,0 +1,97 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShadow(t *testing.T) {
+	t.Parallel()
+	ss := NewFakeServer()
+	defer ss.Close()
+	shadowConn, _, shadowFC := connectFake(t, ss)
+	defer shadowConn.Close()
+
+	diverged := make(chan ShadowDivergence, 1)
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()),
+		WithShadow(ShadowConfig{Conn: shadowConn, Diverged: func(d ShadowDivergence) { diverged <- d }}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	get := func(fc *FakeConn, data string) {
+		t.Helper()
+		req, err := fc.ExpectRequest("getData")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Path != "/node" {
+			t.Fatalf("getData request for %s", req.Path)
+		}
+		if err := fc.Reply(req, 1, nil, &getDataResponse{Data: []byte(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Writes are not mirrored by default, and watches never are.
+	done := make(chan error, 1)
+	go func() {
+		_, err := zk.Set("/node", []byte("x"), -1)
+		done <- err
+	}()
+	req, err := fc.ExpectRequest("setData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, nil, &setDataResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _, _, err := zk.GetW("/node")
+		done <- err
+	}()
+	get(fc, "x")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	req, err = shadowFC.ExpectRequest("getData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Body.(*getDataRequest).Watch {
+		t.Fatal("Mirrored read sets a watch")
+	}
+	if err := shadowFC.Reply(req, 1, nil, &getDataResponse{Data: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Differing results are reported.
+	go func() {
+		_, _, err := zk.Get("/node")
+		done <- err
+	}()
+	get(fc, "x")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	get(shadowFC, "y")
+	select {
+	case d := <-diverged:
+		if d.Op != "getData" || d.Path != "/node" || d.Primary != "x" || d.Shadow != "y" {
+			t.Fatalf("Unexpected divergence %+v", d)
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Divergence not reported")
+	}
+	if stats := zk.ShadowStats(); stats.Mirrored != 2 || stats.Diverged != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,191 @@
+package zk
+
+import "sync"
+
+// SharedValue is a value shared through the data of a node, like Curator's
+// SharedValue. A copy of the data is kept up to date by a watch, so reading
+// it does not ask the server, and changes are written with a version check
+// against the copy, so that they are only made by a client that saw the
+// current value.
+type SharedValue struct {
+	c    *Conn
+	path string
+	acl  []ACL
+	seed []byte
+
+	mu        sync.Mutex // protects value, stat, listeners, nextID and err
+	value     []byte
+	stat      *Stat // nil while the node does not exist
+	listeners map[int]func(value []byte)
+	nextID    int
+	notifyMu  sync.Mutex // runs the listeners one at a time
+
+	ready     chan struct{} // closed once the watch read the value, or failed to
+	readyOnce sync.Once
+	err       error // why the value was not read
+	w         *DataWatch
+}
+
+// NewSharedValue creates a new shared value instance using the provided
+// connection, path, and acl. The node is created with seed when the value is
+// started, if it does not exist.
+func NewSharedValue(c *Conn, path string, acl []ACL, seed []byte) *SharedValue {
+	return &SharedValue{
+		c:         c,
+		path:      path,
+		acl:       acl,
+		seed:      seed,
+		listeners: make(map[int]func(value []byte)),
+		ready:     make(chan struct{}),
+	}
+}
+
+// Start creates the node if needed and starts watching it. It returns once
+// the value was read.
+func (v *SharedValue) Start() error {
+	_, err := v.c.Create(v.path, v.seed, 0, v.acl)
+	if err == ErrNoNode {
+		if err := v.c.CreateParents(v.path, v.acl); err != nil {
+			return err
+		}
+		_, err = v.c.Create(v.path, v.seed, 0, v.acl)
+	}
+	if err != nil && err != ErrNodeExists {
+		return err
+	}
+
+	w, err := v.c.WatchData(v.path, v.changed)
+	if err != nil {
+		return err
+	}
+	v.w = w
+	select {
+	case <-v.ready:
+	case <-v.c.shouldQuit:
+		// The watch ends without reading the value.
+		v.done(ErrClosing)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.err
+}
+
+// Close stops watching the node. The value is not updated anymore.
+func (v *SharedValue) Close() {
+	if v.w != nil {
+		v.w.Stop()
+	}
+	v.done(ErrClosing)
+}
+
+// done ends waiting for the value to be read, with err if it was not.
+func (v *SharedValue) done(err error) {
+	v.readyOnce.Do(func() {
+		v.mu.Lock()
+		v.err = err
+		v.mu.Unlock()
+		close(v.ready)
+	})
+}
+
+// Value returns the cached data of the node and its version, or nil and -1
+// if the node does not exist.
+func (v *SharedValue) Value() ([]byte, int32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stat == nil {
+		return nil, -1
+	}
+	return append([]byte(nil), v.value...), v.stat.Version
+}
+
+// SetValue sets the data of the node if the cached copy is current, and
+// reports whether it did. If the node changed meanwhile the copy is read
+// again, so the caller can look at the new value before trying again.
+func (v *SharedValue) SetValue(data []byte) (bool, error) {
+	v.mu.Lock()
+	stat := v.stat
+	v.mu.Unlock()
+
+	var err error
+	if stat == nil {
+		_, stat, err = v.c.Create2(v.path, data, 0, v.acl)
+	} else {
+		stat, err = v.c.Set(v.path, data, stat.Version)
+	}
+	switch err {
+	case nil:
+		v.update(data, stat)
+		return true, nil
+	case ErrBadVersion, ErrNoNode, ErrNodeExists:
+		data, stat, err := v.c.Get(v.path)
+		if err == ErrNoNode {
+			v.refresh(nil, nil)
+		} else if err == nil {
+			v.refresh(data, stat)
+		}
+		return false, nil
+	}
+	return false, err
+}
+
+// AddListener calls fn with the new value whenever the node is seen changed
+// by someone else than this instance. The listeners run one at a time. The
+// returned function removes the listener.
+func (v *SharedValue) AddListener(fn func(value []byte)) (remove func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	id := v.nextID
+	v.nextID++
+	v.listeners[id] = fn
+	return func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		delete(v.listeners, id)
+	}
+}
+
+// changed is the callback of the watch.
+func (v *SharedValue) changed(ev Event, data []byte, stat *Stat) {
+	if ev.Type == EventNotWatching {
+		v.done(ev.Err)
+		return
+	}
+	v.refresh(data, stat)
+	v.done(nil)
+}
+
+// refresh updates the cached copy with a value read from the server, and
+// calls the listeners if it changed.
+func (v *SharedValue) refresh(data []byte, stat *Stat) {
+	v.notifyMu.Lock()
+	defer v.notifyMu.Unlock()
+	if !v.update(data, stat) {
+		return
+	}
+	v.mu.Lock()
+	listeners := make([]func([]byte), 0, len(v.listeners))
+	for _, fn := range v.listeners {
+		listeners = append(listeners, fn)
+	}
+	v.mu.Unlock()
+	for _, fn := range listeners {
+		fn(append([]byte(nil), data...))
+	}
+}
+
+// update replaces the cached copy unless it is newer, and reports whether it
+// changed.
+func (v *SharedValue) update(data []byte, stat *Stat) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch {
+	case stat == nil && v.stat == nil:
+		return false
+	case stat != nil && v.stat != nil && stat.Mzxid <= v.stat.Mzxid:
+		return false
+	}
+	v.value = data
+	v.stat = stat
+	return true
+}
@@ -0,0 +1,116 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSharedValue(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	v := NewSharedValue(zk, "/value", WorldACL(PermAll), []byte("seed"))
+
+	expectValue := func(data string, version int32) {
+		t.Helper()
+		if d, ver := v.Value(); string(d) != data || ver != version {
+			t.Fatalf("Value %q version %d, expected %q version %d", d, ver, data, version)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- v.Start() }()
+	serveFake(t, fc, "create", "/value", ErrNodeExists, nil)
+	serveFake(t, fc, "getData", "/value", nil, &getDataResponse{Data: []byte("a"), Stat: Stat{Version: 1, Mzxid: 1}})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expectValue("a", 1)
+
+	changes := make(chan string, 4)
+	v.AddListener(func(value []byte) { changes <- string(value) })
+	type result struct {
+		ok  bool
+		err error
+	}
+	set := make(chan result, 1)
+
+	// Setting checks the cached version.
+	go func() {
+		ok, err := v.SetValue([]byte("b"))
+		set <- result{ok, err}
+	}()
+	req := serveFake(t, fc, "setData", "/value", nil, &setDataResponse{Stat: Stat{Version: 2, Mzxid: 2}})
+	if r := req.Body.(*SetDataRequest); r.Version != 1 {
+		t.Fatalf("Set with version %d", r.Version)
+	}
+	if r := <-set; !r.ok || r.err != nil {
+		t.Fatalf("SetValue returned %v, %+v", r.ok, r.err)
+	}
+	expectValue("b", 2)
+
+	// The watch sees the change made here, which is not reported, and then
+	// one made remotely.
+	if err := fc.SendEvent(2, EventNodeDataChanged, "/value"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/value", nil, &getDataResponse{Data: []byte("b"), Stat: Stat{Version: 2, Mzxid: 2}})
+	if err := fc.SendEvent(3, EventNodeDataChanged, "/value"); err != nil {
+		t.Fatal(err)
+	}
+	serveFake(t, fc, "getData", "/value", nil, &getDataResponse{Data: []byte("c"), Stat: Stat{Version: 3, Mzxid: 3}})
+	select {
+	case c := <-changes:
+		if c != "c" {
+			t.Fatalf("Listener called with %q", c)
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Listener not called")
+	}
+	expectValue("c", 3)
+
+	// A stale copy is read again.
+	if err := fc.SendEvent(4, EventNodeDataChanged, "/value"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		ok, err := v.SetValue([]byte("d"))
+		set <- result{ok, err}
+	}()
+	for served := false; !served; {
+		req, err := fc.NextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch req.Op {
+		case "setData":
+			err = fc.Reply(req, 1, ErrBadVersion, nil)
+		case "getData":
+			err = fc.Reply(req, 1, nil, &getDataResponse{Data: []byte("e"), Stat: Stat{Version: 4, Mzxid: 4}})
+			served = !req.Body.(*getDataRequest).Watch
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := <-set; r.ok || r.err != nil {
+		t.Fatalf("SetValue returned %v, %+v", r.ok, r.err)
+	}
+	expectValue("e", 4)
+	select {
+	case c := <-changes:
+		if c != "e" {
+			t.Fatalf("Listener called with %q", c)
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Listener not called")
+	}
+	select {
+	case c := <-changes:
+		t.Fatalf("Listener called again with %q", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+	v.Close()
+}
@@ -0,0 +1,91 @@
+//go:build go1.21
+// +build go1.21
+
+package zk
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// slogLogger is a LeveledLogger writing to a slog.Logger, with the
+// component of the messages, and the session ID, server and state of conn,
+// if set, as attributes of every record.
+type slogLogger struct {
+	l    *slog.Logger
+	conn *Conn
+}
+
+// NewSlogLogger returns a LeveledLogger writing to l, for SetLogger or
+// DefaultLogger. Use WithSlog to also have the session ID, server and state
+// of the connection as attributes.
+func NewSlogLogger(l *slog.Logger) LeveledLogger {
+	return &slogLogger{l: l}
+}
+
+// WithSlog returns a connection option that logs to l, with the component,
+// and the session ID, server and state of the connection as attributes of
+// every record, along with the fields of the messages, such as the xid of a
+// request. The levels of the messages map to those of slog; the connection
+// still drops the messages below the levels set with SetLogLevel.
+func WithSlog(l *slog.Logger) connOption {
+	return func(c *Conn) {
+		c.logger = &slogLogger{l: l, conn: c}
+	}
+}
+
+var slogLevels = map[LogLevel]slog.Level{
+	LogDebug: slog.LevelDebug,
+	LogInfo:  slog.LevelInfo,
+	LogWarn:  slog.LevelWarn,
+	LogError: slog.LevelError,
+}
+
+func (s *slogLogger) Printf(format string, args ...interface{}) {
+	s.log(slog.LevelInfo, fmt.Sprintf(format, args...), nil)
+}
+
+func (s *slogLogger) Log(msg string, keysAndValues ...interface{}) {
+	s.log(slog.LevelInfo, msg, keysAndValues)
+}
+
+func (s *slogLogger) LogAt(level LogLevel, component LogComponent, msg string, keysAndValues ...interface{}) {
+	s.log(slogLevels[level], msg, append(keysAndValues, "component", component.String()))
+}
+
+func (s *slogLogger) log(level slog.Level, msg string, keysAndValues []interface{}) {
+	ctx := context.Background()
+	if !s.l.Enabled(ctx, level) {
+		return
+	}
+	if s.conn != nil {
+		keysAndValues = s.connFields(keysAndValues)
+	}
+	s.l.Log(ctx, level, msg, keysAndValues...)
+}
+
+// connFields appends the fields of the connection to keysAndValues, unless
+// they are there already.
+func (s *slogLogger) connFields(keysAndValues []interface{}) []interface{} {
+	has := func(key string) bool {
+		for i := 0; i < len(keysAndValues); i += 2 {
+			if keysAndValues[i] == key {
+				return true
+			}
+		}
+		return false
+	}
+	fields := make([]interface{}, 0, len(keysAndValues)+6)
+	fields = append(fields, keysAndValues...)
+	if !has("sessionID") {
+		fields = append(fields, "sessionID", s.conn.SessionID())
+	}
+	if !has("server") {
+		fields = append(fields, "server", s.conn.Server())
+	}
+	if !has("state") {
+		fields = append(fields, "state", s.conn.State().String())
+	}
+	return fields
+}
@@ -0,0 +1,64 @@
+//go:build go1.21
+// +build go1.21
+
+package zk
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestSlog(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	var buf lockedBuffer
+	l := slog.New(slog.NewJSONHandler(&buf, nil))
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithSlog(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	// The connection logs once it has the session.
+	var authenticated map[string]interface{}
+	for deadline := time.Now().Add(fakeTimeout); authenticated == nil; {
+		if time.Now().After(deadline) {
+			t.Fatalf("No Authenticated record in %s", buf.Bytes())
+		}
+		time.Sleep(time.Millisecond)
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var record map[string]interface{}
+			if err := json.Unmarshal(line, &record); err == nil && record["msg"] == "Authenticated" {
+				authenticated = record
+			}
+		}
+	}
+	if authenticated["sessionID"] != float64(1) || authenticated["server"] != "127.0.0.1:2181" || authenticated["state"] == nil || authenticated["component"] != "connection" || authenticated["level"] != "INFO" {
+		t.Fatalf("Authenticated record %v", authenticated)
+	}
+}
@@ -0,0 +1,175 @@
+package zk
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSRVRefreshInterval is how often an SRVHostProvider re-resolves its
+// SRV names by default.
+const DefaultSRVRefreshInterval = time.Minute
+
+// SRVHostProvider is a HostProvider that discovers the servers from DNS SRV
+// records, so that the ensemble members need not be listed in the
+// configuration. Servers passed to Init whose host starts with an underscore,
+// e.g. "_zookeeper._tcp.example.com", are looked up as SRV names and their
+// port is ignored; other servers are used as they are. Servers are tried in
+// the order of RFC 2782: by priority, and at random weighted by weight within
+// a priority.
+//
+// The names are resolved again every RefreshInterval, when the next server is
+// picked. If that fails, the previous servers are kept.
+type SRVHostProvider struct {
+	// RefreshInterval is how often the SRV names are resolved again. It
+	// defaults to DefaultSRVRefreshInterval; a negative interval disables
+	// refreshing.
+	RefreshInterval time.Duration
+
+	mu        sync.Mutex
+	names     []string
+	servers   []string
+	curr      int
+	last      int
+	resolved  time.Time
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error) // Override of net.LookupSRV, for testing.
+	rand      *rand.Rand
+}
+
+// Init is called first, with the servers specified in the connection
+// string. It resolves the SRV names among them.
+func (hp *SRVHostProvider) Init(servers []string) error {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if hp.rand == nil {
+		hp.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	found, err := hp.resolve(servers)
+	if err != nil {
+		return err
+	}
+	hp.names = servers
+	hp.setServers(found)
+	return nil
+}
+
+// Len returns the number of servers available
+func (hp *SRVHostProvider) Len() int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return len(hp.servers)
+}
+
+// Next returns the next server to connect to. retryStart will be true
+// if we've looped through all known servers without Connected() being
+// called.
+func (hp *SRVHostProvider) Next() (server string, retryStart bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	interval := hp.RefreshInterval
+	if interval == 0 {
+		interval = DefaultSRVRefreshInterval
+	}
+	if interval > 0 && time.Since(hp.resolved) >= interval {
+		if found, err := hp.resolve(hp.names); err == nil {
+			hp.setServers(found)
+		} else {
+			// Try again later rather than on every attempt.
+			hp.resolved = time.Now()
+		}
+	}
+
+	hp.curr = (hp.curr + 1) % len(hp.servers)
+	retryStart = hp.curr == hp.last
+	if hp.last == -1 {
+		hp.last = 0
+	}
+	return hp.servers[hp.curr], retryStart
+}
+
+// Connected notifies the HostProvider of a successful connection.
+func (hp *SRVHostProvider) Connected() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.last = hp.curr
+}
+
+func (hp *SRVHostProvider) setServers(servers []string) {
+	hp.servers = servers
+	hp.curr = -1
+	hp.last = -1
+	hp.resolved = time.Now()
+}
+
+// resolve returns the servers, with the SRV names among them resolved.
+func (hp *SRVHostProvider) resolve(servers []string) ([]string, error) {
+	lookupSRV := hp.lookupSRV
+	if lookupSRV == nil {
+		lookupSRV = net.LookupSRV
+	}
+
+	found := []string{}
+	for _, server := range servers {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if !strings.HasPrefix(host, "_") {
+			found = append(found, server)
+			continue
+		}
+		_, records, err := lookupSRV("", "", host)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range orderSRV(records, hp.rand) {
+			found = append(found, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, fmt.Errorf("No hosts found for addresses %q", servers)
+	}
+	return found, nil
+}
+
+// orderSRV orders records for connection attempts as described by RFC 2782:
+// by ascending priority, and within a priority by repeatedly picking one of
+// the remaining records at random in proportion to its weight.
+func orderSRV(records []*net.SRV, r *rand.Rand) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	ordered := make([]*net.SRV, 0, len(sorted))
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		group := sorted[start:end]
+		for len(group) > 0 {
+			total := 0
+			for _, srv := range group {
+				// Zero weights get a small chance of being picked early.
+				total += int(srv.Weight) + 1
+			}
+			n := r.Intn(total)
+			i := 0
+			for ; n >= int(group[i].Weight)+1; i++ {
+				n -= int(group[i].Weight) + 1
+			}
+			ordered = append(ordered, group[i])
+			group = append(group[:i:i], group[i+1:]...)
+		}
+		start = end
+	}
+	return ordered
+}
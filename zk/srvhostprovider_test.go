@@ -0,0 +1,92 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestOrderSRV(t *testing.T) {
+	t.Parallel()
+	records := []*net.SRV{
+		{Target: "backup.", Port: 2181, Priority: 20, Weight: 0},
+		{Target: "heavy.", Port: 2181, Priority: 10, Weight: 90},
+		{Target: "light.", Port: 2181, Priority: 10, Weight: 10},
+	}
+	r := rand.New(rand.NewSource(1))
+	first := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		ordered := orderSRV(records, r)
+		if len(ordered) != 3 || ordered[2].Target != "backup." {
+			t.Fatalf("Lower priority record not last in %v", ordered)
+		}
+		first[ordered[0].Target]++
+	}
+	if first["heavy."] < 800 || first["light."] < 50 {
+		t.Fatalf("Order does not follow the weights: %v", first)
+	}
+}
+
+func TestSRVHostProvider(t *testing.T) {
+	t.Parallel()
+	records := []*net.SRV{
+		{Target: "zk1.example.com.", Port: 2181, Priority: 10, Weight: 1},
+		{Target: "zk2.example.com.", Port: 2182, Priority: 10, Weight: 1},
+	}
+	var lookupErr error
+	lookups := 0
+	hp := &SRVHostProvider{
+		RefreshInterval: time.Hour,
+		lookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+			lookups++
+			if name != "_zookeeper._tcp.example.com" {
+				return "", nil, fmt.Errorf("unexpected name %q", name)
+			}
+			return name, records, lookupErr
+		},
+	}
+	if err := hp.Init([]string{"_zookeeper._tcp.example.com:2181", "static:2181"}); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := func() []string {
+		var got []string
+		for i := 0; i < hp.Len(); i++ {
+			server, _ := hp.Next()
+			got = append(got, server)
+		}
+		sort.Strings(got)
+		return got
+	}
+	if got := servers(); !reflect.DeepEqual(got, []string{"static:2181", "zk1.example.com:2181", "zk2.example.com:2182"}) {
+		t.Fatalf("Unexpected servers %v", got)
+	}
+	if _, retryStart := hp.Next(); !retryStart {
+		t.Fatal("Expected retryStart after trying all servers")
+	}
+
+	// The records are looked up again once the interval passed, and kept if
+	// that fails.
+	records = records[:1]
+	hp.resolved = time.Now().Add(-2 * time.Hour)
+	if got := servers(); !reflect.DeepEqual(got, []string{"static:2181", "zk1.example.com:2181"}) {
+		t.Fatalf("Servers not refreshed: %v", got)
+	}
+	lookupErr = errors.New("no such host")
+	hp.resolved = time.Now().Add(-2 * time.Hour)
+	if got := servers(); !reflect.DeepEqual(got, []string{"static:2181", "zk1.example.com:2181"}) {
+		t.Fatalf("Servers not kept after a failed lookup: %v", got)
+	}
+	if lookups != 3 {
+		t.Fatalf("Expected 3 lookups, got %d", lookups)
+	}
+
+	if err := (&SRVHostProvider{lookupSRV: hp.lookupSRV}).Init([]string{"_zookeeper._tcp.example.com:2181"}); err == nil {
+		t.Fatal("Init succeeded with a failing lookup")
+	}
+}
@@ -89,6 +89,56 @@ type ServerStats struct {
 	Error       error
 }
 
+// ServerStatus is the output of the `stat` command: the srvr output of
+// ServerStats along with the clients. Only the address and packet counts of
+// the clients are set; the `cons` command has the rest.
+type ServerStatus struct {
+	ServerStats
+	Clients []*ServerClient
+}
+
+// MonitorStats is the output of the `mntr` command. The values are also in
+// Values by their key, e.g. "zk_znode_count", including those of newer
+// servers without a field here.
+type MonitorStats struct {
+	Version                 string
+	AvgLatency              float64
+	MinLatency              int64
+	MaxLatency              int64
+	PacketsReceived         int64
+	PacketsSent             int64
+	AliveConnections        int64
+	OutstandingRequests     int64
+	Mode                    Mode
+	ZnodeCount              int64
+	WatchCount              int64
+	EphemeralsCount         int64
+	ApproximateDataSize     int64
+	OpenFileDescriptorCount int64
+	MaxFileDescriptorCount  int64
+	Followers               int64 // leader only
+	SyncedFollowers         int64 // leader only
+	PendingSyncs            int64 // leader only
+	Values                  map[string]string
+	Error                   error
+}
+
+// WatchStats is the output of the `wchs` command.
+type WatchStats struct {
+	Connections int64 // connections with watches
+	Paths       int64 // paths watched
+	Watches     int64
+	Error       error
+}
+
+// LeaderInfo is the output of the `leader` command of the AdminServer.
+type LeaderInfo struct {
+	IsLeader bool
+	LeaderID int64 // the myid of the leader
+	LeaderIP string
+	Error    error
+}
+
 type requestHeader struct {
 	Xid    int32
 	Opcode int32
@@ -139,6 +189,18 @@ type statResponse struct {
 //
 
 type CheckVersionRequest PathVersionRequest
+
+// CheckCversionRequest is a Multi op that fails with ErrBadVersion unless
+// the children of the node at Path changed Cversion times, or -1 for any.
+// The server has no such check, so Multi reads the node's stat before it
+// sends the ops and sends a check of the data version read with it, which
+// also fails if the node was changed or replaced since. Only a child
+// created and deleted again between the two requests goes unnoticed.
+type CheckCversionRequest struct {
+	Path     string
+	Cversion int32
+}
+
 type closeRequest struct{}
 type closeResponse struct{}
 
@@ -148,6 +210,7 @@ type connectRequest struct {
 	TimeOut         int32
 	SessionID       int64
 	Passwd          []byte
+	ReadOnly        bool
 }
 
 type connectResponse struct {
@@ -165,6 +228,11 @@ type CreateRequest struct {
 }
 
 type createResponse pathResponse
+
+type create2Response struct {
+	Path string
+	Stat Stat
+}
 type DeleteRequest PathVersionRequest
 type deleteResponse struct{}
 
@@ -194,6 +262,41 @@ type getChildren2Response struct {
 	Stat     Stat
 }
 
+// rawChildrenResponse is a getChildren2Response that keeps the encoded
+// children in a single buffer, to be decoded one at a time by a
+// ChildrenIterator.
+type rawChildrenResponse struct {
+	Children []byte // encoded like the Children of getChildren2Response
+	Count    int
+	Stat     Stat
+}
+
+func (r *rawChildrenResponse) Decode(buf []byte) (int, error) {
+	if len(buf) < 4 {
+		return 0, ErrShortBuffer
+	}
+	count := int(int32(binary.BigEndian.Uint32(buf)))
+	if count < 0 {
+		count = 0
+	}
+	n := 4
+	for i := 0; i < count; i++ {
+		if len(buf) < n+4 {
+			return n, ErrShortBuffer
+		}
+		l := int(int32(binary.BigEndian.Uint32(buf[n:])))
+		if l < 0 || len(buf) < n+4+l {
+			return n, ErrShortBuffer
+		}
+		n += 4 + l
+	}
+	// The receive buffer is reused, so keep a copy.
+	r.Children = append(r.Children[:0], buf[4:n]...)
+	r.Count = count
+	m, err := decodePacket(buf[n:], &r.Stat)
+	return n + m, err
+}
+
 type getDataRequest pathWatchRequest
 
 type getDataResponse struct {
@@ -236,11 +339,11 @@ type setMaxChildren struct {
 }
 
 type setSaslRequest struct {
-	Token string
+	Token []byte
 }
 
 type setSaslResponse struct {
-	Token string
+	Token []byte
 }
 
 type setWatchesRequest struct {
@@ -252,6 +355,59 @@ type setWatchesRequest struct {
 
 type setWatchesResponse struct{}
 
+type setWatches2Request struct {
+	RelativeZxid               int64
+	DataWatches                []string
+	ExistWatches               []string
+	ChildWatches               []string
+	PersistentWatches          []string
+	PersistentRecursiveWatches []string
+}
+
+type addWatchRequest struct {
+	Path string
+	Mode WatchMode
+}
+
+type addWatchResponse errorResponse
+
+type checkWatchesRequest struct {
+	Path string
+	Type WatcherType
+}
+
+type checkWatchesResponse struct{}
+
+type removeWatchesRequest checkWatchesRequest
+type removeWatchesResponse struct{}
+
+// ClientInfo is an auth identity that the server associated with a
+// connection.
+type ClientInfo struct {
+	AuthScheme string
+	User       string
+}
+
+type whoAmIRequest struct{}
+
+type whoAmIResponse struct {
+	ClientInfo []ClientInfo
+}
+
+type createTTLRequest struct {
+	Path  string
+	Data  []byte
+	Acl   []ACL
+	Flags int32
+	Ttl   int64
+}
+
+type getAllChildrenNumberRequest pathRequest
+
+type getAllChildrenNumberResponse struct {
+	TotalNumber int32
+}
+
 type syncRequest pathRequest
 type syncResponse pathResponse
 
@@ -276,6 +432,30 @@ type multiResponse struct {
 	DoneHeader multiHeader
 }
 
+// GetOp reads the data and Stat of a node in MultiRead.
+type GetOp struct {
+	Path string
+}
+
+// ChildrenOp lists the children of a node in MultiRead.
+type ChildrenOp struct {
+	Path string
+}
+
+// MultiReadResponse is the result of one operation of MultiRead. Data and
+// Stat are set for a *GetOp, Children for a *ChildrenOp.
+type MultiReadResponse struct {
+	Data     []byte
+	Stat     *Stat
+	Children []string
+	Err      error
+}
+
+type multiReadResponse struct {
+	Ops        []MultiReadResponse
+	DoneHeader multiHeader
+}
+
 func (r *multiRequest) Encode(buf []byte) (int, error) {
 	total := 0
 	for _, op := range r.Ops {
@@ -349,6 +529,14 @@ func (r *multiResponse) Decode(buf []byte) (int, error) {
 			return total, ErrAPIError
 		case opCreate:
 			w = reflect.ValueOf(&res.String)
+		case opCreate2, opCreateContainer:
+			cr := &create2Response{}
+			n, err := decodePacketValue(buf[total:], reflect.ValueOf(cr))
+			if err != nil {
+				return total, err
+			}
+			total += n
+			res.String, res.Stat = cr.Path, &cr.Stat
 		case opSetData:
 			res.Stat = new(Stat)
 			w = reflect.ValueOf(res.Stat)
@@ -366,6 +554,53 @@ func (r *multiResponse) Decode(buf []byte) (int, error) {
 	return total, nil
 }
 
+func (r *multiReadResponse) Decode(buf []byte) (int, error) {
+	r.Ops = make([]MultiReadResponse, 0)
+	r.DoneHeader = multiHeader{-1, true, -1}
+	total := 0
+	for {
+		header := &multiHeader{}
+		n, err := decodePacketValue(buf[total:], reflect.ValueOf(header))
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if header.Done {
+			r.DoneHeader = *header
+			break
+		}
+
+		var res MultiReadResponse
+		var w interface{}
+		switch header.Type {
+		default:
+			return total, ErrAPIError
+		case opGetData:
+			w = &getDataResponse{}
+		case opGetChildren:
+			w = &getChildrenResponse{}
+		case -1:
+			// The operation failed.
+			w = &errorResponse{}
+		}
+		n, err = decodePacketValue(buf[total:], reflect.ValueOf(w))
+		if err != nil {
+			return total, err
+		}
+		total += n
+		switch w := w.(type) {
+		case *getDataResponse:
+			res.Data, res.Stat = w.Data, &w.Stat
+		case *getChildrenResponse:
+			res.Children = w.Children
+		case *errorResponse:
+			res.Err = ErrCode(w.Err).toError()
+		}
+		r.Ops = append(r.Ops, res)
+	}
+	return total, nil
+}
+
 type watcherEvent struct {
 	Type  EventType
 	State State
@@ -595,6 +830,26 @@ func requestStructForOp(op int32) interface{} {
 		return &CheckVersionRequest{}
 	case opMulti:
 		return &multiRequest{}
+	case opSasl:
+		return &getSaslRequest{}
+	case opSetWatches2:
+		return &setWatches2Request{}
+	case opAddWatch:
+		return &addWatchRequest{}
+	case opCheckWatches:
+		return &checkWatchesRequest{}
+	case opMultiRead:
+		return &multiRequest{}
+	case opWhoAmI:
+		return &whoAmIRequest{}
+	case opGetAllChildrenNumber:
+		return &getAllChildrenNumberRequest{}
+	case opCreateTTL:
+		return &createTTLRequest{}
+	case opCreate2, opCreateContainer:
+		return &CreateRequest{}
+	case opRemoveWatches:
+		return &removeWatchesRequest{}
 	}
 	return nil
 }
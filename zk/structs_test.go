@@ -15,6 +15,7 @@ func TestEncodeDecodePacket(t *testing.T) {
 	encodeDecodeTest(t, &pathWatchRequest{"path", true})
 	encodeDecodeTest(t, &pathWatchRequest{"path", false})
 	encodeDecodeTest(t, &CheckVersionRequest{"/", -1})
+	encodeDecodeTest(t, &whoAmIResponse{[]ClientInfo{{"ip", "127.0.0.1"}, {"digest", "user"}}})
 	encodeDecodeTest(t, &multiRequest{Ops: []multiRequestOp{{multiHeader{opCheck, false, -1}, &CheckVersionRequest{"/", -1}}}})
 }
 
@@ -72,6 +73,68 @@ func TestDecodeShortBuffer(t *testing.T) {
 	}
 }
 
+func TestDecodeMultiResponseContainer(t *testing.T) {
+	t.Parallel()
+	buf := make([]byte, 256)
+	total := 0
+	for _, st := range []interface{}{
+		&multiHeader{opCreateContainer, false, -1},
+		&create2Response{"/container", Stat{Czxid: 7}},
+		&multiHeader{-1, true, -1},
+	} {
+		n, err := encodePacket(buf[total:], st)
+		if err != nil {
+			t.Fatalf("encodePacket returned error: %+v", err)
+		}
+		total += n
+	}
+
+	res := &multiResponse{}
+	if _, err := decodePacket(buf[:total], res); err != nil {
+		t.Fatalf("decodePacket returned error: %+v", err)
+	}
+	if len(res.Ops) != 1 {
+		t.Fatalf("Expected 1 op, got %d", len(res.Ops))
+	}
+	if op := res.Ops[0]; op.String != "/container" || op.Stat == nil || op.Stat.Czxid != 7 {
+		t.Fatalf("Wrong container create response %+v", op)
+	}
+}
+
+func TestDecodeMultiReadResponse(t *testing.T) {
+	t.Parallel()
+	buf := make([]byte, 256)
+	total := 0
+	for _, st := range []interface{}{
+		&multiHeader{opGetData, false, -1},
+		&getDataResponse{[]byte{1, 2}, Stat{Version: 3}},
+		&multiHeader{-1, false, errNoNode},
+		&errorResponse{int32(errNoNode)},
+		&multiHeader{opGetChildren, false, -1},
+		&getChildrenResponse{[]string{"a", "b"}},
+		&multiHeader{-1, true, -1},
+	} {
+		n, err := encodePacket(buf[total:], st)
+		if err != nil {
+			t.Fatalf("encodePacket returned error: %+v", err)
+		}
+		total += n
+	}
+
+	res := &multiReadResponse{}
+	if _, err := decodePacket(buf[:total], res); err != nil {
+		t.Fatalf("decodePacket returned error: %+v", err)
+	}
+	expected := []MultiReadResponse{
+		{Data: []byte{1, 2}, Stat: &Stat{Version: 3}},
+		{Err: ErrNoNode},
+		{Children: []string{"a", "b"}},
+	}
+	if !reflect.DeepEqual(res.Ops, expected) {
+		t.Fatalf("Decoded %+v instead of %+v", res.Ops, expected)
+	}
+}
+
 func BenchmarkEncode(b *testing.B) {
 	buf := make([]byte, 4096)
 	st := &connectRequest{Passwd: []byte("1234567890")}
@@ -0,0 +1,56 @@
+package zk
+
+// Subscribe returns a channel receiving the session events of the
+// connection, like the channel returned by Connect, so that several
+// components can observe the state independently. Each channel receives
+// every event sent after it subscribed, in order, and State tells the state
+// before the first one. Events are queued so that a slow subscriber never
+// blocks the connection or the other subscribers, but the channel must be
+// drained; WithEventBuffer bounds the queue. It is closed after Unsubscribe,
+// or once the connection is closed.
+func (c *Conn) Subscribe() <-chan Event {
+	w := c.limitWatcher(newPersistentWatcher(nil), c.eventBuffer, c.eventPolicy)
+	c.subscribersLock.Lock()
+	defer c.subscribersLock.Unlock()
+	if c.subscribersClosed {
+		w.close()
+		return w.ch
+	}
+	if c.subscribers == nil {
+		c.subscribers = make(map[<-chan Event]*persistentWatcher)
+	}
+	c.subscribers[w.ch] = w
+	return w.ch
+}
+
+// Unsubscribe stops sending events to a channel returned by Subscribe, which
+// is closed once the events queued already are received.
+func (c *Conn) Unsubscribe(ch <-chan Event) {
+	c.subscribersLock.Lock()
+	defer c.subscribersLock.Unlock()
+	if w, ok := c.subscribers[ch]; ok {
+		delete(c.subscribers, ch)
+		w.close()
+	}
+}
+
+// publish sends a session event to the subscribers.
+func (c *Conn) publish(ev Event) {
+	c.subscribersLock.Lock()
+	defer c.subscribersLock.Unlock()
+	for _, w := range c.subscribers {
+		w.deliver(ev, -1)
+	}
+}
+
+// closeSubscribers closes the subscribed channels once the connection is
+// closed.
+func (c *Conn) closeSubscribers() {
+	c.subscribersLock.Lock()
+	defer c.subscribersLock.Unlock()
+	for _, w := range c.subscribers {
+		w.close()
+	}
+	c.subscribers = nil
+	c.subscribersClosed = true
+}
@@ -0,0 +1,69 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+	sub1, sub2 := zk.Subscribe(), zk.Subscribe()
+
+	expectState := func(sub <-chan Event, state State) {
+		t.Helper()
+		select {
+		case ev := <-sub:
+			if ev.Type != EventSession || ev.State != state {
+				t.Fatalf("Received %+v instead of %s", ev, state)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatalf("Timed out waiting for %s", state)
+		}
+	}
+	expectClosed := func(sub <-chan Event) {
+		t.Helper()
+		select {
+		case ev, ok := <-sub:
+			if ok {
+				t.Fatalf("Unexpected event %+v", ev)
+			}
+		case <-time.After(fakeTimeout):
+			t.Fatal("Channel not closed")
+		}
+	}
+
+	// Both subscribers and the channel of Connect see every event.
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	for _, sub := range []<-chan Event{sub1, sub2} {
+		expectState(sub, StateDisconnected)
+		expectState(sub, StateConnecting)
+	}
+
+	zk.Unsubscribe(sub1)
+	expectClosed(sub1)
+	fc = acceptFake(t, s, 1)
+	defer fc.Close()
+	waitForState(t, ch, StateHasSession)
+	expectState(sub2, StateConnected)
+	expectState(sub2, StateHasSession)
+
+	go zk.Close()
+	req, err := fc.ExpectRequest("close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, ok := <-sub2; !ok {
+			break
+		}
+	}
+	expectClosed(zk.Subscribe())
+}
@@ -0,0 +1,132 @@
+package zk
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// WithTLSConfig returns a connection option that connects to the servers over
+// TLS, e.g. to the secure client port (ssl.clientPort, usually 2281). If
+// config.ServerName is empty the host of each server address is used to
+// verify its certificate. Set config.InsecureSkipVerify to disable verification.
+func WithTLSConfig(config *tls.Config) connOption {
+	return func(c *Conn) {
+		c.tlsConfig = config
+	}
+}
+
+// WithClientCertificate returns a connection option that presents the
+// certificate returned by getCert when the server requests one (mutual TLS).
+// getCert is called on every handshake, so rotated certificates are picked up
+// on reconnect without restarting the process. See CertificateReloader.
+// It implies WithTLSConfig with a default config if none was given.
+func WithClientCertificate(getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) connOption {
+	return func(c *Conn) {
+		c.getClientCertificate = getCert
+	}
+}
+
+// TLSDialer returns a Dialer that dials with dialer and performs a TLS
+// handshake over the resulting connection using config. The handshake must
+// complete within the dial timeout.
+func TLSDialer(dialer Dialer, config *tls.Config) Dialer {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dialer(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := config
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			cfg = config.Clone()
+			cfg.ServerName = host
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		if timeout > 0 {
+			tlsConn.SetDeadline(start.Add(timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// CertificateReloader provides a client certificate loaded from a PEM
+// encoded certificate and key file pair. The files are checked on every
+// handshake and reloaded when they change, which suits short-lived
+// certificates rotated on disk (e.g. by SPIFFE or Vault agents).
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertificateReloader loads the certificate and key from the given files
+// and returns a CertificateReloader for them.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	cr := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetClientCertificate returns the current certificate, reloading it first
+// if the files have been modified. It can be passed to WithClientCertificate.
+// If reloading fails, e.g. because the files are being rewritten, the
+// previously loaded certificate is returned.
+func (cr *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, err := cr.load()
+	if err != nil {
+		cr.mu.Lock()
+		defer cr.mu.Unlock()
+		if cr.cert != nil {
+			return cr.cert, nil
+		}
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (cr *CertificateReloader) load() (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cr.cert != nil && certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod) {
+		return cr.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cr.cert = &cert
+	cr.certMod = certInfo.ModTime()
+	cr.keyMod = keyInfo.ModTime()
+	return cr.cert, nil
+}
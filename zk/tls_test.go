@@ -0,0 +1,173 @@
+package zk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate valid for 127.0.0.1
+// and a pool containing it.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gozk-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// startTLSEchoServer starts a TLS server that echoes back everything it reads.
+func startTLSEchoServer(t *testing.T, config *tls.Config) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			cn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer cn.Close()
+				io.Copy(cn, cn)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestTLSDialer(t *testing.T) {
+	t.Parallel()
+	cert, pool := newTestCertificate(t)
+	ln := startTLSEchoServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer ln.Close()
+
+	dialer := TLSDialer(net.DialTimeout, &tls.Config{RootCAs: pool})
+	conn, err := dialer("tcp", ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("TLS dial returned error: %+v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ruok")); err != nil {
+		t.Fatalf("Write returned error: %+v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read returned error: %+v", err)
+	} else if string(buf) != "ruok" {
+		t.Fatalf("Expected ruok instead of %q", buf)
+	}
+
+	// Verification must fail against a server name not in the certificate.
+	dialer = TLSDialer(net.DialTimeout, &tls.Config{RootCAs: pool, ServerName: "zk.example.com"})
+	if _, err := dialer("tcp", ln.Addr().String(), time.Second); err == nil {
+		t.Fatal("Expected TLS dial with wrong server name to fail")
+	}
+}
+
+// writeTestCertificate writes cert and its key as PEM files into dir.
+func writeTestCertificate(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	keyDer, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertificateReloader(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gozk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert1, pool1 := newTestCertificate(t)
+	cert2, pool2 := newTestCertificate(t)
+	certFile, keyFile := writeTestCertificate(t, dir, cert1)
+
+	cr, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader returned error: %+v", err)
+	}
+
+	// The server only trusts the second certificate, which is rotated in later.
+	ln := startTLSEchoServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert1},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool2,
+	})
+	defer ln.Close()
+
+	config := &tls.Config{RootCAs: pool1, GetClientCertificate: cr.GetClientCertificate}
+	dial := func() error {
+		conn, err := TLSDialer(net.DialTimeout, config)("tcp", ln.Addr().String(), time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("ruok")); err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	if err := dial(); err == nil {
+		t.Fatal("Expected handshake with untrusted client certificate to fail")
+	}
+
+	writeTestCertificate(t, dir, cert2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+
+	if err := dial(); err != nil {
+		t.Fatalf("Expected handshake with rotated client certificate to succeed: %+v", err)
+	}
+}
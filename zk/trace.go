@@ -0,0 +1,111 @@
+package zk
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// TraceSelector reports whether requests on a path should be traced.
+type TraceSelector func(path string) bool
+
+// TracePrefix returns a TraceSelector matching paths that start with any of
+// the prefixes. Use a trailing slash, e.g. "/app/", to select the children
+// of a node only.
+func TracePrefix(prefixes ...string) TraceSelector {
+	return func(p string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TracePattern returns a TraceSelector matching paths that match any of the
+// patterns, using the syntax of path.Match. A * does not match across path
+// components. Malformed patterns match nothing.
+func TracePattern(patterns ...string) TraceSelector {
+	return func(p string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WithTracing returns a connection option that logs every request on a path
+// matched by one of the selectors, along with its response, error and
+// latency. It allows debugging the traffic of a single subtree without
+// logging that of the whole application. Requests that do not refer to a
+// path, such as pings, are not traced.
+func WithTracing(selectors ...TraceSelector) connOption {
+	return func(c *Conn) {
+		c.traceSelectors = append(c.traceSelectors, selectors...)
+	}
+}
+
+// WithSlowRequestLogging returns a connection option that logs every request
+// whose reply took longer than threshold to arrive, with its operation,
+// paths, xid and latency, to diagnose intermittent latency of the ensemble
+// from the client side. The latency is measured from when the request was
+// sent, so it excludes the time spent waiting for a connection.
+func WithSlowRequestLogging(threshold time.Duration) connOption {
+	return func(c *Conn) {
+		c.slowThreshold = threshold
+	}
+}
+
+// traced reports whether req refers to a path matched by a trace selector.
+func (c *Conn) traced(req interface{}) bool {
+	if len(c.traceSelectors) == 0 {
+		return false
+	}
+	for _, p := range requestPaths(req) {
+		p = c.clientPath(p)
+		for _, selector := range c.traceSelectors {
+			if selector(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trace logs a completed request.
+func (c *Conn) trace(opcode int32, req, res interface{}, r response, latency time.Duration) {
+	c.logf(LogInfo, LogRequests, "Trace: %s %+v -> %+v zxid=%d err=%v latency=%s", opNames[opcode], req, res, r.zxid, r.err, latency)
+}
+
+// requestPaths returns the paths a request refers to: the paths of all its
+// operations for a multi request, and its Path field otherwise.
+func requestPaths(req interface{}) []string {
+	if m, ok := req.(*multiRequest); ok {
+		var paths []string
+		for _, op := range m.Ops {
+			paths = append(paths, requestPaths(op.Op)...)
+		}
+		return paths
+	}
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if f := v.FieldByName("Path"); f.IsValid() && f.Kind() == reflect.String {
+		return []string{f.String()}
+	}
+	return nil
+}
+
+// logSlow logs a request whose reply took latency to arrive.
+func (c *Conn) logSlow(req *request, latency time.Duration) {
+	paths := requestPaths(req.pkt)
+	for i, p := range paths {
+		paths[i] = c.clientPath(p)
+	}
+	c.logw(LogWarn, LogRequests, "Slow request", "op", opNames[req.opcode], "path", strings.Join(paths, ","), "xid", req.xid, "latency", latency)
+}
@@ -0,0 +1,116 @@
+package zk
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestTraceSelectors(t *testing.T) {
+	t.Parallel()
+	prefix := TracePrefix("/app/", "/other")
+	pattern := TracePattern("/svc/*/lock", "[")
+	for _, c := range []struct {
+		selector TraceSelector
+		path     string
+		expected bool
+	}{
+		{prefix, "/app/a", true},
+		{prefix, "/app", false},
+		{prefix, "/otherwise", true},
+		{prefix, "/", false},
+		{pattern, "/svc/a/lock", true},
+		{pattern, "/svc/a/b/lock", false},
+		{pattern, "[", false},
+	} {
+		if m := c.selector(c.path); m != c.expected {
+			t.Errorf("selector matched %q: %v, expected %v", c.path, m, c.expected)
+		}
+	}
+}
+
+func TestRequestPaths(t *testing.T) {
+	t.Parallel()
+	multi := &multiRequest{Ops: []multiRequestOp{
+		{multiHeader{Type: opCreate}, &CreateRequest{Path: "/a"}},
+		{multiHeader{Type: opDelete}, &DeleteRequest{Path: "/b"}},
+	}}
+	for _, c := range []struct {
+		req      interface{}
+		expected []string
+	}{
+		{&getDataRequest{Path: "/x"}, []string{"/x"}},
+		{&SetDataRequest{Path: "/y"}, []string{"/y"}},
+		{multi, []string{"/a", "/b"}},
+		{&pingRequest{}, nil},
+		{&setWatchesRequest{}, nil},
+	} {
+		if paths := requestPaths(c.req); !reflect.DeepEqual(paths, c.expected) {
+			t.Errorf("requestPaths(%+v) = %v, expected %v", c.req, paths, c.expected)
+		}
+	}
+}
+
+func TestTraced(t *testing.T) {
+	t.Parallel()
+	l := &recordingLogger{}
+	c := &Conn{logger: l}
+	if c.traced(&getDataRequest{Path: "/app/a"}) {
+		t.Fatal("request traced without selectors")
+	}
+
+	WithTracing(TracePrefix("/app/"))(c)
+	if !c.traced(&getDataRequest{Path: "/app/a"}) {
+		t.Fatal("request on a selected path not traced")
+	}
+	if c.traced(&getDataRequest{Path: "/db/a"}) {
+		t.Fatal("request on an unselected path traced")
+	}
+
+	// The selectors match the paths without the chroot.
+	chrooted := &Conn{chroot: "/root"}
+	WithTracing(TracePrefix("/app/"))(chrooted)
+	if !chrooted.traced(&getDataRequest{Path: "/root/app/a"}) {
+		t.Fatal("request on a selected path under the chroot not traced")
+	}
+
+	c.trace(opGetData, &getDataRequest{Path: "/app/a"}, &getDataResponse{}, response{zxid: 7, err: ErrNoNode}, time.Millisecond)
+	if len(l.lines) != 1 {
+		t.Fatalf("Expected 1 trace line got %d", len(l.lines))
+	}
+	for _, s := range []string{"getData", "/app/a", "zxid=7", ErrNoNode.Error(), "latency=1ms"} {
+		if !strings.Contains(l.lines[0], s) {
+			t.Errorf("trace line %q does not contain %q", l.lines[0], s)
+		}
+	}
+}
+
+func TestLogSlow(t *testing.T) {
+	t.Parallel()
+	l := &recordingLogger{}
+	c := &Conn{logger: l, chroot: "/root"}
+	WithSlowRequestLogging(time.Second)(c)
+	if c.slowThreshold != time.Second {
+		t.Fatalf("Slow request threshold %s", c.slowThreshold)
+	}
+
+	c.logSlow(&request{xid: 3, opcode: opGetData, pkt: &getDataRequest{Path: "/root/a"}}, 2*time.Second)
+	if len(l.lines) != 1 {
+		t.Fatalf("Expected 1 log line got %d", len(l.lines))
+	}
+	for _, s := range []string{"getData", "path=/a", "xid=3", "latency=2s"} {
+		if !strings.Contains(l.lines[0], s) {
+			t.Errorf("log line %q does not contain %q", l.lines[0], s)
+		}
+	}
+}
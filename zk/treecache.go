@@ -0,0 +1,638 @@
+package zk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// TreeEventType is the type of a TreeEvent.
+type TreeEventType int
+
+const (
+	// TreeNodeAdded is sent for a node seen for the first time, including the
+	// nodes read by Start.
+	TreeNodeAdded TreeEventType = iota
+	// TreeNodeUpdated is sent when the data of a node changed.
+	TreeNodeUpdated
+	// TreeNodeRemoved is sent when a node was deleted, with its last data.
+	TreeNodeRemoved
+	// TreeInitialized is sent once, after the TreeNodeAdded events of the
+	// nodes read by Start.
+	TreeInitialized
+)
+
+var treeEventNames = map[TreeEventType]string{
+	TreeNodeAdded:   "TreeNodeAdded",
+	TreeNodeUpdated: "TreeNodeUpdated",
+	TreeNodeRemoved: "TreeNodeRemoved",
+	TreeInitialized: "TreeInitialized",
+}
+
+func (t TreeEventType) String() string {
+	if name := treeEventNames[t]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("TreeEventType(%d)", int(t))
+}
+
+// TreeEvent is a change of the subtree seen by a TreeCache.
+type TreeEvent struct {
+	Type TreeEventType
+	Path string    // empty for TreeInitialized
+	Node *NodeData // nil for TreeInitialized
+}
+
+// TreeCache keeps a subtree in memory, like Curator's TreeCache: the data and
+// stat of the node at its path and of its descendants. With ZooKeeper 3.6 or
+// later it is kept up to date by one persistent recursive watch, see
+// AddWatch, and otherwise by a data and a children watch on every cached
+// node, set again once they fired. Everything is read again after the
+// connection was lost or the session expired, so the copy is never left
+// stale.
+//
+// SetMaxDepth and SetFilter bound the nodes cached, and without persistent
+// watches the watches set too.
+//
+// The copy can be saved with SaveState, e.g. to a file when the process
+// exits, and restored with RestoreState before Start when it restarts.
+// Start then only reads the data of the nodes modified since, and sends
+// events for the changes only.
+type TreeCache struct {
+	c        *Conn
+	path     string
+	maxDepth int
+	filter   func(path string) bool
+
+	mu        sync.Mutex // protects nodes, kids, listeners and nextID
+	nodes     map[string]*NodeData
+	kids      map[string]map[string]bool // the cached children of each node
+	listeners map[int]func(TreeEvent)
+	nextID    int
+
+	// Only used by the goroutine keeping the cache, or by Start before it runs.
+	persistent bool
+	watch      <-chan Event            // the persistent watch
+	dataW      map[string]<-chan Event // the data or exists watches
+	childW     map[string]<-chan Event // the children watches
+	restored   map[string]*NodeData    // the restored nodes not read yet
+	ctx        context.Context
+	cancel     context.CancelFunc
+	events     chan treeCacheEvent
+}
+
+// treeCacheEvent is a one-shot watch on path having fired.
+type treeCacheEvent struct {
+	path     string
+	children bool
+	err      error
+}
+
+// NewTreeCache creates a new cache of the subtree at path using the provided
+// connection.
+func NewTreeCache(c *Conn, path string) *TreeCache {
+	t := &TreeCache{
+		c:         c,
+		path:      path,
+		maxDepth:  -1,
+		nodes:     make(map[string]*NodeData),
+		kids:      make(map[string]map[string]bool),
+		listeners: make(map[int]func(TreeEvent)),
+		dataW:     make(map[string]<-chan Event),
+		childW:    make(map[string]<-chan Event),
+		events:    make(chan treeCacheEvent),
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t
+}
+
+// SetMaxDepth limits the cache to the nodes at most depth levels below its
+// path: 0 caches the node only, 1 its children too, and so on. It is
+// unlimited by default, or with a negative depth. It must be called before
+// Start.
+func (t *TreeCache) SetMaxDepth(depth int) {
+	t.maxDepth = depth
+}
+
+// SetFilter limits the cache to the nodes below its path for which filter
+// returns true. A node filtered out is not cached, nor are its descendants.
+// It must be called before Start.
+func (t *TreeCache) SetFilter(filter func(path string) bool) {
+	t.filter = filter
+}
+
+// SaveState writes the cached nodes to w, for RestoreState.
+func (t *TreeCache) SaveState(w io.Writer) error {
+	t.mu.Lock()
+	nodes := make(map[string]*NodeData, len(t.nodes))
+	for path, node := range t.nodes {
+		nodes[path] = node
+	}
+	t.mu.Unlock()
+	return writeCacheState(w, t.path, nodes)
+}
+
+// RestoreState reads the nodes written by SaveState for a cache of the same
+// path, without notifying the listeners. Start then only reads the stat of
+// the restored nodes, and their data if their mzxid changed, and notifies
+// the listeners of the nodes added, updated and removed since the state was
+// saved. It must be called before Start, and after SetMaxDepth and SetFilter.
+func (t *TreeCache) RestoreState(r io.Reader) error {
+	nodes, err := readCacheState(r, t.path)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.restored = make(map[string]*NodeData, len(nodes))
+	for path, node := range nodes {
+		if !t.wanted(path) {
+			continue
+		}
+		t.restored[path] = node
+		t.nodes[path] = node
+		if path != t.path {
+			parent := parentPath(path)
+			if t.kids[parent] == nil {
+				t.kids[parent] = make(map[string]bool)
+			}
+			t.kids[parent][path[strings.LastIndex(path, "/")+1:]] = true
+		}
+	}
+	return nil
+}
+
+// Start reads the subtree and starts watching it. The listeners added before
+// receive a TreeNodeAdded event for every node, or for restored nodes the
+// events of their changes, and then a TreeInitialized event, before Start
+// returns.
+func (t *TreeCache) Start() error {
+	if _, err := t.c.processPath(t.path, false); err != nil {
+		return err
+	}
+	ok, err := t.c.SupportsPersistentWatches()
+	t.persistent = err == nil && ok
+
+	var session <-chan Event
+	if t.persistent {
+		if err := t.addWatch(); err != nil {
+			return err
+		}
+	} else {
+		session = t.c.Subscribe()
+	}
+	// The restored nodes are checked against the server.
+	if err := t.load(t.path, len(t.restored) > 0); err != nil {
+		if session != nil {
+			t.c.Unsubscribe(session)
+		}
+		t.removeWatches()
+		t.cancel()
+		return err
+	}
+	t.notify(TreeEvent{Type: TreeInitialized})
+	go t.run(session)
+	return nil
+}
+
+// Close stops watching the subtree. The copy is not updated anymore.
+func (t *TreeCache) Close() {
+	t.cancel()
+}
+
+// Current returns the cached data and stat of the node at path, or nil if it
+// is not cached.
+func (t *TreeCache) Current(path string) *NodeData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if node := t.nodes[path]; node != nil {
+		return copyNodeData(node)
+	}
+	return nil
+}
+
+// Children returns the cached children of the node at path by name, or nil
+// if it is not cached.
+func (t *TreeCache) Children(path string) map[string]*NodeData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nodes[path] == nil {
+		return nil
+	}
+	children := make(map[string]*NodeData, len(t.kids[path]))
+	for name := range t.kids[path] {
+		children[name] = copyNodeData(t.nodes[childPath(path, name)])
+	}
+	return children
+}
+
+// AddListener calls fn with every change of the subtree. The listeners run
+// one at a time, in the order of the changes. The returned function removes
+// the listener.
+func (t *TreeCache) AddListener(fn func(TreeEvent)) (remove func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := t.nextID
+	t.nextID++
+	t.listeners[id] = fn
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.listeners, id)
+	}
+}
+
+// depth returns how many levels below the path of the cache path is.
+func (t *TreeCache) depth(path string) int {
+	if path == t.path {
+		return 0
+	}
+	rel := strings.TrimPrefix(path, t.path)
+	if t.path == "/" {
+		rel = "/" + rel
+	}
+	return strings.Count(rel, "/")
+}
+
+// wanted reports whether the node at path is to be cached.
+func (t *TreeCache) wanted(path string) bool {
+	if !pathUnder(path, t.path) {
+		return false
+	}
+	if path == t.path {
+		return true
+	}
+	if t.maxDepth >= 0 && t.depth(path) > t.maxDepth {
+		return false
+	}
+	return t.filter == nil || t.filter(path)
+}
+
+// addWatch sets the persistent recursive watch.
+func (t *TreeCache) addWatch() error {
+	return t.c.retryInSession(t.ctx, func() (err error) {
+		t.watch, err = t.c.AddWatch(t.path, WatchModePersistentRecursive)
+		return err
+	})
+}
+
+func (t *TreeCache) run(session <-chan Event) {
+	defer func() {
+		if session != nil {
+			t.c.Unsubscribe(session)
+		}
+		t.removeWatches()
+	}()
+	disconnected := false
+	for {
+		var err error
+		select {
+		case ev, ok := <-t.watch:
+			if !ok {
+				t.watch = nil
+				continue
+			}
+			err = t.persistentEvent(ev)
+		case ev := <-t.events:
+			if ev.err == ErrClosing {
+				return
+			}
+			err = t.watchFired(ev)
+		case ev, ok := <-session:
+			if !ok {
+				session = nil
+				continue
+			}
+			if ev.State == StateDisconnected {
+				disconnected = true
+			} else if ev.State == StateHasSession && disconnected {
+				// Changes may have been missed by the watches.
+				disconnected = false
+				err = t.load(t.path, true)
+			}
+		case <-t.ctx.Done():
+			return
+		}
+		if err == ErrClosing || err == context.Canceled {
+			return
+		}
+	}
+}
+
+// persistentEvent handles an event of the persistent watch.
+func (t *TreeCache) persistentEvent(ev Event) error {
+	switch ev.Type {
+	case EventSession:
+		// Reconnected, changes made meanwhile were not reported.
+		return t.load(t.path, true)
+	case EventNotWatching:
+		if ev.Err == ErrClosing {
+			return ev.Err
+		}
+		// The session expired, start over in the new one.
+		if err := t.addWatch(); err != nil {
+			return err
+		}
+		return t.load(t.path, true)
+	case EventNodeCreated:
+		if t.wanted(ev.Path) && (ev.Path == t.path || t.cached(parentPath(ev.Path))) {
+			return t.load(ev.Path, false)
+		}
+	case EventNodeDataChanged:
+		if t.cached(ev.Path) {
+			_, err := t.readNode(ev.Path)
+			return err
+		}
+	case EventNodeDeleted:
+		t.remove(ev.Path)
+	}
+	return nil
+}
+
+// watchFired handles a one-shot watch having fired.
+func (t *TreeCache) watchFired(ev treeCacheEvent) error {
+	if ev.children {
+		delete(t.childW, ev.path)
+		if !t.cached(ev.path) {
+			return nil
+		}
+		return t.loadChildren(ev.path, false)
+	}
+	delete(t.dataW, ev.path)
+	if ev.path != t.path && !t.cached(ev.path) {
+		return nil
+	}
+	if ev.path == t.path && !t.cached(ev.path) {
+		// Created.
+		return t.load(ev.path, false)
+	}
+	_, err := t.readNode(ev.path)
+	return err
+}
+
+func (t *TreeCache) cached(path string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nodes[path] != nil
+}
+
+// forward hands the event of a one-shot watch to run once it fired.
+func (t *TreeCache) forward(path string, children bool, ch <-chan Event) {
+	select {
+	case ev := <-ch:
+		select {
+		case t.events <- treeCacheEvent{path: path, children: children, err: ev.Err}:
+		case <-t.ctx.Done():
+		}
+	case <-t.ctx.Done():
+	}
+}
+
+// removeWatches removes the watches left once the cache is closed.
+func (t *TreeCache) removeWatches() {
+	if t.watch != nil {
+		t.c.RemoveWatches(t.path, t.watch)
+		t.watch = nil
+	}
+	for path, ch := range t.dataW {
+		t.c.RemoveWatches(path, ch)
+	}
+	for path, ch := range t.childW {
+		t.c.RemoveWatches(path, ch)
+	}
+}
+
+// load reads the node at path and its descendants. Those already cached are
+// only read again if reread.
+func (t *TreeCache) load(path string, reread bool) error {
+	exists, err := t.readNode(path)
+	if err != nil || !exists {
+		return err
+	}
+	return t.loadChildren(path, reread)
+}
+
+// loadChildren lists the children of the node at path, removes those gone
+// and loads the others, unless the maximum depth is reached.
+func (t *TreeCache) loadChildren(path string, reread bool) error {
+	if t.maxDepth >= 0 && t.depth(path) >= t.maxDepth {
+		return nil
+	}
+	var names []string
+	err := t.c.retryInSession(t.ctx, func() (err error) {
+		if _, ok := t.childW[path]; t.persistent || ok {
+			names, _, err = t.c.Children(path)
+			return err
+		}
+		var ch <-chan Event
+		names, _, ch, err = t.c.ChildrenW(path)
+		if err == nil {
+			t.childW[path] = ch
+			go t.forward(path, true, ch)
+		}
+		return err
+	})
+	if err == ErrNoNode {
+		// Deleted meanwhile, as the data watch will tell.
+		return nil
+	} else if err != nil {
+		return t.nodeError(err)
+	}
+
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+	t.mu.Lock()
+	var gone []string
+	for name := range t.kids[path] {
+		if !listed[name] {
+			gone = append(gone, childPath(path, name))
+		}
+	}
+	t.mu.Unlock()
+	for _, p := range gone {
+		t.remove(p)
+	}
+	for _, name := range names {
+		p := childPath(path, name)
+		if !t.wanted(p) || !reread && t.cached(p) {
+			continue
+		}
+		if err := t.load(p, reread); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNode reads the node at path into the cache, or removes it and its
+// descendants if it does not exist, and reports whether it does.
+func (t *TreeCache) readNode(path string) (bool, error) {
+	if old := t.restored[path]; old != nil {
+		delete(t.restored, path)
+		if exists, fresh, err := t.checkRestored(path, old); err != nil || !exists || fresh {
+			return exists, err
+		}
+	}
+	var data []byte
+	var stat *Stat
+	err := t.c.retryInSession(t.ctx, func() (err error) {
+		if _, ok := t.dataW[path]; t.persistent || ok {
+			data, stat, err = t.c.Get(path)
+			return err
+		}
+		for {
+			var ch <-chan Event
+			data, stat, ch, err = t.c.GetW(path)
+			if err == ErrNoNode && path == t.path {
+				// Wait for the node to be created.
+				var exists bool
+				exists, _, ch, err = t.c.ExistsW(path)
+				if err == nil && exists {
+					// Created in between, its data is needed.
+					t.c.RemoveWatches(path, ch)
+					continue
+				} else if err == nil {
+					err = ErrNoNode
+				}
+			}
+			if ch != nil {
+				t.dataW[path] = ch
+				go t.forward(path, false, ch)
+			}
+			return err
+		}
+	})
+	if err == ErrNoNode {
+		t.remove(path)
+		return false, nil
+	} else if err != nil {
+		return false, t.nodeError(err)
+	}
+	t.set(path, &NodeData{Data: data, Stat: stat})
+	return true, nil
+}
+
+// checkRestored reads the stat of the node at path, restored as old, and
+// keeps old cached if the node was not modified since, as fresh tells. The
+// data watch is set along, so that only modified nodes need reading.
+func (t *TreeCache) checkRestored(path string, old *NodeData) (exists, fresh bool, err error) {
+	var stat *Stat
+	err = t.c.retryInSession(t.ctx, func() (err error) {
+		if _, ok := t.dataW[path]; t.persistent || ok {
+			exists, stat, err = t.c.Exists(path)
+			return err
+		}
+		var ch <-chan Event
+		exists, stat, ch, err = t.c.ExistsW(path)
+		if err == nil && !exists && path != t.path {
+			// Only the creation of the node of the cache is waited for.
+			t.c.RemoveWatches(path, ch)
+		} else if err == nil {
+			t.dataW[path] = ch
+			go t.forward(path, false, ch)
+		}
+		return err
+	})
+	if err != nil {
+		if err = t.nodeError(err); err != nil {
+			return false, false, err
+		}
+		// Read as any other node.
+		return true, false, nil
+	}
+	if !exists {
+		t.remove(path)
+		return false, false, nil
+	}
+	if node := restoredNode(old, stat); node != nil {
+		t.set(path, node)
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+// nodeError returns the error reading a node if it ends the cache. Other
+// errors, e.g. ErrNoAuth, leave the node out.
+func (t *TreeCache) nodeError(err error) error {
+	if err == ErrClosing || err == context.Canceled {
+		return err
+	}
+	return nil
+}
+
+// set replaces the cached node at path and notifies the listeners if it
+// changed. An unchanged node only gets its newer stat.
+func (t *TreeCache) set(path string, node *NodeData) {
+	t.mu.Lock()
+	old := t.nodes[path]
+	if !nodeChanged(old, node) {
+		t.nodes[path] = node
+		t.mu.Unlock()
+		return
+	}
+	t.nodes[path] = node
+	if path != t.path {
+		parent := parentPath(path)
+		if t.kids[parent] == nil {
+			t.kids[parent] = make(map[string]bool)
+		}
+		t.kids[parent][path[strings.LastIndex(path, "/")+1:]] = true
+	}
+	t.mu.Unlock()
+	typ := TreeNodeUpdated
+	if old == nil {
+		typ = TreeNodeAdded
+	}
+	t.notify(TreeEvent{Type: typ, Path: path, Node: node})
+}
+
+// remove removes the node at path and its descendants from the cache, and
+// notifies the listeners, the descendants first.
+func (t *TreeCache) remove(path string) {
+	t.mu.Lock()
+	var removed []TreeEvent
+	var walk func(path string)
+	walk = func(path string) {
+		node := t.nodes[path]
+		if node == nil {
+			return
+		}
+		for name := range t.kids[path] {
+			walk(childPath(path, name))
+		}
+		delete(t.nodes, path)
+		delete(t.kids, path)
+		removed = append(removed, TreeEvent{Type: TreeNodeRemoved, Path: path, Node: node})
+	}
+	walk(path)
+	if kids := t.kids[parentPath(path)]; kids != nil && path != t.path {
+		delete(kids, path[strings.LastIndex(path, "/")+1:])
+	}
+	t.mu.Unlock()
+	for _, ev := range removed {
+		t.notify(ev)
+	}
+}
+
+// notify calls the listeners with ev.
+func (t *TreeCache) notify(ev TreeEvent) {
+	if t.ctx.Err() != nil {
+		return
+	}
+	t.mu.Lock()
+	listeners := make([]func(TreeEvent), 0, len(t.listeners))
+	for _, fn := range t.listeners {
+		listeners = append(listeners, fn)
+	}
+	t.mu.Unlock()
+	if ev.Node != nil {
+		ev.Node = copyNodeData(ev.Node)
+	}
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
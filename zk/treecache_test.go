@@ -0,0 +1,247 @@
+package zk
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// treeCacheTest drives a TreeCache at /tree over a fake connection.
+type treeCacheTest struct {
+	t      *testing.T
+	fc     *FakeConn
+	events chan TreeEvent
+}
+
+func (tt *treeCacheTest) serve(op, path string, err error, res interface{}) {
+	tt.t.Helper()
+	req, rerr := tt.fc.ExpectRequest(op)
+	if rerr != nil {
+		tt.t.Fatal(rerr)
+	}
+	if req.Path != path {
+		tt.t.Fatalf("%s request for %s, expected %s", op, req.Path, path)
+	}
+	if rerr := tt.fc.Reply(req, 1, err, res); rerr != nil {
+		tt.t.Fatal(rerr)
+	}
+}
+
+// serveUnordered answers a getData request for path with data and a
+// getChildren2 one with names, in any order.
+func (tt *treeCacheTest) serveUnordered(path, data string, zxid int64, names ...string) {
+	tt.t.Helper()
+	for i := 0; i < 2; i++ {
+		req, err := tt.fc.NextRequest()
+		if err != nil {
+			tt.t.Fatal(err)
+		}
+		if req.Path != path {
+			tt.t.Fatalf("%s request for %s, expected %s", req.Op, req.Path, path)
+		}
+		switch req.Op {
+		case "getData":
+			err = tt.fc.Reply(req, 1, nil, &getDataResponse{Data: []byte(data), Stat: Stat{Czxid: 1, Mzxid: zxid}})
+		case "getChildren2":
+			err = tt.fc.Reply(req, 1, nil, &getChildren2Response{Children: names})
+		default:
+			tt.t.Fatalf("Unexpected %s request", req.Op)
+		}
+		if err != nil {
+			tt.t.Fatal(err)
+		}
+	}
+}
+
+func (tt *treeCacheTest) node(path, data string, zxid int64) {
+	tt.t.Helper()
+	tt.serve("getData", path, nil, &getDataResponse{Data: []byte(data), Stat: Stat{Czxid: 1, Mzxid: zxid}})
+}
+
+func (tt *treeCacheTest) children(path string, names ...string) {
+	tt.t.Helper()
+	tt.serve("getChildren2", path, nil, &getChildren2Response{Children: names})
+}
+
+func (tt *treeCacheTest) expect(typ TreeEventType, path string) {
+	tt.t.Helper()
+	select {
+	case ev := <-tt.events:
+		if ev.Type != typ || ev.Path != path {
+			tt.t.Fatalf("Unexpected event %s for %s, expected %s for %s", ev.Type, ev.Path, typ, path)
+		}
+	case <-time.After(fakeTimeout):
+		tt.t.Fatalf("No %s event for %s", typ, path)
+	}
+}
+
+func (tt *treeCacheTest) send(typ EventType, path string) {
+	tt.t.Helper()
+	if err := tt.fc.SendEvent(2, typ, path); err != nil {
+		tt.t.Fatal(err)
+	}
+}
+
+func startTreeCache(t *testing.T, cache *TreeCache, fc *FakeConn, load func(tt *treeCacheTest)) *treeCacheTest {
+	tt := &treeCacheTest{t: t, fc: fc, events: make(chan TreeEvent, 16)}
+	cache.AddListener(func(ev TreeEvent) { tt.events <- ev })
+	done := make(chan error, 1)
+	go func() { done <- cache.Start() }()
+	load(tt)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+func TestTreeCacheWatches(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	cache := NewTreeCache(zk, "/tree")
+	cache.SetMaxDepth(2)
+	cache.SetFilter(func(path string) bool { return path != "/tree/b" })
+	defer cache.Close()
+
+	// The fake server does not answer srvr, so one-shot watches are used.
+	tt := startTreeCache(t, cache, fc, func(tt *treeCacheTest) {
+		tt.node("/tree", "root", 1)
+		tt.children("/tree", "a", "b")
+		tt.node("/tree/a", "a", 1)
+		tt.children("/tree/a", "x")
+		// At the maximum depth, its children are not listed.
+		tt.node("/tree/a/x", "x", 1)
+	})
+	tt.expect(TreeNodeAdded, "/tree")
+	tt.expect(TreeNodeAdded, "/tree/a")
+	tt.expect(TreeNodeAdded, "/tree/a/x")
+	tt.expect(TreeInitialized, "")
+	if children := cache.Children("/tree"); len(children) != 1 || string(children["a"].Data) != "a" {
+		t.Fatalf("Children returned %+v", children)
+	}
+	if node := cache.Current("/tree/b"); node != nil {
+		t.Fatalf("Filtered node cached as %+v", node)
+	}
+
+	// The client fires the children watch of a node along with its data
+	// watch.
+	tt.send(EventNodeDataChanged, "/tree")
+	tt.serveUnordered("/tree", "root2", 2, "a", "b")
+	tt.expect(TreeNodeUpdated, "/tree")
+
+	tt.send(EventNodeDeleted, "/tree/a/x")
+	tt.serve("getData", "/tree/a/x", ErrNoNode, nil)
+	tt.expect(TreeNodeRemoved, "/tree/a/x")
+	tt.send(EventNodeChildrenChanged, "/tree/a")
+	tt.children("/tree/a", "y")
+	tt.node("/tree/a/y", "y", 2)
+	tt.expect(TreeNodeAdded, "/tree/a/y")
+	if node := cache.Current("/tree/a/y"); node == nil || string(node.Data) != "y" {
+		t.Fatalf("Current returned %+v", node)
+	}
+}
+
+func TestTreeCachePersistentWatch(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	// As if srvr had told 3.6.
+	server := zk.Server()
+	zk.serverMu.Lock()
+	zk.modeServer, zk.mode, zk.version = server, ModeStandalone, "3.6.3"
+	zk.serverMu.Unlock()
+	cache := NewTreeCache(zk, "/tree")
+	defer cache.Close()
+
+	tt := startTreeCache(t, cache, fc, func(tt *treeCacheTest) {
+		tt.serve("addWatch", "/tree", nil, nil)
+		tt.node("/tree", "root", 1)
+		tt.children("/tree", "a")
+		tt.node("/tree/a", "a", 1)
+		tt.children("/tree/a", "x")
+		tt.node("/tree/a/x", "x", 1)
+		tt.children("/tree/a/x")
+	})
+	tt.expect(TreeNodeAdded, "/tree")
+	tt.expect(TreeNodeAdded, "/tree/a")
+	tt.expect(TreeNodeAdded, "/tree/a/x")
+	tt.expect(TreeInitialized, "")
+
+	// Every change below the node is reported by the one watch.
+	tt.send(EventNodeCreated, "/tree/b")
+	tt.node("/tree/b", "b", 2)
+	tt.children("/tree/b")
+	tt.expect(TreeNodeAdded, "/tree/b")
+	tt.send(EventNodeDataChanged, "/tree/a/x")
+	tt.node("/tree/a/x", "x2", 3)
+	tt.expect(TreeNodeUpdated, "/tree/a/x")
+
+	// Deleting a node removes its descendants first.
+	tt.send(EventNodeDeleted, "/tree/a")
+	tt.expect(TreeNodeRemoved, "/tree/a/x")
+	tt.expect(TreeNodeRemoved, "/tree/a")
+	if children := cache.Children("/tree"); len(children) != 1 || children["b"] == nil {
+		t.Fatalf("Children returned %+v", children)
+	}
+}
+
+func TestTreeCacheRestoreState(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	cache := NewTreeCache(zk, "/tree")
+	defer cache.Close()
+
+	startTreeCache(t, cache, fc, func(tt *treeCacheTest) {
+		tt.node("/tree", "root", 1)
+		tt.children("/tree", "a", "b")
+		tt.node("/tree/a", "a", 1)
+		tt.children("/tree/a")
+		tt.node("/tree/b", "b", 1)
+		tt.children("/tree/b")
+	})
+	var state bytes.Buffer
+	if err := cache.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewTreeCache(zk, "/other").RestoreState(bytes.NewReader(state.Bytes())); err == nil {
+		t.Fatal("State of /tree restored for /other")
+	}
+
+	// Only the data of the nodes modified since is read, and only the
+	// changes are reported.
+	restored := NewTreeCache(zk, "/tree")
+	defer restored.Close()
+	if err := restored.RestoreState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if node := restored.Current("/tree/b"); node == nil || string(node.Data) != "b" {
+		t.Fatalf("Current returned %+v after RestoreState", node)
+	}
+	tt := startTreeCache(t, restored, fc, func(tt *treeCacheTest) {
+		tt.serve("exists", "/tree", nil, &existsResponse{Stat: Stat{Czxid: 1, Mzxid: 1}})
+		tt.children("/tree", "a", "c")
+		tt.serve("exists", "/tree/a", nil, &existsResponse{Stat: Stat{Czxid: 1, Mzxid: 2}})
+		tt.node("/tree/a", "a2", 2)
+		tt.children("/tree/a")
+		tt.node("/tree/c", "c", 2)
+		tt.children("/tree/c")
+	})
+	tt.expect(TreeNodeRemoved, "/tree/b")
+	tt.expect(TreeNodeUpdated, "/tree/a")
+	tt.expect(TreeNodeAdded, "/tree/c")
+	tt.expect(TreeInitialized, "")
+	if node := restored.Current("/tree"); node == nil || string(node.Data) != "root" {
+		t.Fatalf("Current returned %+v for the unmodified node", node)
+	}
+	if node := restored.Current("/tree/a"); node == nil || string(node.Data) != "a2" {
+		t.Fatalf("Current returned %+v for the modified node", node)
+	}
+}
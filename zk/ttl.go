@@ -0,0 +1,216 @@
+package zk
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	createModePersistentWithTTL           = 5
+	createModePersistentSequentialWithTTL = 6
+)
+
+// TTLEmulationRoot is the node under which WithTTLEmulation keeps track of
+// the TTL nodes it created. Applications that walk the whole tree may want
+// to skip it.
+const TTLEmulationRoot = "/go-zookeeper-ttl"
+
+var (
+	ttlNodesPath = TTLEmulationRoot + "/nodes"
+	ttlLockPath  = TTLEmulationRoot + "/lock"
+)
+
+// WithTTLEmulation returns a connection option that makes CreateTTL work on
+// servers without TTL node support, i.e. older than 3.5.3 or without
+// zookeeper.extendedTypesEnabled. CreateTTL then creates regular persistent
+// nodes and records their TTL under TTLEmulationRoot, and every interval a
+// single client of the ensemble, picked through a lock node, deletes the
+// nodes that have expired.
+//
+// Unlike server-side TTLs, expiry is measured against the clock of the
+// reaping client and is only as precise as interval.
+func WithTTLEmulation(interval time.Duration) connOption {
+	return func(c *Conn) {
+		c.ttlReapInterval = interval
+	}
+}
+
+// CreateTTL creates a persistent node at path that is deleted once it has
+// had no children and has not been modified for ttl, and returns its path.
+// flags may only contain FlagSequence. It requires ZooKeeper 3.5.3 or later
+// with zookeeper.extendedTypesEnabled set, unless WithTTLEmulation is used.
+func (c *Conn) CreateTTL(path string, data []byte, flags int32, acl []ACL, ttl time.Duration) (string, error) {
+	if flags&^FlagSequence != 0 || ttl <= 0 {
+		return "", ErrBadArguments
+	}
+	if c.ttlReapInterval > 0 {
+		return c.createEmulatedTTL(path, data, flags, acl, ttl)
+	}
+
+	path, err := c.processPath(path, flags&FlagSequence != 0)
+	if err != nil {
+		return "", err
+	}
+	if err := c.checkDataSize(path, data); err != nil {
+		return "", err
+	}
+	if acl, err = c.checkACL(path, acl); err != nil {
+		return "", err
+	}
+
+	mode := int32(createModePersistentWithTTL)
+	if flags&FlagSequence != 0 {
+		mode = createModePersistentSequentialWithTTL
+	}
+	id, err := c.journalBegin(JournalEntry{Op: "create", Path: c.clientPath(path), Data: data, Flags: flags})
+	if err != nil {
+		return "", err
+	}
+	res := &create2Response{}
+	_, err = c.request(opCreateTTL, &createTTLRequest{path, data, acl, mode, int64(ttl / time.Millisecond)}, res, nil)
+	c.journalEnd(id, err)
+	return c.clientPath(res.Path), err
+}
+
+func (c *Conn) createEmulatedTTL(path string, data []byte, flags int32, acl []ACL, ttl time.Duration) (string, error) {
+	path, err := c.Create(path, data, flags, acl)
+	if err != nil {
+		return "", err
+	}
+	ok, stat, err := c.Exists(path)
+	if err == nil && ok {
+		err = c.registerTTLNode(path, stat.Czxid, ttl)
+	}
+	if err != nil {
+		c.Delete(path, -1)
+		return "", err
+	}
+	return path, nil
+}
+
+// registerTTLNode records the TTL of the node at path, which was created in
+// czxid, for the reaper.
+func (c *Conn) registerTTLNode(path string, czxid int64, ttl time.Duration) error {
+	entry := childPath(ttlNodesPath, url.PathEscape(path))
+	data := []byte(fmt.Sprintf("%d %d", int64(ttl/time.Millisecond), czxid))
+	_, err := c.Create(entry, data, 0, WorldACL(PermAll))
+	if err == ErrNoNode {
+		if err := c.CreateParents(entry, WorldACL(PermAll)); err != nil {
+			return err
+		}
+		_, err = c.Create(entry, data, 0, WorldACL(PermAll))
+	}
+	return err
+}
+
+// parseTTLEntry parses the name and data of a node recorded by
+// registerTTLNode.
+func parseTTLEntry(name string, data []byte) (path string, ttl time.Duration, czxid int64, err error) {
+	if path, err = url.PathUnescape(name); err != nil {
+		return "", 0, 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", 0, 0, fmt.Errorf("zk: malformed TTL entry %q", data)
+	}
+	ms, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if czxid, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return "", 0, 0, err
+	}
+	return path, time.Duration(ms) * time.Millisecond, czxid, nil
+}
+
+// reapTTLNodes periodically deletes expired emulated TTL nodes until the
+// connection is closed.
+func (c *Conn) reapTTLNodes() {
+	ticker := time.NewTicker(c.ttlReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shouldQuit:
+			return
+		case <-ticker.C:
+		}
+		if c.State() != StateHasSession {
+			continue
+		}
+		if err := c.reapTTLNodesOnce(time.Now()); err != nil && !isConnectionError(err) {
+			c.logf(LogWarn, LogRecipes, "Failed to reap TTL nodes: %s", err)
+		}
+	}
+}
+
+// reapTTLNodesOnce deletes the emulated TTL nodes that expired before now,
+// unless another client is already doing so.
+func (c *Conn) reapTTLNodesOnce(now time.Time) error {
+	_, err := c.Create(ttlLockPath, nil, FlagEphemeral, WorldACL(PermAll))
+	if err == ErrNodeExists || err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer c.Delete(ttlLockPath, -1)
+
+	names, _, err := c.Children(ttlNodesPath)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := c.reapTTLNode(name, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) reapTTLNode(name string, now time.Time) error {
+	entry := childPath(ttlNodesPath, name)
+	data, _, err := c.Get(entry)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	path, ttl, czxid, err := parseTTLEntry(name, data)
+	if err != nil {
+		c.logf(LogWarn, LogRecipes, "Removing TTL entry %s: %s", name, err)
+		return c.deleteTTLEntry(entry)
+	}
+	ok, stat, err := c.Exists(path)
+	if err != nil {
+		return err
+	}
+	if !ok || stat.Czxid != czxid {
+		// Deleted, possibly re-created since without a TTL.
+		return c.deleteTTLEntry(entry)
+	}
+	if stat.NumChildren > 0 || now.Sub(time.Unix(0, stat.Mtime*int64(time.Millisecond))) < ttl {
+		return nil
+	}
+
+	switch err := c.Delete(path, stat.Version); err {
+	case nil, ErrNoNode:
+		return c.deleteTTLEntry(entry)
+	case ErrBadVersion, ErrNotEmpty:
+		// Modified or given children since it was checked.
+		return nil
+	default:
+		return err
+	}
+}
+
+func (c *Conn) deleteTTLEntry(entry string) error {
+	if err := c.Delete(entry, -1); err != nil && err != ErrNoNode {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package zk
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseTTLEntry(t *testing.T) {
+	t.Parallel()
+	path, ttl, czxid, err := parseTTLEntry(url.PathEscape("/a/b-0000000001"), []byte("1500 42"))
+	if err != nil {
+		t.Fatalf("parseTTLEntry returned error: %+v", err)
+	}
+	if path != "/a/b-0000000001" || ttl != 1500*time.Millisecond || czxid != 42 {
+		t.Fatalf("parseTTLEntry returned %q, %s, %d", path, ttl, czxid)
+	}
+
+	for _, data := range []string{"", "1500", "x 42", "1500 x", "1 2 3"} {
+		if _, _, _, err := parseTTLEntry("%2Fa", []byte(data)); err == nil {
+			t.Errorf("parseTTLEntry accepted %q", data)
+		}
+	}
+	if _, _, _, err := parseTTLEntry("%zz", []byte("1 2")); err == nil {
+		t.Error("parseTTLEntry accepted a malformed name")
+	}
+}
+
+func TestRegisterTTLNodeCreatesParents(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	entry := ttlNodesPath + "/" + url.PathEscape("/a")
+	errs := make(chan error, 1)
+	go func() { errs <- zk.registerTTLNode("/a", 7, 1500*time.Millisecond) }()
+	serveFake(t, fc, "create", entry, ErrNoNode, nil)
+	serveFake(t, fc, "create", TTLEmulationRoot, ErrNodeExists, nil)
+	serveFake(t, fc, "create", ttlNodesPath, nil, &createResponse{Path: ttlNodesPath})
+	req := serveFake(t, fc, "create", entry, nil, &createResponse{Path: entry})
+	if data := string(req.Body.(*CreateRequest).Data); data != "1500 7" {
+		t.Fatalf("TTL entry data %q, expected %q", data, "1500 7")
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
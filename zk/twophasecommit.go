@@ -0,0 +1,324 @@
+package zk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrAlreadyVoted is returned by Proposal.Vote when the participant voted on
+// the proposal already.
+var ErrAlreadyVoted = errors.New("zk: already voted on the proposal")
+
+const (
+	txPrefix      = "tx-"
+	txVotePrefix  = "vote-"
+	txOutcomeNode = "outcome"
+	txCommit      = "commit"
+	txAbort       = "abort"
+)
+
+// The data of a transaction node.
+type txProposal struct {
+	Participants []string `json:"participants"`
+	Data         []byte   `json:"data"`
+}
+
+// TwoPhaseCoordinator proposes transactions to participants and decides
+// their outcome, with the classic two-phase commit over nodes: each
+// transaction is a sequential node under the path of the coordinator holding
+// the proposal and the names of the participants, each participant votes by
+// creating a vote child, and the coordinator creates an outcome child once
+// every participant voted to commit, or one voted to abort. It suits
+// coordinated changes among a few processes, e.g. a cutover that every one of
+// them must be ready for.
+type TwoPhaseCoordinator struct {
+	c    *Conn
+	path string
+	acl  []ACL
+}
+
+// NewTwoPhaseCoordinator creates a new coordinator using the provided
+// connection, path under which the transactions are created, and acl.
+func NewTwoPhaseCoordinator(c *Conn, path string, acl []ACL) *TwoPhaseCoordinator {
+	return &TwoPhaseCoordinator{c: c, path: path, acl: acl}
+}
+
+// Transaction is a transaction proposed by a TwoPhaseCoordinator.
+type Transaction struct {
+	c            *Conn
+	acl          []ACL
+	ID           string // the name of its node
+	Path         string
+	Participants []string
+}
+
+// Propose creates a transaction proposing data to participants, which must
+// not be empty. The path of the coordinator is created if needed.
+func (co *TwoPhaseCoordinator) Propose(data []byte, participants []string) (*Transaction, error) {
+	encoded, err := json.Marshal(txProposal{Participants: participants, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	prefix := childPath(co.path, txPrefix)
+	path, err := co.c.Create(prefix, encoded, FlagSequence, co.acl)
+	if err == ErrNoNode {
+		if err := co.c.CreateParents(prefix, co.acl); err != nil {
+			return nil, err
+		}
+		path, err = co.c.Create(prefix, encoded, FlagSequence, co.acl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		c:            co.c,
+		acl:          co.acl,
+		ID:           path[strings.LastIndex(path, "/")+1:],
+		Path:         path,
+		Participants: participants,
+	}, nil
+}
+
+// Wait waits for the votes of the participants and decides the outcome:
+// commit once all of them voted to commit, abort as soon as one voted to
+// abort, or once ctx is done. It reports whether the transaction committed.
+// If the outcome was decided already, e.g. by Abort, it is returned.
+func (tx *Transaction) Wait(ctx context.Context) (bool, error) {
+	for {
+		names, _, ch, err := tx.c.ChildrenW(tx.Path)
+		if err != nil {
+			return false, err
+		}
+		commit, decided, err := tx.count(names)
+		if err != nil {
+			tx.c.RemoveWatches(tx.Path, ch)
+			return false, err
+		}
+		if decided {
+			tx.c.RemoveWatches(tx.Path, ch)
+			return tx.decide(commit)
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			tx.c.RemoveWatches(tx.Path, ch)
+			return tx.decide(false)
+		}
+	}
+}
+
+// count reads the votes among the children of the transaction node, and
+// reports whether the outcome can be decided and which.
+func (tx *Transaction) count(names []string) (commit, decided bool, err error) {
+	children := make(map[string]bool, len(names))
+	for _, name := range names {
+		children[name] = true
+	}
+	if children[txOutcomeNode] {
+		return false, true, nil
+	}
+	missing := false
+	for _, participant := range tx.Participants {
+		name := txVotePrefix + participant
+		if !children[name] {
+			missing = true
+			continue
+		}
+		data, _, err := tx.c.Get(childPath(tx.Path, name))
+		if err != nil {
+			return false, false, err
+		}
+		if string(data) != txCommit {
+			return false, true, nil
+		}
+	}
+	return !missing, !missing, nil
+}
+
+// Abort decides to abort the transaction unless its outcome was decided
+// already, and reports whether it committed.
+func (tx *Transaction) Abort() (bool, error) {
+	return tx.decide(false)
+}
+
+// decide creates the outcome node, or reads it if it exists, and reports
+// whether the transaction committed.
+func (tx *Transaction) decide(commit bool) (bool, error) {
+	outcome := txAbort
+	if commit {
+		outcome = txCommit
+	}
+	path := childPath(tx.Path, txOutcomeNode)
+	_, err := tx.c.Create(path, []byte(outcome), 0, tx.acl)
+	if err == ErrNodeExists {
+		data, _, err := tx.c.Get(path)
+		if err != nil {
+			return false, err
+		}
+		return string(data) == txCommit, nil
+	} else if err != nil {
+		return false, err
+	}
+	return commit, nil
+}
+
+// Delete deletes the node of the transaction with its votes and outcome,
+// once everyone is done with it.
+func (tx *Transaction) Delete() error {
+	names, _, err := tx.c.Children(tx.Path)
+	if err == ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := tx.c.Delete(childPath(tx.Path, name), -1); err != nil && err != ErrNoNode {
+			return err
+		}
+	}
+	if err := tx.c.Delete(tx.Path, -1); err != nil && err != ErrNoNode {
+		return err
+	}
+	return nil
+}
+
+// TwoPhaseParticipant receives the transactions proposed to it by a
+// TwoPhaseCoordinator and votes on them.
+type TwoPhaseParticipant struct {
+	c    *Conn
+	path string
+	name string
+	acl  []ACL
+	seen map[string]bool
+}
+
+// NewTwoPhaseParticipant creates a new participant called name using the
+// provided connection, path of the coordinator, and acl for its votes.
+func NewTwoPhaseParticipant(c *Conn, path, name string, acl []ACL) *TwoPhaseParticipant {
+	return &TwoPhaseParticipant{c: c, path: path, name: name, acl: acl, seen: make(map[string]bool)}
+}
+
+// Proposal is a transaction proposed to a TwoPhaseParticipant.
+type Proposal struct {
+	p            *TwoPhaseParticipant
+	ID           string // the name of the transaction node
+	Path         string
+	Data         []byte
+	Participants []string
+}
+
+// Next waits until ctx is done for a transaction proposed to the participant
+// that it did not vote on and whose outcome is not decided yet, and returns
+// it, oldest first. It is not safe for concurrent use.
+func (p *TwoPhaseParticipant) Next(ctx context.Context) (*Proposal, error) {
+	for {
+		names, _, ch, err := p.c.ChildrenW(p.path)
+		if err == ErrNoNode {
+			var exists bool
+			exists, _, ch, err = p.c.ExistsW(p.path)
+			if err == nil && exists {
+				p.c.RemoveWatches(p.path, ch)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p.seen[name] || !strings.HasPrefix(name, txPrefix) {
+				continue
+			}
+			prop, err := p.proposal(name)
+			if err != nil {
+				p.c.RemoveWatches(p.path, ch)
+				return nil, err
+			}
+			p.seen[name] = true
+			if prop != nil {
+				p.c.RemoveWatches(p.path, ch)
+				return prop, nil
+			}
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			p.c.RemoveWatches(p.path, ch)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// proposal reads the transaction name, or returns nil if it is not pending
+// for the participant.
+func (p *TwoPhaseParticipant) proposal(name string) (*Proposal, error) {
+	path := childPath(p.path, name)
+	data, _, err := p.c.Get(path)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var tx txProposal
+	if err := json.Unmarshal(data, &tx); err != nil {
+		// Not a transaction.
+		return nil, nil
+	}
+	included := false
+	for _, participant := range tx.Participants {
+		included = included || participant == p.name
+	}
+	if !included {
+		return nil, nil
+	}
+	children, _, err := p.c.Children(path)
+	if err == ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if child == txOutcomeNode || child == txVotePrefix+p.name {
+			return nil, nil
+		}
+	}
+	return &Proposal{p: p, ID: name, Path: path, Data: tx.Data, Participants: tx.Participants}, nil
+}
+
+// Vote votes to commit the transaction, or to abort it.
+func (prop *Proposal) Vote(commit bool) error {
+	vote := txAbort
+	if commit {
+		vote = txCommit
+	}
+	_, err := prop.p.c.Create(childPath(prop.Path, txVotePrefix+prop.p.name), []byte(vote), 0, prop.p.acl)
+	if err == ErrNodeExists {
+		return ErrAlreadyVoted
+	}
+	return err
+}
+
+// Outcome waits until ctx is done for the outcome of the transaction, and
+// reports whether it committed.
+func (prop *Proposal) Outcome(ctx context.Context) (bool, error) {
+	path := childPath(prop.Path, txOutcomeNode)
+	for {
+		data, stat, ch, err := prop.p.c.armDataWatch(ctx, path)
+		if err != nil {
+			return false, err
+		}
+		if stat != nil {
+			prop.p.c.RemoveWatches(path, ch)
+			return string(data) == txCommit, nil
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			prop.p.c.RemoveWatches(path, ch)
+			return false, ctx.Err()
+		}
+	}
+}
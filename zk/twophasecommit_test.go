@@ -0,0 +1,125 @@
+package zk
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTwoPhaseCommit(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	acl := WorldACL(PermAll)
+	const tx = "/txns/tx-0000000001"
+
+	type result struct {
+		commit bool
+		err    error
+	}
+
+	// The coordinator proposes the transaction.
+	proposed := make(chan *Transaction, 1)
+	go func() {
+		tx, err := NewTwoPhaseCoordinator(zk, "/txns", acl).Propose([]byte("cutover"), []string{"a", "b"})
+		if err != nil {
+			t.Error(err)
+		}
+		proposed <- tx
+	}()
+	req := serveFake(t, fc, "create", "/txns/tx-", nil, &createResponse{Path: tx})
+	proposal := req.Body.(*CreateRequest).Data
+	txn := <-proposed
+	if txn.ID != "tx-0000000001" {
+		t.Fatalf("Transaction ID %s", txn.ID)
+	}
+
+	// A participant finds it and votes.
+	found := make(chan *Proposal, 1)
+	p := NewTwoPhaseParticipant(zk, "/txns", "a", acl)
+	go func() {
+		prop, err := p.Next(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		found <- prop
+	}()
+	serveFake(t, fc, "getChildren2", "/txns", nil, &getChildren2Response{Children: []string{"tx-0000000001", "other"}})
+	serveFake(t, fc, "getData", tx, nil, &getDataResponse{Data: proposal})
+	serveFake(t, fc, "getChildren2", tx, nil, &getChildren2Response{})
+	serveFake(t, fc, "removeWatches", "/txns", nil, &removeWatchesResponse{})
+	prop := <-found
+	if prop == nil || string(prop.Data) != "cutover" || len(prop.Participants) != 2 {
+		t.Fatalf("Next returned %+v", prop)
+	}
+	voted := make(chan error, 1)
+	go func() { voted <- prop.Vote(true) }()
+	req = serveFake(t, fc, "create", tx+"/vote-a", nil, &createResponse{Path: tx + "/vote-a"})
+	if vote := string(req.Body.(*CreateRequest).Data); vote != "commit" {
+		t.Fatalf("Voted %s", vote)
+	}
+	if err := <-voted; err != nil {
+		t.Fatal(err)
+	}
+
+	// The coordinator commits once everyone voted to.
+	waited := make(chan result, 1)
+	go func() {
+		commit, err := txn.Wait(context.Background())
+		waited <- result{commit, err}
+	}()
+	serveFake(t, fc, "getChildren2", tx, nil, &getChildren2Response{Children: []string{"vote-a", "vote-b"}})
+	serveFake(t, fc, "getData", tx+"/vote-a", nil, &getDataResponse{Data: []byte("commit")})
+	serveFake(t, fc, "getData", tx+"/vote-b", nil, &getDataResponse{Data: []byte("commit")})
+	serveFake(t, fc, "removeWatches", tx, nil, &removeWatchesResponse{})
+	req = serveFake(t, fc, "create", tx+"/outcome", nil, &createResponse{Path: tx + "/outcome"})
+	if outcome := string(req.Body.(*CreateRequest).Data); outcome != "commit" {
+		t.Fatalf("Decided %s", outcome)
+	}
+	if r := <-waited; !r.commit || r.err != nil {
+		t.Fatalf("Wait returned %v, %+v", r.commit, r.err)
+	}
+
+	// The participant learns the outcome.
+	outcome := make(chan result, 1)
+	go func() {
+		commit, err := prop.Outcome(context.Background())
+		outcome <- result{commit, err}
+	}()
+	serveFake(t, fc, "getData", tx+"/outcome", nil, &getDataResponse{Data: []byte("commit")})
+	serveFake(t, fc, "removeWatches", tx+"/outcome", nil, &removeWatchesResponse{})
+	if r := <-outcome; !r.commit || r.err != nil {
+		t.Fatalf("Outcome returned %v, %+v", r.commit, r.err)
+	}
+}
+
+func TestTwoPhaseCommitAbort(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+	const tx = "/txns/tx-0000000002"
+	txn := &Transaction{c: zk, acl: WorldACL(PermAll), ID: "tx-0000000002", Path: tx, Participants: []string{"a", "b"}}
+
+	// One vote to abort is enough, even before the others voted.
+	done := make(chan error, 1)
+	go func() {
+		commit, err := txn.Wait(context.Background())
+		if commit {
+			t.Error("Transaction committed")
+		}
+		done <- err
+	}()
+	serveFake(t, fc, "getChildren2", tx, nil, &getChildren2Response{Children: []string{"vote-b"}})
+	serveFake(t, fc, "getData", tx+"/vote-b", nil, &getDataResponse{Data: []byte("abort")})
+	serveFake(t, fc, "removeWatches", tx, nil, &removeWatchesResponse{})
+	req := serveFake(t, fc, "create", tx+"/outcome", nil, &createResponse{Path: tx + "/outcome"})
+	if outcome := string(req.Body.(*CreateRequest).Data); outcome != "abort" {
+		t.Fatalf("Decided %s", outcome)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
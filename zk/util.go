@@ -7,8 +7,44 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
+// InvalidPathError is returned when a path fails client-side validation.
+// It describes what is wrong with the path and matches ErrInvalidPath
+// when compared with errors.Is.
+type InvalidPathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *InvalidPathError) Error() string {
+	return fmt.Sprintf("zk: invalid path %q: %s", e.Path, e.Reason)
+}
+
+// Is reports whether target is ErrInvalidPath.
+func (e *InvalidPathError) Is(target error) bool {
+	return target == ErrInvalidPath
+}
+
+// DataTooLargeError is returned when node data exceeds the maximum data
+// size of the client. It matches ErrDataTooLarge when compared with
+// errors.Is.
+type DataTooLargeError struct {
+	Path string
+	Size int
+	Max  int
+}
+
+func (e *DataTooLargeError) Error() string {
+	return fmt.Sprintf("zk: data for %q is %d bytes, larger than the maximum of %d", e.Path, e.Size, e.Max)
+}
+
+// Is reports whether target is ErrDataTooLarge.
+func (e *DataTooLargeError) Is(target error) bool {
+	return target == ErrDataTooLarge
+}
+
 // AuthACL produces an ACL list containing a single ACL which uses the
 // provided permissions, with the scheme "auth", and ID "", which is used
 // by ZooKeeper to represent any authenticated user.
@@ -45,6 +81,24 @@ func FormatServers(servers []string) []string {
 	return servers
 }
 
+// parentPath returns the path of the parent of the node at path. The parent
+// of the root node is the root node itself.
+func parentPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// childPath returns the path of the child called name of the node at path.
+func childPath(path, name string) string {
+	if path == "/" {
+		return "/" + name
+	}
+	return path + "/" + name
+}
+
 // stringShuffle performs a Fisher-Yates shuffle on a slice of strings
 func stringShuffle(s []string) {
 	for i := len(s) - 1; i > 0; i-- {
@@ -52,3 +106,70 @@ func stringShuffle(s []string) {
 		s[i], s[j] = s[j], s[i]
 	}
 }
+
+// validatePath checks that path is a valid absolute znode path, mirroring
+// the server-side rules. If isSequential is set a trailing slash is allowed
+// since the server appends the sequence number to the path.
+func validatePath(path string, isSequential bool) error {
+	if path == "" {
+		return &InvalidPathError{path, "path must not be empty"}
+	}
+	if path[0] != '/' {
+		return &InvalidPathError{path, "path must start with / character"}
+	}
+	if len(path) == 1 {
+		return nil
+	}
+	if path[len(path)-1] == '/' && !isSequential {
+		return &InvalidPathError{path, "path must not end with / character"}
+	}
+	if !utf8.ValidString(path) {
+		return &InvalidPathError{path, "path must be valid UTF-8"}
+	}
+
+	components := strings.Split(path[1:], "/")
+	for i, component := range components {
+		switch {
+		case component == "" && i == len(components)-1 && isSequential:
+			// Trailing slash of a sequential node.
+		case component == "":
+			return &InvalidPathError{path, "empty node name specified"}
+		case component == "." || component == "..":
+			return &InvalidPathError{path, "relative paths not allowed"}
+		}
+	}
+
+	for i, r := range path {
+		switch {
+		case r == 0:
+			return &InvalidPathError{path, fmt.Sprintf("null character not allowed at %d", i)}
+		case r > 0x00 && r <= 0x1f,
+			r >= 0x7f && r <= 0x9f,
+			r >= 0xd800 && r <= 0xf8ff,
+			r >= 0xfff0 && r <= 0xffff:
+			return &InvalidPathError{path, fmt.Sprintf("invalid character %U at %d", r, i)}
+		}
+	}
+	return nil
+}
+
+// normalizePath collapses repeated slashes and strips a trailing slash,
+// which is kept for sequential nodes. Relative paths and "." or ".."
+// components are left untouched for validatePath to reject.
+func normalizePath(path string, isSequential bool) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+	parts := strings.Split(path, "/")
+	components := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			components = append(components, p)
+		}
+	}
+	normalized := "/" + strings.Join(components, "/")
+	if isSequential && len(components) > 0 && strings.HasSuffix(path, "/") {
+		normalized += "/"
+	}
+	return normalized
+}
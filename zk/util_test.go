@@ -1,6 +1,9 @@
 package zk
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestFormatServers(t *testing.T) {
 	t.Parallel()
@@ -12,3 +15,94 @@ func TestFormatServers(t *testing.T) {
 		}
 	}
 }
+
+func TestValidatePath(t *testing.T) {
+	t.Parallel()
+	tt := []struct {
+		path  string
+		seq   bool
+		valid bool
+	}{
+		{"/this is / a valid/path", false, true},
+		{"/", false, true},
+		{"", false, false},
+		{"not/valid", false, false},
+		{"/ends/with/slash/", false, false},
+		{"/ends/with/slash/", true, true},
+		{"/test\u0000", false, false},
+		{"/double//slash", false, false},
+		{"/single/./period", false, false},
+		{"/double/../period", false, false},
+		{"/double/..ok/period", false, true},
+		{"/double/alsook../period", false, true},
+		{"/double/period/at/end/..", false, false},
+		{"/name/with.period", false, true},
+		{"/test\u0001", false, false},
+		{"/test\u001F", false, false},
+		{"/test\u007F", false, false},
+		{"/test\u009F", false, false},
+		{"/test\uF8FF", false, false},
+		{"/test\uFFF0", false, false},
+		{"/test\uFFFE", false, false},
+	}
+
+	for _, tc := range tt {
+		err := validatePath(tc.path, tc.seq)
+		if (err == nil) != tc.valid {
+			t.Errorf("validatePath(%q, %v) returned %v, expected valid=%v", tc.path, tc.seq, err, tc.valid)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("validatePath(%q, %v) returned %v which does not match ErrInvalidPath", tc.path, tc.seq, err)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	t.Parallel()
+	tt := []struct {
+		path     string
+		seq      bool
+		expected string
+	}{
+		{"/", false, "/"},
+		{"//", false, "/"},
+		{"/a/b", false, "/a/b"},
+		{"/a//b/", false, "/a/b"},
+		{"/a//b/", true, "/a/b/"},
+		{"a/b", false, "a/b"},
+		{"/a/../b", false, "/a/../b"},
+	}
+
+	for _, tc := range tt {
+		if p := normalizePath(tc.path, tc.seq); p != tc.expected {
+			t.Errorf("normalizePath(%q, %v) = %q, expected %q", tc.path, tc.seq, p, tc.expected)
+		}
+	}
+}
+
+func TestParentPath(t *testing.T) {
+	t.Parallel()
+	for path, expected := range map[string]string{
+		"/":      "/",
+		"/a":     "/",
+		"/a/b":   "/a",
+		"/a/b/c": "/a/b",
+	} {
+		if p := parentPath(path); p != expected {
+			t.Errorf("parentPath(%q) = %q, expected %q", path, p, expected)
+		}
+	}
+}
+
+func TestChildPath(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct{ path, name, expected string }{
+		{"/", "a", "/a"},
+		{"/a", "b", "/a/b"},
+		{"/a/b", "c", "/a/b/c"},
+	} {
+		if p := childPath(c.path, c.name); p != c.expected {
+			t.Errorf("childPath(%q, %q) = %q, expected %q", c.path, c.name, p, c.expected)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package zk
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerNotServing is reported by Validate for a server that accepted the
+// connection but refused to create a session, e.g. because it is not part of
+// a quorum.
+var ErrServerNotServing = errors.New("zk: server is not serving requests")
+
+// ServerCheck is the result of checking a single server in Validate.
+type ServerCheck struct {
+	Server string
+	// Latency is the time it took to dial the server and create a session.
+	Latency time.Duration
+	Err     error
+}
+
+// ValidationReport is the result of Validate.
+type ValidationReport struct {
+	Servers []ServerCheck
+	// SessionErr is set if a request on the session of the connection
+	// failed, or is ErrNoNode if the chroot of the connection does not
+	// exist.
+	SessionErr error
+	// AuthErr is set if the session failed to authenticate.
+	AuthErr error
+}
+
+// Err returns the first problem found, or nil if every check passed.
+func (r *ValidationReport) Err() error {
+	for _, s := range r.Servers {
+		if s.Err != nil {
+			return s.Err
+		}
+	}
+	if r.AuthErr != nil {
+		return r.AuthErr
+	}
+	return r.SessionErr
+}
+
+// Validate checks that every configured server accepts connections and
+// creates sessions, and that the session of the connection can authenticate
+// and serve requests. Each server is dialed with the same dialer, TLS and
+// compression settings as the connection, and the session created on it is
+// closed right away. It is meant for startup and deploy-time checks; ctx
+// bounds the whole validation.
+func (c *Conn) Validate(ctx context.Context) *ValidationReport {
+	servers := c.configuredServers()
+	report := &ValidationReport{Servers: make([]ServerCheck, len(servers))}
+
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(check *ServerCheck, server string) {
+			defer wg.Done()
+			start := time.Now()
+			check.Server = server
+			check.Err = c.probeServer(ctx, server)
+			check.Latency = time.Since(start)
+		}(&report.Servers[i], server)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		exists, _, err := c.Exists("/")
+		if err == nil && !exists {
+			err = ErrNoNode
+		}
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err == ErrAuthFailed || c.State() == StateAuthFailed {
+		report.AuthErr = ErrAuthFailed
+	} else {
+		report.SessionErr = err
+	}
+
+	wg.Wait()
+	return report
+}
+
+// probeServer creates and closes a session on server.
+func (c *Conn) probeServer(ctx context.Context, server string) error {
+	conn, err := c.dialer("tcp", server, c.connectTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	deadline := time.Now().Add(c.recvTimeout * 10)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	err = c.probeSession(conn, deadline)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// The read deadline may fire just before the context notices.
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+func (c *Conn) probeSession(conn net.Conn, deadline time.Time) error {
+	buf := make([]byte, 256)
+	n, err := encodePacket(buf[4:], &connectRequest{
+		ProtocolVersion: protocolVersion,
+		TimeOut:         c.sessionTimeoutMs,
+		Passwd:          emptyPassword,
+	})
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(buf[:4], uint32(n))
+	if _, err := conn.Write(buf[:n+4]); err != nil {
+		return err
+	}
+
+	blen, err := readPacket(conn, buf, deadline)
+	if err != nil {
+		return err
+	}
+	r := connectResponse{}
+	if _, err := decodePacket(buf[:blen], &r); err != nil {
+		return err
+	}
+	if r.SessionID == 0 {
+		return ErrServerNotServing
+	}
+
+	// Close the session instead of leaving it to expire.
+	n, err = encodePacket(buf[4:], &requestHeader{Xid: 1, Opcode: opClose})
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(buf[:4], uint32(n))
+	if _, err := conn.Write(buf[:n+4]); err != nil {
+		return err
+	}
+	_, err = readPacket(conn, buf, deadline)
+	return err
+}
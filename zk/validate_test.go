@@ -0,0 +1,157 @@
+package zk
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeServer answers the connect request of every connection with
+// sessionID and acknowledges a close request.
+func startFakeServer(t *testing.T, sessionID int64) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 256)
+				if _, err := readPacket(conn, buf, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+				n, _ := encodePacket(buf[4:], &connectResponse{TimeOut: 4000, SessionID: sessionID, Passwd: []byte{1}})
+				binary.BigEndian.PutUint32(buf[:4], uint32(n))
+				conn.Write(buf[:n+4])
+				if _, err := readPacket(conn, buf, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+				n, _ = encodePacket(buf[4:], &responseHeader{Xid: 1})
+				binary.BigEndian.PutUint32(buf[:4], uint32(n))
+				conn.Write(buf[:n+4])
+			}()
+		}
+	}()
+	return l
+}
+
+func TestProbeServer(t *testing.T) {
+	t.Parallel()
+	c := &Conn{dialer: net.DialTimeout, connectTimeout: time.Second}
+	c.setTimeouts(4000)
+
+	serving := startFakeServer(t, 5)
+	defer serving.Close()
+	if err := c.probeServer(context.Background(), serving.Addr().String()); err != nil {
+		t.Fatalf("probeServer returned error: %+v", err)
+	}
+	notServing := startFakeServer(t, 0)
+	defer notServing.Close()
+	if err := c.probeServer(context.Background(), notServing.Addr().String()); err != ErrServerNotServing {
+		t.Fatalf("probeServer returned %v instead of ErrServerNotServing", err)
+	}
+
+	// A server that never answers must not outlive the context.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.probeServer(ctx, l.Addr().String()); err != context.DeadlineExceeded {
+		t.Fatalf("probeServer returned %v instead of context.DeadlineExceeded", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	servers := []string{down, fmt.Sprintf("127.0.0.1:%d", ts.Servers[0].Port)}
+	zk, _, err := Connect(servers, 15*time.Second)
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report := zk.Validate(ctx)
+	if report.SessionErr != nil || report.AuthErr != nil {
+		t.Fatalf("Validate reported session error %v, auth error %v", report.SessionErr, report.AuthErr)
+	}
+	if len(report.Servers) != 2 {
+		t.Fatalf("Validate checked %d servers instead of 2", len(report.Servers))
+	}
+	for _, s := range report.Servers {
+		if (s.Server == down) != (s.Err != nil) {
+			t.Fatalf("Wrong result %+v for %s", s, s.Server)
+		}
+	}
+	if report.Err() == nil {
+		t.Fatal("Err returned nil although a server is down")
+	}
+}
+
+func TestValidateMissingChroot(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithChroot("/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	reports := make(chan *ValidationReport, 1)
+	go func() { reports <- zk.Validate(context.Background()) }()
+
+	// The probe of the server creates and closes a session of its own.
+	probe := acceptFake(t, s, 0)
+	req, err := probe.ExpectRequest("close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := probe.Reply(req, 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = fc.ExpectRequest("exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Path != "/app" {
+		t.Fatalf("exists request on %s instead of the chroot", req.Path)
+	}
+	if err := fc.Reply(req, 1, ErrNoNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	report := <-reports
+	if report.SessionErr != ErrNoNode {
+		t.Fatalf("Validate reported session error %v instead of ErrNoNode", report.SessionErr)
+	}
+	if report.Err() != ErrNoNode {
+		t.Fatalf("Err returned %v instead of ErrNoNode", report.Err())
+	}
+}
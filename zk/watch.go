@@ -0,0 +1,673 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoEventTypes is returned by WatchFor when none of the given event types
+// is a node event.
+var ErrNoEventTypes = errors.New("zk: no node event types to watch for")
+
+// AddWatch adds a watch on path that, unlike the one-shot watches set by
+// GetW, ChildrenW and ExistsW, keeps firing for every change until the
+// session ends. With WatchModePersistent it receives EventNodeCreated,
+// EventNodeDeleted, EventNodeDataChanged and EventNodeChildrenChanged events
+// for path. With WatchModePersistentRecursive it receives EventNodeCreated,
+// EventNodeDeleted and EventNodeDataChanged events for path and every node
+// below it. It requires ZooKeeper 3.6 or later.
+//
+// Events are queued so that a slow reader never blocks the connection, but
+// the returned channel must be drained. It is closed after an
+// EventNotWatching event when the watch is lost. The server does not replay
+// changes made while the connection was down, so after every reconnect the
+// channel receives an EventSession event with StateHasSession, after which
+// the caller should re-read the watched nodes.
+func (c *Conn) AddWatch(path string, mode WatchMode) (<-chan Event, error) {
+	return c.AddWatchFor(path, mode)
+}
+
+// AddWatchFor is like AddWatch but only delivers node events of the given
+// types, or all of them if none are given. Session, watch removal and EventNotWatching events are always
+// delivered. The server has no per type filters for persistent watches, so
+// other events are dropped as they arrive instead of being queued.
+func (c *Conn) AddWatchFor(path string, mode WatchMode, types ...EventType) (<-chan Event, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var ech <-chan Event
+	_, err = c.request(opAddWatch, &addWatchRequest{Path: path, Mode: mode}, &addWatchResponse{}, func(req *request, res *responseHeader, err error) {
+		if err == nil {
+			var wType watchType = watchTypePersistent
+			if mode == WatchModePersistentRecursive {
+				wType = watchTypePersistentRecursive
+			}
+			w := c.addPersistentWatcher(path, wType, nil)
+			w.types = types
+			ech = w.ch
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ech, nil
+}
+
+// WatchFor sets a one-shot watch on path that fires only for an event of one
+// of the given types, e.g. only EventNodeDeleted and not
+// EventNodeDataChanged. It registers the narrowest server watches that can
+// see those events and re-arms them when an event of another type consumes
+// them. Changes that happen while re-arming are detected by comparing the
+// node's Stat and reported as if they had fired the watch.
+//
+// The returned channel receives one event and is then closed. If a watch is
+// lost, e.g. because the session expired, it receives EventNotWatching.
+func (c *Conn) WatchFor(path string, types ...EventType) (<-chan Event, error) {
+	f := &filteredWatch{c: c, path: path, ch: make(chan Event, 1)}
+	for _, t := range types {
+		switch t {
+		case EventNodeChildrenChanged:
+			f.wantChildren = true
+		case EventNodeCreated, EventNodeDeleted, EventNodeDataChanged:
+			f.wantNode = true
+		}
+	}
+	if !f.wantNode && !f.wantChildren {
+		return nil, ErrNoEventTypes
+	}
+	f.types = types
+	if err := f.arm(); err != nil {
+		return nil, err
+	}
+	go f.run()
+	return f.ch, nil
+}
+
+type filteredWatch struct {
+	c            *Conn
+	path         string
+	types        []EventType
+	wantNode     bool
+	wantChildren bool
+	ch           chan Event
+
+	existCh, childCh <-chan Event
+	// exists and stat are the state of the node as of the last watch
+	// registration, once known.
+	known  bool
+	exists bool
+	stat   Stat
+	// missed is a wanted change that happened between registrations.
+	missed *Event
+}
+
+// arm registers the watches needed for the wanted events that are not
+// registered yet: an exists watch for node events or to learn when the node
+// is created, and a children watch while the node exists.
+func (f *filteredWatch) arm() error {
+	for {
+		switch {
+		case f.existCh == nil && (f.wantNode || (f.known && !f.exists)):
+			exists, stat, ch, err := f.c.ExistsW(f.path)
+			if err != nil {
+				return err
+			}
+			f.existCh = ch
+			f.update(exists, stat)
+		case f.childCh == nil && f.wantChildren && (!f.known || f.exists):
+			_, stat, ch, err := f.c.ChildrenW(f.path)
+			if err == ErrNoNode {
+				f.update(false, nil)
+				continue
+			} else if err != nil {
+				return err
+			}
+			f.childCh = ch
+			f.update(true, stat)
+		default:
+			return nil
+		}
+	}
+}
+
+// update records the current state of the node, noting a wanted change
+// since the previously known state.
+func (f *filteredWatch) update(exists bool, stat *Stat) {
+	if f.known && f.missed == nil {
+		var typ EventType
+		switch {
+		case !f.exists && exists:
+			typ = EventNodeCreated
+		case f.exists && !exists:
+			typ = EventNodeDeleted
+		case exists && stat.Version != f.stat.Version:
+			typ = EventNodeDataChanged
+		case exists && stat.Cversion != f.stat.Cversion:
+			typ = EventNodeChildrenChanged
+		}
+		if typ != 0 && f.wants(typ) {
+			f.missed = &Event{Type: typ, State: StateHasSession, Path: f.path, Server: f.c.Server()}
+		}
+	}
+	f.known, f.exists = true, exists
+	if exists {
+		f.stat = *stat
+	}
+}
+
+func (f *filteredWatch) wants(typ EventType) bool {
+	for _, t := range f.types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *filteredWatch) run() {
+	defer close(f.ch)
+	for {
+		if f.missed != nil {
+			f.ch <- *f.missed
+			return
+		}
+		var ev Event
+		select {
+		case ev = <-f.existCh:
+			f.existCh = nil
+		case ev = <-f.childCh:
+			f.childCh = nil
+		}
+		if ev.Err != nil || f.wants(ev.Type) {
+			f.ch <- ev
+			return
+		}
+		if err := f.arm(); err != nil {
+			f.ch <- Event{Type: EventNotWatching, State: StateDisconnected, Path: f.path, Err: err}
+			return
+		}
+	}
+}
+
+func (c *Conn) addPersistentWatcher(path string, watchType watchType, stream *WatchStream) *persistentWatcher {
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+
+	if c.persistentWatchers == nil {
+		c.persistentWatchers = make(map[watchPathType][]*persistentWatcher)
+	}
+	w := c.limitWatcher(newPersistentWatcher(stream), c.watchBuffer, c.watchPolicy)
+	wpt := watchPathType{path, watchType}
+	c.persistentWatchers[wpt] = append(c.persistentWatchers[wpt], w)
+	c.countWatchers(watchType, 1)
+	return w
+}
+
+// RemoveWatches removes the watch on path whose events are delivered on ch,
+// as returned by GetW, ChildrenW, ExistsW or AddWatch. The channel receives
+// an EventDataWatchRemoved, EventChildWatchRemoved or
+// EventPersistentWatchRemoved event and is then closed. The watch is only
+// removed from the server once no other local watcher needs it. ErrNoWatcher
+// is returned if ch is not watching path, e.g. because it already fired.
+// Watches added with AddEmulatedWatch are removed the same way.
+func (c *Conn) RemoveWatches(path string, ch <-chan Event) error {
+	if c.removeEmulatedWatch(path, ch) {
+		return nil
+	}
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return err
+	}
+
+	c.watchersLock.Lock()
+	wType, shared, ok := c.findWatcher(path, ch)
+	c.watchersLock.Unlock()
+	if !ok {
+		return ErrNoWatcher
+	}
+
+	// Other watchers still depend on the server side watch, so only check that
+	// it is still registered before dropping ch locally.
+	var opcode int32 = opRemoveWatches
+	var req, res interface{} = &removeWatchesRequest{Path: path, Type: wType.watcherType()}, &removeWatchesResponse{}
+	if shared {
+		opcode = opCheckWatches
+		req, res = &checkWatchesRequest{Path: path, Type: wType.watcherType()}, &checkWatchesResponse{}
+	}
+	_, err = c.request(opcode, req, res, func(req *request, res *responseHeader, err error) {
+		if err == nil {
+			c.watchersLock.Lock()
+			c.removeWatchers(path, func(wt watchType, wch <-chan Event) bool {
+				return wch == ch
+			})
+			c.watchersLock.Unlock()
+		}
+	})
+	return err
+}
+
+// RemoveAllWatches removes every watch of watcherType on path, both on the
+// server and locally. Each removed watch channel receives a watch removed
+// event and is then closed. ErrNoWatcher is returned if the session has no
+// such watch on path.
+func (c *Conn) RemoveAllWatches(path string, watcherType WatcherType) error {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return err
+	}
+
+	req := &removeWatchesRequest{Path: path, Type: watcherType}
+	_, err = c.request(opRemoveWatches, req, &removeWatchesResponse{}, func(req *request, res *responseHeader, err error) {
+		if err == nil {
+			c.watchersLock.Lock()
+			c.removeWatchers(path, func(wt watchType, wch <-chan Event) bool {
+				return watcherType == WatcherTypeAny || wt.watcherType() == watcherType
+			})
+			c.watchersLock.Unlock()
+		}
+	})
+	return err
+}
+
+// RemoveWatchesUnder removes every watch on prefix and the nodes below it,
+// e.g. when tearing down a subsystem that set many of them. The watches are
+// dropped locally at once, so that they are not set again after a
+// reconnect, and each removed watch channel receives a watch removed event
+// and is then closed. Watches added with AddEmulatedWatch on such a path are
+// removed too. The watches are then removed from the server with one
+// removeWatches request per watched path, all sent at once. These are not
+// retried if the connection is lost, as the server drops the watches of a
+// connection that is not resumed, and servers older than 3.5, which do not
+// support them, keep the watches until they fire. It returns the first
+// other error removing them from the server.
+func (c *Conn) RemoveWatchesUnder(prefix string) error {
+	serverPrefix, err := c.processPath(prefix, false)
+	if err != nil {
+		return err
+	}
+
+	var emulated []*emulatedWatch
+	paths := make(map[string]bool)
+	c.watchersLock.Lock()
+	for _, e := range c.emulatedWatches {
+		if pathUnder(e.path, prefix) {
+			emulated = append(emulated, e)
+		}
+	}
+	for wpt := range c.watchers {
+		if pathUnder(wpt.path, serverPrefix) {
+			paths[wpt.path] = true
+		}
+	}
+	for wpt := range c.persistentWatchers {
+		if pathUnder(wpt.path, serverPrefix) {
+			paths[wpt.path] = true
+		}
+	}
+	for path := range paths {
+		c.removeWatchers(path, func(watchType, <-chan Event) bool { return true })
+	}
+	c.watchersLock.Unlock()
+	for _, e := range emulated {
+		e.finish(Event{Type: EventPersistentWatchRemoved, State: StateConnected, Path: e.path})
+	}
+
+	var pending []<-chan response
+	for path := range paths {
+		req := &removeWatchesRequest{Path: path, Type: WatcherTypeAny}
+		pending = append(pending, c.queueRequest(opRemoveWatches, req, &removeWatchesResponse{}, nil))
+	}
+	var firstErr error
+	for _, ch := range pending {
+		err := c.wait(ch).err
+		if err == nil || err == ErrNoWatcher || err == ErrUnimplemented || err == ErrClosing || isConnectionError(err) {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pathUnder reports whether path is prefix or below it.
+func pathUnder(path, prefix string) bool {
+	return prefix == "/" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// findWatcher looks up the watch on path delivering to ch. shared reports
+// whether another local watcher relies on the same server side watch. The
+// caller must hold watchersLock.
+func (c *Conn) findWatcher(path string, ch <-chan Event) (wType watchType, shared bool, ok bool) {
+	found := false
+	count := make(map[WatcherType]int)
+	for _, wt := range allWatchTypes {
+		wpt := watchPathType{path, wt}
+		n := len(c.watchers[wpt]) + len(c.persistentWatchers[wpt])
+		count[wt.watcherType()] += n
+		if found {
+			continue
+		}
+		for _, wch := range c.watchers[wpt] {
+			if (<-chan Event)(wch) == ch {
+				wType, found = wt, true
+			}
+		}
+		for _, w := range c.persistentWatchers[wpt] {
+			if (<-chan Event)(w.ch) == ch {
+				wType, found = wt, true
+			}
+		}
+	}
+	if !found {
+		return 0, false, false
+	}
+	return wType, count[wType.watcherType()] > 1, true
+}
+
+// removeWatchers drops the watchers on path for which match returns true,
+// notifying them with a watch removed event. The caller must hold
+// watchersLock.
+func (c *Conn) removeWatchers(path string, match func(watchType, <-chan Event) bool) {
+	for _, wt := range allWatchTypes {
+		wpt := watchPathType{path, wt}
+		ev := Event{Type: wt.removedEventType(), State: StateConnected, Path: c.clientPath(path)}
+
+		var keep []chan Event
+		for _, ch := range c.watchers[wpt] {
+			if match(wt, ch) {
+				ch <- ev
+				close(ch)
+			} else {
+				keep = append(keep, ch)
+			}
+		}
+		c.countWatchers(wt, len(keep)-len(c.watchers[wpt]))
+		if len(keep) == 0 {
+			delete(c.watchers, wpt)
+		} else {
+			c.watchers[wpt] = keep
+		}
+
+		var keepPersistent []*persistentWatcher
+		for _, w := range c.persistentWatchers[wpt] {
+			if match(wt, w.ch) {
+				w.deliver(ev, -1)
+				w.close()
+			} else {
+				keepPersistent = append(keepPersistent, w)
+			}
+		}
+		c.countWatchers(wt, len(keepPersistent)-len(c.persistentWatchers[wpt]))
+		if len(keepPersistent) == 0 {
+			delete(c.persistentWatchers, wpt)
+		} else {
+			c.persistentWatchers[wpt] = keepPersistent
+		}
+	}
+}
+
+var allWatchTypes = []watchType{watchTypeData, watchTypeExist, watchTypeChild, watchTypePersistent, watchTypePersistentRecursive}
+
+// watcherType returns the server side watcher type covering wt.
+func (wt watchType) watcherType() WatcherType {
+	switch wt {
+	case watchTypeChild:
+		return WatcherTypeChildren
+	case watchTypePersistent:
+		return WatcherTypePersistent
+	case watchTypePersistentRecursive:
+		return WatcherTypePersistentRecursive
+	default:
+		return WatcherTypeData
+	}
+}
+
+func (wt watchType) removedEventType() EventType {
+	switch wt {
+	case watchTypeChild:
+		return EventChildWatchRemoved
+	case watchTypePersistent, watchTypePersistentRecursive:
+		return EventPersistentWatchRemoved
+	default:
+		return EventDataWatchRemoved
+	}
+}
+
+// persistentWatcher delivers the events of a persistent watch in order
+// without ever blocking the receive loop.
+type persistentWatcher struct {
+	ch chan Event
+	// stream, when set, is handed the events instead of ch.
+	stream *WatchStream
+	// types, when set, are the node event types delivered.
+	types []EventType
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []watchedEvent
+	closed bool
+
+	// limit, when set, bounds the queued events, excluding the overflow
+	// events standing for those dropped, according to policy.
+	limit     int
+	policy    OverflowPolicy
+	overflows int           // queued overflow events
+	space     chan struct{} // signaled when an event leaves the queue
+	quit      <-chan struct{}
+	dropped   *uint64
+}
+
+// watchedEvent is a queued event along with the last zxid the connection had
+// seen when it arrived, or -1 for events generated by the client.
+type watchedEvent struct {
+	Event
+	zxid int64
+}
+
+func newPersistentWatcher(stream *WatchStream) *persistentWatcher {
+	w := &persistentWatcher{ch: make(chan Event), stream: stream, space: make(chan struct{}, 1)}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// deliver queues ev for delivery.
+func (w *persistentWatcher) deliver(ev Event, zxid int64) {
+	if len(w.types) != 0 && ev.Type >= EventNodeCreated && ev.Type <= EventNodeChildrenChanged {
+		wanted := false
+		for _, t := range w.types {
+			wanted = wanted || t == ev.Type
+		}
+		if !wanted {
+			return
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.full() && w.policy == OverflowBlock && !w.closed {
+		w.mu.Unlock()
+		select {
+		case <-w.space:
+			w.mu.Lock()
+			continue
+		case <-w.quit:
+		}
+		w.mu.Lock()
+		// The connection is closing, queue the last events regardless.
+		w.limit = 0
+	}
+	if w.closed {
+		return
+	}
+	if w.full() {
+		w.overflow(watchedEvent{ev, zxid})
+		return
+	}
+	w.queue = append(w.queue, watchedEvent{ev, zxid})
+	w.cond.Signal()
+}
+
+// full reports whether the queue reached its limit. The caller must hold mu.
+func (w *persistentWatcher) full() bool {
+	return w.limit > 0 && len(w.queue)-w.overflows >= w.limit
+}
+
+// overflow drops an event of the full queue, according to the policy, and
+// queues e if it was not the one dropped. The dropped event is replaced by an
+// overflow event, or added to the one before it. The caller must hold mu.
+func (w *persistentWatcher) overflow(e watchedEvent) {
+	if w.dropped != nil {
+		atomic.AddUint64(w.dropped, 1)
+	}
+	i := len(w.queue)
+	if w.policy == OverflowDropOldest {
+		for i = 0; w.queue[i].Type == EventWatcherOverflow; i++ {
+		}
+	}
+	if i > 0 && w.queue[i-1].Type == EventWatcherOverflow {
+		prev := w.queue[i-1].Err.(*EventOverflowError)
+		// A new error, the previous one may be delivered already.
+		w.queue[i-1].Err = &EventOverflowError{Dropped: prev.Dropped + 1}
+		if i < len(w.queue) {
+			w.queue = append(w.queue[:i], w.queue[i+1:]...)
+		}
+	} else {
+		marker := watchedEvent{Event{Type: EventWatcherOverflow, State: e.State, Err: &EventOverflowError{Dropped: 1}}, -1}
+		if i < len(w.queue) {
+			w.queue[i] = marker
+		} else {
+			w.queue = append(w.queue, marker)
+		}
+		w.overflows++
+	}
+	if w.policy == OverflowDropOldest {
+		w.queue = append(w.queue, e)
+	}
+	w.cond.Signal()
+}
+
+// close closes the channel once the queued events have been delivered.
+func (w *persistentWatcher) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+func (w *persistentWatcher) run() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			if w.stream != nil {
+				close(w.stream.ch)
+			} else {
+				close(w.ch)
+			}
+			return
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		if e.Type == EventWatcherOverflow {
+			w.overflows--
+		}
+		w.mu.Unlock()
+		select {
+		case w.space <- struct{}{}:
+		default:
+		}
+		if w.stream != nil {
+			w.stream.handle(e)
+		} else {
+			w.ch <- e.Event
+		}
+	}
+}
+
+// WatchUntil watches path until cond returns true for an event or ctx is
+// done. It sets an exists watch on path, plus a children watch while the node
+// exists, and re-arms them after every event, so cond sees node creation,
+// deletion, data and children changes. The event that matched is returned.
+//
+// If a watch is lost, e.g. because the session expired, the EventNotWatching
+// event is returned along with its error. The watches that did not fire,
+// e.g. when ctx is done, are removed before WatchUntil returns.
+func (c *Conn) WatchUntil(ctx context.Context, path string, cond func(Event) bool) (Event, error) {
+	var existCh, childCh <-chan Event
+	var pending []Event
+	exists := false
+	defer func() {
+		for _, ch := range []<-chan Event{existCh, childCh} {
+			if ch != nil {
+				c.RemoveWatches(path, ch)
+			}
+		}
+	}()
+	for {
+		if existCh == nil {
+			ok, _, ch, err := c.ExistsW(path)
+			if err != nil {
+				return Event{}, err
+			}
+			existCh = ch
+			exists = ok
+		}
+		if childCh == nil && exists {
+			_, _, ch, err := c.ChildrenW(path)
+			if err != nil && err != ErrNoNode {
+				return Event{}, err
+			}
+			childCh = ch
+		}
+
+		var ev Event
+		if len(pending) > 0 {
+			ev, pending = pending[0], pending[1:]
+		} else {
+			select {
+			case ev = <-existCh:
+				existCh = nil
+			case ev = <-childCh:
+				childCh = nil
+			case <-ctx.Done():
+				return Event{}, ctx.Err()
+			}
+
+			// A single server notification fires every matching watch on
+			// the path at once, so the same event may be waiting on the other
+			// channel as well.
+			for _, ch := range []*<-chan Event{&existCh, &childCh} {
+				select {
+				case other := <-*ch:
+					*ch = nil
+					if other != ev {
+						pending = append(pending, other)
+					}
+				default:
+				}
+			}
+		}
+
+		if ev.Err != nil {
+			return ev, ev.Err
+		}
+		switch ev.Type {
+		case EventNodeCreated:
+			exists = true
+		case EventNodeDeleted:
+			exists = false
+		}
+		if cond(ev) {
+			return ev, nil
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package zk
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWatchUntil(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-watch"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		if _, err := zk.Create(path, []byte{1}, 0, WorldACL(PermAll)); err != nil {
+			t.Errorf("Create returned error: %+v", err)
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+		if _, err := zk.Set(path, []byte{2}, -1); err != nil {
+			t.Errorf("Set returned error: %+v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var events []EventType
+	ev, err := zk.WatchUntil(ctx, path, func(ev Event) bool {
+		events = append(events, ev.Type)
+		return ev.Type == EventNodeDataChanged
+	})
+	if err != nil {
+		t.Fatalf("WatchUntil returned error: %+v", err)
+	} else if ev.Path != path {
+		t.Fatalf("WatchUntil returned event for %s instead of %s", ev.Path, path)
+	} else if len(events) != 2 || events[0] != EventNodeCreated {
+		t.Fatalf("WatchUntil saw events %v instead of [EventNodeCreated EventNodeDataChanged]", events)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := zk.WatchUntil(ctx, path, func(Event) bool { return true }); err != context.DeadlineExceeded {
+		t.Fatalf("WatchUntil returned %+v instead of context.DeadlineExceeded", err)
+	}
+}
+
+func TestWatchUntilCancel(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := zk.WatchUntil(ctx, "/a", func(Event) bool { return true })
+		done <- err
+	}()
+	serveFake(t, fc, "exists", "/a", nil, &existsResponse{})
+	serveFake(t, fc, "getChildren2", "/a", nil, &getChildren2Response{})
+	cancel()
+	for _, typ := range []WatcherType{WatcherTypeData, WatcherTypeChildren} {
+		req := serveFake(t, fc, "removeWatches", "/a", nil, &removeWatchesResponse{})
+		if r := req.Body.(*removeWatchesRequest); r.Type != typ {
+			t.Fatalf("Removed watch of type %d, expected %d", r.Type, typ)
+		}
+	}
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("WatchUntil returned %+v instead of context.Canceled", err)
+	}
+	zk.watchersLock.Lock()
+	n := len(zk.watchers)
+	zk.watchersLock.Unlock()
+	if n != 0 {
+		t.Fatalf("%d watchers left after WatchUntil returned", n)
+	}
+}
+
+func TestAddWatch(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-persistent"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+
+	ch, err := zk.AddWatch(path, WatchModePersistent)
+	if err != nil {
+		t.Fatalf("AddWatch returned error: %+v", err)
+	}
+
+	if _, err := zk.Create(path, []byte{1}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := zk.Set(path, []byte{byte(i)}, -1); err != nil {
+			t.Fatalf("Set returned error: %+v", err)
+		}
+	}
+	if err := zk.Delete(path, -1); err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+
+	expected := []EventType{EventNodeCreated, EventNodeDataChanged, EventNodeDataChanged, EventNodeDataChanged, EventNodeDeleted}
+	for _, typ := range expected {
+		select {
+		case ev := <-ch:
+			if ev.Type != typ || ev.Path != path {
+				t.Fatalf("Received %+v instead of %s for %s", ev, typ, path)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Persistent watch timed out waiting for %s", typ)
+		}
+	}
+}
+
+func TestPersistentWatcherOrdering(t *testing.T) {
+	t.Parallel()
+	w := newPersistentWatcher(nil)
+	// Delivering must never block, even if nobody reads yet.
+	for i := 0; i < 1000; i++ {
+		w.deliver(Event{Type: EventNodeDataChanged, Path: string(rune('a' + i%26))}, -1)
+	}
+	w.close()
+
+	n := 0
+	for ev := range w.ch {
+		if expected := string(rune('a' + n%26)); ev.Path != expected {
+			t.Fatalf("Event %d has path %s instead of %s", n, ev.Path, expected)
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Fatalf("Received %d events instead of 1000", n)
+	}
+}
+
+func TestAddWatchRecursive(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	root := "/gozk-test-recursive"
+	ch, err := zk.AddWatch(root, WatchModePersistentRecursive)
+	if err != nil {
+		t.Fatalf("AddWatch returned error: %+v", err)
+	}
+
+	for _, path := range []string{root, root + "/a", root + "/a/b"} {
+		if _, err := zk.Create(path, []byte{}, 0, WorldACL(PermAll)); err != nil {
+			t.Fatalf("Create returned error: %+v", err)
+		}
+	}
+	if _, err := zk.Set(root+"/a/b", []byte{1}, -1); err != nil {
+		t.Fatalf("Set returned error: %+v", err)
+	}
+
+	expected := []Event{
+		{Type: EventNodeCreated, Path: root},
+		{Type: EventNodeCreated, Path: root + "/a"},
+		{Type: EventNodeCreated, Path: root + "/a/b"},
+		{Type: EventNodeDataChanged, Path: root + "/a/b"},
+	}
+	for _, e := range expected {
+		select {
+		case ev := <-ch:
+			if ev.Type != e.Type || ev.Path != e.Path {
+				t.Fatalf("Received %+v instead of %s for %s", ev, e.Type, e.Path)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Recursive watch timed out waiting for %s on %s", e.Type, e.Path)
+		}
+	}
+}
+
+func TestRemoveWatches(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-remove-watches"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	if _, err := zk.Create(path, []byte{1}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	_, _, dataCh1, err := zk.GetW(path)
+	if err != nil {
+		t.Fatalf("GetW returned error: %+v", err)
+	}
+	_, _, dataCh2, err := zk.GetW(path)
+	if err != nil {
+		t.Fatalf("GetW returned error: %+v", err)
+	}
+	_, _, childCh, err := zk.ChildrenW(path)
+	if err != nil {
+		t.Fatalf("ChildrenW returned error: %+v", err)
+	}
+	persistentCh, err := zk.AddWatch(path, WatchModePersistent)
+	if err != nil {
+		t.Fatalf("AddWatch returned error: %+v", err)
+	}
+
+	expectRemoved := func(ch <-chan Event, typ EventType) {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			if ev.Type != typ || ev.Path != path {
+				t.Fatalf("Received %+v instead of %s", ev, typ)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %s", typ)
+		}
+		select {
+		case ev, ok := <-ch:
+			if ok {
+				t.Fatalf("Unexpected event %+v after %s", ev, typ)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Channel not closed after %s", typ)
+		}
+	}
+
+	// dataCh2 still needs the server watch, so only dataCh1 goes away.
+	if err := zk.RemoveWatches(path, dataCh1); err != nil {
+		t.Fatalf("RemoveWatches returned error: %+v", err)
+	}
+	expectRemoved(dataCh1, EventDataWatchRemoved)
+	if err := zk.RemoveWatches(path, dataCh1); err != ErrNoWatcher {
+		t.Fatalf("RemoveWatches of a removed watch returned %v instead of ErrNoWatcher", err)
+	}
+
+	if err := zk.RemoveAllWatches(path, WatcherTypeChildren); err != nil {
+		t.Fatalf("RemoveAllWatches returned error: %+v", err)
+	}
+	expectRemoved(childCh, EventChildWatchRemoved)
+	if err := zk.RemoveAllWatches(path, WatcherTypeChildren); err != ErrNoWatcher {
+		t.Fatalf("RemoveAllWatches without watches returned %v instead of ErrNoWatcher", err)
+	}
+
+	if err := zk.RemoveWatches(path, dataCh2); err != nil {
+		t.Fatalf("RemoveWatches returned error: %+v", err)
+	}
+	expectRemoved(dataCh2, EventDataWatchRemoved)
+
+	if err := zk.RemoveAllWatches(path, WatcherTypeAny); err != nil {
+		t.Fatalf("RemoveAllWatches returned error: %+v", err)
+	}
+	expectRemoved(persistentCh, EventPersistentWatchRemoved)
+
+	zk.watchersLock.Lock()
+	n := len(zk.watchers) + len(zk.persistentWatchers)
+	zk.watchersLock.Unlock()
+	if n != 0 {
+		t.Fatalf("%d watchers left after removal", n)
+	}
+}
+
+func TestRemoveWatchersBookkeeping(t *testing.T) {
+	t.Parallel()
+	c := &Conn{watchers: make(map[watchPathType][]chan Event)}
+	path := "/gozk-test"
+	data1 := c.addWatcher(path, watchTypeData)
+	data2 := c.addWatcher(path, watchTypeExist)
+	child := c.addWatcher(path, watchTypeChild)
+	persistent := c.addPersistentWatcher(path, watchTypePersistent, nil).ch
+
+	if wt, shared, ok := c.findWatcher(path, data1); !ok || !shared || wt != watchTypeData {
+		t.Fatalf("findWatcher(data1) = %v, %v, %v", wt, shared, ok)
+	}
+	if wt, shared, ok := c.findWatcher(path, child); !ok || shared || wt != watchTypeChild {
+		t.Fatalf("findWatcher(child) = %v, %v, %v", wt, shared, ok)
+	}
+	if _, _, ok := c.findWatcher("/other", child); ok {
+		t.Fatal("findWatcher found a watcher on the wrong path")
+	}
+
+	c.removeWatchers(path, func(wt watchType, ch <-chan Event) bool {
+		return WatcherTypeData == wt.watcherType()
+	})
+	for _, ch := range []<-chan Event{data1, data2} {
+		if ev := <-ch; ev.Type != EventDataWatchRemoved {
+			t.Fatalf("Received %+v instead of EventDataWatchRemoved", ev)
+		}
+		if _, ok := <-ch; ok {
+			t.Fatal("Channel not closed after removal")
+		}
+	}
+	if _, _, ok := c.findWatcher(path, data1); ok {
+		t.Fatal("Removed watcher still registered")
+	}
+
+	c.removeWatchers(path, func(watchType, <-chan Event) bool { return true })
+	if ev := <-child; ev.Type != EventChildWatchRemoved {
+		t.Fatalf("Received %+v instead of EventChildWatchRemoved", ev)
+	}
+	if ev := <-persistent; ev.Type != EventPersistentWatchRemoved {
+		t.Fatalf("Received %+v instead of EventPersistentWatchRemoved", ev)
+	}
+	if _, ok := <-persistent; ok {
+		t.Fatal("Persistent channel not closed after removal")
+	}
+	if len(c.watchers) != 0 || len(c.persistentWatchers) != 0 {
+		t.Fatalf("Watchers left after removal: %v %v", c.watchers, c.persistentWatchers)
+	}
+}
+
+func TestRemoveWatchesUnder(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	data := zk.addWatcher("/a/b", watchTypeData)
+	child := zk.addWatcher("/a", watchTypeChild)
+	other := zk.addWatcher("/ab", watchTypeData)
+	persistent := zk.addPersistentWatcher("/a/c/d", watchTypePersistentRecursive, nil).ch
+
+	done := make(chan error, 1)
+	go func() { done <- zk.RemoveWatchesUnder("/a") }()
+	paths := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		req, err := fc.ExpectRequest("removeWatches")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ := req.Body.(*removeWatchesRequest).Type; typ != WatcherTypeAny {
+			t.Fatalf("Unexpected watcher type %d", typ)
+		}
+		paths[req.Path] = true
+		if err := fc.Reply(req, 1, nil, &removeWatchesResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("RemoveWatchesUnder returned error: %+v", err)
+	}
+	if !paths["/a"] || !paths["/a/b"] || !paths["/a/c/d"] {
+		t.Fatalf("Watches removed from the server on %v", paths)
+	}
+
+	for _, w := range []struct {
+		ch  <-chan Event
+		typ EventType
+	}{{data, EventDataWatchRemoved}, {child, EventChildWatchRemoved}, {persistent, EventPersistentWatchRemoved}} {
+		if ev := <-w.ch; ev.Type != w.typ {
+			t.Fatalf("Received %+v instead of %s", ev, w.typ)
+		}
+		if _, ok := <-w.ch; ok {
+			t.Fatal("Channel not closed after removal")
+		}
+	}
+	select {
+	case ev := <-other:
+		t.Fatalf("Watch outside the prefix received %+v", ev)
+	default:
+	}
+	zk.watchersLock.Lock()
+	n := len(zk.watchers) + len(zk.persistentWatchers)
+	zk.watchersLock.Unlock()
+	if n != 1 {
+		t.Fatalf("%d watchers left instead of 1", n)
+	}
+}
+
+func TestSetWatchesSize(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithSetWatchesSize(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	// Each path takes 4+10 bytes, so two fit in a request.
+	paths := []string{"/watched-1", "/watched-2", "/watched-3", "/watched-4"}
+	for _, p := range paths[:3] {
+		zk.addWatcher(p, watchTypeData)
+	}
+	persistent := zk.addPersistentWatcher(paths[3], watchTypePersistent, nil).ch
+
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	fc = acceptFake(t, s, 1)
+	var set []string
+	for i := 0; i < 2; i++ {
+		req, err := fc.NextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch r := req.Body.(type) {
+		case *setWatchesRequest:
+			set = append(set, r.DataWatches...)
+		case *setWatches2Request:
+			set = append(append(set, r.DataWatches...), r.PersistentWatches...)
+		default:
+			t.Fatalf("Unexpected %s request", req.Op)
+		}
+		if err := fc.Reply(req, 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(set)
+	if !reflect.DeepEqual(set, paths) {
+		t.Fatalf("Watches set again on %v", set)
+	}
+	select {
+	case ev := <-persistent:
+		if ev.Type != EventSession || ev.Path != paths[3] {
+			t.Fatalf("Unexpected event %+v", ev)
+		}
+	case <-time.After(fakeTimeout):
+		t.Fatal("Persistent watcher not notified")
+	}
+}
+
+func TestWatchFor(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-watch-for"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	if _, err := zk.Create(path, []byte{1}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	deleted, err := zk.WatchFor(path, EventNodeDeleted)
+	if err != nil {
+		t.Fatalf("WatchFor returned error: %+v", err)
+	}
+	children, err := zk.WatchFor(path, EventNodeChildrenChanged)
+	if err != nil {
+		t.Fatalf("WatchFor returned error: %+v", err)
+	}
+	if _, err := zk.WatchFor(path, EventSession); err != ErrNoEventTypes {
+		t.Fatalf("WatchFor without node events returned %v instead of ErrNoEventTypes", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := zk.Set(path, []byte{byte(i)}, -1); err != nil {
+			t.Fatalf("Set returned error: %+v", err)
+		}
+	}
+	select {
+	case ev := <-deleted:
+		t.Fatalf("Deletion watch fired with %+v", ev)
+	case ev := <-children:
+		t.Fatalf("Children watch fired with %+v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := zk.Create(path+"/child", nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	if err := zk.Delete(path+"/child", -1); err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	if err := zk.Delete(path, -1); err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	for ch, typ := range map[<-chan Event]EventType{children: EventNodeChildrenChanged, deleted: EventNodeDeleted} {
+		select {
+		case ev := <-ch:
+			if ev.Type != typ || ev.Path != path {
+				t.Fatalf("Received %+v instead of %s", ev, typ)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %s", typ)
+		}
+		if _, ok := <-ch; ok {
+			t.Fatalf("Channel for %s not closed after the event", typ)
+		}
+	}
+}
+
+func TestPersistentWatcherTypes(t *testing.T) {
+	t.Parallel()
+	w := newPersistentWatcher(nil)
+	w.types = []EventType{EventNodeDeleted}
+	w.deliver(Event{Type: EventNodeDataChanged, Path: "/a"}, -1)
+	w.deliver(Event{Type: EventNodeDeleted, Path: "/a"}, -1)
+	w.deliver(Event{Type: EventNotWatching, Path: "/a"}, -1)
+	w.close()
+
+	for _, typ := range []EventType{EventNodeDeleted, EventNotWatching} {
+		if ev := <-w.ch; ev.Type != typ {
+			t.Fatalf("Received %+v instead of %s", ev, typ)
+		}
+	}
+	if ev, ok := <-w.ch; ok {
+		t.Fatalf("Unexpected event %+v", ev)
+	}
+}
+
+func TestFilteredWatchUpdate(t *testing.T) {
+	t.Parallel()
+	f := &filteredWatch{c: &Conn{}, types: []EventType{EventNodeDeleted, EventNodeChildrenChanged}}
+	f.update(true, &Stat{Version: 1})
+	f.update(true, &Stat{Version: 2})
+	if f.missed != nil {
+		t.Fatalf("Unwanted change reported as %+v", f.missed)
+	}
+	f.update(true, &Stat{Version: 2, Cversion: 1})
+	if f.missed == nil || f.missed.Type != EventNodeChildrenChanged {
+		t.Fatalf("Children change reported as %+v", f.missed)
+	}
+
+	f = &filteredWatch{c: &Conn{}, types: []EventType{EventNodeDeleted}}
+	f.update(true, &Stat{})
+	f.update(false, nil)
+	if f.missed == nil || f.missed.Type != EventNodeDeleted {
+		t.Fatalf("Deletion reported as %+v", f.missed)
+	}
+}
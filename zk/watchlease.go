@@ -0,0 +1,76 @@
+package zk
+
+import (
+	"runtime"
+	"sync"
+)
+
+// WatchLease ties a watch to an object that removes it when closed, so that
+// watches set by code paths that are abandoned, e.g. after a timeout, do not
+// accumulate on the server for the rest of the session. If a lease becomes
+// unreachable without being closed, the watch is removed when the lease is
+// garbage collected and a warning is logged, so keep the lease, not only its
+// channel, for as long as the watch is used.
+type WatchLease struct {
+	c    *Conn
+	path string
+	ch   <-chan Event
+
+	once sync.Once
+	err  error
+}
+
+// LeaseWatch returns a lease on the watch on path delivering to ch, as
+// returned by GetW, ChildrenW, ExistsW or AddWatch.
+func (c *Conn) LeaseWatch(path string, ch <-chan Event) *WatchLease {
+	l := &WatchLease{c: c, path: path, ch: ch}
+	runtime.SetFinalizer(l, finalizeWatchLease)
+	return l
+}
+
+// AddWatchLease is like AddWatch but returns a lease on the watch.
+func (c *Conn) AddWatchLease(path string, mode WatchMode) (*WatchLease, error) {
+	ch, err := c.AddWatch(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return c.LeaseWatch(path, ch), nil
+}
+
+// Path returns the watched path.
+func (l *WatchLease) Path() string {
+	return l.path
+}
+
+// Events returns the channel of the watch.
+func (l *WatchLease) Events() <-chan Event {
+	return l.ch
+}
+
+// Close removes the watch, if it did not fire or was not removed already.
+// Only the first call does anything, later ones return its result.
+func (l *WatchLease) Close() error {
+	runtime.SetFinalizer(l, nil)
+	return l.remove()
+}
+
+func (l *WatchLease) remove() error {
+	l.once.Do(func() {
+		if err := l.c.RemoveWatches(l.path, l.ch); err != nil && err != ErrNoWatcher && err != ErrClosing {
+			l.err = err
+		}
+	})
+	return l.err
+}
+
+// finalizeWatchLease removes the watch of a lease that was not closed. It
+// must not block the finalizer goroutine with the request.
+func finalizeWatchLease(l *WatchLease) {
+	select {
+	case <-l.c.shouldQuit:
+		return
+	default:
+	}
+	l.c.logf(LogWarn, LogWatches, "Watch lease on %s was garbage collected without being closed", l.path)
+	go l.remove()
+}
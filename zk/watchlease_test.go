@@ -0,0 +1,77 @@
+package zk
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWatchLease(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	lease := func(path string) *WatchLease {
+		done := make(chan *WatchLease, 1)
+		go func() {
+			l, err := zk.AddWatchLease(path, WatchModePersistent)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- l
+		}()
+		req, err := fc.ExpectRequest("addWatch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fc.Reply(req, 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		return <-done
+	}
+	expectRemove := func(path string) {
+		t.Helper()
+		req, err := fc.ExpectRequest("removeWatches")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Path != path {
+			t.Fatalf("Removed the watch on %s instead of %s", req.Path, path)
+		}
+		if err := fc.Reply(req, 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := lease("/closed")
+	if l.Path() != "/closed" {
+		t.Fatalf("Unexpected path %s", l.Path())
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- l.Close() }()
+	expectRemove("/closed")
+	if err := <-closed; err != nil {
+		t.Fatalf("Close returned error: %+v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Second Close returned error: %+v", err)
+	}
+
+	// An abandoned lease removes its watch once collected.
+	lease("/abandoned")
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				runtime.GC()
+			}
+		}
+	}()
+	expectRemove("/abandoned")
+}
@@ -0,0 +1,132 @@
+package zk
+
+// StreamEvent is an event delivered by a WatchStream.
+type StreamEvent struct {
+	Event
+
+	// Seq numbers the events of a stream consecutively, continuing from
+	// the checkpoint the stream was opened with.
+	Seq uint64
+
+	// Gap is set when changes may have been missed before this event, e.g.
+	// while the connection was down or the process was not running. Gaps
+	// are reported as EventSession events, or EventWatcherOverflow events
+	// when a buffer set with WithWatchBuffer dropped events; the consumer
+	// should re-read the watched nodes rather than apply further events to
+	// its current view.
+	Gap bool
+
+	// Checkpoint is the progress to persist once the event is handled.
+	Checkpoint WatchCheckpoint
+}
+
+// WatchCheckpoint records how far a consumer got through a WatchStream, so
+// that a stream opened with it after a restart can tell whether changes were
+// missed in between.
+type WatchCheckpoint struct {
+	Seq  uint64
+	Zxid int64
+}
+
+// WatchStream is a persistent watch whose events are numbered and carry a
+// checkpoint. Gap detection is conservative: a gap may be reported although
+// nothing was missed, but missed changes are always reported. For
+// WatchModePersistent it compares the zxids in the Stat of the watched node
+// against the checkpoint, for WatchModePersistentRecursive any change in the
+// ensemble since the checkpoint counts as a gap.
+type WatchStream struct {
+	c    *Conn
+	path string
+	mode WatchMode
+	w    *persistentWatcher
+	ch   chan StreamEvent
+
+	// cp is only accessed by the watcher goroutine once the stream is open.
+	cp WatchCheckpoint
+}
+
+// OpenWatchStream adds a persistent watch on path, like AddWatch, and returns
+// its events as a stream. If from is not nil the stream continues its
+// numbering and starts with a gap event if changes may have happened since
+// from was taken.
+func (c *Conn) OpenWatchStream(path string, mode WatchMode, from *WatchCheckpoint) (*WatchStream, error) {
+	path, err := c.processPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &WatchStream{c: c, path: path, mode: mode, ch: make(chan StreamEvent)}
+	_, err = c.request(opAddWatch, &addWatchRequest{Path: path, Mode: mode}, &addWatchResponse{}, func(req *request, res *responseHeader, err error) {
+		if err == nil {
+			var wType watchType = watchTypePersistent
+			if mode == WatchModePersistentRecursive {
+				wType = watchTypePersistentRecursive
+			}
+			s.cp.Zxid = res.Zxid
+			if from != nil {
+				s.cp = *from
+			}
+			s.w = c.addPersistentWatcher(path, wType, s)
+			if from != nil {
+				// Queued ahead of any event so that the check for changes
+				// since from runs first.
+				s.w.deliver(Event{Type: EventSession, State: StateHasSession, Path: c.clientPath(path), Server: c.Server()}, -1)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Events returns the events of the stream. The channel must be drained; it
+// is closed after the watch is lost or the stream is closed.
+func (s *WatchStream) Events() <-chan StreamEvent {
+	return s.ch
+}
+
+// Close removes the watch. The events channel receives an
+// EventPersistentWatchRemoved event and is then closed.
+func (s *WatchStream) Close() error {
+	return s.c.RemoveWatches(s.c.clientPath(s.path), s.w.ch)
+}
+
+// handle numbers e and passes it on. It runs on the watcher goroutine.
+func (s *WatchStream) handle(e watchedEvent) {
+	gap := e.Type == EventWatcherOverflow
+	if e.Type == EventSession {
+		// The stream was resumed or the connection re-established, and the
+		// server does not replay what the watch missed in the meantime.
+		if gap, e.zxid = s.missedSince(s.cp.Zxid); !gap {
+			return
+		}
+	}
+	if e.zxid > s.cp.Zxid {
+		s.cp.Zxid = e.zxid
+	}
+	s.cp.Seq++
+	s.ch <- StreamEvent{Event: e.Event, Seq: s.cp.Seq, Gap: gap, Checkpoint: s.cp}
+}
+
+// missedSince reports whether the watched nodes may have changed after zxid,
+// along with the zxid the server was at when it checked.
+func (s *WatchStream) missedSince(zxid int64) (bool, int64) {
+	path := s.path
+	for {
+		res := &existsResponse{}
+		current, err := s.c.request(opExists, &existsRequest{Path: path, Watch: false}, res, nil)
+		switch {
+		case err == ErrNoNode && path != "/":
+			// The creation or deletion of path shows in its parent.
+			path = parentPath(path)
+			continue
+		case err != nil:
+			return true, -1
+		case s.mode == WatchModePersistentRecursive:
+			return current > zxid, current
+		default:
+			return res.Stat.Mzxid > zxid || res.Stat.Pzxid > zxid, current
+		}
+	}
+}
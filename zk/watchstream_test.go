@@ -0,0 +1,98 @@
+package zk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchStreamNumbering(t *testing.T) {
+	t.Parallel()
+	s := &WatchStream{ch: make(chan StreamEvent), cp: WatchCheckpoint{Seq: 41, Zxid: 10}}
+	w := newPersistentWatcher(s)
+	w.deliver(Event{Type: EventNodeCreated, Path: "/a"}, 12)
+	w.deliver(Event{Type: EventNodeDataChanged, Path: "/a"}, 11)
+	w.deliver(Event{Type: EventPersistentWatchRemoved, Path: "/a"}, -1)
+	w.close()
+
+	expected := []StreamEvent{
+		{Event: Event{Type: EventNodeCreated, Path: "/a"}, Seq: 42, Checkpoint: WatchCheckpoint{42, 12}},
+		{Event: Event{Type: EventNodeDataChanged, Path: "/a"}, Seq: 43, Checkpoint: WatchCheckpoint{43, 12}},
+		{Event: Event{Type: EventPersistentWatchRemoved, Path: "/a"}, Seq: 44, Checkpoint: WatchCheckpoint{44, 12}},
+	}
+	for _, e := range expected {
+		if ev := <-s.Events(); ev != e {
+			t.Fatalf("Received %+v instead of %+v", ev, e)
+		}
+	}
+	if ev, ok := <-s.Events(); ok {
+		t.Fatalf("Unexpected event %+v after close", ev)
+	}
+}
+
+func TestWatchStreamResume(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-stream"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+
+	next := func(s *WatchStream) StreamEvent {
+		t.Helper()
+		select {
+		case ev := <-s.Events():
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a stream event")
+		}
+		return StreamEvent{}
+	}
+
+	s, err := zk.OpenWatchStream(path, WatchModePersistent, nil)
+	if err != nil {
+		t.Fatalf("OpenWatchStream returned error: %+v", err)
+	}
+	if _, err := zk.Create(path, []byte{1}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	ev := next(s)
+	if ev.Type != EventNodeCreated || ev.Seq != 1 || ev.Gap {
+		t.Fatalf("Received %+v instead of the creation as event 1", ev)
+	}
+	checkpoint := ev.Checkpoint
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close returned error: %+v", err)
+	}
+	if ev := next(s); ev.Type != EventPersistentWatchRemoved {
+		t.Fatalf("Received %+v instead of EventPersistentWatchRemoved", ev)
+	}
+
+	// A change the stream cannot see must be reported as a gap on resume.
+	if _, err := zk.Set(path, []byte{2}, -1); err != nil {
+		t.Fatalf("Set returned error: %+v", err)
+	}
+	s, err = zk.OpenWatchStream(path, WatchModePersistent, &checkpoint)
+	if err != nil {
+		t.Fatalf("OpenWatchStream returned error: %+v", err)
+	}
+	defer s.Close()
+	ev = next(s)
+	if !ev.Gap || ev.Type != EventSession || ev.Seq != checkpoint.Seq+1 {
+		t.Fatalf("Received %+v instead of a gap as event %d", ev, checkpoint.Seq+1)
+	}
+	if _, err := zk.Set(path, []byte{3}, -1); err != nil {
+		t.Fatalf("Set returned error: %+v", err)
+	}
+	if ev := next(s); ev.Type != EventNodeDataChanged || ev.Gap || ev.Seq != checkpoint.Seq+2 {
+		t.Fatalf("Received %+v instead of the data change as event %d", ev, checkpoint.Seq+2)
+	}
+}
@@ -0,0 +1,170 @@
+package zk
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinWeight    = 0.05
+	defaultLatencyScale = 50 * time.Millisecond
+	defaultHealthDecay  = 0.3
+)
+
+// WeightedHostProvider is a HostProvider that picks servers at random,
+// weighted by a health score built from recent connection attempts. Servers
+// that connect reliably and quickly are preferred, while failing servers keep
+// a small weight so they are still probed and can recover. Hosts are
+// resolved from DNS once during the call to Init.
+type WeightedHostProvider struct {
+	// MinWeight is the weight of the least healthy servers, relative to a
+	// perfectly healthy one. It defaults to 0.05.
+	MinWeight float64
+	// LatencyScale is the connect latency at which a server's weight is
+	// halved. It defaults to 50ms.
+	LatencyScale time.Duration
+	// Decay is the weight given to each new observation in the moving
+	// averages of success rate and latency. It defaults to 0.3.
+	Decay float64
+
+	mu         sync.Mutex
+	servers    []*hostHealth
+	curr       int
+	attempts   int                            // attempts since the last successful connection
+	lookupHost func(string) ([]string, error) // Override of net.LookupHost, for testing.
+	rand       *rand.Rand
+}
+
+type hostHealth struct {
+	addr        string
+	successRate float64
+	latency     time.Duration
+}
+
+var _ HostHealthObserver = &WeightedHostProvider{}
+
+// Init is called first, with the servers specified in the connection
+// string. It uses DNS to look up addresses for each server. All servers start
+// out healthy.
+func (hp *WeightedHostProvider) Init(servers []string) error {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	found, err := resolveServers(servers, hp.lookupHost)
+	if err != nil {
+		return err
+	}
+
+	hp.servers = make([]*hostHealth, len(found))
+	for i, addr := range found {
+		hp.servers[i] = &hostHealth{addr: addr, successRate: 1}
+	}
+	hp.curr = -1
+	hp.attempts = 0
+	if hp.rand == nil {
+		hp.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return nil
+}
+
+// Len returns the number of servers available
+func (hp *WeightedHostProvider) Len() int {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	return len(hp.servers)
+}
+
+// Next returns a server chosen at random according to its health score,
+// avoiding the previously returned one when possible. retryStart will be
+// true once as many attempts as there are servers have been made without
+// Connected() being called.
+func (hp *WeightedHostProvider) Next() (server string, retryStart bool) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	hp.attempts++
+	if hp.attempts > len(hp.servers) {
+		hp.attempts = 1
+		retryStart = true
+	}
+
+	total := 0.0
+	weights := make([]float64, len(hp.servers))
+	for i, h := range hp.servers {
+		if i == hp.curr && len(hp.servers) > 1 {
+			continue
+		}
+		weights[i] = hp.weight(h)
+		total += weights[i]
+	}
+
+	r := hp.rand.Float64() * total
+	next := -1
+	for i, w := range weights {
+		if w == 0 {
+			continue
+		}
+		next = i
+		if r < w {
+			break
+		}
+		r -= w
+	}
+	if next == -1 {
+		next = 0
+	}
+	hp.curr = next
+	return hp.servers[next].addr, retryStart
+}
+
+// Connected notifies the HostProvider of a successful connection.
+func (hp *WeightedHostProvider) Connected() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.attempts = 0
+}
+
+// ObserveConnect updates the health score of server with the outcome of a
+// connection attempt.
+func (hp *WeightedHostProvider) ObserveConnect(server string, latency time.Duration, err error) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	decay := hp.Decay
+	if decay <= 0 || decay > 1 {
+		decay = defaultHealthDecay
+	}
+	for _, h := range hp.servers {
+		if h.addr != server {
+			continue
+		}
+		success := 0.0
+		if err == nil {
+			success = 1
+			if h.latency == 0 {
+				h.latency = latency
+			} else {
+				h.latency = time.Duration(decay*float64(latency) + (1-decay)*float64(h.latency))
+			}
+		}
+		h.successRate = decay*success + (1-decay)*h.successRate
+	}
+}
+
+// weight returns the selection weight of h. hp.mu must be held.
+func (hp *WeightedHostProvider) weight(h *hostHealth) float64 {
+	minWeight := hp.MinWeight
+	if minWeight <= 0 {
+		minWeight = defaultMinWeight
+	}
+	scale := hp.LatencyScale
+	if scale <= 0 {
+		scale = defaultLatencyScale
+	}
+	w := h.successRate / (1 + float64(h.latency)/float64(scale))
+	if w < minWeight {
+		w = minWeight
+	}
+	return w
+}
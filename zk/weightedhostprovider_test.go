@@ -0,0 +1,64 @@
+package zk
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newTestWeightedHostProvider(t *testing.T) *WeightedHostProvider {
+	hp := &WeightedHostProvider{
+		lookupHost: func(host string) ([]string, error) {
+			return []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, nil
+		},
+		rand: rand.New(rand.NewSource(1)),
+	}
+	if err := hp.Init([]string{"foo.example.com:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	return hp
+}
+
+func TestWeightedHostProviderPrefersHealthy(t *testing.T) {
+	t.Parallel()
+	hp := newTestWeightedHostProvider(t)
+
+	errDial := errors.New("connection refused")
+	for i := 0; i < 10; i++ {
+		hp.ObserveConnect("192.0.2.1:2181", 0, errDial)
+		hp.ObserveConnect("192.0.2.2:2181", 100*time.Millisecond, nil)
+		hp.ObserveConnect("192.0.2.3:2181", time.Millisecond, nil)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		server, _ := hp.Next()
+		hp.Connected()
+		counts[server]++
+	}
+	if counts["192.0.2.1:2181"] == 0 {
+		t.Fatal("Failing server should still be probed")
+	}
+	if !(counts["192.0.2.1:2181"] < counts["192.0.2.2:2181"] && counts["192.0.2.2:2181"] < counts["192.0.2.3:2181"]) {
+		t.Fatalf("Servers not preferred by health: %v", counts)
+	}
+}
+
+func TestWeightedHostProviderRetryStart(t *testing.T) {
+	t.Parallel()
+	hp := newTestWeightedHostProvider(t)
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < hp.Len(); j++ {
+			if _, retryStart := hp.Next(); retryStart != (i > 0 && j == 0) {
+				t.Fatalf("Unexpected retryStart=%v on attempt %d of pass %d", retryStart, j, i)
+			}
+		}
+	}
+
+	hp.Connected()
+	if _, retryStart := hp.Next(); retryStart {
+		t.Fatal("retryStart should be reset by Connected")
+	}
+}
@@ -0,0 +1,82 @@
+package zk
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// WithWireTrace returns a connection option that logs every packet sent to
+// and received from the servers with its decoded header, such as the xid,
+// operation, zxid and error code, and with a hex dump of the whole packet
+// if payloads is set, to debug protocol issues against different server
+// versions. The packets are logged at the LogInfo level, the notifications
+// for LogWatches and the others for LogRequests, or LogConnection for the
+// session handshake. The secrets are zeroed in the dumps: the session
+// password of the handshake, the credentials of AddAuth and the SASL tokens.
+func WithWireTrace(payloads bool) connOption {
+	return func(c *Conn) {
+		c.wireTrace = true
+		c.wirePayloads = payloads
+	}
+}
+
+// The offsets of the length prefixed secrets in the traced packets, or of
+// the bodies following the headers.
+const (
+	connectRequestSecret  = 24 // passwd after protocolVersion, lastZxidSeen, timeOut and sessionID
+	connectResponseSecret = 16 // passwd after protocolVersion, timeOut and sessionID
+	requestBody           = 8  // after the xid and opcode
+	responseBody          = 16 // after the xid, zxid and err
+	noSecret              = -1
+)
+
+// secretOffset returns the offset of the secret in a request with opcode,
+// or in the response to it if response is set, or noSecret if it has none.
+func secretOffset(packet []byte, opcode int32, response bool) int {
+	switch {
+	case opcode == opSasl && response:
+		return responseBody
+	case opcode == opSasl:
+		return requestBody
+	case opcode == opSetAuth && !response:
+		// The auth follows the type and the scheme.
+		off := requestBody + 4
+		if len(packet) < off+4 {
+			return off
+		}
+		if n := int(int32(binary.BigEndian.Uint32(packet[off:]))); n > 0 {
+			off += n
+		}
+		return off + 4
+	}
+	return noSecret
+}
+
+// redact returns packet with the length prefixed buffer at offset zeroed, in
+// a copy, or with everything from offset on if the length cannot be read.
+func redact(packet []byte, offset int) []byte {
+	if offset < 0 || offset >= len(packet) {
+		return packet
+	}
+	start, end := offset, len(packet)
+	if offset+4 <= len(packet) {
+		start = offset + 4
+		if n := int(int32(binary.BigEndian.Uint32(packet[offset:]))); n >= 0 && start+n <= end {
+			end = start + n
+		}
+	}
+	out := append([]byte(nil), packet...)
+	for i := start; i < end; i++ {
+		out[i] = 0
+	}
+	return out
+}
+
+// traceWire logs packet with the decoded fields, with the length prefixed
+// secret at offset secret zeroed unless it is noSecret.
+func (c *Conn) traceWire(component LogComponent, msg string, packet []byte, secret int, keysAndValues ...interface{}) {
+	if c.wirePayloads {
+		keysAndValues = append(keysAndValues, "payload", "\n"+hex.Dump(redact(packet, secret)))
+	}
+	c.logw(LogInfo, component, msg, keysAndValues...)
+}
@@ -0,0 +1,127 @@
+package zk
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedLogger records the lines logged, safe for concurrent use.
+type lockedLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *lockedLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+// find returns the first line starting with prefix, or waits for it.
+func (l *lockedLogger) find(t *testing.T, prefix string) string {
+	t.Helper()
+	for deadline := time.Now().Add(fakeTimeout); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		for _, line := range l.lines {
+			if strings.HasPrefix(line, prefix) {
+				l.mu.Unlock()
+				return line
+			}
+		}
+		l.mu.Unlock()
+	}
+	t.Fatalf("Nothing logged starting with %q", prefix)
+	return ""
+}
+
+func TestWireTrace(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	l := &lockedLogger{}
+	zk, ch, err := Connect([]string{"127.0.0.1:2181"}, 10*time.Second, WithDialer(s.Dialer()), WithWireTrace(true), func(c *Conn) { c.logger = l })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+	fc := acceptFake(t, s, 0)
+	waitForState(t, ch, StateHasSession)
+
+	go zk.Delete("/a", -1)
+	req, err := fc.ExpectRequest("delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.Reply(req, 5, ErrNoNode, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.SendEvent(6, EventNodeDeleted, "/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	l.find(t, "Sent connect request sessionID=0")
+	l.find(t, "Received connect response sessionID=1")
+	sent := l.find(t, fmt.Sprintf("Sent request xid=%d op=delete", req.Xid))
+	if !strings.Contains(sent, "payload=\n00000000") {
+		t.Errorf("No payload dump in %q", sent)
+	}
+	l.find(t, fmt.Sprintf("Received response xid=%d zxid=5 op=delete err=%d", req.Xid, errNoNode))
+	l.find(t, "Received notification xid=-1 zxid=6 type=EventNodeDeleted")
+}
+
+func TestWireTraceRedact(t *testing.T) {
+	t.Parallel()
+	secret := []byte("s3cr3t-p4ssw0rd!")
+	encode := func(parts ...interface{}) []byte {
+		buf := make([]byte, 256)
+		n := 0
+		for _, p := range parts {
+			m, err := encodePacket(buf[n:], p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n += m
+		}
+		return buf[:n]
+	}
+	header := func(opcode int32) *requestHeader { return &requestHeader{Xid: 1, Opcode: opcode} }
+	reply := &responseHeader{Xid: 1, Zxid: 2}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		offset func([]byte) int
+	}{
+		{"connect request", encode(&connectRequest{SessionID: 1, Passwd: secret, ReadOnly: true}), func([]byte) int { return connectRequestSecret }},
+		{"connect response", encode(&connectResponse{SessionID: 1, Passwd: secret}), func([]byte) int { return connectResponseSecret }},
+		{"setAuth", encode(header(opSetAuth), &setAuthRequest{Scheme: "digest", Auth: secret}), func(p []byte) int { return secretOffset(p, opSetAuth, false) }},
+		{"sasl request", encode(header(opSasl), &getSaslRequest{Token: secret}), func(p []byte) int { return secretOffset(p, opSasl, false) }},
+		{"sasl response", encode(reply, &getSaslRequest{Token: secret}), func(p []byte) int { return secretOffset(p, opSasl, true) }},
+		{"truncated setAuth", encode(header(opSetAuth), &setAuthRequest{Scheme: "digest", Auth: secret})[:30], func(p []byte) int { return secretOffset(p, opSetAuth, false) }},
+	}
+	for _, tt := range tests {
+		packet := append([]byte(nil), tt.packet...)
+		out := redact(packet, tt.offset(packet))
+		if bytes.Contains(out, secret[:4]) {
+			t.Errorf("%s: secret left in % x", tt.name, out)
+		}
+		if len(out) != len(packet) {
+			t.Errorf("%s: redacted %d bytes into %d", tt.name, len(packet), len(out))
+		}
+		if !bytes.Equal(packet, tt.packet) {
+			t.Errorf("%s: packet modified", tt.name)
+		}
+	}
+
+	del := encode(header(opDelete), &DeleteRequest{Path: "/a", Version: -1})
+	if off := secretOffset(del, opDelete, false); off != noSecret {
+		t.Errorf("secretOffset(delete) = %d, want noSecret", off)
+	}
+	if out := redact(del, noSecret); !bytes.Equal(out, del) {
+		t.Errorf("redact(noSecret) modified the packet")
+	}
+}
@@ -1,8 +1,11 @@
 package zk
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -42,6 +45,74 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestCreate2(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-create2"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	p, stat, err := zk.Create2(path, []byte{1, 2, 3, 4}, 0, WorldACL(PermAll))
+	if err != nil {
+		t.Fatalf("Create2 returned error: %+v", err)
+	} else if p != path {
+		t.Fatalf("Create2 returned different path '%s' != '%s'", p, path)
+	}
+	_, expected, err := zk.Get(path)
+	if err != nil {
+		t.Fatalf("Get returned error: %+v", err)
+	}
+	if *stat != *expected {
+		t.Fatalf("Create2 returned stat %+v instead of %+v", stat, expected)
+	}
+	if _, _, err := zk.Create2(path, nil, 0, WorldACL(PermAll)); err != ErrNodeExists {
+		t.Fatalf("Create2 of an existing node returned %v instead of ErrNodeExists", err)
+	}
+}
+
+func TestCreateContainer(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-container"
+	if p, err := zk.CreateContainer(path, []byte{1}, WorldACL(PermAll)); err != nil {
+		t.Fatalf("CreateContainer returned error: %+v", err)
+	} else if p != path {
+		t.Fatalf("CreateContainer returned different path '%s' != '%s'", p, path)
+	}
+	if _, err := zk.CreateContainer(path, nil, WorldACL(PermAll)); err != ErrNodeExists {
+		t.Fatalf("CreateContainer of an existing node returned %v instead of ErrNodeExists", err)
+	}
+
+	multiPath := path + "-multi"
+	ops := []interface{}{
+		&CreateRequest{Path: multiPath, Acl: WorldACL(PermAll), Flags: FlagContainer},
+		&CreateRequest{Path: multiPath + "/child", Acl: WorldACL(PermAll)},
+	}
+	if res, err := zk.Multi(ops...); err != nil {
+		t.Fatalf("Multi returned error: %+v", err)
+	} else if len(res) != 2 || res[0].String != multiPath || res[0].Stat == nil {
+		t.Fatalf("Multi returned wrong responses %+v", res)
+	}
+}
+
 func TestMulti(t *testing.T) {
 	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
 	if err != nil {
@@ -79,6 +150,55 @@ func TestMulti(t *testing.T) {
 	}
 }
 
+func TestDeleteIfEmpty(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test"
+	if _, err := zk.Create(path, []byte{}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	if _, err := zk.Create(path+"/child", []byte{}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	_, stat, err := zk.Children(path)
+	if err != nil {
+		t.Fatalf("Children returned error: %+v", err)
+	}
+	if err := zk.DeleteIfEmpty(path, -1, stat.Cversion); err != ErrNotEmpty {
+		t.Fatalf("DeleteIfEmpty returned %+v instead of ErrNotEmpty", err)
+	}
+	if err := zk.Delete(path+"/child", -1); err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	if err := zk.DeleteIfEmpty(path, -1, stat.Cversion); err != ErrBadVersion {
+		t.Fatalf("DeleteIfEmpty after a child was deleted returned %+v instead of ErrBadVersion", err)
+	}
+	if err := zk.DeleteIfEmpty(path, 5, -1); err != ErrBadVersion {
+		t.Fatalf("DeleteIfEmpty returned %+v instead of ErrBadVersion", err)
+	}
+	if _, stat, err = zk.Children(path); err != nil {
+		t.Fatalf("Children returned error: %+v", err)
+	}
+	if err := zk.DeleteIfEmpty(path, 0, stat.Cversion); err != nil {
+		t.Fatalf("DeleteIfEmpty returned error: %+v", err)
+	}
+	if exists, _, err := zk.Exists(path); err != nil {
+		t.Fatalf("Exists returned error: %+v", err)
+	} else if exists {
+		t.Fatal("Node should have been deleted")
+	}
+}
+
 func TestGetSetACL(t *testing.T) {
 	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
 	if err != nil {
@@ -430,6 +550,54 @@ func TestExpiringWatch(t *testing.T) {
 	}
 }
 
+func TestEphemeralGuard(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+
+	lostCh := make(chan []string, 1)
+	zk, _, err := Connect([]string{fmt.Sprintf("127.0.0.1:%d", ts.Servers[0].Port)}, time.Second*15, WithEphemeralGuard(func(lost []string) {
+		lostCh <- lost
+	}))
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-ephemeral"
+	if err := zk.Delete(path, -1); err != nil && err != ErrNoNode {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+	if _, err := zk.Create(path, []byte{1, 2, 3, 4}, FlagEphemeral, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	// The original session is still alive on the server, so remove the node
+	// from another connection to simulate it being lost with the session.
+	zk2, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk2.Close()
+	if err := zk2.Delete(path, -1); err != nil {
+		t.Fatalf("Delete returned error: %+v", err)
+	}
+
+	zk.sessionID = 99999
+	zk.conn.Close()
+
+	select {
+	case lost := <-lostCh:
+		if len(lost) != 1 || lost[0] != path {
+			t.Fatalf("Ephemeral guard reported %+v instead of [%s]", lost, path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ephemeral guard was not called")
+	}
+}
+
 func TestRequestFail(t *testing.T) {
 	// If connecting fails to all servers in the list then pending requests
 	// should be errored out so they don't hang forever.
@@ -455,6 +623,53 @@ func TestRequestFail(t *testing.T) {
 	}
 }
 
+func TestDataTooLarge(t *testing.T) {
+	// Oversized payloads must be rejected without talking to a server.
+	zk, _, err := Connect([]string{"127.0.0.1:32444"}, time.Second*15, WithMaxDataSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+
+	data := []byte{1, 2, 3, 4, 5}
+	if _, err := zk.Create("/gozk-test", data, 0, WorldACL(PermAll)); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("Create returned %+v instead of ErrDataTooLarge", err)
+	} else if e, ok := err.(*DataTooLargeError); !ok || e.Size != 5 || e.Max != 4 {
+		t.Fatalf("Create returned %+v without the actual and maximum size", err)
+	}
+	if _, err := zk.Set("/gozk-test", data, -1); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("Set returned %+v instead of ErrDataTooLarge", err)
+	}
+	if _, err := zk.Multi(&SetDataRequest{Path: "/gozk-test", Data: data, Version: -1}); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("Multi returned %+v instead of ErrDataTooLarge", err)
+	}
+}
+
+func TestSessionEstablishmentTimeout(t *testing.T) {
+	start := time.Now()
+	_, _, err := Connect([]string{"127.0.0.1:32444"}, time.Second*15, WithSessionEstablishmentTimeout(time.Millisecond*500))
+	if err != ErrSessionEstablishmentTimeout {
+		t.Fatalf("Connect returned %+v instead of ErrSessionEstablishmentTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*3 {
+		t.Fatalf("Connect took %s to time out", elapsed)
+	}
+
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := Connect([]string{fmt.Sprintf("127.0.0.1:%d", ts.Servers[0].Port)}, time.Second*15, WithSessionEstablishmentTimeout(time.Second*5))
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+	if state := zk.State(); state != StateHasSession {
+		t.Fatalf("State is %s instead of StateHasSession after Connect", state)
+	}
+}
+
 func TestSlowServer(t *testing.T) {
 	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
 	if err != nil {
@@ -571,3 +786,501 @@ func startSlowProxy(t *testing.T, up, down Rate, upstream string, adj func(ln *L
 	}()
 	return ln.Addr().String(), stopCh, nil
 }
+
+func TestDeleteGuaranteed(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-guaranteed"
+	if _, err := zk.Create(path, nil, 0, WorldACL(PermAll)); err != nil && err != ErrNodeExists {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	server := fmt.Sprintf("127.0.0.1:%d", ts.Servers[0].Port)
+	ts.StopServer(server)
+	if err := zk.DeleteGuaranteed(path, -1); err != ErrConnectionClosed && err != ErrNoServer {
+		t.Fatalf("DeleteGuaranteed without a server returned %v", err)
+	}
+	if pending := zk.PendingDeletes(); len(pending) != 1 || pending[0] != path {
+		t.Fatalf("PendingDeletes returned %v instead of [%s]", pending, path)
+	}
+	ts.StartServer(server)
+
+	deadline := time.Now().Add(20 * time.Second)
+	for len(zk.PendingDeletes()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Guaranteed delete still pending")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if ok, _, err := zk.Exists(path); err != nil {
+		t.Fatalf("Exists returned error: %+v", err)
+	} else if ok {
+		t.Fatal("Node still exists after the guaranteed delete")
+	}
+}
+
+func TestDeleteGuaranteedLostReply(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+
+	// The connection fails before the reply to the delete arrives.
+	errs := make(chan error, 1)
+	go func() { errs <- zk.DeleteGuaranteed("/a", 3) }()
+	if _, err := fc.ExpectRequest("delete"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Close()
+	if err := <-errs; err != ErrConnectionClosed {
+		t.Fatalf("DeleteGuaranteed returned %v instead of ErrConnectionClosed", err)
+	}
+	if pending := zk.PendingDeletes(); len(pending) != 1 || pending[0] != "/a" {
+		t.Fatalf("PendingDeletes returned %v instead of [/a]", pending)
+	}
+
+	// The retry is lost as well, and the next one finds that the first
+	// delete was applied after all.
+	for _, lost := range []bool{true, false} {
+		fc = acceptFake(t, s, 1)
+		waitForState(t, ch, StateHasSession)
+		req, err := fc.ExpectRequest("delete")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := req.Body.(*DeleteRequest); r.Path != "/a" || r.Version != 3 {
+			t.Fatalf("Unexpected retried delete %+v", r)
+		}
+		if lost {
+			fc.Close()
+			continue
+		}
+		if err := fc.Reply(req, 2, ErrNoNode, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(fakeTimeout)
+	for len(zk.PendingDeletes()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Guaranteed delete still pending")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	if err := zk.AddAuth("digest", []byte("user:password")); err != nil {
+		t.Fatalf("AddAuth returned error: %+v", err)
+	}
+	infos, err := zk.WhoAmI()
+	if err != nil {
+		t.Fatalf("WhoAmI returned error: %+v", err)
+	}
+	found := false
+	for _, info := range infos {
+		found = found || (info.AuthScheme == "digest" && strings.HasPrefix(info.User, "user"))
+	}
+	if !found {
+		t.Fatalf("WhoAmI returned %+v without the digest identity", infos)
+	}
+}
+
+func TestMultiRead(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-multi-read"
+	if _, err := zk.Create(path, []byte{1, 2, 3}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	if _, err := zk.Create(path+"/child", nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	res, err := zk.MultiRead(&GetOp{Path: path}, &ChildrenOp{Path: path}, &GetOp{Path: path + "/missing"})
+	if err != nil {
+		t.Fatalf("MultiRead returned error: %+v", err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("Expected 3 responses got %d", len(res))
+	}
+	if res[0].Err != nil || !bytes.Equal(res[0].Data, []byte{1, 2, 3}) || res[0].Stat == nil || res[0].Stat.NumChildren != 1 {
+		t.Fatalf("Wrong get response %+v", res[0])
+	}
+	if res[1].Err != nil || len(res[1].Children) != 1 || res[1].Children[0] != "child" {
+		t.Fatalf("Wrong children response %+v", res[1])
+	}
+	if res[2].Err != ErrNoNode {
+		t.Fatalf("Get of a missing node returned %v instead of ErrNoNode", res[2].Err)
+	}
+}
+
+func TestGetAllChildrenNumber(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	path := "/gozk-test-all-children"
+	for _, p := range []string{path, path + "/a", path + "/a/b", path + "/c"} {
+		if _, err := zk.Create(p, nil, 0, WorldACL(PermAll)); err != nil {
+			t.Fatalf("Create returned error: %+v", err)
+		}
+	}
+
+	if n, err := zk.GetAllChildrenNumber(path); err != nil {
+		t.Fatalf("GetAllChildrenNumber returned error: %+v", err)
+	} else if n != 3 {
+		t.Fatalf("GetAllChildrenNumber returned %d instead of 3", n)
+	}
+	if _, err := zk.GetAllChildrenNumber(path + "/missing"); err != ErrNoNode {
+		t.Fatalf("GetAllChildrenNumber of a missing node returned %v instead of ErrNoNode", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ts, err := StartTestCluster(3, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	writer, err := ts.Connect(0)
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer writer.Close()
+	reader, err := ts.Connect(1)
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer reader.Close()
+
+	path := "/gozk-test-sync"
+	if _, err := writer.Create(path, []byte{1}, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+	if p, err := reader.Sync(path); err != nil {
+		t.Fatalf("Sync returned error: %+v", err)
+	} else if p != path {
+		t.Fatalf("Sync returned path %q instead of %q", p, path)
+	}
+	if data, _, err := reader.Get(path); err != nil {
+		t.Fatalf("Get returned error: %+v", err)
+	} else if !bytes.Equal(data, []byte{1}) {
+		t.Fatalf("Get after Sync returned %v", data)
+	}
+	if _, err := reader.Sync("invalid"); err == nil {
+		t.Fatal("Sync of an invalid path did not fail")
+	}
+}
+
+func TestCreateTTLBadArguments(t *testing.T) {
+	// Invalid TTL nodes must be rejected without talking to a server.
+	zk, _, err := Connect([]string{"127.0.0.1:32444"}, time.Second*15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+
+	if _, err := zk.CreateTTL("/gozk-test", nil, FlagEphemeral, WorldACL(PermAll), time.Second); err != ErrBadArguments {
+		t.Fatalf("CreateTTL of an ephemeral node returned %v instead of ErrBadArguments", err)
+	}
+	if _, err := zk.CreateTTL("/gozk-test", nil, 0, WorldACL(PermAll), 0); err != ErrBadArguments {
+		t.Fatalf("CreateTTL without a TTL returned %v instead of ErrBadArguments", err)
+	}
+}
+
+func TestCreateTTLEmulated(t *testing.T) {
+	ts, err := StartTestCluster(1, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := Connect([]string{fmt.Sprintf("127.0.0.1:%d", ts.Servers[0].Port)}, time.Second*15, WithTTLEmulation(time.Millisecond*100))
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	expiring, err := zk.CreateTTL("/gozk-test-ttl-", []byte{1}, FlagSequence, WorldACL(PermAll), time.Millisecond*200)
+	if err != nil {
+		t.Fatalf("CreateTTL returned error: %+v", err)
+	}
+	parent, err := zk.CreateTTL("/gozk-test-ttl-parent", nil, 0, WorldACL(PermAll), time.Millisecond*200)
+	if err != nil {
+		t.Fatalf("CreateTTL returned error: %+v", err)
+	}
+	if _, err := zk.Create(parent+"/child", nil, 0, WorldACL(PermAll)); err != nil {
+		t.Fatalf("Create returned error: %+v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, _, err := zk.Exists(expiring)
+		if err != nil {
+			t.Fatalf("Exists returned error: %+v", err)
+		}
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TTL node %s was not deleted", expiring)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if ok, _, err := zk.Exists(parent); err != nil || !ok {
+		t.Fatalf("TTL node with children was deleted: %v", err)
+	}
+	if children, _, err := zk.Children(ttlNodesPath); err != nil || len(children) != 1 {
+		t.Fatalf("Expected 1 tracked TTL node got %v: %v", children, err)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	ts, err := StartTestCluster(3, nil, logWriter{t: t, p: "[ZKERR] "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Stop()
+	zk, _, err := ts.ConnectAll()
+	if err != nil {
+		t.Fatalf("Connect returned error: %+v", err)
+	}
+	defer zk.Close()
+
+	qc, stat, ch, err := zk.GetConfigW()
+	if err != nil {
+		t.Fatalf("GetConfigW returned error: %+v", err)
+	}
+	if stat == nil || ch == nil {
+		t.Fatal("GetConfigW returned no stat or watch")
+	}
+	if len(qc.Servers) != 3 {
+		t.Fatalf("Expected 3 servers in the config got %+v", qc)
+	}
+	for _, s := range qc.Servers {
+		if s.Host != "127.0.0.1" || s.PeerPort != ts.Servers[s.ID-1].PeerPort {
+			t.Errorf("Unexpected server in the config %+v", s)
+		}
+	}
+}
+
+func TestReadOnlyServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	connectReqs := make(chan connectRequest, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		n, err := readPacket(conn, buf, time.Now().Add(5*time.Second))
+		if err != nil {
+			return
+		}
+		req := connectRequest{}
+		decodePacket(buf[:n], &req)
+		connectReqs <- req
+
+		// A read-only server appends a true flag to the connect response.
+		n, _ = encodePacket(buf[4:], &connectResponse{TimeOut: 4000, SessionID: 1, Passwd: []byte{1}})
+		buf[4+n] = 1
+		binary.BigEndian.PutUint32(buf[:4], uint32(n+1))
+		conn.Write(buf[:n+5])
+
+		for {
+			if _, err := readPacket(conn, buf, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+			hdr := requestHeader{}
+			decodePacket(buf, &hdr)
+			res := responseHeader{Xid: hdr.Xid}
+			if hdr.Opcode != opPing && hdr.Opcode != opClose {
+				res.Err = errNotReadOnly
+			}
+			n, _ := encodePacket(buf[4:], &res)
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			conn.Write(buf[:n+4])
+		}
+	}()
+
+	zk, ch, err := Connect([]string{l.Addr().String()}, time.Second*15, WithCanBeReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zk.Close()
+
+	req := <-connectReqs
+	if !req.ReadOnly || req.SessionID != 0 {
+		t.Fatalf("Connect request %+v does not allow read-only servers", req)
+	}
+	deadline := time.After(5 * time.Second)
+	for readOnly := false; !readOnly; {
+		select {
+		case ev := <-ch:
+			if ev.State == StateHasSession {
+				t.Fatal("Read-only session reported as StateHasSession")
+			}
+			readOnly = ev.State == StateConnectedReadOnly
+		case <-deadline:
+			t.Fatal("Connection did not become read-only")
+		}
+	}
+	if _, err := zk.Create("/gozk-test", nil, 0, WorldACL(PermAll)); err != ErrNotReadOnly {
+		t.Fatalf("Create on a read-only server returned %v instead of ErrNotReadOnly", err)
+	}
+}
+
+func TestDeleteIfEmptyCversion(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	for _, tt := range []struct {
+		cversion int32
+		multi    bool
+		err      error
+	}{
+		{4, false, ErrBadVersion}, // a child was created or deleted
+		{3, true, ErrBadVersion},  // the node changed before the multi
+		{3, true, nil},
+	} {
+		errs := make(chan error, 1)
+		go func() { errs <- zk.DeleteIfEmpty("/a", 2, 3) }()
+		serveFake(t, fc, "exists", "/a", nil, &existsResponse{Stat: Stat{Version: 5, Cversion: tt.cversion}})
+		if tt.multi {
+			req, err := fc.ExpectRequest("multi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops := req.Body.(*multiRequest).Ops
+			if len(ops) != 2 {
+				t.Fatalf("Unexpected multi request %+v", ops)
+			}
+			if r, ok := ops[0].Op.(*CheckVersionRequest); !ok || r.Path != "/a" || r.Version != 5 {
+				t.Fatalf("Unexpected check op %+v", ops[0].Op)
+			}
+			if r, ok := ops[1].Op.(*DeleteRequest); !ok || r.Path != "/a" || r.Version != 2 {
+				t.Fatalf("Unexpected delete op %+v", ops[1].Op)
+			}
+			res := &struct{ Check, Delete, Done multiHeader }{
+				multiHeader{opCheck, false, 0},
+				multiHeader{opDelete, false, 0},
+				multiHeader{-1, true, -1},
+			}
+			if err := fc.Reply(req, 2, tt.err, res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := <-errs; err != tt.err {
+			t.Fatalf("DeleteIfEmpty with cversion %d returned %v instead of %v", tt.cversion, err, tt.err)
+		}
+	}
+}
+
+func TestCreateParents(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, _, fc := connectFake(t, s)
+	defer zk.Close()
+
+	if err := zk.CreateParents("a/b", WorldACL(PermAll)); err == nil {
+		t.Fatal("CreateParents accepted a relative path")
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- zk.CreateParents("/a/b/lock-", WorldACL(PermAll)) }()
+	for _, tt := range []struct {
+		path string
+		err  error
+	}{
+		{"/a", ErrNodeExists},
+		{"/a/b", nil},
+	} {
+		req, err := fc.ExpectRequest("create")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Path != tt.path {
+			t.Fatalf("Created %s instead of %s", req.Path, tt.path)
+		}
+		if err := fc.Reply(req, 1, tt.err, &createResponse{Path: tt.path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("CreateParents returned error: %+v", err)
+	}
+}
+
+func TestUpdateServers(t *testing.T) {
+	t.Parallel()
+	s := NewFakeServer()
+	defer s.Close()
+	zk, ch, fc := connectFake(t, s)
+	defer zk.Close()
+
+	if err := zk.UpdateServers(nil); err == nil {
+		t.Fatal("UpdateServers accepted an empty server list")
+	}
+	if err := zk.UpdateServers([]string{"127.0.0.2"}); err != nil {
+		t.Fatalf("UpdateServers returned error: %+v", err)
+	}
+	if server := zk.Server(); server != "127.0.0.1:2181" {
+		t.Fatalf("Connection moved to %s before reconnecting", server)
+	}
+
+	// The session is resumed on the new server.
+	fc.Close()
+	waitForState(t, ch, StateDisconnected)
+	acceptFake(t, s, 1)
+	waitForState(t, ch, StateHasSession)
+	if server := zk.Server(); server != "127.0.0.2:2181" {
+		t.Fatalf("Reconnected to %s instead of the new server", server)
+	}
+	if servers := zk.configuredServers(); len(servers) != 1 || servers[0] != "127.0.0.2:2181" {
+		t.Fatalf("Unexpected configured servers %v", servers)
+	}
+}